@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package alerts tells repeater owners when their repeaters go offline and
+// come back online.
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+)
+
+// Event is something a subscriber is told about.
+type Event string
+
+const (
+	EventOffline  Event = "offline"
+	EventOnline   Event = "online"
+	EventFlapping Event = "flapping"
+)
+
+const (
+	// DefaultOfflineAfter is how long a repeater can miss keepalives by default
+	DefaultOfflineAfter = 10 * time.Minute
+	// FlapThreshold is how many state changes within FlapWindow mark a repeater as flapping
+	FlapThreshold = 4
+	// FlapWindow is how long a flapping repeater has to stay stable before alerts resume
+	FlapWindow = 30 * time.Minute
+)
+
+var (
+	ErrInvalidQuietHours = errors.New("quiet hours must be HH:MM")
+	ErrInvalidTimezone   = errors.New("invalid timezone")
+)
+
+// IsOffline reports whether a repeater last heard from at lastPing has missed
+// keepalives for longer than the alert allows.
+func IsOffline(alert *models.RepeaterAlert, lastPing time.Time, now time.Time) bool {
+	offlineAfter := time.Duration(alert.OfflineAfterMinutes) * time.Minute
+	if offlineAfter <= 0 {
+		offlineAfter = DefaultOfflineAfter
+	}
+	return now.Sub(lastPing) > offlineAfter
+}
+
+// Evaluate advances the state of an alert given the repeater's last keepalive
+// and returns the events the subscriber should be told about now.
+//
+// State changes are only reported outside quiet hours and once the repeater
+// has stopped flapping. Changes that were held back are reported later if the
+// repeater is still in the new state, so a repeater that drops and recovers
+// overnight doesn't wake anyone up.
+func Evaluate(alert *models.RepeaterAlert, lastPing time.Time, now time.Time) []Event {
+	var events []Event
+	offline := IsOffline(alert, lastPing, now)
+	if offline != alert.Offline {
+		if now.Sub(alert.LastTransitionAt) > FlapWindow {
+			alert.Transitions = 0
+		}
+		alert.Transitions++
+		alert.LastTransitionAt = now
+		alert.Offline = offline
+		if alert.Transitions == FlapThreshold && !InQuietHours(alert, now) {
+			events = append(events, EventFlapping)
+		}
+	}
+
+	if alert.NotifiedOffline == alert.Offline || Flapping(alert, now) || InQuietHours(alert, now) {
+		return events
+	}
+	alert.NotifiedOffline = alert.Offline
+	if alert.Offline {
+		return append(events, EventOffline)
+	}
+	return append(events, EventOnline)
+}
+
+// Flapping reports whether alerts are paused because the repeater keeps going up and down.
+func Flapping(alert *models.RepeaterAlert, now time.Time) bool {
+	return alert.Transitions >= FlapThreshold && now.Sub(alert.LastTransitionAt) < FlapWindow
+}
+
+// InQuietHours reports whether now falls within the alert's quiet hours.
+func InQuietHours(alert *models.RepeaterAlert, now time.Time) bool {
+	if alert.QuietHoursStart == "" || alert.QuietHoursEnd == "" {
+		return false
+	}
+	start, err := ParseClock(alert.QuietHoursStart)
+	if err != nil {
+		return false
+	}
+	end, err := ParseClock(alert.QuietHoursEnd)
+	if err != nil {
+		return false
+	}
+	location, err := time.LoadLocation(alert.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	// Quiet hours span midnight
+	return minute >= start || minute < end
+}
+
+// ParseClock parses HH:MM into minutes after midnight.
+func ParseClock(clock string) (int, error) {
+	hours, minutes, found := strings.Cut(clock, ":")
+	if !found || len(hours) != 2 || len(minutes) != 2 {
+		return 0, ErrInvalidQuietHours
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 23 {
+		return 0, ErrInvalidQuietHours
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 {
+		return 0, ErrInvalidQuietHours
+	}
+	return h*60 + m, nil
+}
+
+// ValidateSchedule checks the quiet hours and timezone of an alert.
+func ValidateSchedule(alert models.RepeaterAlert) error {
+	if (alert.QuietHoursStart == "") != (alert.QuietHoursEnd == "") {
+		return fmt.Errorf("%w, with both a start and an end", ErrInvalidQuietHours)
+	}
+	if alert.QuietHoursStart != "" {
+		if _, err := ParseClock(alert.QuietHoursStart); err != nil {
+			return err
+		}
+		if _, err := ParseClock(alert.QuietHoursEnd); err != nil {
+			return err
+		}
+	}
+	if _, err := time.LoadLocation(alert.Timezone); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTimezone, alert.Timezone)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package alerts_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/alerts"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
+)
+
+//nolint:golint,gochecknoglobals
+var start = time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+func TestEvaluateOfflineAndOnline(t *testing.T) {
+	t.Parallel()
+	alert := &models.RepeaterAlert{OfflineAfterMinutes: 5}
+	lastPing := start
+
+	if events := alerts.Evaluate(alert, lastPing, start.Add(4*time.Minute)); len(events) != 0 {
+		t.Fatalf("expected no events before the offline threshold, got %v", events)
+	}
+	events := alerts.Evaluate(alert, lastPing, start.Add(6*time.Minute))
+	if !reflect.DeepEqual(events, []alerts.Event{alerts.EventOffline}) {
+		t.Fatalf("expected offline event, got %v", events)
+	}
+	if events := alerts.Evaluate(alert, lastPing, start.Add(7*time.Minute)); len(events) != 0 {
+		t.Fatalf("expected the offline event only once, got %v", events)
+	}
+
+	lastPing = start.Add(8 * time.Minute)
+	events = alerts.Evaluate(alert, lastPing, start.Add(9*time.Minute))
+	if !reflect.DeepEqual(events, []alerts.Event{alerts.EventOnline}) {
+		t.Fatalf("expected online event, got %v", events)
+	}
+}
+
+func TestEvaluateQuietHours(t *testing.T) {
+	t.Parallel()
+	// 12:00 UTC is 08:00 in New York
+	alert := &models.RepeaterAlert{
+		OfflineAfterMinutes: 5,
+		QuietHoursStart:     "22:00",
+		QuietHoursEnd:       "08:30",
+		Timezone:            "America/New_York",
+	}
+
+	if events := alerts.Evaluate(alert, start, start.Add(10*time.Minute)); len(events) != 0 {
+		t.Fatalf("expected the offline event to be held during quiet hours, got %v", events)
+	}
+	events := alerts.Evaluate(alert, start, start.Add(31*time.Minute))
+	if !reflect.DeepEqual(events, []alerts.Event{alerts.EventOffline}) {
+		t.Fatalf("expected the held offline event after quiet hours, got %v", events)
+	}
+
+	// Dropping and recovering within quiet hours sends nothing
+	overnight := &models.RepeaterAlert{
+		OfflineAfterMinutes: 5,
+		QuietHoursStart:     "22:00",
+		QuietHoursEnd:       "08:30",
+		Timezone:            "America/New_York",
+	}
+	alerts.Evaluate(overnight, start, start.Add(10*time.Minute))
+	alerts.Evaluate(overnight, start.Add(15*time.Minute), start.Add(16*time.Minute))
+	if events := alerts.Evaluate(overnight, start.Add(40*time.Minute), start.Add(41*time.Minute)); len(events) != 0 {
+		t.Fatalf("expected no events for an outage that ended during quiet hours, got %v", events)
+	}
+}
+
+func TestEvaluateFlapping(t *testing.T) {
+	t.Parallel()
+	alert := &models.RepeaterAlert{OfflineAfterMinutes: 1}
+	now := start
+	lastPing := start
+	var got []alerts.Event
+	for range alerts.FlapThreshold {
+		// Go offline
+		now = now.Add(2 * time.Minute)
+		got = append(got, alerts.Evaluate(alert, lastPing, now)...)
+		// Come back
+		now = now.Add(time.Minute)
+		lastPing = now
+		got = append(got, alerts.Evaluate(alert, lastPing, now)...)
+	}
+	want := []alerts.Event{alerts.EventOffline, alerts.EventOnline, alerts.EventOffline, alerts.EventFlapping}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	if !alerts.Flapping(alert, now) {
+		t.Fatal("expected the repeater to be flapping")
+	}
+
+	// The last state the subscriber heard was offline, once stable they hear it is online
+	if events := alerts.Evaluate(alert, now.Add(alerts.FlapWindow/2), now.Add(alerts.FlapWindow/2)); len(events) != 0 {
+		t.Fatalf("expected no events while damped, got %v", events)
+	}
+	lastPing = now.Add(alerts.FlapWindow)
+	events := alerts.Evaluate(alert, lastPing, lastPing)
+	if !reflect.DeepEqual(events, []alerts.Event{alerts.EventOnline}) {
+		t.Fatalf("expected online event once stable, got %v", events)
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		alert models.RepeaterAlert
+		want  error
+	}{
+		{models.RepeaterAlert{Timezone: "UTC"}, nil},
+		{models.RepeaterAlert{QuietHoursStart: "23:00", QuietHoursEnd: "06:00", Timezone: "Europe/London"}, nil},
+		{models.RepeaterAlert{QuietHoursStart: "23:00", Timezone: "UTC"}, alerts.ErrInvalidQuietHours},
+		{models.RepeaterAlert{QuietHoursStart: "24:00", QuietHoursEnd: "06:00", Timezone: "UTC"}, alerts.ErrInvalidQuietHours},
+		{models.RepeaterAlert{QuietHoursStart: "7:00", QuietHoursEnd: "06:00", Timezone: "UTC"}, alerts.ErrInvalidQuietHours},
+		{models.RepeaterAlert{Timezone: "Mars/Olympus_Mons"}, alerts.ErrInvalidTimezone},
+	}
+	for _, tt := range tests {
+		err := alerts.ValidateSchedule(tt.alert)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("ValidateSchedule(%+v) = %v, want %v", tt.alert, err, tt.want)
+		}
+	}
+}
+
+func TestCheckRecordsWebhook(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	db := testutils.OpenDB(t)
+	repeater := models.Repeater{OwnerID: 3191868, LastPing: start}
+	repeater.ID = 319186801
+	repeater.Callsign = "KI5VMF"
+	if err := db.Create(&models.User{ID: 3191868, Callsign: "KI5VMF", Username: "jacob"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&repeater).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Alert webhooks are chosen by users, so the loopback test server must be refused
+	alert := models.RepeaterAlert{RepeaterID: repeater.ID, UserID: 3191868, OfflineAfterMinutes: 5, WebhookURL: server.URL, WebhookSecret: "whsec_test", Timezone: "UTC"}
+	if err := db.Create(&alert).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	alerts.Check(context.Background(), db, start.Add(10*time.Minute))
+	var deliveries []models.WebhookDelivery
+	if err := db.Find(&deliveries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("expected one delivery, got %d", len(deliveries))
+	}
+	delivery := deliveries[0]
+	if delivery.RepeaterAlertID != alert.ID || delivery.Event != alerts.WebhookEvent(alerts.EventOffline) || delivery.Attempts != 1 {
+		t.Errorf("unexpected delivery %+v", delivery)
+	}
+	if !strings.Contains(delivery.Error, webhooks.ErrPrivateDestination.Error()) {
+		t.Errorf("expected the loopback address to be refused, got %q", delivery.Error)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("expected no request to reach the loopback server, got %d", calls.Load())
+	}
+	var envelope struct {
+		Data alerts.Payload `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(delivery.Payload), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Data.Event != alerts.EventOffline || envelope.Data.RepeaterID != repeater.ID || envelope.Data.Callsign != "KI5VMF" {
+		t.Errorf("unexpected payload %s", delivery.Payload)
+	}
+
+	// The state was stored, so a second check sends nothing
+	alerts.Check(context.Background(), db, start.Add(11*time.Minute))
+	var count int64
+	db.Model(&models.WebhookDelivery{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("expected no second delivery, got %d", count)
+	}
+}
@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/smtp"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
+	"gorm.io/gorm"
+)
+
+// Payload is the data of the webhook envelope posted for alert events.
+type Payload struct {
+	Event      Event     `json:"event"`
+	RepeaterID uint      `json:"repeater_id"`
+	Callsign   string    `json:"callsign"`
+	LastPing   time.Time `json:"last_ping_time"`
+	Time       time.Time `json:"time"`
+}
+
+// Check evaluates every alert subscription against the repeaters' last
+// keepalives and sends the alerts that are due.
+// It is safe to run on several instances at once, each state change is only
+// handled by the instance that stores it.
+func Check(ctx context.Context, db *gorm.DB, now time.Time) {
+	subscriptions, err := models.ListRepeaterAlerts(db)
+	if err != nil {
+		logging.Errorf("Error listing repeater alerts: %v", err)
+		return
+	}
+	for i := range subscriptions {
+		alert := &subscriptions[i]
+		if alert.Repeater.ID == 0 {
+			// Repeater has been deleted
+			continue
+		}
+		before := *alert
+		events := Evaluate(alert, alert.Repeater.LastPing, now)
+		if alert.Offline == before.Offline && alert.NotifiedOffline == before.NotifiedOffline {
+			continue
+		}
+		stored, err := models.SaveRepeaterAlertState(db, alert)
+		if err != nil {
+			logging.Errorf("Error saving repeater alert %d: %v", alert.ID, err)
+			continue
+		}
+		if !stored {
+			continue
+		}
+		for _, event := range events {
+			Send(ctx, db, alert, event, now)
+		}
+	}
+}
+
+// WebhookEvent is the webhook event name of an alert event, e.g. alert.offline.
+func WebhookEvent(event Event) string {
+	return "alert." + string(event)
+}
+
+// Send delivers an event to every channel the subscriber has enabled.
+// Webhook deliveries are recorded in the delivery log and retried like admin webhooks.
+func Send(ctx context.Context, db *gorm.DB, alert *models.RepeaterAlert, event Event, now time.Time) {
+	if alert.Email {
+		err := sendEmail(alert, event)
+		if err != nil {
+			logging.Errorf("Error sending repeater %d %s alert email to user %d: %v", alert.RepeaterID, event, alert.UserID, err)
+		}
+	}
+	if alert.WebhookURL != "" {
+		webhooks.EmitAlert(ctx, db, *alert, WebhookEvent(event), Payload{
+			Event:      event,
+			RepeaterID: alert.RepeaterID,
+			Callsign:   alert.Repeater.Callsign,
+			LastPing:   alert.Repeater.LastPing,
+			Time:       now,
+		})
+	}
+}
+
+func sendEmail(alert *models.RepeaterAlert, event Event) error {
+	if !config.GetConfig().EnableEmail || alert.User.Email == "" || !alert.User.EmailVerified {
+		return nil
+	}
+	name := fmt.Sprintf("%s (%d)", html.EscapeString(alert.Repeater.Callsign), alert.RepeaterID)
+	var subject, body string
+	switch event {
+	case EventOffline:
+		subject = fmt.Sprintf("Repeater %s is offline", alert.Repeater.Callsign)
+		body = fmt.Sprintf("Repeater %s has not sent a keepalive since %s.", name, alert.Repeater.LastPing.UTC().Format(time.RFC1123))
+	case EventOnline:
+		subject = fmt.Sprintf("Repeater %s is back online", alert.Repeater.Callsign)
+		body = fmt.Sprintf("Repeater %s has reconnected.", name)
+	case EventFlapping:
+		subject = fmt.Sprintf("Repeater %s is flapping", alert.Repeater.Callsign)
+		body = fmt.Sprintf("Repeater %s keeps going offline and coming back. Alerts are paused until it has been stable for %d minutes.", name, int(FlapWindow.Minutes()))
+	}
+	return smtp.Send( //nolint:golint,wrapcheck
+		alert.User.Email,
+		subject,
+		fmt.Sprintf("Hi %s,<br><br>%s<br><br><a href=\"%s/repeaters\">Click here</a> to see your repeaters.", html.EscapeString(alert.User.Callsign), body, config.GetConfig().CanonicalHost),
+	)
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package audit records administrative actions taken through the API.
+package audit
+
+import (
+	"encoding/json"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Actions
+const (
+	ActionUserPromote        = "user.promote"
+	ActionUserDemote         = "user.demote"
+	ActionUserApprove        = "user.approve"
+	ActionUserSuspend        = "user.suspend"
+	ActionUserUnsuspend      = "user.unsuspend"
+	ActionUserUpdate         = "user.update"
+	ActionUserDelete         = "user.delete"
+	ActionTalkgroupCreate    = "talkgroup.create"
+	ActionTalkgroupUpdate    = "talkgroup.update"
+	ActionTalkgroupAdmins    = "talkgroup.admins"
+	ActionTalkgroupNCOs      = "talkgroup.ncos"
+	ActionTalkgroupDelete    = "talkgroup.delete"
+	ActionPeerCreate         = "peer.create"
+	ActionPeerDelete         = "peer.delete"
+	ActionRepeaterLink       = "repeater.link"
+	ActionRepeaterUnlink     = "repeater.unlink"
+	ActionRepeaterTalkgroups = "repeater.talkgroups"
+	ActionRepeaterMapPrivacy = "repeater.map_privacy"
+	ActionRepeaterDelete     = "repeater.delete"
+	ActionWebhookCreate      = "webhook.create"
+	ActionWebhookUpdate      = "webhook.update"
+	ActionWebhookDelete      = "webhook.delete"
+)
+
+// Target types
+const (
+	TargetUser      = "user"
+	TargetTalkgroup = "talkgroup"
+	TargetPeer      = "peer"
+	TargetRepeater  = "repeater"
+	TargetWebhook   = "webhook"
+)
+
+// Record writes an audit log entry for an action taken by the logged in user
+// of the request. Actions are recorded whoever takes them, so owners changing
+// their own repeaters or account show up alongside admins acting for them.
+// before and after are marshalled to JSON and may be nil.
+// The action has already happened, so a failure to record it is only logged.
+func Record(c *gin.Context, db *gorm.DB, action string, targetType string, targetID uint, before any, after any) {
+	actorID, ok := sessions.Default(c).Get("user_id").(uint)
+	if !ok {
+		logging.Errorf("Audit: %s on %s %d has no logged in user", action, targetType, targetID)
+		return
+	}
+	entry := models.AuditLog{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         c.ClientIP(),
+		Before:     marshal(before),
+		After:      marshal(after),
+	}
+	err := db.Create(&entry).Error
+	if err != nil {
+		logging.Errorf("Audit: Error recording %s on %s %d: %v", action, targetType, targetID, err)
+	}
+}
+
+func marshal(value any) string {
+	if value == nil {
+		return ""
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		logging.Errorf("Audit: Error marshalling value: %v", err)
+		return ""
+	}
+	return string(data)
+}
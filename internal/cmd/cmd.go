@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrMissingFlag    = errors.New("missing required flag")
+	ErrNotFound       = errors.New("not found")
+)
+
+// Env is what a subcommand runs against.
+type Env struct {
+	Out io.Writer
+	// OpenDB connects to the database without migrating it.
+	OpenDB func() *gorm.DB
+}
+
+type command struct {
+	usage string
+	run   func(env Env, args []string) error
+}
+
+func commands() map[string]command {
+	return map[string]command{
+		"migrate":             {"Run all pending migrations", runMigrate},
+		"migrate rollback":    {"Roll back the most recently applied migration", runMigrateRollback},
+		"user create":         {"Create an approved user", runUserCreate},
+		"user promote":        {"Make a user an admin", runUserPromote},
+		"user reset-password": {"Reset a user's password", runUserResetPassword},
+		"user reset-totp":     {"Disable a user's two-factor authentication", runUserResetTOTP},
+		"repeater list":       {"List all repeaters", runRepeaterList},
+		"repeater delete":     {"Delete a repeater", runRepeaterDelete},
+		"talkgroup import":    {"Import talkgroups from a CSV file of id,name,description", runTalkgroupImport},
+		"network export":      {"Export the network configuration as JSON or YAML", runNetworkExport},
+		"network import":      {"Import a network configuration export", runNetworkImport},
+		"db prune":            {"Delete call history older than a given age", runDBPrune},
+		"config check":        {"Validate the configuration and exit", runConfigCheck},
+	}
+}
+
+// IsCommand reports whether args start with an administrative subcommand.
+func IsCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	for name := range commands() {
+		if strings.Split(name, " ")[0] == args[0] {
+			return true
+		}
+	}
+	return args[0] == "help"
+}
+
+// Run executes the administrative subcommand given in args.
+func Run(env Env, args []string) error {
+	cmds := commands()
+	if len(args) >= 2 {
+		if cmd, ok := cmds[args[0]+" "+args[1]]; ok {
+			return cmd.run(env, args[2:])
+		}
+	}
+	if len(args) >= 1 {
+		if cmd, ok := cmds[args[0]]; ok {
+			return cmd.run(env, args[1:])
+		}
+		if args[0] == "help" {
+			printUsage(env.Out)
+			return nil
+		}
+	}
+	printUsage(env.Out)
+	return fmt.Errorf("%w: %s", ErrUnknownCommand, strings.Join(args, " "))
+}
+
+func printUsage(out io.Writer) {
+	cmds := commands()
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(out, "Usage: DMRHub [command] [flags]")
+	fmt.Fprintln(out, "\nWith no command, the server is started.\n\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-22s %s\n", name, cmds[name].usage)
+	}
+	fmt.Fprintln(out, "\nRun a command with -h to see its flags.")
+}
+
+func newFlagSet(env Env, name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(env.Out)
+	return flags
+}
+
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package cmd_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/cmd"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"gorm.io/gorm"
+)
+
+func makeEnv(t *testing.T) (cmd.Env, *bytes.Buffer, *gorm.DB) {
+	t.Helper()
+	database := testutils.OpenDB(t)
+	out := new(bytes.Buffer)
+	return cmd.Env{Out: out, OpenDB: func() *gorm.DB { return database }}, out, database
+}
+
+func TestIsCommand(t *testing.T) {
+	t.Parallel()
+	if cmd.IsCommand(nil) {
+		t.Error("Expected no arguments to start the server")
+	}
+	if !cmd.IsCommand([]string{"user", "create"}) {
+		t.Error("Expected user create to be a command")
+	}
+	if cmd.IsCommand([]string{"bogus"}) {
+		t.Error("Expected bogus not to be a command")
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	t.Parallel()
+	env, _, _ := makeEnv(t)
+	err := cmd.Run(env, []string{"user", "bogus"})
+	if !errors.Is(err, cmd.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand, got %v", err)
+	}
+}
+
+func TestUserCreateAndPromote(t *testing.T) {
+	t.Parallel()
+	env, out, database := makeEnv(t)
+
+	err := cmd.Run(env, []string{"user", "create", "-id", "3191868", "-callsign", "ki5vmf", "-username", "jacob", "-password", "hunter22"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user, err := models.FindUserByID(database, 3191868)
+	if err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+	if user.Callsign != "KI5VMF" || !user.Approved || user.Admin {
+		t.Errorf("Unexpected user %+v", user)
+	}
+
+	err = cmd.Run(env, []string{"user", "promote", "-username", "jacob"})
+	if err != nil {
+		t.Fatalf("Failed to promote user: %v", err)
+	}
+	user, err = models.FindUserByID(database, 3191868)
+	if err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+	if !user.Admin {
+		t.Error("Expected user to be an admin")
+	}
+
+	err = cmd.Run(env, []string{"user", "reset-password", "-username", "jacob"})
+	if err != nil {
+		t.Fatalf("Failed to reset password: %v", err)
+	}
+	if !strings.Contains(out.String(), "New password for jacob: ") {
+		t.Errorf("Expected generated password in output, got %q", out.String())
+	}
+
+	err = cmd.Run(env, []string{"user", "promote", "-username", "nobody"})
+	if !errors.Is(err, cmd.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestUserCreateMissingFlag(t *testing.T) {
+	t.Parallel()
+	env, _, _ := makeEnv(t)
+	err := cmd.Run(env, []string{"user", "create", "-id", "3191868"})
+	if !errors.Is(err, cmd.ErrMissingFlag) {
+		t.Errorf("Expected ErrMissingFlag, got %v", err)
+	}
+}
+
+func TestUserCreateInvalid(t *testing.T) {
+	t.Parallel()
+	env, _, database := makeEnv(t)
+	for _, args := range [][]string{
+		{"-id", "319186", "-callsign", "ki5vmf", "-username", "jacob", "-password", "hunter22"},
+		{"-id", "3191868", "-callsign", "n0call", "-username", "jacob", "-password", "hunter22"},
+		{"-id", "3191868", "-callsign", "ki5vmf", "-username", "j", "-password", "hunter22"},
+	} {
+		err := cmd.Run(env, append([]string{"user", "create"}, args...))
+		if !errors.Is(err, cmd.ErrInvalidUser) {
+			t.Errorf("%v: expected ErrInvalidUser, got %v", args, err)
+		}
+	}
+	if exists, _ := models.UserIDExists(database, 3191868); exists {
+		t.Error("Expected no user to be created")
+	}
+}
+
+func TestDBPrune(t *testing.T) {
+	t.Parallel()
+	env, out, database := makeEnv(t)
+	talkgroupID := uint(3100)
+	userID := uint(3140598)
+	old := time.Now().Add(-48 * time.Hour)
+	calls := []models.Call{
+		{StartTime: old, Duration: 10 * time.Second, UserID: 3191868, RepeaterID: 311860, GroupCall: true, IsToTalkgroup: true, ToTalkgroupID: &talkgroupID, DestinationID: talkgroupID},
+		{StartTime: old, Duration: 5 * time.Second, UserID: 3191868, RepeaterID: 311860, IsToUser: true, ToUserID: &userID, DestinationID: userID},
+		{StartTime: time.Now(), Duration: 5 * time.Second, UserID: 3191868, RepeaterID: 311860, GroupCall: true, IsToTalkgroup: true, ToTalkgroupID: &talkgroupID, DestinationID: talkgroupID},
+	}
+	if err := database.Create(&calls).Error; err != nil {
+		t.Fatalf("Failed to create calls: %v", err)
+	}
+
+	if err := cmd.Run(env, []string{"db", "prune", "-older-than", "24h"}); err != nil {
+		t.Fatalf("Failed to prune calls: %v", err)
+	}
+	if !strings.Contains(out.String(), "Deleted 2 calls") {
+		t.Errorf("Expected two calls to be deleted, got %q", out.String())
+	}
+	var remaining int64
+	if err := database.Model(&models.Call{}).Count(&remaining).Error; err != nil {
+		t.Fatalf("Failed to count calls: %v", err)
+	}
+	if remaining != 1 {
+		t.Errorf("Expected the recent call to be kept, got %d calls", remaining)
+	}
+	// The pruned calls still count towards the statistics
+	var summaries []models.CallSummary
+	if err := database.Where("subject_type = ?", models.SummaryUser).Find(&summaries).Error; err != nil {
+		t.Fatalf("Failed to find call summaries: %v", err)
+	}
+	var summarized uint
+	for _, summary := range summaries {
+		summarized += summary.Calls
+	}
+	if summarized != 2 {
+		t.Errorf("Expected the pruned calls to be summarized, got %+v", summaries)
+	}
+}
+
+func TestTalkgroupImport(t *testing.T) {
+	t.Parallel()
+	env, _, database := makeEnv(t)
+
+	file := filepath.Join(t.TempDir(), "talkgroups.csv")
+	csv := "id,name,description\n1,Local,Local traffic\n91,Worldwide,\n"
+	if err := os.WriteFile(file, []byte(csv), 0o600); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+	if err := cmd.Run(env, []string{"talkgroup", "import", "-file", file}); err != nil {
+		t.Fatalf("Failed to import talkgroups: %v", err)
+	}
+
+	// Importing again updates existing rows rather than failing
+	csv = "1,Local,Renamed\n"
+	if err := os.WriteFile(file, []byte(csv), 0o600); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+	if err := cmd.Run(env, []string{"talkgroup", "import", "-file", file}); err != nil {
+		t.Fatalf("Failed to re-import talkgroups: %v", err)
+	}
+
+	talkgroup, err := models.FindTalkgroupByID(database, 1)
+	if err != nil {
+		t.Fatalf("Failed to find talkgroup: %v", err)
+	}
+	if talkgroup.Description != "Renamed" {
+		t.Errorf("Expected description to be updated, got %q", talkgroup.Description)
+	}
+	if _, err := models.FindTalkgroupByID(database, 91); err != nil {
+		t.Errorf("Expected talkgroup 91 to exist: %v", err)
+	}
+}
+
+func TestTalkgroupImportInvalid(t *testing.T) {
+	t.Parallel()
+	env, _, _ := makeEnv(t)
+
+	file := filepath.Join(t.TempDir(), "talkgroups.csv")
+	if err := os.WriteFile(file, []byte("abc,Bad\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+	err := cmd.Run(env, []string{"talkgroup", "import", "-file", file})
+	if !errors.Is(err, cmd.ErrInvalidCSV) {
+		t.Errorf("Expected ErrInvalidCSV, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/retention"
+)
+
+func runDBPrune(env Env, args []string) error {
+	flags := newFlagSet(env, "db prune")
+	olderThan := flags.Duration("older-than", 0, "Delete calls that started longer ago than this, e.g. 720h")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *olderThan <= 0 {
+		return fmt.Errorf("%w: -older-than", ErrMissingFlag)
+	}
+
+	database, err := openMigratedDB(env)
+	if err != nil {
+		return err
+	}
+
+	// Deleted calls are added to the call summaries, so the statistics keep them
+	cutoff := time.Now().Add(-*olderThan)
+	deleted := 0
+	for _, groupCall := range []bool{true, false} {
+		count, err := retention.DeleteCalls(database, groupCall, cutoff)
+		deleted += count
+		if err != nil {
+			return fmt.Errorf("could not prune calls: %w", err)
+		}
+	}
+	fmt.Fprintf(env.Out, "Deleted %d calls older than %s\n", deleted, cutoff.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db"
+	"github.com/USA-RedDragon/DMRHub/internal/db/migration"
+	"gorm.io/gorm"
+)
+
+func runMigrate(env Env, args []string) error {
+	flags := newFlagSet(env, "migrate")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	err := db.Migrate(env.OpenDB())
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	fmt.Fprintln(env.Out, "Migrations applied")
+	return nil
+}
+
+func runMigrateRollback(env Env, args []string) error {
+	flags := newFlagSet(env, "migrate rollback")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	err := migration.RollbackLast(env.OpenDB())
+	if err != nil {
+		return fmt.Errorf("migrate rollback: %w", err)
+	}
+	fmt.Fprintln(env.Out, "Rolled back the last migration")
+	return nil
+}
+
+func runConfigCheck(env Env, args []string) error {
+	flags := newFlagSet(env, "config check")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	err := config.Load()
+	if err != nil {
+		return fmt.Errorf("config check: %w", err)
+	}
+	fmt.Fprintln(env.Out, "Configuration is valid")
+	return nil
+}
+
+// openMigratedDB opens the database and makes sure the schema is current.
+func openMigratedDB(env Env) (*gorm.DB, error) {
+	database := env.OpenDB()
+	err := db.Migrate(database)
+	if err != nil {
+		return nil, fmt.Errorf("could not migrate database: %w", err)
+	}
+	return database, nil
+}
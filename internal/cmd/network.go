@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/USA-RedDragon/DMRHub/internal/netconfig"
+)
+
+func runNetworkExport(env Env, args []string) error {
+	flags := newFlagSet(env, "network export")
+	file := flags.String("file", "", "File to write to, stdout if empty")
+	format := flags.String("format", "", "json or yaml, guessed from the file extension if empty")
+	includeSecrets := flags.Bool("include-secrets", false, "Include user password hashes and repeater and peer passwords")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	database, err := openMigratedDB(env)
+	if err != nil {
+		return err
+	}
+
+	doc, err := netconfig.Export(database, *includeSecrets)
+	if err != nil {
+		return fmt.Errorf("network export: %w", err)
+	}
+
+	var out io.Writer = env.Out
+	if *file != "" {
+		f, err := os.OpenFile(*file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("could not create export file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	err = netconfig.Encode(out, doc, documentFormat(*format, *file))
+	if err != nil {
+		return fmt.Errorf("network export: %w", err)
+	}
+	return nil
+}
+
+func runNetworkImport(env Env, args []string) error {
+	flags := newFlagSet(env, "network import")
+	file := flags.String("file", "", "File to import")
+	format := flags.String("format", "", "json or yaml, guessed from the file extension if empty")
+	mode := flags.String("mode", string(netconfig.ModeMerge), "merge keeps anything not in the file, replace deletes it")
+	dryRun := flags.Bool("dry-run", false, "Report what would change without changing anything")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("%w: -file", ErrMissingFlag)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("could not open import file: %w", err)
+	}
+	defer f.Close()
+
+	doc, err := netconfig.Decode(f, documentFormat(*format, *file))
+	if err != nil {
+		return fmt.Errorf("network import: %w", err)
+	}
+
+	database, err := openMigratedDB(env)
+	if err != nil {
+		return err
+	}
+
+	report, err := netconfig.Import(database, doc, netconfig.Mode(*mode), *dryRun)
+	if err != nil {
+		return fmt.Errorf("network import: %w", err)
+	}
+
+	if report.DryRun {
+		fmt.Fprintln(env.Out, "Dry run, nothing was changed")
+	}
+	for _, row := range []struct {
+		name   string
+		counts netconfig.Counts
+	}{
+		{"Users", report.Users},
+		{"Talkgroups", report.Talkgroups},
+		{"Repeaters", report.Repeaters},
+		{"Peers", report.Peers},
+	} {
+		fmt.Fprintf(env.Out, "%-11s %d created, %d updated, %d deleted\n", row.name+":", row.counts.Created, row.counts.Updated, row.counts.Deleted)
+	}
+	return nil
+}
+
+func documentFormat(format, file string) netconfig.Format {
+	if format != "" {
+		return netconfig.Format(format)
+	}
+	return netconfig.FormatFromPath(file)
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package cmd
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+)
+
+func runRepeaterList(env Env, args []string) error {
+	flags := newFlagSet(env, "repeater list")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	database, err := openMigratedDB(env)
+	if err != nil {
+		return err
+	}
+
+	repeaters, err := models.ListRepeaters(database)
+	if err != nil {
+		return fmt.Errorf("could not list repeaters: %w", err)
+	}
+
+	const padding = 2
+	w := tabwriter.NewWriter(env.Out, 0, 0, padding, ' ', 0)
+	fmt.Fprintln(w, "ID\tCALLSIGN\tOWNER\tHOTSPOT\tLAST PING")
+	for _, repeater := range repeaters {
+		lastPing := "never"
+		if !repeater.LastPing.IsZero() {
+			lastPing = repeater.LastPing.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%t\t%s\n", repeater.ID, repeater.Callsign, repeater.Owner.Callsign, repeater.Hotspot, lastPing)
+	}
+	return w.Flush() //nolint:golint,wrapcheck
+}
+
+func runRepeaterDelete(env Env, args []string) error {
+	flags := newFlagSet(env, "repeater delete")
+	id := flags.Uint("id", 0, "ID of the repeater")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *id == 0 {
+		return fmt.Errorf("%w: -id", ErrMissingFlag)
+	}
+
+	database, err := openMigratedDB(env)
+	if err != nil {
+		return err
+	}
+
+	exists, err := models.RepeaterIDExists(database, *id)
+	if err != nil {
+		return fmt.Errorf("could not look up repeater: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("repeater %w", ErrNotFound)
+	}
+
+	err = models.DeleteRepeater(database, *id)
+	if err != nil {
+		return fmt.Errorf("could not delete repeater: %w", err)
+	}
+	fmt.Fprintf(env.Out, "Deleted repeater %d\n", *id)
+	return nil
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package cmd
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidCSV = errors.New("invalid talkgroup CSV")
+
+func runTalkgroupImport(env Env, args []string) error {
+	flags := newFlagSet(env, "talkgroup import")
+	file := flags.String("file", "", "CSV file with id,name,description rows")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("%w: -file", ErrMissingFlag)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("could not open talkgroup file: %w", err)
+	}
+	defer f.Close()
+
+	talkgroups, err := readTalkgroups(f)
+	if err != nil {
+		return err
+	}
+
+	database, err := openMigratedDB(env)
+	if err != nil {
+		return err
+	}
+
+	if len(talkgroups) > 0 {
+		err = database.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"name", "description", "updated_at"}),
+		}).Create(&talkgroups).Error
+		if err != nil {
+			return fmt.Errorf("could not import talkgroups: %w", err)
+		}
+	}
+	fmt.Fprintf(env.Out, "Imported %d talkgroups\n", len(talkgroups))
+	return nil
+}
+
+// readTalkgroups parses id,name,description rows. A leading header row is skipped.
+func readTalkgroups(r io.Reader) ([]models.Talkgroup, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var talkgroups []models.Talkgroup
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCSV, err)
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "id") {
+			continue
+		}
+
+		const minFields = 2
+		if len(record) < minFields {
+			return nil, fmt.Errorf("%w: line %d needs at least an id and a name", ErrInvalidCSV, line)
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(record[0]), 10, 32)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("%w: line %d has an invalid id %q", ErrInvalidCSV, line, record[0])
+		}
+		talkgroup := models.Talkgroup{
+			ID:   uint(id),
+			Name: strings.TrimSpace(record[1]),
+		}
+		if len(record) > minFields {
+			talkgroup.Description = strings.TrimSpace(record[2])
+		}
+		talkgroups = append(talkgroups, talkgroup)
+	}
+	return talkgroups, nil
+}
@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/utils"
+	"github.com/USA-RedDragon/DMRHub/internal/userdb"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidUser = errors.New("invalid user")
+
+func runUserCreate(env Env, args []string) error {
+	flags := newFlagSet(env, "user create")
+	id := flags.Uint("id", 0, "DMR radio ID of the user")
+	callsign := flags.String("callsign", "", "Callsign of the user")
+	username := flags.String("username", "", "Username to log in with")
+	password := flags.String("password", "", "Password to log in with")
+	admin := flags.Bool("admin", false, "Make the user an admin")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	switch {
+	case *id == 0:
+		return fmt.Errorf("%w: -id", ErrMissingFlag)
+	case *callsign == "":
+		return fmt.Errorf("%w: -callsign", ErrMissingFlag)
+	case *username == "":
+		return fmt.Errorf("%w: -username", ErrMissingFlag)
+	case *password == "":
+		return fmt.Errorf("%w: -password", ErrMissingFlag)
+	}
+	// Apply the same rules as registering through the API
+	if !userdb.IsValidUserID(*id) {
+		return fmt.Errorf("%w: DMR ID is not valid", ErrInvalidUser)
+	}
+	if !userdb.ValidUserCallsign(*id, *callsign) {
+		return fmt.Errorf("%w: callsign does not match DMR ID", ErrInvalidUser)
+	}
+	registration := apimodels.UserRegistration{Username: *username}
+	if valid, reason := registration.IsValidUsername(); !valid {
+		return fmt.Errorf("%w: %s", ErrInvalidUser, reason)
+	}
+	err := utils.CheckNewPassword(*password, config.GetConfig().HIBPAPIKey)
+	if err != nil {
+		return fmt.Errorf("password not accepted: %w", err)
+	}
+
+	database, err := openMigratedDB(env)
+	if err != nil {
+		return err
+	}
+
+	user := models.User{
+		ID:       *id,
+		Callsign: strings.ToUpper(*callsign),
+		Username: *username,
+		Password: utils.HashPassword(*password, config.GetConfig().PasswordSalt),
+		Admin:    *admin,
+		Approved: true,
+	}
+	err = database.Create(&user).Error
+	if err != nil {
+		return fmt.Errorf("could not create user: %w", err)
+	}
+	fmt.Fprintf(env.Out, "Created user %s (%d)\n", user.Username, user.ID)
+	return nil
+}
+
+func runUserPromote(env Env, args []string) error {
+	flags := newFlagSet(env, "user promote")
+	id := flags.Uint("id", 0, "DMR radio ID of the user")
+	username := flags.String("username", "", "Username of the user")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *id == 0 && *username == "" {
+		return fmt.Errorf("%w: -id or -username", ErrMissingFlag)
+	}
+
+	database, err := openMigratedDB(env)
+	if err != nil {
+		return err
+	}
+
+	user, err := findUser(database, *id, *username)
+	if err != nil {
+		return err
+	}
+	err = database.Model(&user).Update("admin", true).Error
+	if err != nil {
+		return fmt.Errorf("could not promote user: %w", err)
+	}
+	fmt.Fprintf(env.Out, "User %s (%d) is now an admin\n", user.Username, user.ID)
+	return nil
+}
+
+func runUserResetPassword(env Env, args []string) error {
+	flags := newFlagSet(env, "user reset-password")
+	id := flags.Uint("id", 0, "DMR radio ID of the user")
+	username := flags.String("username", "", "Username of the user")
+	password := flags.String("password", "", "New password, generated and printed if empty")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *id == 0 && *username == "" {
+		return fmt.Errorf("%w: -id or -username", ErrMissingFlag)
+	}
+
+	database, err := openMigratedDB(env)
+	if err != nil {
+		return err
+	}
+
+	user, err := findUser(database, *id, *username)
+	if err != nil {
+		return err
+	}
+
+	newPassword := *password
+	generated := newPassword == ""
+	if generated {
+		const (
+			length     = 15
+			minNumbers = 4
+			minSpecial = 2
+		)
+		newPassword, err = utils.RandomPassword(length, minNumbers, minSpecial)
+		if err != nil {
+			return fmt.Errorf("could not generate password: %w", err)
+		}
+	} else {
+		err = utils.CheckNewPassword(newPassword, config.GetConfig().HIBPAPIKey)
+		if err != nil {
+			return fmt.Errorf("password not accepted: %w", err)
+		}
+	}
+
+	err = database.Model(&user).Update("password", utils.HashPassword(newPassword, config.GetConfig().PasswordSalt)).Error
+	if err != nil {
+		return fmt.Errorf("could not reset password: %w", err)
+	}
+	if generated {
+		fmt.Fprintf(env.Out, "New password for %s: %s\n", user.Username, newPassword)
+	} else {
+		fmt.Fprintf(env.Out, "Password for %s has been reset\n", user.Username)
+	}
+	return nil
+}
+
+func runUserResetTOTP(env Env, args []string) error {
+	flags := newFlagSet(env, "user reset-totp")
+	id := flags.Uint("id", 0, "DMR radio ID of the user")
+	username := flags.String("username", "", "Username of the user")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *id == 0 && *username == "" {
+		return fmt.Errorf("%w: -id or -username", ErrMissingFlag)
+	}
+
+	database, err := openMigratedDB(env)
+	if err != nil {
+		return err
+	}
+
+	user, err := findUser(database, *id, *username)
+	if err != nil {
+		return err
+	}
+
+	err = database.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Updates(map[string]any{"totp_enabled": false, "totp_secret": "", "totp_last_step": 0}).Error
+		if err != nil {
+			return err
+		}
+		return models.ReplaceRecoveryCodes(tx, user.ID, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("could not reset two-factor authentication: %w", err)
+	}
+	fmt.Fprintf(env.Out, "Two-factor authentication for %s has been disabled\n", user.Username)
+	return nil
+}
+
+func findUser(database *gorm.DB, id uint, username string) (models.User, error) {
+	var user models.User
+	query := database
+	if id != 0 {
+		query = query.Where("id = ?", id)
+	}
+	if username != "" {
+		query = query.Where("username = ?", username)
+	}
+	err := query.First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, fmt.Errorf("user %w", ErrNotFound)
+	} else if err != nil {
+		return user, fmt.Errorf("could not find user: %w", err)
+	}
+	return user, nil
+}
@@ -21,8 +21,11 @@ package config
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -35,8 +38,14 @@ import (
 
 // Config stores the application configuration.
 type Config struct {
+	Store                    string
+	Ingress                  string
+	IngressShards            int
+	IngressBacklog           int
 	RedisHost                string
 	RedisPassword            string
+	DatabaseDriver           string
+	DatabaseDSN              string
 	PostgresDSN              string
 	postgresUser             string
 	postgresPassword         string
@@ -49,6 +58,7 @@ type Config struct {
 	ListenAddr               string
 	DMRPort                  int
 	MetricsPort              int
+	MetricsRepeaterIDLabel   bool
 	OpenBridgePort           int
 	HTTPPort                 int
 	CORSHosts                []string
@@ -71,81 +81,282 @@ type Config struct {
 	AdminEmail               string
 	EnableEmail              bool
 	CanonicalHost            string
+	OIDCIssuerURL            string
+	OIDCClientID             string
+	OIDCClientSecret         string
+	OIDCRedirectURL          string
+	OIDCScopes               []string
+	OIDCUserClaim            string
+	OIDCDMRIDClaim           string
+	OIDCCreateUsers          bool
+	OIDCUserClaimTrusted     bool
+	RequireAdminTOTP         bool
+	MQTTBroker               string
+	MQTTClientID             string
+	MQTTUsername             string
+	MQTTPassword             string
+	MQTTTopicPrefix          string
+	MQTTQoS                  int
+	MQTTRetain               bool
+	GroupCallDataRetention   int
+	PrivateCallDataRetention int
+	GroupCallRetention       int
+	PrivateCallRetention     int
 }
 
+var (
+	ErrInvalidInt        = errors.New("invalid integer")
+	ErrInvalidBool       = errors.New("invalid boolean")
+	ErrInvalidPort       = errors.New("port must be between 0 and 65535")
+	ErrInvalidListenAddr = errors.New("invalid listen address")
+	ErrInvalidCORSHost   = errors.New("CORS host must not be empty")
+	ErrInvalidSMTP       = errors.New("invalid SMTP configuration")
+	ErrInvalidOIDC       = errors.New("invalid OIDC configuration")
+	ErrInvalidMQTT       = errors.New("invalid MQTT configuration")
+	ErrInvalidRetention  = errors.New("retention days must not be negative")
+	ErrInvalidStore      = errors.New("invalid store")
+	ErrInvalidDatabase   = errors.New("invalid database configuration")
+	ErrInvalidIngress    = errors.New("invalid ingress configuration")
+)
+
+// Stores that can hold DMRHub's shared state.
+const (
+	// StoreRedis shares state between DMRHub instances through Redis
+	StoreRedis = "redis"
+	// StoreMemory keeps state in memory, for a single DMRHub instance without Redis
+	StoreMemory = "memory"
+)
+
+// Ways incoming HBRP packets reach the instance that handles them.
+const (
+	// IngressStreams shards packets over Redis Streams so each is handled by one instance
+	IngressStreams = "streams"
+	// IngressPubSub publishes packets to every instance, for a single DMRHub instance
+	IngressPubSub = "pubsub"
+)
+
+// Database drivers DMRHub can store its records in.
+const (
+	DatabasePostgres = "postgres"
+	// DatabaseMySQL also covers MariaDB
+	DatabaseMySQL = "mysql"
+	// DatabaseSQLite stores the records in a single file, for small networks
+	DatabaseSQLite = "sqlite"
+)
+
+// defaultSQLitePath is the database file used when DATABASE_DSN is not set for SQLite.
+const defaultSQLitePath = "DMRHub.db"
+
 var currentConfig atomic.Value //nolint:golint,gochecknoglobals
 var isInit atomic.Bool         //nolint:golint,gochecknoglobals
 var loaded atomic.Bool         //nolint:golint,gochecknoglobals
 
-func loadConfig() Config {
-	portStr := os.Getenv("PG_PORT")
-	pgPort, err := strconv.ParseInt(portStr, 10, 0)
-	if err != nil {
-		pgPort = 0
-	}
+const maxPort = 65535
 
-	portStr = os.Getenv("DMR_PORT")
-	dmrPort, err := strconv.ParseInt(portStr, 10, 0)
-	if err != nil {
-		dmrPort = 0
+func envString(name string, dest *string) {
+	if value := os.Getenv(name); value != "" {
+		*dest = value
 	}
+}
 
-	portStr = os.Getenv("HTTP_PORT")
-	httpPort, err := strconv.ParseInt(portStr, 10, 0)
+func envInt(name string, dest *int) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 0)
 	if err != nil {
-		httpPort = 0
+		return fmt.Errorf("%w: %s=%q", ErrInvalidInt, name, value)
 	}
+	*dest = int(parsed)
+	return nil
+}
 
-	portStr = os.Getenv("OPENBRIDGE_PORT")
-	openBridgePort, err := strconv.ParseInt(portStr, 10, 0)
+func envBool(name string, dest *bool) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(value)
 	if err != nil {
-		openBridgePort = 0
+		return fmt.Errorf("%w: %s=%q", ErrInvalidBool, name, value)
 	}
+	*dest = parsed
+	return nil
+}
 
-	portStr = os.Getenv("METRICS_PORT")
-	metricsPort, err := strconv.ParseInt(portStr, 10, 0)
-	if err != nil {
-		metricsPort = 0
+// envList reads a comma separated list.
+func envList(name string, dest *[]string) {
+	if value := os.Getenv(name); value != "" {
+		*dest = strings.Split(value, ",")
 	}
+}
 
-	portStr = os.Getenv("SMTP_PORT")
-	smtpPort, err := strconv.ParseInt(portStr, 10, 0)
+//nolint:golint,gocyclo
+func loadConfig() (Config, error) {
+	file, err := readConfigFile(os.Getenv("CONFIG_FILE"))
 	if err != nil {
-		smtpPort = 0
+		return Config{}, err
 	}
 
 	tmpConfig := Config{
-		RedisHost:                os.Getenv("REDIS_HOST"),
-		postgresUser:             os.Getenv("PG_USER"),
-		postgresPassword:         os.Getenv("PG_PASSWORD"),
-		postgresHost:             os.Getenv("PG_HOST"),
-		postgresPort:             int(pgPort),
-		postgresDatabase:         os.Getenv("PG_DATABASE"),
-		strSecret:                os.Getenv("SECRET"),
-		PasswordSalt:             os.Getenv("PASSWORD_SALT"),
-		ListenAddr:               os.Getenv("LISTEN_ADDR"),
-		DMRPort:                  int(dmrPort),
-		HTTPPort:                 int(httpPort),
-		MetricsPort:              int(metricsPort),
-		HIBPAPIKey:               os.Getenv("HIBP_API_KEY"),
-		OTLPEndpoint:             os.Getenv("OTLP_ENDPOINT"),
-		InitialAdminUserPassword: os.Getenv("INIT_ADMIN_USER_PASSWORD"),
-		RedisPassword:            os.Getenv("REDIS_PASSWORD"),
-		Debug:                    os.Getenv("DEBUG") != "",
-		NetworkName:              os.Getenv("NETWORK_NAME"),
-		AllowScraping:            os.Getenv("ALLOW_SCRAPING") != "",
-		CustomRobotsTxt:          os.Getenv("CUSTOM_ROBOTS_TXT"),
-		OpenBridgePort:           int(openBridgePort),
-		SMTPHost:                 os.Getenv("SMTP_HOST"),
-		SMTPPort:                 int(smtpPort),
-		SMTPImplicitTLS:          os.Getenv("SMTP_IMPLICIT_TLS") != "",
-		SMTPUsername:             os.Getenv("SMTP_USERNAME"),
-		SMTPPassword:             os.Getenv("SMTP_PASSWORD"),
-		SMTPFrom:                 os.Getenv("SMTP_FROM"),
-		SMTPAuthMethod:           os.Getenv("SMTP_AUTH_METHOD"),
-		AdminEmail:               os.Getenv("ADMIN_EMAIL"),
-		EnableEmail:              os.Getenv("ENABLE_EMAIL") != "",
-		CanonicalHost:            os.Getenv("CANONICAL_HOST"),
+		Store:                    file.Store,
+		Ingress:                  file.Ingress,
+		IngressShards:            file.IngressShards,
+		IngressBacklog:           file.IngressBacklog,
+		RedisHost:                file.RedisHost,
+		RedisPassword:            file.RedisPassword,
+		DatabaseDriver:           file.DatabaseDriver,
+		DatabaseDSN:              file.DatabaseDSN,
+		postgresUser:             file.PostgresUser,
+		postgresPassword:         file.PostgresPassword,
+		postgresHost:             file.PostgresHost,
+		postgresPort:             file.PostgresPort,
+		postgresDatabase:         file.PostgresDatabase,
+		strSecret:                file.Secret,
+		PasswordSalt:             file.PasswordSalt,
+		ListenAddr:               file.ListenAddr,
+		DMRPort:                  file.DMRPort,
+		MetricsPort:              file.MetricsPort,
+		MetricsRepeaterIDLabel:   file.MetricsRepeaterIDLabel,
+		OpenBridgePort:           file.OpenBridgePort,
+		HTTPPort:                 file.HTTPPort,
+		CORSHosts:                file.CORSHosts,
+		TrustedProxies:           file.TrustedProxies,
+		HIBPAPIKey:               file.HIBPAPIKey,
+		OTLPEndpoint:             file.OTLPEndpoint,
+		InitialAdminUserPassword: file.InitialAdminUserPassword,
+		Debug:                    file.Debug,
+		NetworkName:              file.NetworkName,
+		AllowScraping:            file.AllowScraping,
+		CustomRobotsTxt:          file.CustomRobotsTxt,
+		FeatureFlags:             file.FeatureFlags,
+		SMTPHost:                 file.SMTPHost,
+		SMTPPort:                 file.SMTPPort,
+		SMTPImplicitTLS:          file.SMTPImplicitTLS,
+		SMTPUsername:             file.SMTPUsername,
+		SMTPPassword:             file.SMTPPassword,
+		SMTPFrom:                 file.SMTPFrom,
+		SMTPAuthMethod:           file.SMTPAuthMethod,
+		AdminEmail:               file.AdminEmail,
+		EnableEmail:              file.EnableEmail,
+		CanonicalHost:            file.CanonicalHost,
+		OIDCIssuerURL:            file.OIDCIssuerURL,
+		OIDCClientID:             file.OIDCClientID,
+		OIDCClientSecret:         file.OIDCClientSecret,
+		OIDCRedirectURL:          file.OIDCRedirectURL,
+		OIDCScopes:               file.OIDCScopes,
+		OIDCUserClaim:            file.OIDCUserClaim,
+		OIDCDMRIDClaim:           file.OIDCDMRIDClaim,
+		OIDCCreateUsers:          file.OIDCCreateUsers,
+		OIDCUserClaimTrusted:     file.OIDCUserClaimTrusted,
+		RequireAdminTOTP:         file.RequireAdminTOTP,
+		MQTTBroker:               file.MQTTBroker,
+		MQTTClientID:             file.MQTTClientID,
+		MQTTUsername:             file.MQTTUsername,
+		MQTTPassword:             file.MQTTPassword,
+		MQTTTopicPrefix:          file.MQTTTopicPrefix,
+		MQTTQoS:                  file.MQTTQoS,
+		MQTTRetain:               file.MQTTRetain,
+		GroupCallDataRetention:   file.GroupCallDataRetention,
+		PrivateCallDataRetention: file.PrivateCallDataRetention,
+		GroupCallRetention:       file.GroupCallRetention,
+		PrivateCallRetention:     file.PrivateCallRetention,
+	}
+
+	// Environment variables take precedence over the config file
+	// STORE is where pubsub, sessions and other shared state live, redis or memory
+	envString("STORE", &tmpConfig.Store)
+	// INGRESS is streams or pubsub, and defaults to streams when the store is redis
+	envString("INGRESS", &tmpConfig.Ingress)
+	envString("REDIS_HOST", &tmpConfig.RedisHost)
+	envString("REDIS_PASSWORD", &tmpConfig.RedisPassword)
+	// DATABASE_DRIVER is postgres, mysql or sqlite. DATABASE_DSN is the connection string,
+	// or the file path for sqlite, and defaults to the PG_* settings for postgres.
+	envString("DATABASE_DRIVER", &tmpConfig.DatabaseDriver)
+	envString("DATABASE_DSN", &tmpConfig.DatabaseDSN)
+	envString("PG_USER", &tmpConfig.postgresUser)
+	envString("PG_PASSWORD", &tmpConfig.postgresPassword)
+	envString("PG_HOST", &tmpConfig.postgresHost)
+	envString("PG_DATABASE", &tmpConfig.postgresDatabase)
+	envString("SECRET", &tmpConfig.strSecret)
+	envString("PASSWORD_SALT", &tmpConfig.PasswordSalt)
+	envString("LISTEN_ADDR", &tmpConfig.ListenAddr)
+	envString("HIBP_API_KEY", &tmpConfig.HIBPAPIKey)
+	envString("OTLP_ENDPOINT", &tmpConfig.OTLPEndpoint)
+	envString("INIT_ADMIN_USER_PASSWORD", &tmpConfig.InitialAdminUserPassword)
+	envString("NETWORK_NAME", &tmpConfig.NetworkName)
+	envString("CUSTOM_ROBOTS_TXT", &tmpConfig.CustomRobotsTxt)
+	envString("SMTP_HOST", &tmpConfig.SMTPHost)
+	envString("SMTP_USERNAME", &tmpConfig.SMTPUsername)
+	envString("SMTP_PASSWORD", &tmpConfig.SMTPPassword)
+	envString("SMTP_FROM", &tmpConfig.SMTPFrom)
+	envString("SMTP_AUTH_METHOD", &tmpConfig.SMTPAuthMethod)
+	envString("ADMIN_EMAIL", &tmpConfig.AdminEmail)
+	envString("CANONICAL_HOST", &tmpConfig.CanonicalHost)
+	envString("OIDC_ISSUER_URL", &tmpConfig.OIDCIssuerURL)
+	envString("OIDC_CLIENT_ID", &tmpConfig.OIDCClientID)
+	envString("OIDC_CLIENT_SECRET", &tmpConfig.OIDCClientSecret)
+	envString("OIDC_REDIRECT_URL", &tmpConfig.OIDCRedirectURL)
+	// OIDC_USER_CLAIM is the ID token claim holding the user's callsign
+	envString("OIDC_USER_CLAIM", &tmpConfig.OIDCUserClaim)
+	// OIDC_DMR_ID_CLAIM is the ID token claim holding the user's DMR ID, used when creating users
+	envString("OIDC_DMR_ID_CLAIM", &tmpConfig.OIDCDMRIDClaim)
+	envList("OIDC_SCOPES", &tmpConfig.OIDCScopes)
+	// MQTT_BROKER is the broker URL, such as tcp://localhost:1883. MQTT publishing is disabled when unset
+	envString("MQTT_BROKER", &tmpConfig.MQTTBroker)
+	envString("MQTT_CLIENT_ID", &tmpConfig.MQTTClientID)
+	envString("MQTT_USERNAME", &tmpConfig.MQTTUsername)
+	envString("MQTT_PASSWORD", &tmpConfig.MQTTPassword)
+	envString("MQTT_TOPIC_PREFIX", &tmpConfig.MQTTTopicPrefix)
+	// CORS_HOSTS is a comma separated list of hosts that are allowed to access the API
+	envList("CORS_HOSTS", &tmpConfig.CORSHosts)
+	// FEATURE_FLAGS is a comma separated list of enabled feature flags
+	envList("FEATURE_FLAGS", &tmpConfig.FeatureFlags)
+	envList("TRUSTED_PROXIES", &tmpConfig.TrustedProxies)
+
+	errs := []error{
+		envInt("PG_PORT", &tmpConfig.postgresPort),
+		envInt("DMR_PORT", &tmpConfig.DMRPort),
+		envInt("HTTP_PORT", &tmpConfig.HTTPPort),
+		envInt("OPENBRIDGE_PORT", &tmpConfig.OpenBridgePort),
+		envInt("METRICS_PORT", &tmpConfig.MetricsPort),
+		envInt("SMTP_PORT", &tmpConfig.SMTPPort),
+		// Packets are sharded by repeater ID, and each shard keeps at most INGRESS_BACKLOG packets
+		envInt("INGRESS_SHARDS", &tmpConfig.IngressShards),
+		envInt("INGRESS_BACKLOG", &tmpConfig.IngressBacklog),
+		envBool("METRICS_REPEATER_ID_LABEL", &tmpConfig.MetricsRepeaterIDLabel),
+		envBool("DEBUG", &tmpConfig.Debug),
+		envBool("ALLOW_SCRAPING", &tmpConfig.AllowScraping),
+		envBool("SMTP_IMPLICIT_TLS", &tmpConfig.SMTPImplicitTLS),
+		envBool("ENABLE_EMAIL", &tmpConfig.EnableEmail),
+		envBool("OIDC_CREATE_USERS", &tmpConfig.OIDCCreateUsers),
+		// OIDC_USER_CLAIM_TRUSTED says the provider's admins manage the user claim, so users can't set it themselves
+		envBool("OIDC_USER_CLAIM_TRUSTED", &tmpConfig.OIDCUserClaimTrusted),
+		envBool("REQUIRE_ADMIN_TOTP", &tmpConfig.RequireAdminTOTP),
+		envInt("MQTT_QOS", &tmpConfig.MQTTQoS),
+		envBool("MQTT_RETAIN", &tmpConfig.MQTTRetain),
+		// The retention settings are in days, 0 keeps calls and their voice data forever
+		envInt("GROUP_CALL_DATA_RETENTION_DAYS", &tmpConfig.GroupCallDataRetention),
+		envInt("PRIVATE_CALL_DATA_RETENTION_DAYS", &tmpConfig.PrivateCallDataRetention),
+		envInt("GROUP_CALL_RETENTION_DAYS", &tmpConfig.GroupCallRetention),
+		envInt("PRIVATE_CALL_RETENTION_DAYS", &tmpConfig.PrivateCallRetention),
+	}
+
+	if tmpConfig.Store == "" {
+		tmpConfig.Store = StoreRedis
+	}
+	if tmpConfig.Ingress == "" {
+		tmpConfig.Ingress = IngressPubSub
+		if tmpConfig.Store == StoreRedis {
+			tmpConfig.Ingress = IngressStreams
+		}
+	}
+	if tmpConfig.IngressShards == 0 {
+		tmpConfig.IngressShards = 16
+	}
+	if tmpConfig.IngressBacklog == 0 {
+		tmpConfig.IngressBacklog = 10000
 	}
 	if tmpConfig.RedisHost == "" {
 		tmpConfig.RedisHost = "localhost:6379"
@@ -166,6 +377,17 @@ func loadConfig() Config {
 		tmpConfig.postgresDatabase = "postgres"
 	}
 	tmpConfig.PostgresDSN = "host=" + tmpConfig.postgresHost + " port=" + strconv.FormatInt(int64(tmpConfig.postgresPort), 10) + " user=" + tmpConfig.postgresUser + " dbname=" + tmpConfig.postgresDatabase + " password=" + tmpConfig.postgresPassword
+	if tmpConfig.DatabaseDriver == "" {
+		tmpConfig.DatabaseDriver = DatabasePostgres
+	}
+	if tmpConfig.DatabaseDSN == "" {
+		switch tmpConfig.DatabaseDriver {
+		case DatabasePostgres:
+			tmpConfig.DatabaseDSN = tmpConfig.PostgresDSN
+		case DatabaseSQLite:
+			tmpConfig.DatabaseDSN = defaultSQLitePath
+		}
+	}
 	if tmpConfig.strSecret == "" {
 		tmpConfig.strSecret = "secret"
 		logging.Error("SECRET not set, using INSECURE default")
@@ -200,38 +422,44 @@ func loadConfig() Config {
 			os.Exit(1)
 		}
 	}
-	if tmpConfig.RedisPassword == "" {
+	if tmpConfig.RedisPassword == "" && tmpConfig.Store == StoreRedis {
 		tmpConfig.RedisPassword = "password"
 		logging.Error("REDIS_PASSWORD not set, using INSECURE default")
 	}
-	// CORS_HOSTS is a comma separated list of hosts that are allowed to access the API
-	corsHosts := os.Getenv("CORS_HOSTS")
-	if corsHosts == "" {
+	if len(tmpConfig.CORSHosts) == 0 {
 		tmpConfig.CORSHosts = []string{
 			fmt.Sprintf("http://localhost:%d", tmpConfig.HTTPPort),
 			fmt.Sprintf("http://127.0.0.1:%d", tmpConfig.HTTPPort),
 		}
-	} else {
-		tmpConfig.CORSHosts = strings.Split(corsHosts, ",")
 	}
-	// FEATURE_FLAGS is a comma separated list of enabled feature flags
-	featureFlags := os.Getenv("FEATURE_FLAGS")
-	if featureFlags == "" {
+	if tmpConfig.FeatureFlags == nil {
 		tmpConfig.FeatureFlags = []string{}
-	} else {
-		tmpConfig.FeatureFlags = strings.Split(featureFlags, ",")
 	}
-	trustedProxies := os.Getenv("TRUSTED_PROXIES")
-	if trustedProxies == "" {
+	if tmpConfig.TrustedProxies == nil {
 		tmpConfig.TrustedProxies = []string{}
-	} else {
-		tmpConfig.TrustedProxies = strings.Split(trustedProxies, ",")
 	}
 
 	if tmpConfig.CanonicalHost == "" {
 		tmpConfig.CanonicalHost = "localhost"
 	}
 
+	if len(tmpConfig.OIDCScopes) == 0 {
+		tmpConfig.OIDCScopes = []string{"openid", "profile", "email"}
+	}
+	if tmpConfig.OIDCUserClaim == "" {
+		tmpConfig.OIDCUserClaim = "callsign"
+	}
+	if tmpConfig.OIDCDMRIDClaim == "" {
+		tmpConfig.OIDCDMRIDClaim = "dmr_id"
+	}
+
+	if tmpConfig.MQTTClientID == "" {
+		tmpConfig.MQTTClientID = "dmrhub"
+	}
+	if tmpConfig.MQTTTopicPrefix == "" {
+		tmpConfig.MQTTTopicPrefix = "dmrhub"
+	}
+
 	switch tmpConfig.SMTPAuthMethod {
 	case "PLAIN":
 	case "LOGIN":
@@ -239,6 +467,11 @@ func loadConfig() Config {
 		logging.Error("SMTP_AUTH_METHOD not set to a valid value. You can ignore this if you are not using email features.")
 	}
 
+	errs = append(errs, tmpConfig.validate()...)
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+
 	if tmpConfig.Debug {
 		logging.Error("Debug mode enabled, this should not be used in production")
 		logging.Errorf("Config: %+v", tmpConfig)
@@ -246,15 +479,147 @@ func loadConfig() Config {
 	const iterations = 4096
 	const keyLen = 32
 	tmpConfig.Secret = pbkdf2.Key([]byte(tmpConfig.strSecret), []byte(tmpConfig.PasswordSalt), iterations, keyLen, sha256.New)
-	return tmpConfig
+	return tmpConfig, nil
+}
+
+// validate checks the configuration for values that cannot work.
+func (c *Config) validate() []error {
+	var errs []error
+	if c.Store != StoreRedis && c.Store != StoreMemory {
+		errs = append(errs, fmt.Errorf("%w: STORE must be redis or memory, got %q", ErrInvalidStore, c.Store))
+	}
+	switch c.Ingress {
+	case IngressPubSub:
+	case IngressStreams:
+		if c.Store != StoreRedis {
+			errs = append(errs, fmt.Errorf("%w: INGRESS=streams needs STORE=redis", ErrInvalidIngress))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%w: INGRESS must be streams or pubsub, got %q", ErrInvalidIngress, c.Ingress))
+	}
+	if c.IngressShards < 1 || c.IngressBacklog < 1 {
+		errs = append(errs, fmt.Errorf("%w: INGRESS_SHARDS and INGRESS_BACKLOG must be positive", ErrInvalidIngress))
+	}
+	switch c.DatabaseDriver {
+	case DatabasePostgres, DatabaseSQLite:
+	case DatabaseMySQL:
+		if c.DatabaseDSN == "" {
+			errs = append(errs, fmt.Errorf("%w: DATABASE_DSN is required for mysql", ErrInvalidDatabase))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%w: DATABASE_DRIVER must be postgres, mysql or sqlite, got %q", ErrInvalidDatabase, c.DatabaseDriver))
+	}
+	for name, port := range map[string]int{
+		"PG_PORT":         c.postgresPort,
+		"DMR_PORT":        c.DMRPort,
+		"HTTP_PORT":       c.HTTPPort,
+		"OPENBRIDGE_PORT": c.OpenBridgePort,
+		"METRICS_PORT":    c.MetricsPort,
+		"SMTP_PORT":       c.SMTPPort,
+	} {
+		if port < 0 || port > maxPort {
+			errs = append(errs, fmt.Errorf("%w: %s=%d", ErrInvalidPort, name, port))
+		}
+	}
+	if net.ParseIP(c.ListenAddr) == nil {
+		errs = append(errs, fmt.Errorf("%w: LISTEN_ADDR=%q", ErrInvalidListenAddr, c.ListenAddr))
+	}
+	for _, host := range c.CORSHosts {
+		if strings.TrimSpace(host) == "" {
+			errs = append(errs, ErrInvalidCORSHost)
+			break
+		}
+	}
+	if c.EnableEmail {
+		if c.SMTPHost == "" || c.SMTPPort == 0 || c.SMTPFrom == "" {
+			errs = append(errs, fmt.Errorf("%w: SMTP_HOST, SMTP_PORT and SMTP_FROM are required when ENABLE_EMAIL is set", ErrInvalidSMTP))
+		}
+		if c.SMTPAuthMethod != "PLAIN" && c.SMTPAuthMethod != "LOGIN" {
+			errs = append(errs, fmt.Errorf("%w: SMTP_AUTH_METHOD must be PLAIN or LOGIN, got %q", ErrInvalidSMTP, c.SMTPAuthMethod))
+		}
+	}
+	if c.OIDCIssuerURL != "" {
+		if c.OIDCClientID == "" || c.OIDCRedirectURL == "" {
+			errs = append(errs, fmt.Errorf("%w: OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required when OIDC_ISSUER_URL is set", ErrInvalidOIDC))
+		}
+		if !slices.Contains(c.OIDCScopes, "openid") {
+			errs = append(errs, fmt.Errorf("%w: OIDC_SCOPES must include openid", ErrInvalidOIDC))
+		}
+	}
+	for name, days := range map[string]int{
+		"GROUP_CALL_DATA_RETENTION_DAYS":   c.GroupCallDataRetention,
+		"PRIVATE_CALL_DATA_RETENTION_DAYS": c.PrivateCallDataRetention,
+		"GROUP_CALL_RETENTION_DAYS":        c.GroupCallRetention,
+		"PRIVATE_CALL_RETENTION_DAYS":      c.PrivateCallRetention,
+	} {
+		if days < 0 {
+			errs = append(errs, fmt.Errorf("%w: %s=%d", ErrInvalidRetention, name, days))
+		}
+	}
+	if c.MQTTQoS < 0 || c.MQTTQoS > 2 {
+		errs = append(errs, fmt.Errorf("%w: MQTT_QOS must be 0, 1 or 2, got %d", ErrInvalidMQTT, c.MQTTQoS))
+	}
+	if strings.ContainsAny(c.MQTTTopicPrefix, "+#") || strings.HasSuffix(c.MQTTTopicPrefix, "/") {
+		errs = append(errs, fmt.Errorf("%w: MQTT_TOPIC_PREFIX must not contain wildcards or end with /", ErrInvalidMQTT))
+	}
+	return errs
+}
+
+// Load reads and validates the configuration from the config file and environment.
+// All problems found are returned together so they can be fixed at once.
+func Load() error {
+	tmpConfig, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	currentConfig.Store(tmpConfig)
+	isInit.Store(true)
+	loaded.Store(true)
+	return nil
+}
+
+// Reload re-reads the configuration and applies the settings that are safe
+// to change while running. Other changes require a restart and are ignored.
+func Reload() error {
+	newConfig, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	updated := *GetConfig()
+	updated.NetworkName = newConfig.NetworkName
+	updated.CORSHosts = newConfig.CORSHosts
+	updated.FeatureFlags = newConfig.FeatureFlags
+	updated.SMTPHost = newConfig.SMTPHost
+	updated.SMTPPort = newConfig.SMTPPort
+	updated.SMTPImplicitTLS = newConfig.SMTPImplicitTLS
+	updated.SMTPUsername = newConfig.SMTPUsername
+	updated.SMTPPassword = newConfig.SMTPPassword
+	updated.SMTPFrom = newConfig.SMTPFrom
+	updated.SMTPAuthMethod = newConfig.SMTPAuthMethod
+	updated.AdminEmail = newConfig.AdminEmail
+	updated.EnableEmail = newConfig.EnableEmail
+	updated.Debug = newConfig.Debug
+	updated.RequireAdminTOTP = newConfig.RequireAdminTOTP
+	updated.GroupCallDataRetention = newConfig.GroupCallDataRetention
+	updated.PrivateCallDataRetention = newConfig.PrivateCallDataRetention
+	updated.GroupCallRetention = newConfig.GroupCallRetention
+	updated.PrivateCallRetention = newConfig.PrivateCallRetention
+	currentConfig.Store(updated)
+	return nil
 }
 
 // GetConfig obtains the current configuration
-// On the first call, it will load the configuration from the environment variables.
+// On the first call, it will load the configuration from the config file and environment variables.
 func GetConfig() *Config {
 	lastInit := isInit.Swap(true)
 	if !lastInit {
-		currentConfig.Store(loadConfig())
+		tmpConfig, err := loadConfig()
+		if err != nil {
+			logging.Errorf("Invalid configuration: %v", err)
+			os.Exit(1)
+		}
+		currentConfig.Store(tmpConfig)
 		loaded.Store(true)
 	}
 	for !loaded.Load() {
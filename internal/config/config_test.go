@@ -20,10 +20,255 @@
 package config_test
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
 )
 
 func TestNoop(t *testing.T) {
 	t.Parallel()
 	t.Log("Noop")
 }
+
+func writeConfigFile(t *testing.T, name string, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(contents), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+//nolint:golint,paralleltest // modifies the environment
+func TestLoadYAMLFile(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "network_name: TestNet\ndmr_port: 62032\ncors_hosts:\n  - https://example.com\n")
+	t.Setenv("CONFIG_FILE", path)
+
+	err := config.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.GetConfig().NetworkName != "TestNet" {
+		t.Errorf("Expected network name TestNet, got %s", config.GetConfig().NetworkName)
+	}
+	if config.GetConfig().DMRPort != 62032 {
+		t.Errorf("Expected DMR port 62032, got %d", config.GetConfig().DMRPort)
+	}
+	if len(config.GetConfig().CORSHosts) != 1 || config.GetConfig().CORSHosts[0] != "https://example.com" {
+		t.Errorf("Unexpected CORS hosts %v", config.GetConfig().CORSHosts)
+	}
+}
+
+//nolint:golint,paralleltest // modifies the environment
+func TestLoadTOMLFileEnvOverrides(t *testing.T) {
+	path := writeConfigFile(t, "config.toml", "network_name = \"FileNet\"\nhttp_port = 8080\n")
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("NETWORK_NAME", "EnvNet")
+
+	err := config.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.GetConfig().NetworkName != "EnvNet" {
+		t.Errorf("Expected environment to override file, got %s", config.GetConfig().NetworkName)
+	}
+	if config.GetConfig().HTTPPort != 8080 {
+		t.Errorf("Expected HTTP port 8080, got %d", config.GetConfig().HTTPPort)
+	}
+}
+
+//nolint:golint,paralleltest // modifies the environment
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "config.yaml", "network_nme: Typo\n"))
+	if err := config.Load(); err == nil {
+		t.Error("Expected an error for an unknown YAML key")
+	}
+
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "config.toml", "network_nme = \"Typo\"\n"))
+	if err := config.Load(); err == nil {
+		t.Error("Expected an error for an unknown TOML key")
+	}
+}
+
+//nolint:golint,paralleltest // modifies the environment
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	t.Setenv("DMR_PORT", "abc")
+	t.Setenv("HTTP_PORT", "70000")
+	t.Setenv("DEBUG", "maybe")
+
+	err := config.Load()
+	if err == nil {
+		t.Fatal("Expected an error for invalid values")
+	}
+	if !errors.Is(err, config.ErrInvalidInt) || !errors.Is(err, config.ErrInvalidPort) || !errors.Is(err, config.ErrInvalidBool) {
+		t.Errorf("Expected every problem to be reported, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "DMR_PORT") {
+		t.Errorf("Expected the error to name DMR_PORT, got %v", err)
+	}
+}
+
+//nolint:golint,paralleltest // modifies the environment
+func TestReloadAppliesSafeSettings(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "network_name: Before\ndmr_port: 62031\n")
+	t.Setenv("CONFIG_FILE", path)
+
+	err := config.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = os.WriteFile(path, []byte("network_name: After\ndmr_port: 62035\nfeature_flags:\n  - openbridge\n"), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	err = config.Reload()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.GetConfig().NetworkName != "After" {
+		t.Errorf("Expected network name to reload, got %s", config.GetConfig().NetworkName)
+	}
+	if len(config.GetConfig().FeatureFlags) != 1 {
+		t.Errorf("Expected feature flags to reload, got %v", config.GetConfig().FeatureFlags)
+	}
+	if config.GetConfig().DMRPort != 62031 {
+		t.Errorf("Expected DMR port to require a restart, got %d", config.GetConfig().DMRPort)
+	}
+}
+
+//nolint:golint,paralleltest // modifies the environment
+func TestLoadRejectsInvalidMQTT(t *testing.T) {
+	t.Setenv("MQTT_QOS", "3")
+	t.Setenv("MQTT_TOPIC_PREFIX", "dmrhub/#")
+
+	err := config.Load()
+	if !errors.Is(err, config.ErrInvalidMQTT) {
+		t.Fatalf("Expected an invalid MQTT configuration error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "MQTT_QOS") || !strings.Contains(err.Error(), "MQTT_TOPIC_PREFIX") {
+		t.Errorf("Expected both problems to be reported, got %v", err)
+	}
+}
+
+//nolint:golint,paralleltest // modifies the environment
+func TestLoadRejectsNegativeRetention(t *testing.T) {
+	t.Setenv("PRIVATE_CALL_RETENTION_DAYS", "-1")
+
+	err := config.Load()
+	if !errors.Is(err, config.ErrInvalidRetention) {
+		t.Fatalf("Expected an invalid retention error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "PRIVATE_CALL_RETENTION_DAYS") {
+		t.Errorf("Expected the error to name PRIVATE_CALL_RETENTION_DAYS, got %v", err)
+	}
+}
+
+//nolint:golint,paralleltest // modifies the environment
+func TestLoadStore(t *testing.T) {
+	err := config.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.GetConfig().Store != config.StoreRedis {
+		t.Errorf("Expected Redis to be the default store, got %s", config.GetConfig().Store)
+	}
+
+	t.Setenv("STORE", "memory")
+	err = config.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.GetConfig().Store != config.StoreMemory {
+		t.Errorf("Expected the memory store, got %s", config.GetConfig().Store)
+	}
+
+	t.Setenv("STORE", "etcd")
+	err = config.Load()
+	if !errors.Is(err, config.ErrInvalidStore) {
+		t.Fatalf("Expected an invalid store error, got %v", err)
+	}
+}
+
+//nolint:golint,paralleltest // modifies the environment
+func TestLoadDatabase(t *testing.T) {
+	// The suite may be running against another backend
+	t.Setenv("DATABASE_DRIVER", "")
+	t.Setenv("DATABASE_DSN", "")
+	err := config.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.GetConfig().DatabaseDriver != config.DatabasePostgres {
+		t.Errorf("Expected Postgres to be the default database, got %s", config.GetConfig().DatabaseDriver)
+	}
+	if config.GetConfig().DatabaseDSN != config.GetConfig().PostgresDSN {
+		t.Errorf("Expected the Postgres DSN to be built from PG_* settings, got %s", config.GetConfig().DatabaseDSN)
+	}
+
+	t.Setenv("DATABASE_DRIVER", "sqlite")
+	err = config.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.GetConfig().DatabaseDSN != "DMRHub.db" {
+		t.Errorf("Expected the default SQLite path, got %s", config.GetConfig().DatabaseDSN)
+	}
+
+	t.Setenv("DATABASE_DRIVER", "mysql")
+	err = config.Load()
+	if !errors.Is(err, config.ErrInvalidDatabase) {
+		t.Fatalf("Expected MySQL without a DSN to be rejected, got %v", err)
+	}
+
+	t.Setenv("DATABASE_DSN", "dmrhub:password@tcp(localhost:3306)/dmrhub")
+	err = config.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	t.Setenv("DATABASE_DRIVER", "oracle")
+	err = config.Load()
+	if !errors.Is(err, config.ErrInvalidDatabase) {
+		t.Fatalf("Expected an invalid database error, got %v", err)
+	}
+}
+
+//nolint:golint,paralleltest // modifies the environment
+func TestLoadIngress(t *testing.T) {
+	err := config.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.GetConfig().Ingress != config.IngressStreams {
+		t.Errorf("Expected Redis Streams to be the default ingress, got %s", config.GetConfig().Ingress)
+	}
+
+	t.Setenv("STORE", "memory")
+	err = config.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.GetConfig().Ingress != config.IngressPubSub {
+		t.Errorf("Expected pubsub ingress without Redis, got %s", config.GetConfig().Ingress)
+	}
+
+	t.Setenv("INGRESS", "streams")
+	err = config.Load()
+	if !errors.Is(err, config.ErrInvalidIngress) {
+		t.Fatalf("Expected streams without Redis to be rejected, got %v", err)
+	}
+
+	t.Setenv("STORE", "redis")
+	t.Setenv("INGRESS_SHARDS", "-1")
+	err = config.Load()
+	if !errors.Is(err, config.ErrInvalidIngress) {
+		t.Fatalf("Expected an invalid ingress error, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrUnknownConfigFormat = errors.New("unknown config file format, expected .yaml, .yml or .toml")
+
+// fileConfig is the layout of the optional config file.
+// Keys are the lowercase names of the matching environment variables.
+type fileConfig struct {
+	Store                    string   `yaml:"store" toml:"store"`
+	Ingress                  string   `yaml:"ingress" toml:"ingress"`
+	IngressShards            int      `yaml:"ingress_shards" toml:"ingress_shards"`
+	IngressBacklog           int      `yaml:"ingress_backlog" toml:"ingress_backlog"`
+	RedisHost                string   `yaml:"redis_host" toml:"redis_host"`
+	RedisPassword            string   `yaml:"redis_password" toml:"redis_password"`
+	DatabaseDriver           string   `yaml:"database_driver" toml:"database_driver"`
+	DatabaseDSN              string   `yaml:"database_dsn" toml:"database_dsn"`
+	PostgresUser             string   `yaml:"pg_user" toml:"pg_user"`
+	PostgresPassword         string   `yaml:"pg_password" toml:"pg_password"`
+	PostgresHost             string   `yaml:"pg_host" toml:"pg_host"`
+	PostgresPort             int      `yaml:"pg_port" toml:"pg_port"`
+	PostgresDatabase         string   `yaml:"pg_database" toml:"pg_database"`
+	Secret                   string   `yaml:"secret" toml:"secret"`
+	PasswordSalt             string   `yaml:"password_salt" toml:"password_salt"`
+	ListenAddr               string   `yaml:"listen_addr" toml:"listen_addr"`
+	DMRPort                  int      `yaml:"dmr_port" toml:"dmr_port"`
+	MetricsPort              int      `yaml:"metrics_port" toml:"metrics_port"`
+	MetricsRepeaterIDLabel   bool     `yaml:"metrics_repeater_id_label" toml:"metrics_repeater_id_label"`
+	OpenBridgePort           int      `yaml:"openbridge_port" toml:"openbridge_port"`
+	HTTPPort                 int      `yaml:"http_port" toml:"http_port"`
+	CORSHosts                []string `yaml:"cors_hosts" toml:"cors_hosts"`
+	TrustedProxies           []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	HIBPAPIKey               string   `yaml:"hibp_api_key" toml:"hibp_api_key"`
+	OTLPEndpoint             string   `yaml:"otlp_endpoint" toml:"otlp_endpoint"`
+	InitialAdminUserPassword string   `yaml:"init_admin_user_password" toml:"init_admin_user_password"`
+	Debug                    bool     `yaml:"debug" toml:"debug"`
+	NetworkName              string   `yaml:"network_name" toml:"network_name"`
+	AllowScraping            bool     `yaml:"allow_scraping" toml:"allow_scraping"`
+	CustomRobotsTxt          string   `yaml:"custom_robots_txt" toml:"custom_robots_txt"`
+	FeatureFlags             []string `yaml:"feature_flags" toml:"feature_flags"`
+	SMTPHost                 string   `yaml:"smtp_host" toml:"smtp_host"`
+	SMTPPort                 int      `yaml:"smtp_port" toml:"smtp_port"`
+	SMTPImplicitTLS          bool     `yaml:"smtp_implicit_tls" toml:"smtp_implicit_tls"`
+	SMTPUsername             string   `yaml:"smtp_username" toml:"smtp_username"`
+	SMTPPassword             string   `yaml:"smtp_password" toml:"smtp_password"`
+	SMTPFrom                 string   `yaml:"smtp_from" toml:"smtp_from"`
+	SMTPAuthMethod           string   `yaml:"smtp_auth_method" toml:"smtp_auth_method"`
+	AdminEmail               string   `yaml:"admin_email" toml:"admin_email"`
+	EnableEmail              bool     `yaml:"enable_email" toml:"enable_email"`
+	CanonicalHost            string   `yaml:"canonical_host" toml:"canonical_host"`
+	OIDCIssuerURL            string   `yaml:"oidc_issuer_url" toml:"oidc_issuer_url"`
+	OIDCClientID             string   `yaml:"oidc_client_id" toml:"oidc_client_id"`
+	OIDCClientSecret         string   `yaml:"oidc_client_secret" toml:"oidc_client_secret"`
+	OIDCRedirectURL          string   `yaml:"oidc_redirect_url" toml:"oidc_redirect_url"`
+	OIDCScopes               []string `yaml:"oidc_scopes" toml:"oidc_scopes"`
+	OIDCUserClaim            string   `yaml:"oidc_user_claim" toml:"oidc_user_claim"`
+	OIDCDMRIDClaim           string   `yaml:"oidc_dmr_id_claim" toml:"oidc_dmr_id_claim"`
+	OIDCCreateUsers          bool     `yaml:"oidc_create_users" toml:"oidc_create_users"`
+	OIDCUserClaimTrusted     bool     `yaml:"oidc_user_claim_trusted" toml:"oidc_user_claim_trusted"`
+	RequireAdminTOTP         bool     `yaml:"require_admin_totp" toml:"require_admin_totp"`
+	MQTTBroker               string   `yaml:"mqtt_broker" toml:"mqtt_broker"`
+	MQTTClientID             string   `yaml:"mqtt_client_id" toml:"mqtt_client_id"`
+	MQTTUsername             string   `yaml:"mqtt_username" toml:"mqtt_username"`
+	MQTTPassword             string   `yaml:"mqtt_password" toml:"mqtt_password"`
+	MQTTTopicPrefix          string   `yaml:"mqtt_topic_prefix" toml:"mqtt_topic_prefix"`
+	MQTTQoS                  int      `yaml:"mqtt_qos" toml:"mqtt_qos"`
+	MQTTRetain               bool     `yaml:"mqtt_retain" toml:"mqtt_retain"`
+	GroupCallDataRetention   int      `yaml:"group_call_data_retention_days" toml:"group_call_data_retention_days"`
+	PrivateCallDataRetention int      `yaml:"private_call_data_retention_days" toml:"private_call_data_retention_days"`
+	GroupCallRetention       int      `yaml:"group_call_retention_days" toml:"group_call_retention_days"`
+	PrivateCallRetention     int      `yaml:"private_call_retention_days" toml:"private_call_retention_days"`
+}
+
+// readConfigFile parses the config file at path, rejecting unknown keys.
+// An empty path means no config file is used.
+func readConfigFile(path string) (fileConfig, error) {
+	var file fileConfig
+	if path == "" {
+		return file, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return file, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return file, fmt.Errorf("error parsing config file %s: %w", path, err)
+		}
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+		if err != nil {
+			var strictErr *toml.StrictMissingError
+			if errors.As(err, &strictErr) {
+				return file, fmt.Errorf("error parsing config file %s: %w\n%s", path, err, strictErr.String())
+			}
+			return file, fmt.Errorf("error parsing config file %s: %w", path, err)
+		}
+	default:
+		return file, fmt.Errorf("%w: %s", ErrUnknownConfigFormat, path)
+	}
+
+	return file, nil
+}
@@ -20,8 +20,10 @@
 package db
 
 import (
+	"fmt"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/USA-RedDragon/DMRHub/internal/config"
@@ -29,45 +31,108 @@ import (
 	"github.com/USA-RedDragon/DMRHub/internal/db/models"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
 	"github.com/glebarez/sqlite"
+	mysqlDriver "github.com/go-sql-driver/mysql"
 	gorm_seeder "github.com/kachit/gorm-seeder"
 	"github.com/uptrace/opentelemetry-go-extra/otelgorm"
+	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-func MakeDB() *gorm.DB {
-	var db *gorm.DB
-	var err error
+// sqlitePragmas switch SQLite to write-ahead logging so readers don't block the writer,
+// and make writers wait for each other instead of failing with SQLITE_BUSY.
+const sqlitePragmas = "_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate"
+
+// dialector picks the database driver from the configuration.
+// Tests built on the shared test router use a fresh in-memory SQLite database,
+// as they would otherwise all share one database on the server. Tests that
+// run against each backend open their own with testutils.OpenDB.
+func dialector() (gorm.Dialector, error) {
 	if os.Getenv("TEST") != "" {
 		logging.Error("Using in-memory database for testing")
-		db, err = gorm.Open(sqlite.Open(""), &gorm.Config{})
+		return sqlite.Open(""), nil
+	}
+
+	return Dialector(config.GetConfig().DatabaseDriver, config.GetConfig().DatabaseDSN)
+}
+
+// Dialector returns the gorm dialector for a database driver and DSN.
+func Dialector(driver string, dsn string) (gorm.Dialector, error) {
+	switch driver {
+	case config.DatabasePostgres:
+		return postgres.Open(dsn), nil
+	case config.DatabaseMySQL:
+		// parseTime is needed to scan DATETIME columns into time.Time
+		cfg, err := mysqlDriver.ParseDSN(dsn)
 		if err != nil {
-			logging.Errorf("Could not open database: %s", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("invalid mysql DSN: %w", err)
 		}
-	} else {
-		db, err = gorm.Open(postgres.Open(config.GetConfig().PostgresDSN), &gorm.Config{})
-		if err != nil {
-			logging.Errorf("Could not open database: %s", err)
-			os.Exit(1)
+		cfg.ParseTime = true
+		return mysql.New(mysql.Config{DSNConfig: cfg}), nil
+	case config.DatabaseSQLite:
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
 		}
-		if config.GetConfig().OTLPEndpoint != "" {
-			if err = db.Use(otelgorm.NewPlugin()); err != nil {
-				logging.Errorf("Could not trace database: %s", err)
-				os.Exit(1)
-			}
+		return sqlite.Open(dsn + separator + sqlitePragmas), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", config.ErrInvalidDatabase, driver)
+	}
+}
+
+// Open connects to the database without migrating or seeding it.
+func Open() *gorm.DB {
+	dialector, err := dialector()
+	if err != nil {
+		logging.Errorf("Could not open database: %s", err)
+		os.Exit(1)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		logging.Errorf("Could not open database: %s", err)
+		os.Exit(1)
+	}
+	if os.Getenv("TEST") == "" && config.GetConfig().OTLPEndpoint != "" {
+		if err = db.Use(otelgorm.NewPlugin()); err != nil {
+			logging.Errorf("Could not trace database: %s", err)
+			os.Exit(1)
 		}
 	}
 
-	err = migration.Migrate(db)
+	sqlDB, err := db.DB()
 	if err != nil {
-		logging.Errorf("Could not migrate database: %v", err)
+		logging.Errorf("Failed to open database: %s", err)
 		os.Exit(1)
 	}
+	sqlDB.SetMaxIdleConns(runtime.GOMAXPROCS(0))
+	const connsPerCPU = 10
+	sqlDB.SetMaxOpenConns(runtime.GOMAXPROCS(0) * connsPerCPU)
+	const maxIdleTime = 10 * time.Minute
+	sqlDB.SetConnMaxIdleTime(maxIdleTime)
+
+	return db
+}
+
+// Migrate runs the migrations and brings the schema up to date.
+func Migrate(db *gorm.DB) error {
+	err := migration.Migrate(db)
+	if err != nil {
+		return fmt.Errorf("could not run migrations: %w", err)
+	}
+
+	err = db.AutoMigrate(&models.AppSettings{}, &models.Call{}, &models.Peer{}, &models.PeerRule{}, &models.Repeater{}, &models.Talkgroup{}, &models.User{}, &models.APIToken{}, &models.RecoveryCode{}, &models.RepeaterAlert{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.AuditLog{}, &models.CallSummary{})
+	if err != nil {
+		return fmt.Errorf("could not update schema: %w", err)
+	}
+	return nil
+}
+
+func MakeDB() *gorm.DB {
+	db := Open()
 
-	err = db.AutoMigrate(&models.AppSettings{}, &models.Call{}, &models.Peer{}, &models.PeerRule{}, &models.Repeater{}, &models.Talkgroup{}, &models.User{})
+	err := Migrate(db)
 	if err != nil {
-		logging.Errorf("Could not migrate database: %s", err)
+		logging.Errorf("Could not migrate database: %v", err)
 		os.Exit(1)
 	}
 
@@ -114,16 +179,5 @@ func MakeDB() *gorm.DB {
 		}
 	}
 
-	sqlDB, err := db.DB()
-	if err != nil {
-		logging.Errorf("Failed to open database: %s", err)
-		os.Exit(1)
-	}
-	sqlDB.SetMaxIdleConns(runtime.GOMAXPROCS(0))
-	const connsPerCPU = 10
-	sqlDB.SetMaxOpenConns(runtime.GOMAXPROCS(0) * connsPerCPU)
-	const maxIdleTime = 10 * time.Minute
-	sqlDB.SetConnMaxIdleTime(maxIdleTime)
-
 	return db
 }
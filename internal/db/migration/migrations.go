@@ -27,8 +27,8 @@ import (
 	"gorm.io/gorm"
 )
 
-func Migrate(db *gorm.DB) error {
-	m := gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+func migrations(db *gorm.DB) []*gormigrate.Migration {
+	return []*gormigrate.Migration{
 		// convert models.Repeater radio_id to id
 		{
 			ID: "202302242025",
@@ -72,7 +72,11 @@ func Migrate(db *gorm.DB) error {
 				return nil
 			},
 		},
-	})
+	}
+}
+
+func Migrate(db *gorm.DB) error {
+	m := gormigrate.New(db, gormigrate.DefaultOptions, migrations(db))
 
 	if err := m.Migrate(); err != nil {
 		return fmt.Errorf("could not migrate: %w", err)
@@ -80,3 +84,27 @@ func Migrate(db *gorm.DB) error {
 
 	return nil
 }
+
+// RollbackLast undoes the most recently applied migration.
+func RollbackLast(db *gorm.DB) error {
+	m := gormigrate.New(db, gormigrate.DefaultOptions, migrations(db))
+
+	if err := m.RollbackLast(); err != nil {
+		return fmt.Errorf("could not roll back: %w", err)
+	}
+
+	return nil
+}
+
+// IsMigrated reports whether the latest migration has been applied to the database.
+func IsMigrated(db *gorm.DB) (bool, error) {
+	all := migrations(db)
+	latest := all[len(all)-1].ID
+
+	var count int64
+	err := db.Table(gormigrate.DefaultOptions.TableName).Where(gormigrate.DefaultOptions.IDColumnName+" = ?", latest).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("could not check migrations: %w", err)
+	}
+	return count > 0, nil
+}
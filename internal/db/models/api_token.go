@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+//nolint:golint,wrapcheck
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TokenPrefix marks a string as a DMRHub API token
+const TokenPrefix = "dmrhub_"
+
+// Scopes an API token can be granted
+const (
+	ScopeLastheardRead   = "lastheard:read"
+	ScopeRepeatersRead   = "repeaters:read"
+	ScopeRepeatersWrite  = "repeaters:write"
+	ScopeTalkgroupsRead  = "talkgroups:read"
+	ScopeTalkgroupsWrite = "talkgroups:write"
+	ScopeUsersRead       = "users:read"
+	ScopeUsersWrite      = "users:write"
+	ScopePeersRead       = "peers:read"
+	ScopePeersWrite      = "peers:write"
+	ScopeStatsRead       = "stats:read"
+)
+
+// ValidTokenScopes returns every scope an API token can be granted
+func ValidTokenScopes() []string {
+	return []string{
+		ScopeLastheardRead,
+		ScopeRepeatersRead,
+		ScopeRepeatersWrite,
+		ScopeTalkgroupsRead,
+		ScopeTalkgroupsWrite,
+		ScopeUsersRead,
+		ScopeUsersWrite,
+		ScopePeersRead,
+		ScopePeersWrite,
+		ScopeStatsRead,
+	}
+}
+
+// APIToken is a personal access token. Only the SHA-256 hash of the token is stored.
+type APIToken struct {
+	ID         uint           `json:"id" gorm:"primaryKey"`
+	UserID     uint           `json:"-" gorm:"index"`
+	User       User           `json:"-" gorm:"foreignKey:UserID"`
+	Name       string         `json:"name"`
+	Hint       string         `json:"hint"`
+	Hash       string         `json:"-" gorm:"index:,unique"`
+	Scopes     string         `json:"-"`
+	ExpiresAt  time.Time      `json:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"-"`
+	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+func (t *APIToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.ScopeList(), scope)
+}
+
+func (t *APIToken) Expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// GenerateAPIToken returns a new random token string and the hash to store for it
+func GenerateAPIToken() (string, string, error) {
+	const tokenBytes = 32
+	b := make([]byte, tokenBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	token := TokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashAPIToken(token), nil
+}
+
+// HashAPIToken hashes a token for storage and lookup. Tokens are random and
+// long, so a fast hash is sufficient unlike with passwords.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenHint is the part of a token shown to users to tell their tokens apart
+func TokenHint(token string) string {
+	const hintLen = 4
+	if len(token) <= len(TokenPrefix)+hintLen {
+		return token
+	}
+	return token[:len(TokenPrefix)+hintLen] + "..."
+}
+
+func FindAPITokenByHash(db *gorm.DB, hash string) (APIToken, error) {
+	var token APIToken
+	err := db.Where("hash = ?", hash).First(&token).Error
+	return token, err
+}
+
+func ListUserAPITokens(db *gorm.DB, userID uint) ([]APIToken, error) {
+	var tokens []APIToken
+	err := db.Where("user_id = ?", userID).Order("id asc").Find(&tokens).Error
+	return tokens, err
+}
+
+func DeleteUserAPIToken(db *gorm.DB, userID uint, id uint) (bool, error) {
+	result := db.Unscoped().Where("user_id = ? AND id = ?", userID, id).Delete(&APIToken{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteUserAPITokens revokes every token a user holds.
+func DeleteUserAPITokens(db *gorm.DB, userID uint) error {
+	return db.Unscoped().Where("user_id = ?", userID).Delete(&APIToken{}).Error
+}
+
+// TouchAPIToken records that a token was used. Writes are skipped if the
+// token was already marked as used within the last minute.
+func TouchAPIToken(db *gorm.DB, token *APIToken, now time.Time) error {
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < time.Minute {
+		return nil
+	}
+	token.LastUsedAt = &now
+	return db.Model(token).UpdateColumn("last_used_at", now).Error
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+//nolint:golint,wrapcheck
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditLog records an administrative action taken through the API.
+type AuditLog struct {
+	ID uint `json:"id" gorm:"primaryKey"`
+	// ActorID is the user who took the action
+	ActorID    uint   `json:"actor_id" gorm:"index"`
+	Action     string `json:"action" gorm:"index"`
+	TargetType string `json:"target_type" gorm:"index:idx_audit_logs_target"`
+	TargetID   uint   `json:"target_id" gorm:"index:idx_audit_logs_target"`
+	IP         string `json:"ip"`
+	// Before and After hold the JSON of the changed values, empty when there are none
+	Before    string    `json:"before"`
+	After     string    `json:"after"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// AuditLogFilter narrows down the audit log. Zero values match everything.
+type AuditLogFilter struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   uint
+	Since      time.Time
+	Until      time.Time
+}
+
+func (f AuditLogFilter) apply(db *gorm.DB) *gorm.DB {
+	if f.ActorID != 0 {
+		db = db.Where("actor_id = ?", f.ActorID)
+	}
+	if f.Action != "" {
+		db = db.Where("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		db = db.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != 0 {
+		db = db.Where("target_id = ?", f.TargetID)
+	}
+	if !f.Since.IsZero() {
+		db = db.Where("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		db = db.Where("created_at < ?", f.Until)
+	}
+	return db
+}
+
+// ListAuditLogs returns the matching audit log entries, newest first.
+func ListAuditLogs(db *gorm.DB, filter AuditLogFilter) ([]AuditLog, error) {
+	var logs []AuditLog
+	err := filter.apply(db).Order("id desc").Find(&logs).Error
+	return logs, err
+}
+
+func CountAuditLogs(db *gorm.DB, filter AuditLogFilter) (int, error) {
+	var count int64
+	err := filter.apply(db.Model(&AuditLog{})).Count(&count).Error
+	return int(count), err
+}
@@ -20,6 +20,9 @@
 package models
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
@@ -29,7 +32,7 @@ type Call struct {
 	ID             uint           `json:"id" gorm:"primarykey"`
 	CallData       []byte         `json:"-"`
 	StreamID       uint           `json:"-"`
-	StartTime      time.Time      `json:"start_time"`
+	StartTime      time.Time      `json:"start_time" gorm:"index"`
 	Duration       time.Duration  `json:"duration"`
 	Active         bool           `json:"active"`
 	User           User           `json:"user" gorm:"foreignKey:UserID"`
@@ -66,59 +69,233 @@ type Call struct {
 	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
-func FindCalls(db *gorm.DB) []Call {
-	var calls []Call
-	db.Preload("User").Preload("Repeater").Preload("ToTalkgroup").Preload("ToUser").Preload("ToRepeater").Where("is_to_talkgroup = ?", true).Order("start_time desc").Find(&calls)
-	return calls
+// CallSort is a column calls can be sorted by.
+type CallSort string
+
+const (
+	CallSortStartTime CallSort = "start_time"
+	CallSortDuration  CallSort = "duration"
+	CallSortLoss      CallSort = "loss"
+	CallSortBER       CallSort = "ber"
+)
+
+var ErrCursorMismatch = errors.New("cursor does not match the sort order")
+
+// CallFilter narrows down and orders a list of calls. Zero values match everything.
+type CallFilter struct {
+	Since       time.Time
+	Until       time.Time
+	UserID      uint
+	Callsign    string
+	TalkgroupID uint
+	RepeaterID  uint
+	GroupCall   *bool
+	MinDuration time.Duration
+	MinLoss     *float32
+	MaxLoss     *float32
+	MinBER      *float32
+	MaxBER      *float32
+	// Sort defaults to start_time, newest first
+	Sort      CallSort
+	Ascending bool
+	// After continues a listing from the last call of the previous page
+	After *CallCursor
 }
 
-func CountCalls(db *gorm.DB) int {
-	var count int64
-	db.Model(&Call{}).Where("is_to_talkgroup = ?", true).Count(&count)
-	return int(count)
+// CallCursor is the position of a call in a sorted listing, used for keyset pagination.
+type CallCursor struct {
+	Sort      CallSort      `json:"s"`
+	Ascending bool          `json:"a,omitempty"`
+	StartTime time.Time     `json:"t"`
+	Duration  time.Duration `json:"d"`
+	Loss      float32       `json:"l"`
+	BER       float32       `json:"b"`
+	ID        uint          `json:"i"`
 }
 
-func FindRepeaterCalls(db *gorm.DB, repeaterID uint) []Call {
-	var calls []Call
-	db.Preload("User").Preload("Repeater").Preload("ToTalkgroup").Preload("ToUser").Preload("ToRepeater").
-		Where("(is_to_repeater = ? AND to_repeater_id = ?) OR repeater_id = ?", true, repeaterID, repeaterID).
-		Order("start_time desc").Find(&calls)
-	return calls
+// Cursor returns the position of call in the listing f describes.
+func (f CallFilter) Cursor(call Call) CallCursor {
+	return CallCursor{
+		Sort:      f.sort(),
+		Ascending: f.Ascending,
+		StartTime: call.StartTime,
+		Duration:  call.Duration,
+		Loss:      call.Loss,
+		BER:       call.BER,
+		ID:        call.ID,
+	}
 }
 
-func CountRepeaterCalls(db *gorm.DB, repeaterID uint) int {
-	var count int64
-	db.Model(&Call{}).Where("(is_to_repeater = ? AND to_repeater_id = ?) OR repeater_id = ?", true, repeaterID, repeaterID).Count(&count)
-	return int(count)
+// Encode returns the cursor as an opaque string for API clients.
+func (c CallCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCallCursor(s string) (CallCursor, error) {
+	var cursor CallCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
+
+func (c CallCursor) value() any {
+	switch c.Sort {
+	case CallSortDuration:
+		return c.Duration
+	case CallSortLoss:
+		return c.Loss
+	case CallSortBER:
+		return c.BER
+	default:
+		return c.StartTime
+	}
+}
+
+func (f CallFilter) sort() CallSort {
+	switch f.Sort {
+	case CallSortDuration, CallSortLoss, CallSortBER:
+		return f.Sort
+	default:
+		return CallSortStartTime
+	}
+}
+
+// Validate checks that the cursor, if any, belongs to the same sort order.
+func (f CallFilter) Validate() error {
+	if f.After != nil && (f.After.Sort != f.sort() || f.After.Ascending != f.Ascending) {
+		return ErrCursorMismatch
+	}
+	return nil
+}
+
+func (f CallFilter) apply(db *gorm.DB) *gorm.DB {
+	if !f.Since.IsZero() {
+		db = db.Where("start_time >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		db = db.Where("start_time < ?", f.Until)
+	}
+	if f.UserID != 0 {
+		db = db.Where("user_id = ?", f.UserID)
+	}
+	if f.Callsign != "" {
+		db = db.Where("user_id IN (SELECT id FROM users WHERE UPPER(callsign) = UPPER(?))", f.Callsign)
+	}
+	if f.TalkgroupID != 0 {
+		db = db.Where("is_to_talkgroup = ? AND to_talkgroup_id = ?", true, f.TalkgroupID)
+	}
+	if f.RepeaterID != 0 {
+		db = db.Where("repeater_id = ?", f.RepeaterID)
+	}
+	if f.GroupCall != nil {
+		db = db.Where("group_call = ?", *f.GroupCall)
+	}
+	if f.MinDuration > 0 {
+		db = db.Where("duration >= ?", f.MinDuration)
+	}
+	if f.MinLoss != nil {
+		db = db.Where("loss >= ?", *f.MinLoss)
+	}
+	if f.MaxLoss != nil {
+		db = db.Where("loss <= ?", *f.MaxLoss)
+	}
+	if f.MinBER != nil {
+		db = db.Where("ber >= ?", *f.MinBER)
+	}
+	if f.MaxBER != nil {
+		db = db.Where("ber <= ?", *f.MaxBER)
+	}
+	return db
 }
 
-func FindUserCalls(db *gorm.DB, userID uint) []Call {
+// page orders the calls and skips past the cursor. The id breaks ties so
+// calls with the same sort value are neither repeated nor skipped.
+func (f CallFilter) page(db *gorm.DB) *gorm.DB {
+	column := string(f.sort())
+	direction, op := " desc", "<"
+	if f.Ascending {
+		direction, op = " asc", ">"
+	}
+	if f.After != nil {
+		value := f.After.value()
+		db = db.Where("("+column+" "+op+" ? OR ("+column+" = ? AND id "+op+" ?))", value, value, f.After.ID)
+	}
+	return db.Order(column + direction).Order("id" + direction)
+}
+
+func findCalls(db *gorm.DB, filter CallFilter) []Call {
 	var calls []Call
-	db.Preload("User").Preload("Repeater").Preload("ToTalkgroup").Preload("ToUser").Preload("ToRepeater").
-		Where("(is_to_user = ? AND to_user_id = ?) OR user_id = ?", true, userID, userID).
-		Order("start_time desc").Find(&calls)
+	filter.page(filter.apply(db)).
+		Preload("User").Preload("Repeater").Preload("ToTalkgroup").Preload("ToUser").Preload("ToRepeater").
+		Find(&calls)
 	return calls
 }
 
-func CountUserCalls(db *gorm.DB, userID uint) int {
+func countCalls(db *gorm.DB, filter CallFilter) int {
 	var count int64
-	db.Model(&Call{}).Where("(is_to_user = ? AND to_user_id = ?) OR user_id = ?", true, userID, userID).Count(&count)
+	filter.apply(db.Model(&Call{})).Count(&count)
 	return int(count)
 }
 
-func FindTalkgroupCalls(db *gorm.DB, talkgroupID uint) []Call {
-	var calls []Call
+func FindCalls(db *gorm.DB, filter CallFilter) []Call {
+	return findCalls(db.Where("is_to_talkgroup = ?", true), filter)
+}
+
+func CountCalls(db *gorm.DB, filter CallFilter) int {
+	return countCalls(db.Where("is_to_talkgroup = ?", true), filter)
+}
+
+func FindRepeaterCalls(db *gorm.DB, repeaterID uint, filter CallFilter) []Call {
+	return findCalls(db.Where("((is_to_repeater = ? AND to_repeater_id = ?) OR repeater_id = ?)", true, repeaterID, repeaterID), filter)
+}
+
+func CountRepeaterCalls(db *gorm.DB, repeaterID uint, filter CallFilter) int {
+	return countCalls(db.Where("((is_to_repeater = ? AND to_repeater_id = ?) OR repeater_id = ?)", true, repeaterID, repeaterID), filter)
+}
+
+func FindUserCalls(db *gorm.DB, userID uint, filter CallFilter) []Call {
+	return findCalls(db.Where("((is_to_user = ? AND to_user_id = ?) OR user_id = ?)", true, userID, userID), filter)
+}
+
+func CountUserCalls(db *gorm.DB, userID uint, filter CallFilter) int {
+	return countCalls(db.Where("((is_to_user = ? AND to_user_id = ?) OR user_id = ?)", true, userID, userID), filter)
+}
+
+func FindTalkgroupCalls(db *gorm.DB, talkgroupID uint, filter CallFilter) []Call {
 	// Find calls where (IsToTalkgroup is true and ToTalkgroupID is talkgroupID)
-	db.Preload("User").Preload("Repeater").Preload("ToTalkgroup").Preload("ToUser").Preload("ToRepeater").
-		Where("is_to_talkgroup = ? AND to_talkgroup_id = ?", true, talkgroupID).
-		Order("start_time desc").Find(&calls)
-	return calls
+	return findCalls(db.Where("is_to_talkgroup = ? AND to_talkgroup_id = ?", true, talkgroupID), filter)
 }
 
-func CountTalkgroupCalls(db *gorm.DB, talkgroupID uint) int {
-	var count int64
-	db.Model(&Call{}).Where("is_to_talkgroup = ? AND to_talkgroup_id = ?", true, talkgroupID).Count(&count)
-	return int(count)
+func CountTalkgroupCalls(db *gorm.DB, talkgroupID uint, filter CallFilter) int {
+	return countCalls(db.Where("is_to_talkgroup = ? AND to_talkgroup_id = ?", true, talkgroupID), filter)
+}
+
+// exportBatchSize is how many calls EachCall and EachUserCall load at a time.
+const exportBatchSize = 500
+
+func eachCall(db *gorm.DB, filter CallFilter, fn func([]Call) error) error {
+	var calls []Call
+	// The raw call data isn't exported and would otherwise be loaded for every call
+	return filter.apply(db).Omit("call_data").
+		Preload("User").Preload("Repeater").Preload("ToTalkgroup").Preload("ToUser").Preload("ToRepeater").
+		FindInBatches(&calls, exportBatchSize, func(_ *gorm.DB, _ int) error {
+			return fn(calls)
+		}).Error
+}
+
+// EachCall calls fn with every call matching filter, oldest first, a batch
+// at a time. The sort order and cursor of the filter are ignored.
+func EachCall(db *gorm.DB, filter CallFilter, fn func([]Call) error) error {
+	return eachCall(db, filter, fn)
+}
+
+// EachUserCall is EachCall for the calls made by or privately to a user.
+func EachUserCall(db *gorm.DB, userID uint, filter CallFilter, fn func([]Call) error) error {
+	return eachCall(db.Where("((is_to_user = ? AND to_user_id = ?) OR user_id = ?)", true, userID, userID), filter, fn)
 }
 
 func FindActiveCall(db *gorm.DB, streamID uint, src uint, dst uint, slot bool, groupCall bool) (Call, error) {
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+//nolint:golint,wrapcheck
+package models
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CallActivity is the traffic of one user, talkgroup or repeater.
+type CallActivity struct {
+	ID uint `json:"id"`
+	// Name is the callsign of a user or repeater, or the name of a talkgroup
+	Name     string        `json:"name" gorm:"-"`
+	Calls    int           `json:"calls"`
+	Duration time.Duration `json:"duration"`
+}
+
+// RepeaterQuality is the average quality of the calls heard through a repeater.
+type RepeaterQuality struct {
+	RepeaterID uint    `json:"repeater_id"`
+	Callsign   string  `json:"callsign" gorm:"-"`
+	Calls      int     `json:"calls"`
+	Loss       float64 `json:"loss"`
+	Jitter     float64 `json:"jitter"`
+	BER        float64 `json:"ber"`
+}
+
+// HourlyCalls is the number of calls started within an hour.
+type HourlyCalls struct {
+	Hour  time.Time `json:"hour"`
+	Calls int       `json:"calls"`
+}
+
+// The statistics count the calls in the calls table and the daily summaries
+// of the calls that retention has deleted. As summaries are per UTC day, only
+// the days that start within a window are counted from them.
+
+// TopTalkers returns the users with the most airtime since the given time.
+func TopTalkers(db *gorm.DB, since time.Time, limit int) ([]CallActivity, error) {
+	activity, err := callActivity(db, db.Where("start_time >= ?", since), "user_id", SummaryUser, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	return activity, fillNames(db, &User{}, "callsign", activity)
+}
+
+// BusiestTalkgroups returns the talkgroups with the most airtime since the given time.
+func BusiestTalkgroups(db *gorm.DB, since time.Time, limit int) ([]CallActivity, error) {
+	activity, err := callActivity(db, db.Where("start_time >= ? AND is_to_talkgroup = ?", since, true), "to_talkgroup_id", SummaryTalkgroup, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	return activity, fillNames(db, &Talkgroup{}, "name", activity)
+}
+
+// BusiestRepeaters returns the repeaters that carried the most airtime since the given time.
+func BusiestRepeaters(db *gorm.DB, since time.Time, limit int) ([]CallActivity, error) {
+	activity, err := callActivity(db, db.Where("start_time >= ?", since), "repeater_id", SummaryRepeater, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	return activity, fillNames(db, &Repeater{}, "callsign", activity)
+}
+
+// callActivity adds up the calls matching the calls query by column and the
+// summaries of subjectType, returning the limit with the most airtime.
+func callActivity(db *gorm.DB, calls *gorm.DB, column string, subjectType string, since time.Time, limit int) ([]CallActivity, error) {
+	var recent []CallActivity
+	err := calls.Model(&Call{}).
+		Select(column + " AS id, COUNT(*) AS calls, " + castInteger(db, "SUM(duration)") + " AS duration").
+		Group(column).
+		Scan(&recent).Error
+	if err != nil {
+		return nil, err
+	}
+	var summarized []CallActivity
+	err = summariesSince(db, subjectType, since).
+		Select("subject_id AS id, " + castInteger(db, "SUM(calls)") + " AS calls, " + castInteger(db, "SUM(duration)") + " AS duration").
+		Group("subject_id").
+		Scan(&summarized).Error
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[uint]int, len(recent)+len(summarized))
+	activity := make([]CallActivity, 0, len(recent)+len(summarized))
+	for _, entry := range slices.Concat(recent, summarized) {
+		if i, ok := totals[entry.ID]; ok {
+			activity[i].Calls += entry.Calls
+			activity[i].Duration += entry.Duration
+			continue
+		}
+		totals[entry.ID] = len(activity)
+		activity = append(activity, entry)
+	}
+	slices.SortFunc(activity, func(a, b CallActivity) int {
+		return cmp.Or(cmp.Compare(b.Duration, a.Duration), cmp.Compare(a.ID, b.ID))
+	})
+	return activity[:min(limit, len(activity))], nil
+}
+
+// summariesSince selects the summaries of subjectType for the days starting at or after since.
+func summariesSince(db *gorm.DB, subjectType string, since time.Time) *gorm.DB {
+	return db.Model(&CallSummary{}).Where("subject_type = ? AND day >= ?", subjectType, since)
+}
+
+// fillNames looks up the name column of the model for each ID in activity.
+func fillNames(db *gorm.DB, model any, column string, activity []CallActivity) error {
+	if len(activity) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(activity))
+	for _, entry := range activity {
+		ids = append(ids, entry.ID)
+	}
+	var rows []struct {
+		ID   uint
+		Name string
+	}
+	err := db.Model(model).Select("id, "+column+" AS name").Where("id IN ?", ids).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	names := make(map[uint]string, len(rows))
+	for _, row := range rows {
+		names[row.ID] = row.Name
+	}
+	for i := range activity {
+		activity[i].Name = names[activity[i].ID]
+	}
+	return nil
+}
+
+// RepeaterCallQuality returns the average loss, jitter and BER of the calls
+// heard through each repeater since the given time.
+func RepeaterCallQuality(db *gorm.DB, since time.Time) ([]RepeaterQuality, error) {
+	// The sums are divided by the number of calls once both sources are added up
+	var recent []RepeaterQuality
+	err := db.Model(&Call{}).
+		Select("repeater_id, COUNT(*) AS calls, SUM(loss) AS loss, SUM(jitter) AS jitter, SUM(ber) AS ber").
+		Where("start_time >= ?", since).
+		Group("repeater_id").
+		Scan(&recent).Error
+	if err != nil {
+		return nil, err
+	}
+	var summarized []RepeaterQuality
+	err = summariesSince(db, SummaryRepeater, since).
+		Select("subject_id AS repeater_id, " + castInteger(db, "SUM(calls)") + " AS calls, SUM(loss_sum) AS loss, SUM(jitter_sum) AS jitter, SUM(ber_sum) AS ber").
+		Group("subject_id").
+		Scan(&summarized).Error
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[uint]int, len(recent)+len(summarized))
+	quality := make([]RepeaterQuality, 0, len(recent)+len(summarized))
+	for _, entry := range slices.Concat(recent, summarized) {
+		if i, ok := totals[entry.RepeaterID]; ok {
+			quality[i].Calls += entry.Calls
+			quality[i].Loss += entry.Loss
+			quality[i].Jitter += entry.Jitter
+			quality[i].BER += entry.BER
+			continue
+		}
+		totals[entry.RepeaterID] = len(quality)
+		quality = append(quality, entry)
+	}
+	if len(quality) == 0 {
+		return quality, nil
+	}
+	slices.SortFunc(quality, func(a, b RepeaterQuality) int {
+		return cmp.Compare(a.RepeaterID, b.RepeaterID)
+	})
+	for i := range quality {
+		calls := float64(quality[i].Calls)
+		quality[i].Loss /= calls
+		quality[i].Jitter /= calls
+		quality[i].BER /= calls
+	}
+	activity := make([]CallActivity, len(quality))
+	for i, entry := range quality {
+		activity[i].ID = entry.RepeaterID
+	}
+	err = fillNames(db, &Repeater{}, "callsign", activity)
+	if err != nil {
+		return nil, err
+	}
+	for i := range quality {
+		quality[i].Callsign = activity[i].Name
+	}
+	return quality, nil
+}
+
+// CallsPerHour returns the number of calls started in each UTC hour from
+// since until until, including hours without calls. The calls that retention
+// has deleted are not counted, as their summaries have no hours.
+func CallsPerHour(db *gorm.DB, since time.Time, until time.Time) ([]HourlyCalls, error) {
+	var counts []struct {
+		Hour  int64
+		Calls int
+	}
+	err := db.Model(&Call{}).
+		Select(unixHour(db, "start_time")+" AS hour, COUNT(*) AS calls").
+		Where("start_time >= ? AND start_time < ?", since, until).
+		Group("hour").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	first := since.UTC().Truncate(time.Hour)
+	hours := make([]HourlyCalls, 0, int(until.Sub(first)/time.Hour)+1)
+	for hour := first; hour.Before(until); hour = hour.Add(time.Hour) {
+		hours = append(hours, HourlyCalls{Hour: hour})
+	}
+	firstHour := first.Unix() / int64(time.Hour/time.Second)
+	for _, count := range counts {
+		index := count.Hour - firstHour
+		if index >= 0 && index < int64(len(hours)) {
+			hours[index].Calls += count.Calls
+		}
+	}
+	return hours, nil
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+//nolint:golint,wrapcheck
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Subjects of call summaries
+const (
+	SummaryTalkgroup = "talkgroup"
+	SummaryUser      = "user"
+	SummaryRepeater  = "repeater"
+)
+
+// CallSummary is the traffic of one talkgroup, user or repeater on one UTC
+// day. Calls are rolled up into summaries before they are deleted, so long
+// term statistics outlive the calls themselves.
+type CallSummary struct {
+	ID          uint      `json:"-" gorm:"primaryKey"`
+	Day         time.Time `json:"day" gorm:"index:idx_call_summaries_key,unique"`
+	SubjectType string    `json:"subject_type" gorm:"index:idx_call_summaries_key,unique"`
+	SubjectID   uint      `json:"subject_id" gorm:"index:idx_call_summaries_key,unique"`
+	GroupCall   bool      `json:"group_call" gorm:"index:idx_call_summaries_key,unique"`
+	Calls       uint      `json:"calls"`
+	// Duration is the total airtime of the calls
+	Duration time.Duration `json:"duration"`
+	// LossSum, JitterSum, BERSum and RSSISum divided by Calls give the averages
+	LossSum   float64 `json:"loss_sum"`
+	JitterSum float64 `json:"jitter_sum"`
+	BERSum    float64 `json:"ber_sum"`
+	RSSISum   float64 `json:"rssi_sum"`
+}
+
+// AddCallSummaries adds the summaries to the stored ones for the same day and subject.
+func AddCallSummaries(db *gorm.DB, summaries []CallSummary) error {
+	if len(summaries) == 0 {
+		return nil
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "day"}, {Name: "subject_type"}, {Name: "subject_id"}, {Name: "group_call"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"calls":      gorm.Expr("call_summaries.calls + " + excluded(db, "calls")),
+			"duration":   gorm.Expr("call_summaries.duration + " + excluded(db, "duration")),
+			"loss_sum":   gorm.Expr("call_summaries.loss_sum + " + excluded(db, "loss_sum")),
+			"jitter_sum": gorm.Expr("call_summaries.jitter_sum + " + excluded(db, "jitter_sum")),
+			"ber_sum":    gorm.Expr("call_summaries.ber_sum + " + excluded(db, "ber_sum")),
+			"rssi_sum":   gorm.Expr("call_summaries.rssi_sum + " + excluded(db, "rssi_sum")),
+		}),
+	}).Create(&summaries).Error
+}
+
+// ListCallSummaries returns the daily summaries of a subject from since onwards, oldest first.
+func ListCallSummaries(db *gorm.DB, subjectType string, subjectID uint, since time.Time) ([]CallSummary, error) {
+	var summaries []CallSummary
+	err := db.Where("subject_type = ? AND subject_id = ? AND day >= ?", subjectType, subjectID, since).Order("day asc").Find(&summaries).Error
+	return summaries, err
+}
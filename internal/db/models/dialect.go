@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// isMySQL reports whether db talks to MySQL or MariaDB, which spell a few
+// expressions differently from Postgres and SQLite.
+func isMySQL(db *gorm.DB) bool {
+	return db.Dialector.Name() == "mysql"
+}
+
+// castInteger casts a numeric SQL expression to a 64-bit integer.
+func castInteger(db *gorm.DB, expr string) string {
+	if isMySQL(db) {
+		return "CAST(" + expr + " AS SIGNED)"
+	}
+	return "CAST(" + expr + " AS BIGINT)"
+}
+
+// excluded refers to the value of column in the row that an upsert failed to insert.
+func excluded(db *gorm.DB, column string) string {
+	if isMySQL(db) {
+		return "VALUES(" + column + ")"
+	}
+	return "excluded." + column
+}
+
+// unixHour is the number of whole hours from the Unix epoch to a timestamp column.
+func unixHour(db *gorm.DB, column string) string {
+	switch db.Dialector.Name() {
+	case "mysql":
+		// DATETIME columns hold UTC, this avoids converting them in the session time zone
+		return "TIMESTAMPDIFF(HOUR, '1970-01-01 00:00:00', " + column + ")"
+	case "postgres":
+		return "CAST(FLOOR(EXTRACT(EPOCH FROM " + column + ") / 3600) AS BIGINT)"
+	default:
+		return "CAST(strftime('%s', " + column + ") AS INTEGER) / 3600"
+	}
+}
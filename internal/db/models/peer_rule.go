@@ -61,12 +61,12 @@ func ListRulesForPeer(db *gorm.DB, peerID uint) []PeerRule {
 
 func ListIngressRulesForPeer(db *gorm.DB, peerID uint) []PeerRule {
 	var peerRules []PeerRule
-	db.Preload("Peer").Order("id asc").Where("peer_id = ? AND direction = true", peerID).Find(&peerRules)
+	db.Preload("Peer").Order("id asc").Where("peer_id = ? AND direction = ?", peerID, true).Find(&peerRules)
 	return peerRules
 }
 
 func ListEgressRulesForPeer(db *gorm.DB, peerID uint) []PeerRule {
 	var peerRules []PeerRule
-	db.Preload("Peer").Order("id asc").Where("peer_id = ? AND direction = false", peerID).Find(&peerRules)
+	db.Preload("Peer").Order("id asc").Where("peer_id = ? AND direction = ?", peerID, false).Find(&peerRules)
 	return peerRules
 }
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+//nolint:golint,wrapcheck
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a single-use code that stands in for a TOTP code.
+// Only a keyed hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Hash      string `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// ReplaceRecoveryCodes swaps all of a user's recovery codes for new ones.
+func ReplaceRecoveryCodes(db *gorm.DB, userID uint, hashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+		if err != nil {
+			return err
+		}
+		if len(hashes) == 0 {
+			return nil
+		}
+		codes := make([]RecoveryCode, 0, len(hashes))
+		for _, hash := range hashes {
+			codes = append(codes, RecoveryCode{UserID: userID, Hash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseRecoveryCode consumes a recovery code, reporting whether it was valid.
+func UseRecoveryCode(db *gorm.DB, userID uint, hash string) (bool, error) {
+	result := db.Where("user_id = ? AND hash = ?", userID, hash).Delete(&RecoveryCode{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func CountRecoveryCodes(db *gorm.DB, userID uint) (int, error) {
+	var count int64
+	err := db.Model(&RecoveryCode{}).Where("user_id = ?", userID).Count(&count).Error
+	return int(count), err
+}
@@ -22,6 +22,7 @@ package models
 
 import (
 	"encoding/json"
+	"math"
 	"time"
 
 	"github.com/USA-RedDragon/DMRHub/internal/config"
@@ -51,12 +52,69 @@ type Repeater struct {
 	Owner                 User           `json:"owner" gorm:"foreignKey:OwnerID" msg:"-"`
 	OwnerID               uint           `json:"-" msg:"-"`
 	Hotspot               bool           `json:"hotspot" msg:"hotspot"`
+	MapPrivacy            MapPrivacy     `json:"map_privacy" msg:"-"`
 	CreatedAt             time.Time      `json:"created_at" msg:"-"`
 	UpdatedAt             time.Time      `json:"-" msg:"-"`
 	DeletedAt             gorm.DeletedAt `json:"-" gorm:"index" msg:"-"`
 	RepeaterConfiguration
 }
 
+// MapPrivacy is how a repeater's owner wants it shown on the public map.
+type MapPrivacy string
+
+const (
+	// MapPrivacyDefault hides hotspots and shows repeaters at their position
+	MapPrivacyDefault MapPrivacy = ""
+	MapPrivacyExact   MapPrivacy = "exact"
+	// MapPrivacyApproximate snaps the position to a grid of mapFuzzDegrees
+	MapPrivacyApproximate MapPrivacy = "approximate"
+	MapPrivacyHidden      MapPrivacy = "hidden"
+)
+
+// mapFuzzDegrees is the grid approximate positions are snapped to, around 5km.
+// Snapping rather than adding noise means repeated requests can't be averaged
+// to find the real position.
+const mapFuzzDegrees = 0.05
+
+func (m MapPrivacy) Valid() bool {
+	switch m {
+	case MapPrivacyDefault, MapPrivacyExact, MapPrivacyApproximate, MapPrivacyHidden:
+		return true
+	default:
+		return false
+	}
+}
+
+// EffectiveMapPrivacy resolves the default privacy setting of the repeater.
+func (p *Repeater) EffectiveMapPrivacy() MapPrivacy {
+	if p.MapPrivacy != MapPrivacyDefault {
+		return p.MapPrivacy
+	}
+	if p.Hotspot {
+		return MapPrivacyHidden
+	}
+	return MapPrivacyExact
+}
+
+// MapPosition returns the position to show the repeater at on the public map,
+// or false if it isn't shown or hasn't reported a position.
+func (p *Repeater) MapPosition() (latitude float64, longitude float64, ok bool) {
+	if p.Latitude == 0 && p.Longitude == 0 {
+		return 0, 0, false
+	}
+	switch p.EffectiveMapPrivacy() {
+	case MapPrivacyExact:
+		return p.Latitude, p.Longitude, true
+	case MapPrivacyApproximate:
+		snap := func(degrees float64) float64 {
+			return math.Round(math.Round(degrees/mapFuzzDegrees)*mapFuzzDegrees*100) / 100
+		}
+		return snap(p.Latitude), snap(p.Longitude), true
+	default:
+		return 0, 0, false
+	}
+}
+
 func (p *Repeater) String() string {
 	jsn, err := json.Marshal(p)
 	if err != nil {
@@ -113,6 +171,7 @@ func RepeaterIDExists(db *gorm.DB, id uint) (bool, error) {
 func DeleteRepeater(db *gorm.DB, id uint) error {
 	err := db.Transaction(func(tx *gorm.DB) error {
 		tx.Unscoped().Where("(is_to_repeater = ? AND to_repeater_id = ?) OR repeater_id = ?", true, id, id).Delete(&Call{})
+		tx.Unscoped().Where("repeater_id = ?", id).Delete(&RepeaterAlert{})
 		tx.Unscoped().Where("id = ?", id).Select(clause.Associations, "TS1StaticTalkgroups").Select(clause.Associations, "TS2StaticTalkgroups").Delete(&Repeater{})
 		return nil
 	})
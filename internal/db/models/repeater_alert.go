@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+//nolint:golint,wrapcheck
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RepeaterAlert is a user's subscription to offline and online alerts for a repeater.
+// It also holds the alert state, which is only updated by the alert checker.
+type RepeaterAlert struct {
+	ID         uint     `json:"id" gorm:"primaryKey"`
+	RepeaterID uint     `json:"repeater_id" gorm:"index"`
+	Repeater   Repeater `json:"-" gorm:"foreignKey:RepeaterID"`
+	UserID     uint     `json:"-" gorm:"index"`
+	User       User     `json:"-" gorm:"foreignKey:UserID"`
+	// OfflineAfterMinutes is how long a repeater can miss keepalives before it is considered offline
+	OfflineAfterMinutes uint   `json:"offline_after_minutes"`
+	Email               bool   `json:"email"`
+	WebhookURL          string `json:"webhook_url"`
+	// WebhookSecret signs webhook requests, it is only shown when the alert is created
+	WebhookSecret string `json:"-"`
+	// QuietHoursStart and QuietHoursEnd are HH:MM in Timezone, alerts are held back in between
+	QuietHoursStart string `json:"quiet_hours_start"`
+	QuietHoursEnd   string `json:"quiet_hours_end"`
+	Timezone        string `json:"timezone"`
+
+	// Offline is the last state the checker saw
+	Offline bool `json:"offline"`
+	// NotifiedOffline is the last state the subscriber was told about
+	NotifiedOffline  bool      `json:"-"`
+	Transitions      uint      `json:"-"`
+	LastTransitionAt time.Time `json:"-"`
+	// Revision guards against two instances handling the same check
+	Revision  uint           `json:"-"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"-"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+func ListRepeaterAlerts(db *gorm.DB) ([]RepeaterAlert, error) {
+	var alerts []RepeaterAlert
+	err := db.Preload("Repeater").Preload("User").Order("id asc").Find(&alerts).Error
+	return alerts, err
+}
+
+func FindRepeaterAlertByID(db *gorm.DB, id uint) (RepeaterAlert, error) {
+	var alert RepeaterAlert
+	err := db.First(&alert, id).Error
+	return alert, err
+}
+
+func ListUserRepeaterAlerts(db *gorm.DB, userID uint, repeaterID uint) ([]RepeaterAlert, error) {
+	var alerts []RepeaterAlert
+	err := db.Where("user_id = ? AND repeater_id = ?", userID, repeaterID).Order("id asc").Find(&alerts).Error
+	return alerts, err
+}
+
+// DeleteUserRepeaterAlert deletes one of a user's alert subscriptions, reporting whether it existed.
+func DeleteUserRepeaterAlert(db *gorm.DB, userID uint, repeaterID uint, id uint) (bool, error) {
+	var deleted bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("id = ? AND user_id = ? AND repeater_id = ?", id, userID, repeaterID).Delete(&RepeaterAlert{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		deleted = true
+		return tx.Where("repeater_alert_id = ?", id).Delete(&WebhookDelivery{}).Error
+	})
+	return deleted, err
+}
+
+// SaveRepeaterAlertState stores the state of an alert if no one else has since the alert was loaded.
+// It reports whether the state was stored.
+func SaveRepeaterAlertState(db *gorm.DB, alert *RepeaterAlert) (bool, error) {
+	result := db.Model(&RepeaterAlert{}).Where("id = ? AND revision = ?", alert.ID, alert.Revision).Updates(map[string]any{
+		"offline":            alert.Offline,
+		"notified_offline":   alert.NotifiedOffline,
+		"transitions":        alert.Transitions,
+		"last_transition_at": alert.LastTransitionAt,
+		"revision":           alert.Revision + 1,
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	alert.Revision++
+	return true, nil
+}
@@ -106,9 +106,9 @@ func DeleteTalkgroup(db *gorm.DB, id uint) error {
 
 func FindTalkgroupsByOwnerID(db *gorm.DB, ownerID uint) ([]Talkgroup, error) {
 	var talkgroups []Talkgroup
-	if err := db.Joins("JOIN talkgroup_admins on talkgroup_admins.talkgroup_id=talkgroups.id").
-		Joins("JOIN users on talkgroup_admins.user_id=users.id").Order("id asc").Where("users.id=?", ownerID).
-		Group("talkgroups.id").Find(&talkgroups).Error; err != nil {
+	if err := db.Joins("JOIN talkgroup_admins ON talkgroup_admins.talkgroup_id = talkgroups.id").
+		Where("talkgroup_admins.user_id = ?", ownerID).Order("talkgroups.id asc").
+		Find(&talkgroups).Error; err != nil {
 		logging.Errorf("Error getting talkgroups owned by user %d: %v", ownerID, err)
 		return nil, err
 	}
@@ -117,9 +117,8 @@ func FindTalkgroupsByOwnerID(db *gorm.DB, ownerID uint) ([]Talkgroup, error) {
 
 func CountTalkgroupsByOwnerID(db *gorm.DB, ownerID uint) (int, error) {
 	var count int64
-	err := db.Model(&Talkgroup{}).Joins("JOIN talkgroup_admins on talkgroup_admins.talkgroup_id=talkgroups.id").
-		Joins("JOIN users on talkgroup_admins.user_id=users.id").
-		Where("users.id=?", ownerID).Count(&count).Error
+	err := db.Model(&Talkgroup{}).Joins("JOIN talkgroup_admins ON talkgroup_admins.talkgroup_id = talkgroups.id").
+		Where("talkgroup_admins.user_id = ?", ownerID).Count(&count).Error
 	return int(count), err
 }
 
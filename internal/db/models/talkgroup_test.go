@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package models_test
+
+import (
+	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+)
+
+func TestTalkgroupsByOwner(t *testing.T) {
+	t.Parallel()
+	db := testutils.OpenDB(t)
+	owner := models.User{ID: 3191868, Callsign: "KI5VMF", Username: "jacob"}
+	other := models.User{ID: 3140598, Callsign: "KP4DJT", Username: "dan"}
+	talkgroups := []models.Talkgroup{
+		{ID: 3100, Name: "USA", Admins: []models.User{owner, other}},
+		{ID: 91, Name: "Worldwide", Admins: []models.User{owner}},
+		{ID: 9990, Name: "Parrot", Admins: []models.User{other}},
+	}
+	if err := db.Create(&talkgroups).Error; err != nil {
+		t.Fatalf("Failed to create talkgroups: %v", err)
+	}
+
+	owned, err := models.FindTalkgroupsByOwnerID(db, owner.ID)
+	if err != nil {
+		t.Fatalf("Failed to find talkgroups: %v", err)
+	}
+	if len(owned) != 2 || owned[0].ID != 91 || owned[1].ID != 3100 {
+		t.Errorf("Expected talkgroups 91 and 3100, got %+v", owned)
+	}
+	count, err := models.CountTalkgroupsByOwnerID(db, other.ID)
+	if err != nil || count != 2 {
+		t.Errorf("Expected 2 talkgroups, got %d %v", count, err)
+	}
+}
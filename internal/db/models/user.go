@@ -32,18 +32,25 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+// User is a registered operator. Unique strings are tagged index:,unique rather
+// than uniqueIndex so that MySQL gives them an indexable VARCHAR column.
 type User struct {
-	ID        uint           `json:"id" gorm:"primaryKey" binding:"required"`
-	Callsign  string         `json:"callsign" gorm:"uniqueIndex" binding:"required"`
-	Username  string         `json:"username" gorm:"uniqueIndex" binding:"required"`
-	Password  string         `json:"-"`
-	Admin     bool           `json:"admin"`
-	Approved  bool           `json:"approved" binding:"required"`
-	Suspended bool           `json:"suspended"`
-	Repeaters []Repeater     `json:"repeaters" gorm:"foreignKey:OwnerID"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"-"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID            uint           `json:"id" gorm:"primaryKey" binding:"required"`
+	Callsign      string         `json:"callsign" gorm:"index:,unique" binding:"required"`
+	Username      string         `json:"username" gorm:"index:,unique" binding:"required"`
+	Password      string         `json:"-"`
+	Email         string         `json:"-" gorm:"index"`
+	EmailVerified bool           `json:"-"`
+	Admin         bool           `json:"admin"`
+	Approved      bool           `json:"approved" binding:"required"`
+	Suspended     bool           `json:"suspended"`
+	Repeaters     []Repeater     `json:"repeaters" gorm:"foreignKey:OwnerID"`
+	TOTPSecret    string         `json:"-"`
+	TOTPEnabled   bool           `json:"totp_enabled"`
+	TOTPLastStep  int64          `json:"-"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"-"`
+	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 func (u User) TableName() string {
@@ -68,6 +75,34 @@ func FindUserByID(db *gorm.DB, id uint) (User, error) {
 	return user, err
 }
 
+// FindUserByVerifiedEmail finds the user who has verified the given address.
+func FindUserByVerifiedEmail(db *gorm.DB, email string) (User, error) {
+	var user User
+	err := db.Where("email = ? AND email_verified = ?", email, true).First(&user).Error
+	return user, err
+}
+
+// EmailInUse reports whether another user has already verified the given address.
+// Unverified addresses don't count, so nobody can claim someone else's address
+// to stop them from adding it.
+func EmailInUse(db *gorm.DB, email string, exceptUserID uint) (bool, error) {
+	var count int64
+	err := db.Model(&User{}).Where("email = ? AND email_verified = ? AND id <> ?", email, true, exceptUserID).Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+// ClaimVerifiedEmail marks the user's address verified and removes it from
+// any other users who added it without verifying it.
+func ClaimVerifiedEmail(db *gorm.DB, user *User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("email = ? AND email_verified = ? AND id <> ?", user.Email, false, user.ID).Update("email", "").Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(user).Update("email_verified", true).Error
+	})
+}
+
 func ListUsers(db *gorm.DB) ([]User, error) {
 	var users []User
 	err := db.Preload("Repeaters").Find(&users).Error
@@ -156,11 +191,15 @@ func DeleteUser(db *gorm.DB, id uint) error {
 		var repeaters []Repeater
 		tx.Where("owner_id = ?", id).Find(&repeaters)
 		for _, repeater := range repeaters {
+			tx.Unscoped().Where("repeater_id = ?", repeater.ID).Delete(&RepeaterAlert{})
 			tx.Unscoped().Where("(is_to_repeater = ? AND to_repeater_id = ?) OR repeater_id = ?", true, repeater.ID, repeater.ID).Delete(&Call{})
 			tx.Unscoped().Select(clause.Associations, "TS1StaticTalkgroups").Select(clause.Associations, "TS2StaticTalkgroups").Delete(repeater)
 			tx.Unscoped().Table("talkgroup_admins").Where("user_id = ?", id).Delete(&Talkgroup{})
 			tx.Unscoped().Table("talkgroup_ncos").Where("user_id = ?", id).Delete(&Talkgroup{})
 		}
+		tx.Unscoped().Where("user_id = ?", id).Delete(&APIToken{})
+		tx.Unscoped().Where("user_id = ?", id).Delete(&RecoveryCode{})
+		tx.Unscoped().Where("user_id = ?", id).Delete(&RepeaterAlert{})
 		tx.Unscoped().Select(clause.Associations, "Repeaters").Delete(&User{ID: id})
 		return nil
 	})
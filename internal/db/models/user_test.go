@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package models_test
+
+import (
+	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+)
+
+func TestUnverifiedEmailIsNotInUse(t *testing.T) {
+	t.Parallel()
+	db := testutils.OpenDB(t)
+	squatter := models.User{ID: 3140598, Callsign: "N0CALL", Username: "squatter", Email: "jacob@example.com"}
+	owner := models.User{ID: 3191868, Callsign: "KI5VMF", Username: "jacob", Email: "jacob@example.com"}
+	for _, user := range []*models.User{&squatter, &owner} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	inUse, err := models.EmailInUse(db, "jacob@example.com", owner.ID)
+	if err != nil || inUse {
+		t.Errorf("Expected an unverified address not to be in use, got %t %v", inUse, err)
+	}
+
+	if err := models.ClaimVerifiedEmail(db, &owner); err != nil {
+		t.Fatalf("Failed to verify email: %v", err)
+	}
+	inUse, err = models.EmailInUse(db, "jacob@example.com", squatter.ID)
+	if err != nil || !inUse {
+		t.Errorf("Expected a verified address to be in use, got %t %v", inUse, err)
+	}
+	var reloaded models.User
+	if err := db.First(&reloaded, squatter.ID).Error; err != nil {
+		t.Fatalf("Failed to reload user: %v", err)
+	}
+	if reloaded.Email != "" {
+		t.Errorf("Expected the unverified copy of the address to be removed, got %q", reloaded.Email)
+	}
+}
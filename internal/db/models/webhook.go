@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+//nolint:golint,wrapcheck
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook is an endpoint that is sent signed JSON POSTs when events happen.
+type Webhook struct {
+	ID      uint   `json:"id" gorm:"primaryKey"`
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Secret  string `json:"-"`
+	Events  string `json:"-"`
+	Enabled bool   `json:"enabled"`
+	// CreatedByID is the admin who registered the webhook
+	CreatedByID uint           `json:"created_by_id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"-"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// EventList returns the events the webhook is subscribed to.
+func (w Webhook) EventList() []string {
+	if w.Events == "" {
+		return []string{}
+	}
+	return strings.Split(w.Events, ",")
+}
+
+// Subscribed reports whether the webhook should receive an event.
+func (w Webhook) Subscribed(event string) bool {
+	return slices.Contains(w.EventList(), event)
+}
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook.
+type WebhookDelivery struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	WebhookID uint   `json:"webhook_id" gorm:"index"`
+	Event     string `json:"event"`
+	Payload   string `json:"payload"`
+	Status    string `json:"status" gorm:"index"`
+	Attempts  uint   `json:"attempts"`
+	// StatusCode and Error describe the most recent attempt
+	StatusCode    int        `json:"status_code"`
+	Error         string     `json:"error"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	// RepeaterAlertID is set instead of WebhookID for deliveries to a repeater alert's webhook
+	RepeaterAlertID uint      `json:"repeater_alert_id,omitempty" gorm:"index"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"-"`
+}
+
+func ListWebhooks(db *gorm.DB) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := db.Order("id asc").Find(&webhooks).Error
+	return webhooks, err
+}
+
+func CountWebhooks(db *gorm.DB) (int, error) {
+	var count int64
+	err := db.Model(&Webhook{}).Count(&count).Error
+	return int(count), err
+}
+
+func FindWebhookByID(db *gorm.DB, id uint) (Webhook, error) {
+	var webhook Webhook
+	err := db.First(&webhook, id).Error
+	return webhook, err
+}
+
+// ListWebhooksForEvent returns the enabled webhooks subscribed to an event.
+func ListWebhooksForEvent(db *gorm.DB, event string) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := db.Where("enabled = ?", true).Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(webhooks, func(webhook Webhook) bool {
+		return !webhook.Subscribed(event)
+	}), nil
+}
+
+func DeleteWebhook(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("webhook_id = ?", id).Delete(&WebhookDelivery{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&Webhook{ID: id}).Error
+	})
+}
+
+// ListWebhookDeliveries returns the deliveries to a webhook, newest first.
+func ListWebhookDeliveries(db *gorm.DB, webhookID uint) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.Where("webhook_id = ?", webhookID).Order("id desc").Find(&deliveries).Error
+	return deliveries, err
+}
+
+func CountWebhookDeliveries(db *gorm.DB, webhookID uint) (int, error) {
+	var count int64
+	err := db.Model(&WebhookDelivery{}).Where("webhook_id = ?", webhookID).Count(&count).Error
+	return int(count), err
+}
+
+// ListDueWebhookDeliveries returns pending deliveries whose next attempt is due.
+func ListDueWebhookDeliveries(db *gorm.DB, now time.Time, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).Order("next_attempt_at asc").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// ClaimWebhookDelivery reserves a due delivery for one attempt until lease,
+// so only one instance sends it. It reports whether the claim succeeded.
+func ClaimWebhookDelivery(db *gorm.DB, delivery *WebhookDelivery, now time.Time, lease time.Time) (bool, error) {
+	result := db.Model(&WebhookDelivery{}).
+		Where("id = ? AND status = ? AND attempts = ? AND next_attempt_at <= ?", delivery.ID, DeliveryPending, delivery.Attempts, now).
+		Updates(map[string]any{"attempts": delivery.Attempts + 1, "next_attempt_at": lease})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	delivery.Attempts++
+	delivery.NextAttemptAt = lease
+	return true, nil
+}
+
+// DeleteWebhookDeliveriesBefore prunes the delivery log, keeping pending deliveries.
+func DeleteWebhookDeliveriesBefore(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("created_at < ? AND status <> ?", before, DeliveryPending).Delete(&WebhookDelivery{})
+	return result.RowsAffected, result.Error
+}
@@ -20,18 +20,27 @@
 package calltracker
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/gob"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/USA-RedDragon/DMRHub/internal/config"
 	"github.com/USA-RedDragon/DMRHub/internal/db/models"
 	dmrconst "github.com/USA-RedDragon/DMRHub/internal/dmr/dmrconst"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/metrics"
+	"github.com/USA-RedDragon/DMRHub/internal/mqtt"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
 	"github.com/mitchellh/hashstructure/v2"
-	"github.com/puzpuzpuz/xsync/v3"
-	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/otel"
 	"gorm.io/gorm"
 )
@@ -42,6 +51,41 @@ const timerDelay = 2 * time.Second
 const packetTimingMs = 60
 const pct = 100
 
+// In-flight calls are kept in the shared store so that every DMRHub instance
+// sees the same calls, whichever instance their packets arrive on.
+const (
+	// callsKey is the hash of in-flight calls, keyed by call hash
+	callsKey = "calltracker:calls"
+	// callDataPrefix prefixes the list of voice frames received for a call
+	callDataPrefix = "calltracker:data:"
+	// alivePrefix prefixes a key that expires timerDelay after the last packet
+	// of a call. The store expires it, so instances with skewed clocks agree on
+	// when a call has timed out.
+	alivePrefix = "calltracker:alive:"
+	// activePrefix prefixes the hash of the call in progress on a repeater's timeslot
+	activePrefix = "calltracker:active:"
+	// lockPrefix prefixes the lock held while a call is updated
+	lockPrefix = "calltracker:lock:"
+	// announcedPrefix marks the calls that webhooks have been told about
+	announcedPrefix = "calltracker:announced:"
+	// lockTTL frees the lock of an instance that died while holding it
+	lockTTL = 5 * time.Second
+	// lockTimeout is how long to wait for another instance to release a call
+	lockTimeout = time.Second
+	lockRetry   = 5 * time.Millisecond
+	// announcedTTL forgets announced calls that were never ended
+	announcedTTL = 24 * time.Hour
+	// activeTTL forgets the timeslot index of calls that were never ended
+	activeTTL = 24 * time.Hour
+	// sweepInterval is how often calls without recent packets are looked for
+	sweepInterval = 250 * time.Millisecond
+	// keyUpWindow is how long a call must last before it is announced,
+	// shorter calls are taken for key-ups and discarded
+	keyUpWindow = 100 * time.Millisecond
+)
+
+var ErrCallLocked = errors.New("call is locked by another instance")
+
 // These are the keys that we use to create a consistent hash
 type callMapStruct struct {
 	Active        bool
@@ -86,22 +130,99 @@ func getCallHash(call models.Call) (uint64, error) {
 	return hash, err //nolint:golint,wrapcheck
 }
 
+// inFlightCall is a call in progress as kept in the shared store.
+type inFlightCall struct {
+	Call models.Call
+}
+
 // CallTracker is a struct that holds the state of the calls that are currently in progress.
 type CallTracker struct {
-	db            *gorm.DB
-	redis         *redis.Client
-	callEndTimers *xsync.MapOf[uint64, *time.Timer]
-	inFlightCalls *xsync.MapOf[uint64, *models.Call]
+	db     *gorm.DB
+	kv     kv.KV
+	pubsub pubsub.PubSub
 }
 
 // NewCallTracker creates a new CallTracker.
-func NewCallTracker(db *gorm.DB, redis *redis.Client) *CallTracker {
+func NewCallTracker(db *gorm.DB, store kv.KV, ps pubsub.PubSub) *CallTracker {
 	return &CallTracker{
-		db:            db,
-		redis:         redis,
-		callEndTimers: xsync.NewMapOf[uint64, *time.Timer](),
-		inFlightCalls: xsync.NewMapOf[uint64, *models.Call](),
+		db:     db,
+		kv:     store,
+		pubsub: ps,
+	}
+}
+
+func callField(hash uint64) string {
+	return strconv.FormatUint(hash, 10)
+}
+
+func activeKey(repeaterID uint, timeSlot bool) string {
+	slot := "1"
+	if timeSlot {
+		slot = "2"
+	}
+	return activePrefix + strconv.FormatUint(uint64(repeaterID), 10) + ":" + slot
+}
+
+// touchCall keeps a call from timing out for another timerDelay.
+func (c *CallTracker) touchCall(ctx context.Context, hash uint64) error {
+	return c.kv.Set(ctx, alivePrefix+callField(hash), []byte{}, timerDelay) //nolint:golint,wrapcheck
+}
+
+// lock stops other instances from changing the call until the returned func is called.
+func (c *CallTracker) lock(ctx context.Context, hash uint64) (func(), error) {
+	key := lockPrefix + callField(hash)
+	token := make([]byte, 16) //nolint:golint,mnd
+	_, err := rand.Read(token)
+	if err != nil {
+		return nil, fmt.Errorf("could not create lock token: %w", err)
+	}
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		locked, err := c.kv.SetNX(ctx, key, token, lockTTL)
+		if err != nil {
+			return nil, fmt.Errorf("could not lock call: %w", err)
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrCallLocked
+		}
+		time.Sleep(lockRetry)
+	}
+	return func() {
+		_, err := c.kv.CompareAndDelete(context.WithoutCancel(ctx), key, token)
+		if err != nil {
+			logging.Errorf("Error unlocking call: %v", err)
+		}
+	}, nil
+}
+
+// loadCall returns the in-flight call with the given hash, or kv.ErrNotFound.
+func (c *CallTracker) loadCall(ctx context.Context, hash uint64) (*inFlightCall, error) {
+	data, err := c.kv.HGet(ctx, callsKey, callField(hash))
+	if err != nil {
+		return nil, err //nolint:golint,wrapcheck
+	}
+	return decodeCall(data)
+}
+
+func (c *CallTracker) storeCall(ctx context.Context, hash uint64, call *inFlightCall) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(call)
+	if err != nil {
+		return fmt.Errorf("could not encode call: %w", err)
+	}
+	return c.kv.HSet(ctx, callsKey, callField(hash), buf.Bytes()) //nolint:golint,wrapcheck
+}
+
+func decodeCall(data []byte) (*inFlightCall, error) {
+	var call inFlightCall
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&call)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode call: %w", err)
 	}
+	return &call, nil
 }
 
 // StartCall starts tracking a new call.
@@ -250,6 +371,27 @@ func (c *CallTracker) StartCall(ctx context.Context, packet models.Packet) {
 		call.ToTalkgroup = destTalkgroup
 	}
 
+	callHash, err := getCallHash(call)
+	if err != nil {
+		return
+	}
+
+	unlock, err := c.lock(ctx, callHash)
+	if err != nil {
+		logging.Errorf("Error starting call: %v", err)
+		return
+	}
+	defer unlock()
+
+	// Another instance may have started the call while we looked up its details
+	_, err = c.kv.HGet(ctx, callsKey, callField(callHash))
+	if err == nil {
+		return
+	} else if !errors.Is(err, kv.ErrNotFound) {
+		logging.Errorf("Error checking for call: %v", err)
+		return
+	}
+
 	// Create the call in the database
 	err = c.db.Create(&call).Error
 	if err != nil {
@@ -257,20 +399,29 @@ func (c *CallTracker) StartCall(ctx context.Context, packet models.Packet) {
 		return
 	}
 
-	callHash, err := getCallHash(call)
+	// Add the call to the in-flight calls. The sweeper ends it
+	// once we haven't seen a packet for timerDelay.
+	err = c.touchCall(ctx, callHash)
+	if err == nil {
+		err = c.storeCall(ctx, callHash, &inFlightCall{Call: call})
+	}
 	if err != nil {
+		logging.Errorf("Error storing call: %v", err)
+		// Nothing would ever end the call, so don't leave it active in the database
+		err = c.db.Unscoped().Delete(&call).Error
+		if err != nil {
+			logging.Errorf("Error removing call: %v", err)
+		}
 		return
 	}
-
-	// Add the call to the active calls map
-	c.inFlightCalls.Store(callHash, &call)
+	err = c.kv.Set(ctx, activeKey(call.RepeaterID, call.TimeSlot), []byte(callField(callHash)), activeTTL)
+	if err != nil {
+		logging.Errorf("Error indexing call: %v", err)
+	}
 
 	if config.GetConfig().Debug {
 		logging.Logf("Started call %d", call.StreamID)
 	}
-
-	// Add a timer that will end the call if we haven't seen a packet in 1 second.
-	c.callEndTimers.Store(callHash, time.AfterFunc(timerDelay, endCallHandler(ctx, c, packet)))
 }
 
 // IsCallActive checks if a call is active.
@@ -283,14 +434,78 @@ func (c *CallTracker) IsCallActive(ctx context.Context, packet models.Packet) bo
 		return false
 	}
 
-	_, ok := c.inFlightCalls.Load(callHash)
-	return ok
+	_, err = c.kv.HGet(ctx, callsKey, callField(callHash))
+	if err != nil && !errors.Is(err, kv.ErrNotFound) {
+		logging.Errorf("Error checking for call: %v", err)
+	}
+	return err == nil
+}
+
+// ActiveCall returns the call currently in progress from the given repeater on the given timeslot, if any.
+func (c *CallTracker) ActiveCall(ctx context.Context, repeaterID uint, timeSlot bool) (models.Call, bool) {
+	field, err := c.kv.Get(ctx, activeKey(repeaterID, timeSlot))
+	if errors.Is(err, kv.ErrNotFound) {
+		return models.Call{}, false
+	} else if err != nil {
+		logging.Errorf("Error finding active call: %v", err)
+		return models.Call{}, false
+	}
+	data, err := c.kv.HGet(ctx, callsKey, string(field))
+	if errors.Is(err, kv.ErrNotFound) {
+		// The call ended between the two lookups
+		return models.Call{}, false
+	} else if err != nil {
+		logging.Errorf("Error loading call: %v", err)
+		return models.Call{}, false
+	}
+	inFlight, err := decodeCall(data)
+	if err != nil {
+		logging.Errorf("Error reading call: %v", err)
+		return models.Call{}, false
+	}
+	return inFlight.Call, true
 }
 
 func (c *CallTracker) publishCall(ctx context.Context, call *models.Call) {
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "CallTracker.publishCall")
 	defer span.End()
 
+	announcedKey := announcedPrefix + strconv.FormatUint(uint64(call.ID), 10)
+	if call.Active {
+		// Calls are only announced once they can no longer be discarded as a key-up
+		if time.Since(call.StartTime) >= keyUpWindow {
+			// Whichever instance publishes the call first announces it
+			first, err := c.kv.SetNX(ctx, announcedKey, []byte{}, announcedTTL)
+			switch {
+			case err != nil:
+				logging.Errorf("Error marking call announced: %v", err)
+			case first:
+				go webhooks.Emit(c.db, webhooks.EventCallStarted, webhooks.CallFromModel(call))
+				mqtt.PublishCall(mqtt.CallStarted, webhooks.CallFromModel(call))
+			default:
+				mqtt.PublishCall(mqtt.CallUpdated, webhooks.CallFromModel(call))
+			}
+		}
+	} else {
+		// The call is only ended once, so this is the only publish after it ends
+		announced, err := c.kv.Delete(ctx, announcedKey)
+		if err != nil {
+			logging.Errorf("Error clearing call announcement: %v", err)
+		}
+		ended := webhooks.CallFromModel(call)
+		go func() {
+			if !announced {
+				// The call ended before a packet arrived after the key-up window
+				webhooks.Emit(c.db, webhooks.EventCallStarted, ended)
+			}
+			webhooks.Emit(c.db, webhooks.EventCallEnded, ended)
+		}()
+		if !announced {
+			mqtt.PublishCall(mqtt.CallStarted, ended)
+		}
+		mqtt.PublishCall(mqtt.CallEnded, ended)
+	}
+
 	if (call.IsToRepeater || call.IsToTalkgroup) && call.GroupCall {
 		// copy call into a jsonCallResponse
 		var jsonCall apimodels.WSCallResponse
@@ -322,14 +537,14 @@ func (c *CallTracker) publishCall(ctx context.Context, call *models.Call) {
 		jsonCall.Jitter = call.Jitter
 		jsonCall.BER = call.BER
 		jsonCall.RSSI = call.RSSI
-		// Publish the call JSON to Redis
+		// Publish the call JSON
 		callJSON, err := json.Marshal(jsonCall)
 		if err != nil {
 			logging.Errorf("Error marshalling call JSON: %v", err)
 			return
 		}
 
-		_, err = c.redis.Publish(ctx, "calls:public", callJSON).Result()
+		err = c.pubsub.Publish(ctx, "calls:public", callJSON)
 		if err != nil {
 			logging.Errorf("Error publishing call JSON: %v", err)
 			return
@@ -341,33 +556,18 @@ func (c *CallTracker) publishCall(ctx context.Context, call *models.Call) {
 		logging.Errorf("Error marshalling call JSON: %v", err)
 		return
 	}
-	_, err = c.redis.Publish(ctx, "calls", origCallJSON).Result()
+	err = c.pubsub.Publish(ctx, "calls", origCallJSON)
 	if err != nil {
 		logging.Errorf("Error publishing call JSON: %v", err)
 		return
 	}
 }
 
-func (c *CallTracker) updateCall(ctx context.Context, call *models.Call, packet models.Packet) {
-	ctx, span := otel.Tracer("DMRHub").Start(ctx, "CallTracker.updateCall")
-	defer span.End()
-
-	hash, err := getCallHash(*call)
-	if err != nil {
-		return
-	}
-
-	timer, ok := c.callEndTimers.Load(hash)
-	if !ok {
-		return
-	}
-
-	// Reset call end timer
-	timer.Reset(timerDelay)
-
+// updateCall adds the packet to the call's statistics, returning false for duplicate packets.
+func updateCall(call *models.Call, packet models.Packet) bool {
 	if call.LastSeq == packet.Seq {
 		// This is a dup
-		return
+		return false
 	}
 
 	call.LastSeq = packet.Seq
@@ -406,9 +606,7 @@ func (c *CallTracker) updateCall(ctx context.Context, call *models.Call, packet
 		call.RSSI = (call.RSSI + float32(packet.RSSI)) / 2 //nolint:golint,gomnd
 	}
 
-	call.CallData = append(call.CallData, packet.DMRData[:]...)
-
-	go c.publishCall(ctx, call)
+	return true
 }
 
 func calcSequenceLoss(call *models.Call, packet models.Packet) {
@@ -490,22 +688,85 @@ func (c *CallTracker) ProcessCallPacket(ctx context.Context, packet models.Packe
 		return
 	}
 
-	call, ok := c.inFlightCalls.Load(hash)
-	if !ok {
+	unlock, err := c.lock(ctx, hash)
+	if err != nil {
+		logging.Errorf("Error updating call: %v", err)
+		return
+	}
+	defer unlock()
+
+	inFlight, err := c.loadCall(ctx, hash)
+	if errors.Is(err, kv.ErrNotFound) {
 		logging.Errorf("Active call not found")
 		return
+	} else if err != nil {
+		logging.Errorf("Error loading call: %v", err)
+		return
 	}
 
-	c.updateCall(ctx, call, packet)
+	// Duplicates keep the call alive too
+	err = c.touchCall(ctx, hash)
+	if err != nil {
+		logging.Errorf("Error refreshing call: %v", err)
+	}
+	updated := updateCall(&inFlight.Call, packet)
+	if updated {
+		// The voice data is appended separately so it isn't rewritten with every packet
+		err = c.kv.RPush(ctx, callDataPrefix+callField(hash), packet.DMRData[:])
+		if err != nil {
+			logging.Errorf("Error storing call data: %v", err)
+		}
+	}
+	err = c.storeCall(ctx, hash, inFlight)
+	if err != nil {
+		logging.Errorf("Error storing call: %v", err)
+		return
+	}
+
+	if updated {
+		// Published while the call is still locked, so an update can't
+		// overtake the end of the call
+		c.publishCall(ctx, &inFlight.Call)
+	}
 }
 
-func endCallHandler(ctx context.Context, c *CallTracker, packet models.Packet) func() {
-	ctx, span := otel.Tracer("DMRHub").Start(ctx, "calltracker.endCallHandler")
-	defer span.End()
+// Start ends the calls that stopped receiving packets until ctx is done.
+// Every instance runs it, and the first to find a timed out call ends it.
+func (c *CallTracker) Start(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.endTimedOutCalls(ctx)
+		}
+	}
+}
 
-	return func() {
-		logging.Errorf("Call %d timed out", packet.StreamID)
-		c.EndCall(ctx, packet)
+func (c *CallTracker) endTimedOutCalls(ctx context.Context) {
+	calls, err := c.kv.HGetAll(ctx, callsKey)
+	if err != nil {
+		logging.Errorf("Error listing calls: %v", err)
+		return
+	}
+	metrics.SetActiveCalls(len(calls))
+	for field := range calls {
+		alive, err := c.kv.Has(ctx, alivePrefix+field)
+		if err != nil {
+			logging.Errorf("Error checking call: %v", err)
+			continue
+		}
+		if alive {
+			continue
+		}
+		hash, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			logging.Errorf("Invalid call hash %s: %v", field, err)
+			continue
+		}
+		c.endCall(ctx, hash, true)
 	}
 }
 
@@ -520,28 +781,77 @@ func (c *CallTracker) EndCall(ctx context.Context, packet models.Packet) {
 		return
 	}
 
-	call, ok := c.inFlightCalls.LoadAndDelete(hash)
-	if !ok {
-		logging.Errorf("Active call not found")
+	c.endCall(ctx, hash, false)
+}
+
+// endCall removes the call from the in-flight calls and saves it. A call ended
+// because it timed out is left alone if a packet arrived in the meantime.
+func (c *CallTracker) endCall(ctx context.Context, hash uint64, timedOut bool) {
+	unlock, err := c.lock(ctx, hash)
+	if err != nil {
+		logging.Errorf("Error ending call: %v", err)
 		return
 	}
+	defer unlock()
 
-	if time.Since(call.StartTime) < 100*time.Millisecond {
-		// This is probably a key-up, so delete the call from the db
-		c.db.Unscoped().Delete(call)
+	inFlight, err := c.loadCall(ctx, hash)
+	if errors.Is(err, kv.ErrNotFound) {
+		// Another instance already ended it
+		if !timedOut {
+			logging.Errorf("Active call not found")
+		}
+		return
+	} else if err != nil {
+		logging.Errorf("Error loading call: %v", err)
 		return
 	}
+	if timedOut {
+		alive, err := c.kv.Has(ctx, alivePrefix+callField(hash))
+		if err != nil {
+			logging.Errorf("Error checking call: %v", err)
+			return
+		}
+		if alive {
+			return
+		}
+		logging.Errorf("Call %d timed out", inFlight.Call.StreamID)
+	}
 
-	// Delete the call end timer
-	timer, ok := c.callEndTimers.LoadAndDelete(hash)
-	if !ok {
-		logging.Errorf("Call end timer not found")
-	} else {
-		timer.Stop()
+	_, err = c.kv.HDel(ctx, callsKey, callField(hash))
+	if err != nil {
+		logging.Errorf("Error removing call: %v", err)
+		return
+	}
+	_, err = c.kv.Delete(ctx, alivePrefix+callField(hash))
+	if err != nil {
+		logging.Errorf("Error removing call: %v", err)
+	}
+	_, err = c.kv.CompareAndDelete(ctx, activeKey(inFlight.Call.RepeaterID, inFlight.Call.TimeSlot), []byte(callField(hash)))
+	if err != nil {
+		logging.Errorf("Error removing call from its timeslot: %v", err)
+	}
+	dataKey := callDataPrefix + callField(hash)
+	frames, err := c.kv.LRange(ctx, dataKey)
+	if err != nil {
+		logging.Errorf("Error loading call data: %v", err)
+	}
+	_, err = c.kv.Delete(ctx, dataKey)
+	if err != nil {
+		logging.Errorf("Error removing call data: %v", err)
+	}
+
+	call := &inFlight.Call
+	if time.Since(call.StartTime) < keyUpWindow {
+		// This is probably a key-up, so delete the call from the db.
+		// It was never announced, so there is nothing to end.
+		c.db.Unscoped().Delete(call)
+		return
 	}
 
+	call.CallData = bytes.Join(frames, nil)
 	call.Duration = time.Since(call.StartTime)
 	call.Active = false
+	metrics.CallEnded(call.Duration, call.Loss, call.Jitter, call.BER)
 
 	err = c.db.Save(call).Error
 	if err != nil {
@@ -551,5 +861,5 @@ func (c *CallTracker) EndCall(ctx context.Context, packet models.Packet) {
 
 	c.publishCall(ctx, call)
 
-	logging.Logf("Call %d from %d to %d via %d ended with duration %v, %f%% Loss, %f%% BER, %fdBm RSSI, and %fms Jitter", packet.StreamID, packet.Src, packet.Dst, packet.Repeater, call.Duration, call.Loss*pct, call.BER*pct, call.RSSI, call.Jitter)
+	logging.Logf("Call %d from %d to %d via %d ended with duration %v, %f%% Loss, %f%% BER, %fdBm RSSI, and %fms Jitter", call.StreamID, call.UserID, call.DestinationID, call.RepeaterID, call.Duration, call.Loss*pct, call.BER*pct, call.RSSI, call.Jitter)
 }
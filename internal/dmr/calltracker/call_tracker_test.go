@@ -20,10 +20,271 @@
 package calltracker_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/dmr/calltracker"
+	dmrconst "github.com/USA-RedDragon/DMRHub/internal/dmr/dmrconst"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNoop(t *testing.T) {
 	t.Parallel()
 	t.Log("Noop")
 }
+
+func TestCallSharedBetweenTrackers(t *testing.T) {
+	t.Parallel()
+	database := testutils.OpenDB(t)
+	owner := models.User{ID: 3191868, Callsign: "KI5VMF", Username: "owner", Password: "hash", Approved: true}
+	require.NoError(t, database.Create(&owner).Error)
+	require.NoError(t, database.Create(&models.Talkgroup{ID: 91, Name: "Worldwide"}).Error)
+	repeater := models.Repeater{
+		OwnerID:               owner.ID,
+		RepeaterConfiguration: models.RepeaterConfiguration{ID: 319186801, Callsign: "KI5VMF", ColorCode: 1},
+	}
+	require.NoError(t, database.Create(&repeater).Error)
+
+	// Two instances sharing one store
+	store := kv.NewMemory()
+	ps := pubsub.NewMemory()
+	first := calltracker.NewCallTracker(database, store, ps)
+	second := calltracker.NewCallTracker(database, store, ps)
+	ctx := context.Background()
+
+	packet := models.Packet{
+		Signature: "DMRD",
+		Src:       owner.ID,
+		Dst:       91,
+		Repeater:  repeater.ID,
+		Slot:      true,
+		GroupCall: true,
+		FrameType: dmrconst.FrameVoice,
+		StreamID:  1234,
+		BER:       -1,
+		RSSI:      -1,
+	}
+	first.StartCall(ctx, packet)
+	require.True(t, second.IsCallActive(ctx, packet))
+	call, ok := second.ActiveCall(ctx, repeater.ID, true)
+	require.True(t, ok)
+	assert.Equal(t, uint(1234), call.StreamID)
+	_, ok = second.ActiveCall(ctx, repeater.ID, false)
+	assert.False(t, ok)
+
+	for seq := uint(1); seq <= 3; seq++ {
+		packet.Seq = seq
+		packet.DTypeOrVSeq = seq - 1
+		packet.DMRData[0] = byte(seq)
+		if seq%2 == 0 {
+			first.ProcessCallPacket(ctx, packet)
+		} else {
+			second.ProcessCallPacket(ctx, packet)
+		}
+	}
+	// A duplicate must not add voice data
+	second.ProcessCallPacket(ctx, packet)
+
+	// Long enough not to be taken for a key-up
+	time.Sleep(150 * time.Millisecond)
+	second.EndCall(ctx, packet)
+	assert.False(t, first.IsCallActive(ctx, packet))
+	_, ok = first.ActiveCall(ctx, repeater.ID, true)
+	assert.False(t, ok)
+
+	var saved models.Call
+	require.NoError(t, database.First(&saved, call.ID).Error)
+	assert.False(t, saved.Active)
+	assert.Equal(t, uint(3), saved.LastSeq)
+	require.Len(t, saved.CallData, 3*33)
+	assert.Equal(t, []byte{1, 2, 3}, []byte{saved.CallData[0], saved.CallData[33], saved.CallData[66]})
+}
+
+func TestTimedOutCallIsEnded(t *testing.T) {
+	t.Parallel()
+	database := testutils.OpenDB(t)
+	owner := models.User{ID: 3191868, Callsign: "KI5VMF", Username: "owner", Password: "hash", Approved: true}
+	require.NoError(t, database.Create(&owner).Error)
+	repeater := models.Repeater{
+		OwnerID:               owner.ID,
+		RepeaterConfiguration: models.RepeaterConfiguration{ID: 319186801, Callsign: "KI5VMF", ColorCode: 1},
+	}
+	require.NoError(t, database.Create(&repeater).Error)
+
+	tracker := calltracker.NewCallTracker(database, kv.NewMemory(), pubsub.NewMemory())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tracker.Start(ctx)
+
+	packet := models.Packet{
+		Signature: "DMRD",
+		Src:       owner.ID,
+		Dst:       repeater.ID,
+		Repeater:  repeater.ID,
+		GroupCall: true,
+		FrameType: dmrconst.FrameVoice,
+		StreamID:  5678,
+		BER:       -1,
+		RSSI:      -1,
+	}
+	tracker.StartCall(ctx, packet)
+	require.True(t, tracker.IsCallActive(ctx, packet))
+	assert.Eventually(t, func() bool {
+		return !tracker.IsCallActive(ctx, packet)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestKeyUpIsNeverAnnounced(t *testing.T) {
+	t.Parallel()
+	database := testutils.OpenDB(t)
+	owner := models.User{ID: 3191868, Callsign: "KI5VMF", Username: "owner", Password: "hash", Approved: true}
+	require.NoError(t, database.Create(&owner).Error)
+	require.NoError(t, database.Create(&models.Talkgroup{ID: 91, Name: "Worldwide"}).Error)
+	repeater := models.Repeater{
+		OwnerID:               owner.ID,
+		RepeaterConfiguration: models.RepeaterConfiguration{ID: 319186801, Callsign: "KI5VMF", ColorCode: 1},
+	}
+	require.NoError(t, database.Create(&repeater).Error)
+	// Nothing listens here, only the delivery log is checked
+	webhook := models.Webhook{Name: "bot", URL: "http://127.0.0.1:1", Secret: "whsec_test", Events: "call.started,call.ended", Enabled: true}
+	require.NoError(t, database.Create(&webhook).Error)
+
+	tracker := calltracker.NewCallTracker(database, kv.NewMemory(), pubsub.NewMemory())
+	ctx := context.Background()
+	events := func() []string {
+		var events []string
+		database.Model(&models.WebhookDelivery{}).Order("id asc").Pluck("event", &events)
+		return events
+	}
+
+	packet := models.Packet{
+		Signature: "DMRD",
+		Src:       owner.ID,
+		Dst:       91,
+		Repeater:  repeater.ID,
+		Slot:      true,
+		GroupCall: true,
+		FrameType: dmrconst.FrameVoice,
+		StreamID:  1234,
+		BER:       -1,
+		RSSI:      -1,
+	}
+	tracker.StartCall(ctx, packet)
+	tracker.EndCall(ctx, packet)
+	assert.Never(t, func() bool { return len(events()) > 0 }, 200*time.Millisecond, 20*time.Millisecond)
+
+	// A call that outlives the key-up window is started and ended,
+	// even if no packet arrived after the window
+	packet.StreamID = 5678
+	tracker.StartCall(ctx, packet)
+	time.Sleep(150 * time.Millisecond)
+	tracker.EndCall(ctx, packet)
+	assert.Eventually(t, func() bool {
+		got := events()
+		return len(got) == 2 && got[0] == "call.started" && got[1] == "call.ended"
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestCallEndIsPublishedLast(t *testing.T) {
+	t.Parallel()
+	database := testutils.OpenDB(t)
+	owner := models.User{ID: 3191868, Callsign: "KI5VMF", Username: "owner", Password: "hash", Approved: true}
+	require.NoError(t, database.Create(&owner).Error)
+	require.NoError(t, database.Create(&models.Talkgroup{ID: 91, Name: "Worldwide"}).Error)
+	repeater := models.Repeater{
+		OwnerID:               owner.ID,
+		RepeaterConfiguration: models.RepeaterConfiguration{ID: 319186801, Callsign: "KI5VMF", ColorCode: 1},
+	}
+	require.NoError(t, database.Create(&repeater).Error)
+
+	ps := pubsub.NewMemory()
+	tracker := calltracker.NewCallTracker(database, kv.NewMemory(), ps)
+	ctx := context.Background()
+	sub := ps.Subscribe(ctx, "calls")
+	defer sub.Close()
+
+	packet := models.Packet{
+		Signature: "DMRD",
+		Src:       owner.ID,
+		Dst:       91,
+		Repeater:  repeater.ID,
+		Slot:      true,
+		GroupCall: true,
+		FrameType: dmrconst.FrameVoice,
+		StreamID:  1234,
+		BER:       -1,
+		RSSI:      -1,
+	}
+	tracker.StartCall(ctx, packet)
+	time.Sleep(150 * time.Millisecond)
+	for seq := uint(1); seq <= 5; seq++ {
+		packet.Seq = seq
+		packet.DTypeOrVSeq = seq % 6
+		tracker.ProcessCallPacket(ctx, packet)
+	}
+	tracker.EndCall(ctx, packet)
+
+	var published []bool
+	for len(published) < 6 {
+		select {
+		case msg := <-sub.Channel():
+			var call models.Call
+			require.NoError(t, json.Unmarshal(msg.Payload, &call))
+			published = append(published, call.Active)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected 6 published calls, got %d", len(published))
+		}
+	}
+	assert.Equal(t, []bool{true, true, true, true, true, false}, published)
+}
+
+// failingStore refuses to store in-flight calls, like a store that went away mid-call.
+type failingStore struct {
+	kv.KV
+}
+
+func (failingStore) HSet(context.Context, string, string, []byte) error {
+	return errors.New("store is unavailable")
+}
+
+func TestCallIsRemovedWhenItCannotBeStored(t *testing.T) {
+	t.Parallel()
+	database := testutils.OpenDB(t)
+	owner := models.User{ID: 3191868, Callsign: "KI5VMF", Username: "owner", Password: "hash", Approved: true}
+	require.NoError(t, database.Create(&owner).Error)
+	repeater := models.Repeater{
+		OwnerID:               owner.ID,
+		RepeaterConfiguration: models.RepeaterConfiguration{ID: 319186801, Callsign: "KI5VMF", ColorCode: 1},
+	}
+	require.NoError(t, database.Create(&repeater).Error)
+
+	tracker := calltracker.NewCallTracker(database, failingStore{kv.NewMemory()}, pubsub.NewMemory())
+	ctx := context.Background()
+	packet := models.Packet{
+		Signature: "DMRD",
+		Src:       owner.ID,
+		Dst:       repeater.ID,
+		Repeater:  repeater.ID,
+		GroupCall: true,
+		FrameType: dmrconst.FrameVoice,
+		StreamID:  5678,
+		BER:       -1,
+		RSSI:      -1,
+	}
+	tracker.StartCall(ctx, packet)
+	assert.False(t, tracker.IsCallActive(ctx, packet))
+	_, ok := tracker.ActiveCall(ctx, repeater.ID, false)
+	assert.False(t, ok)
+
+	var count int64
+	require.NoError(t, database.Unscoped().Model(&models.Call{}).Count(&count).Error)
+	assert.Zero(t, count, "expected the call to be removed from the database")
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package parrot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"go.opentelemetry.io/otel"
+)
+
+type parrotStorage struct {
+	KV kv.KV
+}
+
+var (
+	ErrStorage      = fmt.Errorf("storage error")
+	ErrCast         = fmt.Errorf("cast error")
+	ErrMarshal      = fmt.Errorf("marshal error")
+	ErrUnmarshal    = fmt.Errorf("unmarshal error")
+	ErrNoSuchStream = fmt.Errorf("no such stream")
+)
+
+const parrotExpireTime = 5 * time.Minute
+
+func makeParrotStorage(store kv.KV) parrotStorage {
+	return parrotStorage{
+		KV: store,
+	}
+}
+
+func (r *parrotStorage) store(ctx context.Context, streamID uint, repeaterID uint) {
+	ctx, span := otel.Tracer("DMRHub").Start(ctx, "parrotStorage.store")
+	defer span.End()
+
+	_ = r.KV.Set(ctx, fmt.Sprintf("parrot:stream:%d", streamID), []byte(strconv.FormatUint(uint64(repeaterID), 10)), parrotExpireTime)
+}
+
+func (r *parrotStorage) exists(ctx context.Context, streamID uint) bool {
+	ctx, span := otel.Tracer("DMRHub").Start(ctx, "parrotStorage.exists")
+	defer span.End()
+
+	exists, _ := r.KV.Has(ctx, fmt.Sprintf("parrot:stream:%d", streamID))
+	return exists
+}
+
+func (r *parrotStorage) refresh(ctx context.Context, streamID uint) {
+	ctx, span := otel.Tracer("DMRHub").Start(ctx, "parrotStorage.refresh")
+	defer span.End()
+
+	_ = r.KV.Expire(ctx, fmt.Sprintf("parrot:stream:%d", streamID), parrotExpireTime)
+}
+
+func (r *parrotStorage) get(ctx context.Context, streamID uint) (uint, error) {
+	ctx, span := otel.Tracer("DMRHub").Start(ctx, "parrotStorage.get")
+	defer span.End()
+
+	repeaterIDStr, err := r.KV.Get(ctx, fmt.Sprintf("parrot:stream:%d", streamID))
+	if err != nil {
+		return 0, ErrStorage
+	}
+	repeaterID, err := strconv.Atoi(string(repeaterIDStr))
+	if err != nil {
+		return 0, ErrCast
+	}
+	return uint(repeaterID), nil
+}
+
+func (r *parrotStorage) stream(ctx context.Context, streamID uint, packet models.Packet) error {
+	ctx, span := otel.Tracer("DMRHub").Start(ctx, "parrotStorage.stream")
+	defer span.End()
+
+	packetBytes, err := packet.MarshalMsg(nil)
+	if err != nil {
+		return ErrMarshal
+	}
+
+	err = r.KV.RPush(ctx, fmt.Sprintf("parrot:stream:%d:packets", streamID), packetBytes)
+	if err != nil {
+		return ErrStorage
+	}
+	return nil
+}
+
+func (r *parrotStorage) delete(ctx context.Context, streamID uint) {
+	ctx, span := otel.Tracer("DMRHub").Start(ctx, "parrotStorage.delete")
+	defer span.End()
+
+	_, _ = r.KV.Delete(ctx, fmt.Sprintf("parrot:stream:%d", streamID))
+	_ = r.KV.Expire(ctx, fmt.Sprintf("parrot:stream:%d:packets", streamID), parrotExpireTime)
+}
+
+func (r *parrotStorage) getStream(ctx context.Context, streamID uint) ([]models.Packet, error) {
+	ctx, span := otel.Tracer("DMRHub").Start(ctx, "parrotStorage.getStream")
+	defer span.End()
+
+	packets, err := r.KV.LRange(ctx, fmt.Sprintf("parrot:stream:%d:packets", streamID))
+	if err != nil {
+		return nil, ErrNoSuchStream
+	}
+	// Delete the stream
+	_, _ = r.KV.Delete(ctx, fmt.Sprintf("parrot:stream:%d:packets", streamID))
+
+	// Empty array of packets
+	packetArray := make([]models.Packet, len(packets))
+	// Loop through the packets and unmarshal them
+	for i, packet := range packets {
+		var packetObj models.Packet
+		_, err := packetObj.UnmarshalMsg(packet)
+		if err != nil {
+			return nil, ErrUnmarshal
+		}
+		packetArray[i] = packetObj
+	}
+	return packetArray, nil
+}
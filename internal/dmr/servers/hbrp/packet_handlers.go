@@ -35,6 +35,7 @@ import (
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/rules"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/utils"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/metrics"
 	"go.opentelemetry.io/otel"
 )
 
@@ -72,7 +73,7 @@ func (s *Server) switchDynamicTalkgroup(ctx context.Context, packet models.Packe
 	// field, then we need to update the database entry to reflect
 	// the new dynamic talkgroup on the appropriate slot.
 
-	_, span := otel.Tracer("DMRHub").Start(ctx, "Server.switchDynamicTalkgroup")
+	ctx, span := otel.Tracer("DMRHub").Start(ctx, "Server.switchDynamicTalkgroup")
 	defer span.End()
 
 	repeaterExists, err := models.RepeaterIDExists(s.DB, packet.Repeater)
@@ -113,22 +114,24 @@ func (s *Server) switchDynamicTalkgroup(ctx context.Context, packet models.Packe
 			logging.Logf("Dynamically Linking %d timeslot 2 to %d", packet.Repeater, packet.Dst)
 			repeater.TS2DynamicTalkgroup = talkgroup
 			repeater.TS2DynamicTalkgroupID = &packet.Dst
-			go GetSubscriptionManager(s.DB).ListenForCallsOn(s.Redis.Redis, repeater.ID, packet.Dst) //nolint:golint,contextcheck
+			go GetSubscriptionManager(s.DB).ListenForCallsOn(s.Redis.PubSub, repeater.ID, packet.Dst) //nolint:golint,contextcheck
 			err := s.DB.Save(&repeater).Error
 			if err != nil {
 				logging.Errorf("Error saving repeater: %s", err.Error())
 			}
+			s.publishRepeaterState(ctx, repeater.ID, RepeaterEventTalkgroup)
 		}
 	} else {
 		if repeater.TS1DynamicTalkgroupID == nil || *repeater.TS1DynamicTalkgroupID != packet.Dst {
 			logging.Logf("Dynamically Linking %d timeslot 1 to %d", packet.Repeater, packet.Dst)
 			repeater.TS1DynamicTalkgroup = talkgroup
 			repeater.TS1DynamicTalkgroupID = &packet.Dst
-			go GetSubscriptionManager(s.DB).ListenForCallsOn(s.Redis.Redis, repeater.ID, packet.Dst) //nolint:golint,contextcheck
+			go GetSubscriptionManager(s.DB).ListenForCallsOn(s.Redis.PubSub, repeater.ID, packet.Dst) //nolint:golint,contextcheck
 			err := s.DB.Save(&repeater).Error
 			if err != nil {
 				logging.Errorf("Error saving repeater: %s", err.Error())
 			}
+			s.publishRepeaterState(ctx, repeater.ID, RepeaterEventTalkgroup)
 		}
 	}
 }
@@ -182,11 +185,15 @@ func (s *Server) TrackCall(ctx context.Context, packet models.Packet, isVoice bo
 	if packet.Dst != 4000 && isVoice {
 		if !s.CallTracker.IsCallActive(ctx, packet) {
 			s.CallTracker.StartCall(ctx, packet)
+			if s.CallTracker.IsCallActive(ctx, packet) {
+				go s.publishRepeaterState(ctx, packet.Repeater, RepeaterEventTransmission)
+			}
 		}
 		if s.CallTracker.IsCallActive(ctx, packet) {
 			s.CallTracker.ProcessCallPacket(ctx, packet)
 			if packet.FrameType == dmrconst.FrameDataSync && dmrconst.DataType(packet.DTypeOrVSeq) == dmrconst.DTypeVoiceTerm {
 				s.CallTracker.EndCall(ctx, packet)
+				go s.publishRepeaterState(ctx, packet.Repeater, RepeaterEventTransmission)
 			}
 		}
 	}
@@ -233,7 +240,7 @@ func (s *Server) doParrot(ctx context.Context, packet models.Packet, repeaterID
 }
 
 func (s *Server) doUnlink(ctx context.Context, packet models.Packet, dbRepeater models.Repeater) {
-	_, span := otel.Tracer("DMRHub").Start(ctx, "Server.doUnlink")
+	ctx, span := otel.Tracer("DMRHub").Start(ctx, "Server.doUnlink")
 	defer span.End()
 
 	if packet.Slot {
@@ -263,6 +270,7 @@ func (s *Server) doUnlink(ctx context.Context, packet models.Packet, dbRepeater
 	if err != nil {
 		logging.Errorf("Error saving repeater: %s", err)
 	}
+	s.publishRepeaterState(ctx, dbRepeater.ID, RepeaterEventTalkgroup)
 }
 
 func (s *Server) doUser(ctx context.Context, packet models.Packet, packedBytes []byte) {
@@ -294,7 +302,7 @@ func (s *Server) doUser(ctx context.Context, packet models.Packet, packedBytes [
 	} else if lastCall.ID != 0 && s.Redis.RepeaterExists(ctx, lastCall.RepeaterID) {
 		// If the last call exists and that repeater is online
 		// Send the packet to the last user call's repeater
-		s.Redis.Redis.Publish(ctx, fmt.Sprintf("hbrp:packets:repeater:%d", lastCall.RepeaterID), packedBytes)
+		s.Redis.Publish(ctx, fmt.Sprintf("hbrp:packets:repeater:%d", lastCall.RepeaterID), packedBytes)
 	}
 
 	// For each user repeaters
@@ -302,7 +310,7 @@ func (s *Server) doUser(ctx context.Context, packet models.Packet, packedBytes [
 		// If the repeater is online and the last user call was not to this repeater
 		if repeater.ID != lastCall.RepeaterID && s.Redis.RepeaterExists(ctx, lastCall.RepeaterID) {
 			// Send the packet to the repeater
-			s.Redis.Redis.Publish(ctx, fmt.Sprintf("hbrp:packets:repeater:%d", repeater.ID), packedBytes)
+			s.Redis.Publish(ctx, fmt.Sprintf("hbrp:packets:repeater:%d", repeater.ID), packedBytes)
 		}
 	}
 }
@@ -315,12 +323,14 @@ func (s *Server) handleDMRDPacket(ctx context.Context, remoteAddr net.UDPAddr, d
 	// DMRD packets are either 53 or 55 bytes long
 	if len(data) != 53 && len(data) != 55 {
 		logging.Errorf("Invalid DMRD packet length: %d", len(data))
+		metrics.PacketDropped(metrics.ProtocolHBRP, metrics.DropInvalidLength)
 		return
 	}
 	repeaterIDBytes := data[11:15]
 	repeaterID := uint(binary.BigEndian.Uint32(repeaterIDBytes))
 	logging.Logf("DMR Data from Repeater ID: %d", repeaterID)
 	if s.validRepeater(ctx, repeaterID, "YES", remoteAddr) {
+		metrics.PacketFromSource(metrics.ProtocolHBRP, repeaterID)
 		s.Redis.UpdateRepeaterPing(ctx, repeaterID)
 
 		dbRepeater, err := models.FindRepeaterByID(s.DB, repeaterID)
@@ -338,6 +348,7 @@ func (s *Server) handleDMRDPacket(ctx context.Context, remoteAddr net.UDPAddr, d
 		packet, ok := models.UnpackPacket(data)
 		if !ok {
 			logging.Errorf("Failed to unpack packet from repeater %d", repeaterID)
+			metrics.PacketDropped(metrics.ProtocolHBRP, metrics.DropInvalidPacket)
 			return
 		}
 
@@ -399,7 +410,7 @@ func (s *Server) handleDMRDPacket(ctx context.Context, remoteAddr net.UDPAddr, d
 				logging.Errorf("Error marshalling raw packet: %v", err)
 				return
 			}
-			s.Redis.Redis.Publish(ctx, fmt.Sprintf("hbrp:packets:talkgroup:%d", packet.Dst), packedBytes)
+			s.Redis.Publish(ctx, fmt.Sprintf("hbrp:packets:talkgroup:%d", packet.Dst), packedBytes)
 		case !packet.GroupCall && isVoice:
 			// packet.Dst is either a repeater or a user
 			// If it's a repeater, we need to send it to the repeater
@@ -436,7 +447,7 @@ func (s *Server) handleDMRDPacket(ctx context.Context, remoteAddr net.UDPAddr, d
 					logging.Errorf("Repeater %d does not exist", packet.Dst)
 					return
 				}
-				s.Redis.Redis.Publish(ctx, fmt.Sprintf("hbrp:packets:repeater:%d", packet.Dst), packedBytes)
+				s.Redis.Publish(ctx, fmt.Sprintf("hbrp:packets:repeater:%d", packet.Dst), packedBytes)
 			} else if packet.Dst >= userIDMin && packet.Dst <= userIDMax {
 				exists, err := models.UserIDExists(s.DB, packet.Dst)
 				if err != nil {
@@ -454,6 +465,8 @@ func (s *Server) handleDMRDPacket(ctx context.Context, remoteAddr net.UDPAddr, d
 		default:
 			logging.Error("Unhandled packet type")
 		}
+	} else {
+		metrics.PacketDropped(metrics.ProtocolHBRP, metrics.DropInvalidRepeater)
 	}
 }
 
@@ -569,8 +582,10 @@ func (s *Server) handleRPTLPacket(ctx context.Context, remoteAddr net.UDPAddr, d
 		} else {
 			copy(saltBytes[:], bigSalt.Bytes())
 		}
+		s.publishRepeaterState(ctx, repeaterID, RepeaterEventLogin)
 		s.sendCommand(ctx, repeaterID, dmrconst.CommandRPTACK, saltBytes[:])
 		s.Redis.UpdateRepeaterConnection(ctx, repeaterID, "CHALLENGE_SENT")
+		s.publishRepeaterState(ctx, repeaterID, RepeaterEventChallenge)
 	}
 }
 
@@ -648,6 +663,7 @@ func (s *Server) handleRPTKPacket(ctx context.Context, remoteAddr net.UDPAddr, d
 		if calcedSalt == rxSalt {
 			logging.Logf("Repeater ID %d authed, sending ACK", repeaterID)
 			s.Redis.UpdateRepeaterConnection(ctx, repeaterID, "WAITING_CONFIG")
+			s.publishRepeaterState(ctx, repeaterID, RepeaterEventConfig)
 			s.sendCommand(ctx, repeaterID, dmrconst.CommandRPTACK, repeaterIDBytes)
 			go func() {
 				time.Sleep(1 * time.Second)
@@ -680,6 +696,7 @@ func (s *Server) handleRPTCLPacket(ctx context.Context, remoteAddr net.UDPAddr,
 	if !s.Redis.DeleteRepeater(ctx, repeaterID) {
 		logging.Errorf("Repeater ID %d not deleted", repeaterID)
 	}
+	s.publishRepeaterState(ctx, repeaterID, RepeaterEventDisconnect)
 }
 
 func (s *Server) handleRPTCPacket(ctx context.Context, remoteAddr net.UDPAddr, data []byte) {
@@ -733,6 +750,7 @@ func (s *Server) handleRPTCPacket(ctx context.Context, remoteAddr net.UDPAddr, d
 			s.sendCommand(ctx, repeaterID, dmrconst.CommandMSTNAK, repeaterIDBytes)
 			return
 		}
+		s.publishRepeaterState(ctx, repeaterID, RepeaterEventConnected)
 	} else {
 		s.sendCommand(ctx, repeaterID, dmrconst.CommandMSTNAK, repeaterIDBytes)
 	}
@@ -776,6 +794,7 @@ func (s *Server) handleRPTPINGPacket(ctx context.Context, remoteAddr net.UDPAddr
 		repeater.PingsReceived++
 		s.Redis.StoreRepeater(ctx, repeaterID, repeater)
 		s.sendCommand(ctx, repeaterID, dmrconst.CommandMSTPONG, repeaterIDBytes)
+		s.publishRepeaterState(ctx, repeaterID, RepeaterEventPing)
 	} else {
 		s.sendCommand(ctx, repeaterID, dmrconst.CommandMSTNAK, repeaterIDBytes)
 	}
@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package hbrp
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/mqtt"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
+	"go.opentelemetry.io/otel"
+)
+
+// RepeaterStateKey is the hash holding the latest state of each repeater, keyed by repeater ID.
+const RepeaterStateKey = "hbrp:repeaters:state"
+
+// RepeaterStateChannel is the channel on which repeater state changes are published.
+const RepeaterStateChannel = "hbrp:repeaters:state"
+
+// repeaterTimeoutInterval is how often the server looks for repeaters that stopped
+// pinging without disconnecting.
+const repeaterTimeoutInterval = 30 * time.Second
+
+// repeaterTimeoutPrefix holds a short lock per repeater so that only one server
+// publishes its disconnect.
+const repeaterTimeoutPrefix = "hbrp:repeaters:timeout:"
+
+// Repeater state events.
+const (
+	RepeaterEventLogin        = "rptl"
+	RepeaterEventChallenge    = "challenge"
+	RepeaterEventConfig       = "config"
+	RepeaterEventConnected    = "connected"
+	RepeaterEventPing         = "ping"
+	RepeaterEventDisconnect   = "disconnect"
+	RepeaterEventTalkgroup    = "talkgroup"
+	RepeaterEventTransmission = "transmission"
+)
+
+// TransmissionFromCall converts an in-progress call into the transmission shown on a repeater slot.
+func TransmissionFromCall(call models.Call) *apimodels.WSRepeaterStateTransmission {
+	transmission := apimodels.WSRepeaterStateTransmission{
+		CallID:        call.ID,
+		StartTime:     call.StartTime,
+		GroupCall:     call.GroupCall,
+		IsToTalkgroup: call.IsToTalkgroup,
+		DestinationID: call.DestinationID,
+	}
+	transmission.User.ID = call.User.ID
+	transmission.User.Callsign = call.User.Callsign
+	if call.IsToTalkgroup {
+		transmission.ToTalkgroup.ID = call.ToTalkgroup.ID
+		transmission.ToTalkgroup.Name = call.ToTalkgroup.Name
+		transmission.ToTalkgroup.Description = call.ToTalkgroup.Description
+	}
+	return &transmission
+}
+
+func talkgroupState(talkgroupID *uint, talkgroup models.Talkgroup) *apimodels.WSCallResponseTalkgroup {
+	if talkgroupID == nil {
+		return nil
+	}
+	return &apimodels.WSCallResponseTalkgroup{
+		ID:          talkgroup.ID,
+		Name:        talkgroup.Name,
+		Description: talkgroup.Description,
+	}
+}
+
+// publishRepeaterState stores the current state of a repeater in Redis and
+// publishes it so that websocket clients can follow the connection along.
+func (s *Server) publishRepeaterState(ctx context.Context, repeaterID uint, event string) {
+	ctx, span := otel.Tracer("DMRHub").Start(ctx, "Server.publishRepeaterState")
+	defer span.End()
+
+	exists, err := models.RepeaterIDExists(s.DB, repeaterID)
+	if err != nil {
+		logging.Errorf("Error checking if repeater %d exists: %v", repeaterID, err)
+		return
+	}
+	if !exists {
+		// Only repeaters registered to an owner are shown
+		return
+	}
+
+	dbRepeater, err := models.FindRepeaterByID(s.DB, repeaterID)
+	if err != nil {
+		logging.Errorf("Error finding repeater %d: %v", repeaterID, err)
+		return
+	}
+
+	switch event {
+	case RepeaterEventConnected:
+		go webhooks.Emit(s.DB, webhooks.EventRepeaterConnected, webhooks.RepeaterFromModel(dbRepeater))
+	case RepeaterEventDisconnect:
+		go webhooks.Emit(s.DB, webhooks.EventRepeaterDisconnected, webhooks.RepeaterFromModel(dbRepeater))
+	case RepeaterEventTalkgroup:
+		mqtt.PublishTalkgroups(dbRepeater)
+	}
+
+	state := apimodels.WSRepeaterStateResponse{
+		ID:                  repeaterID,
+		Event:               event,
+		Connection:          "DISCONNECTED",
+		Connected:           dbRepeater.Connected,
+		LastPing:            dbRepeater.LastPing,
+		TS1DynamicTalkgroup: talkgroupState(dbRepeater.TS1DynamicTalkgroupID, dbRepeater.TS1DynamicTalkgroup),
+		TS2DynamicTalkgroup: talkgroupState(dbRepeater.TS2DynamicTalkgroupID, dbRepeater.TS2DynamicTalkgroup),
+	}
+
+	repeater, err := s.Redis.GetRepeater(ctx, repeaterID)
+	if err == nil {
+		state.Connection = repeater.Connection
+		state.Connected = repeater.Connected
+		state.LastPing = repeater.LastPing
+		state.PingsReceived = repeater.PingsReceived
+	}
+
+	if s.CallTracker != nil {
+		if call, ok := s.CallTracker.ActiveCall(ctx, repeaterID, false); ok {
+			state.TS1Transmission = TransmissionFromCall(call)
+		}
+		if call, ok := s.CallTracker.ActiveCall(ctx, repeaterID, true); ok {
+			state.TS2Transmission = TransmissionFromCall(call)
+		}
+	}
+
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		logging.Errorf("Error marshalling repeater state: %v", err)
+		return
+	}
+	err = s.Redis.KV.HSet(ctx, RepeaterStateKey, strconv.FormatUint(uint64(repeaterID), 10), stateJSON)
+	if err != nil {
+		logging.Errorf("Error storing repeater state: %v", err)
+		return
+	}
+	s.Redis.Publish(ctx, RepeaterStateChannel, stateJSON)
+	mqtt.PublishRepeaterState(repeaterID, stateJSON)
+}
+
+// watchRepeaterTimeouts times out repeaters until ctx is done.
+func (s *Server) watchRepeaterTimeouts(ctx context.Context) {
+	ticker := time.NewTicker(repeaterTimeoutInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.TimeOutRepeaters(ctx)
+		}
+	}
+}
+
+// TimeOutRepeaters publishes a disconnect for every repeater still shown as connected
+// whose connection expired because it stopped pinging.
+func (s *Server) TimeOutRepeaters(ctx context.Context) {
+	ctx, span := otel.Tracer("DMRHub").Start(ctx, "Server.TimeOutRepeaters")
+	defer span.End()
+
+	states, err := s.Redis.KV.HGetAll(ctx, RepeaterStateKey)
+	if err != nil {
+		logging.Errorf("Error getting repeater states: %v", err)
+		return
+	}
+	for field, stateJSON := range states {
+		repeaterID, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			logging.Errorf("Invalid repeater ID %s in repeater states: %v", field, err)
+			continue
+		}
+		var state apimodels.WSRepeaterStateResponse
+		err = json.Unmarshal(stateJSON, &state)
+		if err != nil {
+			logging.Errorf("Error unmarshalling state of repeater %s: %v", field, err)
+			continue
+		}
+		if state.Connection == "DISCONNECTED" || s.Redis.RepeaterExists(ctx, uint(repeaterID)) {
+			continue
+		}
+		claimed, err := s.Redis.KV.SetNX(ctx, repeaterTimeoutPrefix+field, []byte{1}, repeaterTimeoutInterval)
+		if err != nil {
+			logging.Errorf("Error claiming timeout of repeater %s: %v", field, err)
+			continue
+		}
+		if !claimed {
+			// Another server is publishing this disconnect
+			continue
+		}
+		logging.Logf("Repeater ID %d timed out", repeaterID)
+		s.publishRepeaterState(ctx, uint(repeaterID), RepeaterEventDisconnect)
+	}
+}
@@ -31,8 +31,11 @@ import (
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/dmrconst"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/parrot"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/servers"
+	"github.com/USA-RedDragon/DMRHub/internal/health"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
-	"github.com/redis/go-redis/v9"
+	"github.com/USA-RedDragon/DMRHub/internal/metrics"
+	"github.com/USA-RedDragon/DMRHub/internal/queue"
 	"go.opentelemetry.io/otel"
 	"gorm.io/gorm"
 )
@@ -46,6 +49,7 @@ type Server struct {
 	Parrot        *parrot.Parrot
 	DB            *gorm.DB
 	Redis         *servers.RedisClient
+	Ingress       queue.Queue
 	CallTracker   *calltracker.CallTracker
 	Version       string
 	Commit        string
@@ -61,7 +65,7 @@ const repeaterIDLength = 4
 const bufferSize = 1000000 // 1MB
 
 // MakeServer creates a new DMR server.
-func MakeServer(db *gorm.DB, redis *redis.Client, redisClient *servers.RedisClient, callTracker *calltracker.CallTracker, version, commit string) Server {
+func MakeServer(db *gorm.DB, store kv.KV, redisClient *servers.RedisClient, ingress queue.Queue, callTracker *calltracker.CallTracker, version, commit string) Server {
 	return Server{
 		Buffer: make([]byte, largestMessageSize),
 		SocketAddress: net.UDPAddr{
@@ -69,9 +73,10 @@ func MakeServer(db *gorm.DB, redis *redis.Client, redisClient *servers.RedisClie
 			Port: config.GetConfig().DMRPort,
 		},
 		Started:     false,
-		Parrot:      parrot.NewParrot(redis),
+		Parrot:      parrot.NewParrot(store),
 		DB:          db,
 		Redis:       redisClient,
+		Ingress:     ingress,
 		CallTracker: callTracker,
 		Version:     version,
 		Commit:      commit,
@@ -93,6 +98,7 @@ func (s *Server) Stop(ctx context.Context) {
 			logging.Logf("Repeater found: %d", repeater)
 		}
 		s.Redis.UpdateRepeaterConnection(ctx, repeater, "DISCONNECTED")
+		s.publishRepeaterState(ctx, repeater, RepeaterEventDisconnect)
 		repeaterBinary := make([]byte, repeaterIDLength)
 		binary.BigEndian.PutUint32(repeaterBinary, uint32(repeater))
 		s.sendCommand(ctx, repeater, dmrconst.CommandMSTCL, repeaterBinary)
@@ -101,36 +107,29 @@ func (s *Server) Stop(ctx context.Context) {
 }
 
 func (s *Server) listen(ctx context.Context) {
-	pubsub := s.Redis.Redis.Subscribe(ctx, "hbrp:incoming")
-	defer func() {
-		err := pubsub.Close()
+	defer health.Track(health.HBRPIncomingListener)()
+	err := s.Ingress.Consume(ctx, func(ctx context.Context, payload []byte) {
+		var packet models.RawDMRPacket
+		_, err := packet.UnmarshalMsg(payload)
 		if err != nil {
-			logging.Errorf("Error closing pubsub: %v", err)
-		}
-	}()
-	pubsubChannel := pubsub.Channel()
-	for {
-		select {
-		case <-ctx.Done():
-			logging.Log("Stopping HBRP server")
+			logging.Errorf("Error unmarshalling packet: %v", err)
 			return
-		case msg := <-pubsubChannel:
-			var packet models.RawDMRPacket
-			_, err := packet.UnmarshalMsg([]byte(msg.Payload))
-			if err != nil {
-				logging.Errorf("Error unmarshalling packet: %v", err)
-				continue
-			}
-			s.handlePacket(ctx, net.UDPAddr{
-				IP:   net.ParseIP(packet.RemoteIP),
-				Port: packet.RemotePort,
-			}, packet.Data)
 		}
+		s.handlePacket(ctx, net.UDPAddr{
+			IP:   net.ParseIP(packet.RemoteIP),
+			Port: packet.RemotePort,
+		}, packet.Data)
+	})
+	if err != nil {
+		logging.Errorf("Error consuming incoming packets: %v", err)
+		return
 	}
+	logging.Log("Stopping HBRP server")
 }
 
 func (s *Server) subscribePackets(ctx context.Context) {
-	pubsub := s.Redis.Redis.Subscribe(ctx, "hbrp:outgoing")
+	defer health.Track(health.HBRPOutgoingListener)()
+	pubsub := s.Redis.PubSub.Subscribe(ctx, "hbrp:outgoing")
 	defer func() {
 		err := pubsub.Close()
 		if err != nil {
@@ -139,7 +138,7 @@ func (s *Server) subscribePackets(ctx context.Context) {
 	}()
 	for msg := range pubsub.Channel() {
 		var packet models.RawDMRPacket
-		_, err := packet.UnmarshalMsg([]byte(msg.Payload))
+		_, err := packet.UnmarshalMsg(msg.Payload)
 		if err != nil {
 			logging.Errorf("Error unmarshalling packet: %v", err)
 			continue
@@ -150,12 +149,15 @@ func (s *Server) subscribePackets(ctx context.Context) {
 		})
 		if err != nil {
 			logging.Errorf("Error sending packet: %v", err)
+			continue
 		}
+		metrics.PacketSent(metrics.ProtocolHBRP, sentCommand(packet.Data))
 	}
 }
 
 func (s *Server) subscribeRawPackets(ctx context.Context) {
-	pubsub := s.Redis.Redis.Subscribe(ctx, "hbrp:outgoing:noaddr")
+	defer health.Track(health.HBRPOutgoingNoAddrListener)()
+	pubsub := s.Redis.PubSub.Subscribe(ctx, "hbrp:outgoing:noaddr")
 	defer func() {
 		err := pubsub.Close()
 		if err != nil {
@@ -163,7 +165,7 @@ func (s *Server) subscribeRawPackets(ctx context.Context) {
 		}
 	}()
 	for msg := range pubsub.Channel() {
-		packet, ok := models.UnpackPacket([]byte(msg.Payload))
+		packet, ok := models.UnpackPacket(msg.Payload)
 		if !ok {
 			logging.Error("Error unpacking packet")
 			continue
@@ -179,7 +181,9 @@ func (s *Server) subscribeRawPackets(ctx context.Context) {
 		})
 		if err != nil {
 			logging.Errorf("Error sending packet: %v", err)
+			continue
 		}
+		metrics.PacketSent(metrics.ProtocolHBRP, string(dmrconst.CommandDMRD))
 	}
 }
 
@@ -206,12 +210,14 @@ func (s *Server) Start(ctx context.Context) error {
 
 	s.Server = server
 	s.Started = true
+	health.Set(health.HBRPSocket, true)
 
 	logging.Errorf("HBRP Server listening at %s on port %d", s.SocketAddress.IP.String(), s.SocketAddress.Port)
 
 	go s.listen(ctx)
 	go s.subscribePackets(ctx)
 	go s.subscribeRawPackets(ctx)
+	go s.watchRepeaterTimeouts(ctx)
 
 	go func() {
 		for {
@@ -233,7 +239,10 @@ func (s *Server) Start(ctx context.Context) error {
 				logging.Errorf("Error marshalling packet: %v", err)
 				return
 			}
-			s.Redis.Redis.Publish(ctx, "hbrp:incoming", packedBytes)
+			err = s.Ingress.Add(ctx, packetRepeaterID(p.Data), packedBytes)
+			if err != nil {
+				logging.Errorf("Error queueing packet: %v", err)
+			}
 		}
 	}()
 
@@ -264,7 +273,7 @@ func (s *Server) sendCommand(ctx context.Context, repeaterIDBytes uint, command
 		logging.Errorf("Error marshalling packet: %v", err)
 		return
 	}
-	s.Redis.Redis.Publish(ctx, "hbrp:outgoing", packedBytes)
+	s.Redis.Publish(ctx, "hbrp:outgoing", packedBytes)
 }
 
 func (s *Server) sendOpenBridgePacket(ctx context.Context, repeaterIDBytes uint, packet models.Packet) {
@@ -292,7 +301,7 @@ func (s *Server) sendOpenBridgePacket(ctx context.Context, repeaterIDBytes uint,
 		logging.Errorf("Error marshalling packet: %v", err)
 		return
 	}
-	s.Redis.Redis.Publish(ctx, "openbridge:outgoing", packedBytes)
+	s.Redis.Publish(ctx, "openbridge:outgoing", packedBytes)
 }
 
 func (s *Server) sendPacket(ctx context.Context, repeaterIDBytes uint, packet models.Packet) {
@@ -318,7 +327,7 @@ func (s *Server) sendPacket(ctx context.Context, repeaterIDBytes uint, packet mo
 		logging.Errorf("Error marshalling packet: %v", err)
 		return
 	}
-	s.Redis.Redis.Publish(ctx, "hbrp:outgoing", packedBytes)
+	s.Redis.Publish(ctx, "hbrp:outgoing", packedBytes)
 }
 
 func (s *Server) handlePacket(ctx context.Context, remoteAddr net.UDPAddr, data []byte) {
@@ -328,27 +337,36 @@ func (s *Server) handlePacket(ctx context.Context, remoteAddr net.UDPAddr, data
 	if len(data) < signatureLength {
 		// Not enough data here to be a valid packet
 		logging.Errorf("Invalid packet length: %d", len(data))
+		metrics.PacketDropped(metrics.ProtocolHBRP, metrics.DropInvalidLength)
 		return
 	}
 
 	switch dmrconst.Command(data[:4]) { //nolint:golint,exhaustive
 	case dmrconst.CommandDMRA:
+		metrics.PacketReceived(metrics.ProtocolHBRP, string(dmrconst.CommandDMRA))
 		s.handleDMRAPacket(ctx, remoteAddr, data)
 	case dmrconst.CommandDMRD:
+		metrics.PacketReceived(metrics.ProtocolHBRP, string(dmrconst.CommandDMRD))
 		s.handleDMRDPacket(ctx, remoteAddr, data)
 	case dmrconst.CommandRPTO:
+		metrics.PacketReceived(metrics.ProtocolHBRP, string(dmrconst.CommandRPTO))
 		s.handleRPTOPacket(ctx, remoteAddr, data)
 	case dmrconst.CommandRPTL:
+		metrics.PacketReceived(metrics.ProtocolHBRP, string(dmrconst.CommandRPTL))
 		s.handleRPTLPacket(ctx, remoteAddr, data)
 	case dmrconst.CommandRPTK:
+		metrics.PacketReceived(metrics.ProtocolHBRP, string(dmrconst.CommandRPTK))
 		s.handleRPTKPacket(ctx, remoteAddr, data)
 	case dmrconst.CommandRPTC:
 		if dmrconst.Command(data[:5]) == dmrconst.CommandRPTCL {
+			metrics.PacketReceived(metrics.ProtocolHBRP, string(dmrconst.CommandRPTCL))
 			s.handleRPTCLPacket(ctx, remoteAddr, data)
 		} else {
+			metrics.PacketReceived(metrics.ProtocolHBRP, string(dmrconst.CommandRPTC))
 			s.handleRPTCPacket(ctx, remoteAddr, data)
 		}
 	case dmrconst.CommandRPTPING[:4]:
+		metrics.PacketReceived(metrics.ProtocolHBRP, string(dmrconst.CommandRPTPING))
 		s.handleRPTPINGPacket(ctx, remoteAddr, data)
 	// I don't think we ever receive these
 	case dmrconst.CommandRPTACK[:4]:
@@ -363,5 +381,44 @@ func (s *Server) handlePacket(ctx context.Context, remoteAddr net.UDPAddr, data
 		logging.Error("TODO: RPTSBKN")
 	default:
 		logging.Errorf("Unknown command: %s", dmrconst.Command(data[:4]))
+		metrics.PacketDropped(metrics.ProtocolHBRP, metrics.DropUnknownCommand)
+	}
+}
+
+// packetRepeaterID returns the repeater ID of an incoming packet, which is
+// used to keep the packets of each repeater in order. It is 0 for packets too
+// short to carry one, which handlePacket rejects anyway.
+func packetRepeaterID(data []byte) uint {
+	offset := 4
+	switch {
+	case len(data) < len(dmrconst.CommandDMRD):
+		return 0
+	case dmrconst.Command(data[:4]) == dmrconst.CommandDMRD:
+		offset = 11
+	case len(data) >= len(dmrconst.CommandRPTPING) && dmrconst.Command(data[:len(dmrconst.CommandRPTPING)]) == dmrconst.CommandRPTPING:
+		offset = len(dmrconst.CommandRPTPING)
+	case len(data) >= len(dmrconst.CommandRPTCL) && dmrconst.Command(data[:len(dmrconst.CommandRPTCL)]) == dmrconst.CommandRPTCL:
+		offset = len(dmrconst.CommandRPTCL)
+	}
+	if len(data) < offset+repeaterIDLength {
+		return 0
+	}
+	return uint(binary.BigEndian.Uint32(data[offset : offset+repeaterIDLength]))
+}
+
+// sentCommand returns the command of an outgoing packet, for use as a metric label.
+func sentCommand(data []byte) string {
+	for _, command := range []dmrconst.Command{
+		dmrconst.CommandDMRD,
+		dmrconst.CommandMSTCL,
+		dmrconst.CommandMSTNAK,
+		dmrconst.CommandMSTPONG,
+		dmrconst.CommandRPTACK,
+		dmrconst.CommandRPTSBKN,
+	} {
+		if len(data) >= len(command) && dmrconst.Command(data[:len(command)]) == command {
+			return string(command)
+		}
 	}
+	return "unknown"
 }
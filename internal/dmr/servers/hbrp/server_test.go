@@ -20,11 +20,18 @@
 package hbrp_test
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
 
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/calltracker"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/servers"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/servers/hbrp"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
 	"gorm.io/gorm"
 )
 
@@ -35,7 +42,7 @@ func TestMakeServerInitialization(t *testing.T) {
 	version := "1.0.0"
 	commit := "abc123"
 
-	server := hbrp.MakeServer(db, nil, redisClient, callTracker, version, commit)
+	server := hbrp.MakeServer(db, nil, redisClient, nil, callTracker, version, commit)
 
 	if server.DB != db {
 		t.Errorf("Expected DB to be %v, got %v", db, server.DB)
@@ -56,3 +63,89 @@ func TestMakeServerInitialization(t *testing.T) {
 		t.Error("Expected Started to be false")
 	}
 }
+
+func TestTransmissionFromCall(t *testing.T) {
+	t.Parallel()
+
+	call := models.Call{
+		ID:            5,
+		User:          models.User{ID: 3191868, Callsign: "KI5VMF"},
+		GroupCall:     true,
+		IsToTalkgroup: true,
+		ToTalkgroup:   models.Talkgroup{ID: 91, Name: "Worldwide"},
+		DestinationID: 91,
+	}
+
+	transmission := hbrp.TransmissionFromCall(call)
+	if transmission.CallID != 5 {
+		t.Errorf("Expected call ID 5, got %d", transmission.CallID)
+	}
+	if transmission.User.ID != 3191868 || transmission.User.Callsign != "KI5VMF" {
+		t.Errorf("Unexpected user %v", transmission.User)
+	}
+	if transmission.ToTalkgroup.ID != 91 || transmission.ToTalkgroup.Name != "Worldwide" {
+		t.Errorf("Unexpected talkgroup %v", transmission.ToTalkgroup)
+	}
+
+	call.IsToTalkgroup = false
+	transmission = hbrp.TransmissionFromCall(call)
+	if transmission.ToTalkgroup.ID != 0 {
+		t.Errorf("Expected no talkgroup for a private call, got %v", transmission.ToTalkgroup)
+	}
+}
+
+func TestTimeOutRepeaters(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := testutils.OpenDB(t)
+	if err := db.Create(&models.User{ID: 3191868, Callsign: "KI5VMF", Username: "jacob", Approved: true}).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	for _, id := range []uint{319186801, 319186802} {
+		repeater := models.Repeater{OwnerID: 3191868}
+		repeater.ID = id
+		repeater.Callsign = "KI5VMF"
+		if err := db.Create(&repeater).Error; err != nil {
+			t.Fatalf("Failed to create repeater: %v", err)
+		}
+	}
+
+	store := kv.NewMemory()
+	redisClient := servers.MakeRedisClient(store, pubsub.NewMemory())
+	server := hbrp.MakeServer(db, store, redisClient, nil, nil, "1.0.0", "abc123")
+	connected, err := json.Marshal(apimodels.WSRepeaterStateResponse{Event: hbrp.RepeaterEventPing, Connection: "YES"})
+	if err != nil {
+		t.Fatalf("Failed to marshal state: %v", err)
+	}
+	for _, id := range []string{"319186801", "319186802"} {
+		if err := store.HSet(ctx, hbrp.RepeaterStateKey, id, connected); err != nil {
+			t.Fatalf("Failed to store state: %v", err)
+		}
+	}
+	// Only the second repeater is still pinging
+	var pinging models.Repeater
+	pinging.ID = 319186802
+	pinging.Connection = "YES"
+	redisClient.StoreRepeater(ctx, 319186802, pinging)
+
+	server.TimeOutRepeaters(ctx)
+
+	state := func(id string) apimodels.WSRepeaterStateResponse {
+		t.Helper()
+		stateJSON, err := store.HGet(ctx, hbrp.RepeaterStateKey, id)
+		if err != nil {
+			t.Fatalf("Failed to get state: %v", err)
+		}
+		var state apimodels.WSRepeaterStateResponse
+		if err := json.Unmarshal(stateJSON, &state); err != nil {
+			t.Fatalf("Failed to unmarshal state: %v", err)
+		}
+		return state
+	}
+	if state := state("319186801"); state.Event != hbrp.RepeaterEventDisconnect || state.Connection != "DISCONNECTED" {
+		t.Errorf("Expected the silent repeater to be disconnected, got %+v", state)
+	}
+	if state := state("319186802"); state.Event != hbrp.RepeaterEventPing || state.Connection != "YES" {
+		t.Errorf("Expected the pinging repeater to stay connected, got %+v", state)
+	}
+}
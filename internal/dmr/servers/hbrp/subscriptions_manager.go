@@ -29,8 +29,8 @@ import (
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/dmrconst"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
 	"github.com/puzpuzpuz/xsync/v3"
-	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/otel"
 	"gorm.io/gorm"
 )
@@ -121,7 +121,7 @@ func (m *SubscriptionManager) CancelAllRepeaterSubscriptions(repeaterID uint) {
 	})
 }
 
-func (m *SubscriptionManager) ListenForCallsOn(redis *redis.Client, repeaterID uint, talkgroupID uint) {
+func (m *SubscriptionManager) ListenForCallsOn(ps pubsub.PubSub, repeaterID uint, talkgroupID uint) {
 	_, span := otel.Tracer("DMRHub").Start(context.Background(), "SubscriptionManager.ListenForCallsOn")
 	defer span.End()
 	radioSubs, ok := m.subscriptions.Load(repeaterID)
@@ -132,11 +132,11 @@ func (m *SubscriptionManager) ListenForCallsOn(redis *redis.Client, repeaterID u
 	if !ok {
 		newCtx, cancel := context.WithCancel(context.Background())
 		radioSubs.Store(talkgroupID, &cancel)
-		go m.subscribeTG(newCtx, redis, repeaterID, talkgroupID) //nolint:golint,contextcheck
+		go m.subscribeTG(newCtx, ps, repeaterID, talkgroupID) //nolint:golint,contextcheck
 	}
 }
 
-func (m *SubscriptionManager) ListenForCalls(redis *redis.Client, repeaterID uint) {
+func (m *SubscriptionManager) ListenForCalls(ps pubsub.PubSub, repeaterID uint) {
 	// Subscribe to Redis "packets:repeater:<id>" channel for a dmr.RawDMRPacket
 	// This channel is used to get private calls headed to this repeater
 	// When a packet is received, we need to publish it to "outgoing" channel
@@ -165,7 +165,7 @@ func (m *SubscriptionManager) ListenForCalls(redis *redis.Client, repeaterID uin
 	if !ok {
 		newCtx, cancel := context.WithCancel(context.Background())
 		radioSubs.Store(repeaterID, &cancel)
-		go m.subscribeRepeater(newCtx, redis, repeaterID) //nolint:golint,contextcheck
+		go m.subscribeRepeater(newCtx, ps, repeaterID) //nolint:golint,contextcheck
 	}
 
 	// Subscribe to Redis "packets:talkgroup:<id>" channel for each talkgroup
@@ -174,7 +174,7 @@ func (m *SubscriptionManager) ListenForCalls(redis *redis.Client, repeaterID uin
 		if !ok {
 			newCtx, cancel := context.WithCancel(context.Background())
 			radioSubs.Store(tg.ID, &cancel)
-			go m.subscribeTG(newCtx, redis, repeaterID, tg.ID) //nolint:golint,contextcheck
+			go m.subscribeTG(newCtx, ps, repeaterID, tg.ID) //nolint:golint,contextcheck
 		}
 	}
 	for _, tg := range p.TS2StaticTalkgroups {
@@ -182,7 +182,7 @@ func (m *SubscriptionManager) ListenForCalls(redis *redis.Client, repeaterID uin
 		if !ok {
 			newCtx, cancel := context.WithCancel(context.Background())
 			radioSubs.Store(tg.ID, &cancel)
-			go m.subscribeTG(newCtx, redis, repeaterID, tg.ID) //nolint:golint,contextcheck
+			go m.subscribeTG(newCtx, ps, repeaterID, tg.ID) //nolint:golint,contextcheck
 		}
 	}
 	if p.TS1DynamicTalkgroupID != nil {
@@ -190,7 +190,7 @@ func (m *SubscriptionManager) ListenForCalls(redis *redis.Client, repeaterID uin
 		if !ok {
 			newCtx, cancel := context.WithCancel(context.Background())
 			radioSubs.Store(*p.TS1DynamicTalkgroupID, &cancel)
-			go m.subscribeTG(newCtx, redis, repeaterID, *p.TS1DynamicTalkgroupID) //nolint:golint,contextcheck
+			go m.subscribeTG(newCtx, ps, repeaterID, *p.TS1DynamicTalkgroupID) //nolint:golint,contextcheck
 		}
 	}
 	if p.TS2DynamicTalkgroupID != nil {
@@ -198,25 +198,21 @@ func (m *SubscriptionManager) ListenForCalls(redis *redis.Client, repeaterID uin
 		if !ok {
 			newCtx, cancel := context.WithCancel(context.Background())
 			radioSubs.Store(*p.TS2DynamicTalkgroupID, &cancel)
-			go m.subscribeTG(newCtx, redis, repeaterID, *p.TS2DynamicTalkgroupID) //nolint:golint,contextcheck
+			go m.subscribeTG(newCtx, ps, repeaterID, *p.TS2DynamicTalkgroupID) //nolint:golint,contextcheck
 		}
 	}
 }
 
-func (m *SubscriptionManager) ListenForWebsocket(ctx context.Context, redis *redis.Client, userID uint) {
+func (m *SubscriptionManager) ListenForWebsocket(ctx context.Context, ps pubsub.PubSub, userID uint) {
 	logging.Logf("Listening for websocket for user %d", userID)
-	pubsub := redis.Subscribe(ctx, "calls")
+	sub := ps.Subscribe(ctx, "calls")
 	defer func() {
-		err := pubsub.Unsubscribe(ctx, "calls")
-		if err != nil {
-			logging.Errorf("Error unsubscribing from calls: %s", err)
-		}
-		err = pubsub.Close()
+		err := sub.Close()
 		if err != nil {
 			logging.Errorf("Error closing pubsub connection: %s", err)
 		}
 	}()
-	pubsubChannel := pubsub.Channel()
+	pubsubChannel := sub.Channel()
 	for {
 		select {
 		case <-ctx.Done():
@@ -224,7 +220,7 @@ func (m *SubscriptionManager) ListenForWebsocket(ctx context.Context, redis *red
 			return
 		case msg := <-pubsubChannel:
 			var call models.Call
-			err := json.Unmarshal([]byte(msg.Payload), &call)
+			err := json.Unmarshal(msg.Payload, &call)
 			if err != nil {
 				logging.Errorf("Error unmarshalling call: %s", err)
 				continue
@@ -286,7 +282,10 @@ func (m *SubscriptionManager) ListenForWebsocket(ctx context.Context, redis *red
 						logging.Errorf("Error marshalling call JSON: %v", err)
 						break
 					}
-					redis.Publish(ctx, fmt.Sprintf("calls:%d", userID), callJSON)
+					err = ps.Publish(ctx, fmt.Sprintf("calls:%d", userID), callJSON)
+					if err != nil {
+						logging.Errorf("Error publishing call JSON: %v", err)
+					}
 					break
 				}
 			}
@@ -294,22 +293,18 @@ func (m *SubscriptionManager) ListenForWebsocket(ctx context.Context, redis *red
 	}
 }
 
-func (m *SubscriptionManager) subscribeRepeater(ctx context.Context, redis *redis.Client, repeaterID uint) {
+func (m *SubscriptionManager) subscribeRepeater(ctx context.Context, ps pubsub.PubSub, repeaterID uint) {
 	if config.GetConfig().Debug {
 		logging.Errorf("Listening for calls on repeater %d", repeaterID)
 	}
-	pubsub := redis.Subscribe(ctx, fmt.Sprintf("hbrp:packets:repeater:%d", repeaterID))
+	sub := ps.Subscribe(ctx, fmt.Sprintf("hbrp:packets:repeater:%d", repeaterID))
 	defer func() {
-		err := pubsub.Unsubscribe(ctx, fmt.Sprintf("hbrp:packets:repeater:%d", repeaterID))
-		if err != nil {
-			logging.Errorf("Error unsubscribing from hbrp:packets:repeater:%d: %s", repeaterID, err)
-		}
-		err = pubsub.Close()
+		err := sub.Close()
 		if err != nil {
 			logging.Errorf("Error closing pubsub connection: %s", err)
 		}
 	}()
-	pubsubChannel := pubsub.Channel()
+	pubsubChannel := sub.Channel()
 	for {
 		select {
 		case <-ctx.Done():
@@ -323,7 +318,7 @@ func (m *SubscriptionManager) subscribeRepeater(ctx context.Context, redis *redi
 			return
 		case msg := <-pubsubChannel:
 			rawPacket := models.RawDMRPacket{}
-			_, err := rawPacket.UnmarshalMsg([]byte(msg.Payload))
+			_, err := rawPacket.UnmarshalMsg(msg.Payload)
 			if err != nil {
 				logging.Errorf("Failed to unmarshal raw packet: %s", err)
 				continue
@@ -335,30 +330,29 @@ func (m *SubscriptionManager) subscribeRepeater(ctx context.Context, redis *redi
 				continue
 			}
 			packet.Repeater = repeaterID
-			redis.Publish(ctx, "hbrp:outgoing:noaddr", packet.Encode())
+			err = ps.Publish(ctx, "hbrp:outgoing:noaddr", packet.Encode())
+			if err != nil {
+				logging.Errorf("Failed to publish packet: %s", err)
+			}
 		}
 	}
 }
 
-func (m *SubscriptionManager) subscribeTG(ctx context.Context, redis *redis.Client, repeaterID uint, tg uint) {
+func (m *SubscriptionManager) subscribeTG(ctx context.Context, ps pubsub.PubSub, repeaterID uint, tg uint) {
 	if tg == 0 {
 		return
 	}
 	if config.GetConfig().Debug {
 		logging.Logf("Listening for calls on repeater %d, talkgroup %d", repeaterID, tg)
 	}
-	pubsub := redis.Subscribe(ctx, fmt.Sprintf("hbrp:packets:talkgroup:%d", tg))
+	sub := ps.Subscribe(ctx, fmt.Sprintf("hbrp:packets:talkgroup:%d", tg))
 	defer func() {
-		err := pubsub.Unsubscribe(ctx, fmt.Sprintf("hbrp:packets:talkgroup:%d", tg))
-		if err != nil {
-			logging.Errorf("Error unsubscribing from hbrp:packets:talkgroup:%d: %s", tg, err)
-		}
-		err = pubsub.Close()
+		err := sub.Close()
 		if err != nil {
 			logging.Errorf("Error closing pubsub connection: %s", err)
 		}
 	}()
-	pubsubChannel := pubsub.Channel()
+	pubsubChannel := sub.Channel()
 
 	for {
 		select {
@@ -373,7 +367,7 @@ func (m *SubscriptionManager) subscribeTG(ctx context.Context, redis *redis.Clie
 			return
 		case msg := <-pubsubChannel:
 			rawPacket := models.RawDMRPacket{}
-			_, err := rawPacket.UnmarshalMsg([]byte(msg.Payload))
+			_, err := rawPacket.UnmarshalMsg(msg.Payload)
 			if err != nil {
 				logging.Errorf("Failed to unmarshal raw packet: %s", err)
 				continue
@@ -399,17 +393,12 @@ func (m *SubscriptionManager) subscribeTG(ctx context.Context, redis *redis.Clie
 				// We need to send it to the repeater
 				packet.Repeater = p.ID
 				packet.Slot = slot
-				redis.Publish(ctx, "hbrp:outgoing:noaddr", packet.Encode())
-			} else {
-				// We're subscribed but don't want this packet? With a talkgroup that can only mean we're unlinked, so we should unsubscribe
-				err := pubsub.Unsubscribe(ctx, fmt.Sprintf("hbrp:packets:talkgroup:%d", tg))
+				err = ps.Publish(ctx, "hbrp:outgoing:noaddr", packet.Encode())
 				if err != nil {
-					logging.Errorf("Error unsubscribing from hbrp:packets:talkgroup:%d: %s", tg, err)
-				}
-				err = pubsub.Close()
-				if err != nil {
-					logging.Errorf("Error closing pubsub connection: %s", err)
+					logging.Errorf("Failed to publish packet: %s", err)
 				}
+			} else {
+				// We're subscribed but don't want this packet? With a talkgroup that can only mean we're unlinked, so we should unsubscribe
 				return
 			}
 		}
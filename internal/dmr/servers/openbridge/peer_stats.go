@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package openbridge
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/dmr/dmrconst"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+)
+
+// streamTimeout is how long a stream may go without packets before it is no longer considered active.
+const streamTimeout = 2 * time.Second
+
+// PeerStats holds the live counters for a single OpenBridge peer.
+type PeerStats struct {
+	mu            sync.Mutex
+	id            uint
+	lastKeepalive time.Time
+	packetsIn     uint64
+	packetsOut    uint64
+	bytesIn       uint64
+	bytesOut      uint64
+	hmacFailures  uint64
+	streams       map[uint]*apimodels.WSPeerStatusStream
+	dirty         bool
+}
+
+// NewPeerStats creates an empty set of counters for the given peer.
+func NewPeerStats(id uint) *PeerStats {
+	return &PeerStats{
+		id:      id,
+		streams: make(map[uint]*apimodels.WSPeerStatusStream),
+	}
+}
+
+// RecordIngress records a valid packet received from the peer.
+func (p *PeerStats) RecordIngress(packet models.Packet, length int, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastKeepalive = now
+	p.packetsIn++
+	p.bytesIn += uint64(length)
+	p.dirty = true
+
+	if packet.FrameType == dmrconst.FrameDataSync && dmrconst.DataType(packet.DTypeOrVSeq) == dmrconst.DTypeVoiceTerm {
+		delete(p.streams, packet.StreamID)
+		return
+	}
+
+	stream, ok := p.streams[packet.StreamID]
+	if !ok {
+		stream = &apimodels.WSPeerStatusStream{
+			StreamID:  packet.StreamID,
+			Src:       packet.Src,
+			Dst:       packet.Dst,
+			GroupCall: packet.GroupCall,
+			StartTime: now,
+		}
+		p.streams[packet.StreamID] = stream
+	}
+	stream.LastSeen = now
+}
+
+// RecordEgress records a packet sent to the peer.
+func (p *PeerStats) RecordEgress(length int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.packetsOut++
+	p.bytesOut += uint64(length)
+	p.dirty = true
+}
+
+// RecordHMACFailure records a packet from the peer that failed HMAC validation.
+func (p *PeerStats) RecordHMACFailure() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.hmacFailures++
+	p.dirty = true
+}
+
+// Snapshot expires stale streams and returns the current status of the peer
+// along with whether it changed since the previous snapshot.
+func (p *PeerStats) Snapshot(now time.Time) (apimodels.WSPeerStatusResponse, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	streams := make([]apimodels.WSPeerStatusStream, 0, len(p.streams))
+	for id, stream := range p.streams {
+		if now.Sub(stream.LastSeen) > streamTimeout {
+			delete(p.streams, id)
+			p.dirty = true
+			continue
+		}
+		streams = append(streams, *stream)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].StartTime.Before(streams[j].StartTime)
+	})
+
+	changed := p.dirty
+	p.dirty = false
+
+	return apimodels.WSPeerStatusResponse{
+		ID:            p.id,
+		LastKeepalive: p.lastKeepalive,
+		PacketsIn:     p.packetsIn,
+		PacketsOut:    p.packetsOut,
+		BytesIn:       p.bytesIn,
+		BytesOut:      p.bytesOut,
+		HMACFailures:  p.hmacFailures,
+		ActiveStreams: streams,
+	}, changed
+}
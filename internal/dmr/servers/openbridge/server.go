@@ -24,8 +24,11 @@ import (
 	"crypto/hmac"
 	"crypto/sha1" //#nosec G505 -- False positive, used for a protocol
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
+	"time"
 
 	"github.com/USA-RedDragon/DMRHub/internal/config"
 	"github.com/USA-RedDragon/DMRHub/internal/db/models"
@@ -33,7 +36,10 @@ import (
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/dmrconst"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/rules"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/servers"
+	"github.com/USA-RedDragon/DMRHub/internal/health"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/metrics"
+	"github.com/puzpuzpuz/xsync/v3"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
@@ -42,6 +48,13 @@ import (
 const packetLength = 73
 const largestMessageSize = 73
 const bufferSize = 1000000 // 1MB
+const statusInterval = 1 * time.Second
+
+// StatusKey is the hash holding the latest status of each peer, keyed by peer ID.
+const StatusKey = "openbridge:peers:status"
+
+// StatusChannel is the channel on which changed peer statuses are published.
+const StatusChannel = "openbridge:peers:status"
 
 // OpenBridge is the same as HBRP, but with a single packet type.
 type Server struct {
@@ -54,6 +67,8 @@ type Server struct {
 	Redis *servers.RedisClient
 
 	CallTracker *calltracker.CallTracker
+
+	stats *xsync.MapOf[uint, *PeerStats]
 }
 
 // MakeServer creates a new DMR server.
@@ -68,6 +83,7 @@ func MakeServer(db *gorm.DB, redisClient *servers.RedisClient, callTracker *call
 		Redis:       redisClient,
 		CallTracker: callTracker,
 		Tracer:      otel.Tracer("dmr-openbridge-server"),
+		stats:       xsync.NewMapOf[uint, *PeerStats](),
 	}
 }
 
@@ -91,11 +107,13 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 
 	s.Server = server
+	health.Set(health.OpenBridgeSocket, true)
 
 	logging.Logf("OpenBridge Server listening at %s on port %d", s.SocketAddress.IP.String(), s.SocketAddress.Port)
 
 	go s.listen(ctx)
 	go s.subcribeOutgoing(ctx)
+	go s.publishStatus(ctx)
 
 	go func() {
 		for {
@@ -118,7 +136,7 @@ func (s *Server) Start(ctx context.Context) error {
 					logging.Errorf("Error marshalling packet: %v", err)
 					return
 				}
-				s.Redis.Redis.Publish(ctx, "openbridge:incoming", packedBytes)
+				s.Redis.Publish(ctx, "openbridge:incoming", packedBytes)
 			}()
 		}
 	}()
@@ -133,8 +151,9 @@ func (s *Server) Stop(_ context.Context) {
 func (s *Server) listen(ctx context.Context) {
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "Server.listen")
 	defer span.End()
+	defer health.Track(health.OpenBridgeIncomingListener)()
 
-	pubsub := s.Redis.Redis.Subscribe(ctx, "openbridge:incoming")
+	pubsub := s.Redis.PubSub.Subscribe(ctx, "openbridge:incoming")
 	defer func() {
 		err := pubsub.Close()
 		if err != nil {
@@ -143,7 +162,7 @@ func (s *Server) listen(ctx context.Context) {
 	}()
 	for msg := range pubsub.Channel() {
 		var packet models.RawDMRPacket
-		_, err := packet.UnmarshalMsg([]byte(msg.Payload))
+		_, err := packet.UnmarshalMsg(msg.Payload)
 		if err != nil {
 			logging.Errorf("Error unmarshalling packet: %v", err)
 			continue
@@ -156,7 +175,8 @@ func (s *Server) listen(ctx context.Context) {
 }
 
 func (s *Server) subcribeOutgoing(ctx context.Context) {
-	pubsub := s.Redis.Redis.Subscribe(ctx, "openbridge:outgoing")
+	defer health.Track(health.OpenBridgeOutgoingListener)()
+	pubsub := s.Redis.PubSub.Subscribe(ctx, "openbridge:outgoing")
 	defer func() {
 		err := pubsub.Close()
 		if err != nil {
@@ -164,7 +184,7 @@ func (s *Server) subcribeOutgoing(ctx context.Context) {
 		}
 	}()
 	for msg := range pubsub.Channel() {
-		packet, ok := models.UnpackPacket([]byte(msg.Payload))
+		packet, ok := models.UnpackPacket(msg.Payload)
 		if !ok {
 			logging.Errorf("Error unpacking packet")
 			continue
@@ -182,6 +202,48 @@ func (s *Server) subcribeOutgoing(ctx context.Context) {
 		})
 		if err != nil {
 			logging.Errorf("Error sending packet: %v", err)
+			continue
+		}
+		s.peerStats(packet.Repeater).RecordEgress(packetLength)
+		metrics.PacketSent(metrics.ProtocolOpenBridge, string(dmrconst.CommandDMRD))
+	}
+}
+
+func (s *Server) peerStats(peerID uint) *PeerStats {
+	stats, _ := s.stats.LoadOrCompute(peerID, func() *PeerStats {
+		return NewPeerStats(peerID)
+	})
+	return stats
+}
+
+// publishStatus periodically stores the status of each peer and
+// publishes any that changed so that websocket clients can follow along.
+func (s *Server) publishStatus(ctx context.Context) {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.stats.Range(func(peerID uint, stats *PeerStats) bool {
+				status, changed := stats.Snapshot(now)
+				if !changed {
+					return true
+				}
+				statusJSON, err := json.Marshal(status)
+				if err != nil {
+					logging.Errorf("Error marshalling peer status: %v", err)
+					return true
+				}
+				err = s.Redis.KV.HSet(ctx, StatusKey, strconv.FormatUint(uint64(peerID), 10), statusJSON)
+				if err != nil {
+					logging.Errorf("Error storing peer status: %v", err)
+					return true
+				}
+				s.Redis.Publish(ctx, StatusChannel, statusJSON)
+				return true
+			})
 		}
 	}
 }
@@ -211,7 +273,7 @@ func (s *Server) sendPacket(ctx context.Context, repeaterIDBytes uint, packet mo
 		logging.Errorf("Error marshalling packet: %v", err)
 		return
 	}
-	s.Redis.Redis.Publish(ctx, "openbridge:outgoing", packedBytes)
+	s.Redis.Publish(ctx, "openbridge:outgoing", packedBytes)
 }
 
 func (s *Server) validateHMAC(ctx context.Context, packetBytes []byte, hmacBytes []byte, peer models.Peer) bool {
@@ -239,13 +301,16 @@ func (s *Server) handlePacket(ctx context.Context, _ *net.UDPAddr, data []byte)
 
 	if len(data) != packetLength {
 		logging.Errorf("Invalid OpenBridge packet length: %d", len(data))
+		metrics.PacketDropped(metrics.ProtocolOpenBridge, metrics.DropInvalidLength)
 		return
 	}
 
 	if dmrconst.Command(data[:signatureLength]) != dmrconst.CommandDMRD {
 		logging.Errorf("Unknown command: %s", data[:signatureLength])
+		metrics.PacketDropped(metrics.ProtocolOpenBridge, metrics.DropUnknownCommand)
 		return
 	}
+	metrics.PacketReceived(metrics.ProtocolOpenBridge, string(dmrconst.CommandDMRD))
 
 	packetBytes := data[:dmrconst.HBRPPacketLength]
 	hmacBytes := data[dmrconst.HBRPPacketLength:packetLength]
@@ -253,6 +318,7 @@ func (s *Server) handlePacket(ctx context.Context, _ *net.UDPAddr, data []byte)
 	packet, ok := models.UnpackPacket(packetBytes)
 	if !ok {
 		logging.Error("Invalid OpenBridge packet")
+		metrics.PacketDropped(metrics.ProtocolOpenBridge, metrics.DropInvalidPacket)
 		return
 	}
 
@@ -263,6 +329,7 @@ func (s *Server) handlePacket(ctx context.Context, _ *net.UDPAddr, data []byte)
 	if packet.Slot {
 		// Drop TS2 packets on OpenBridge
 		logging.Log("Dropping TS2 packet from OpenBridge")
+		metrics.PacketDropped(metrics.ProtocolOpenBridge, metrics.DropTimeslot)
 		return
 	}
 
@@ -274,17 +341,26 @@ func (s *Server) handlePacket(ctx context.Context, _ *net.UDPAddr, data []byte)
 
 	if !models.PeerIDExists(s.DB, peerID) {
 		logging.Errorf("Unknown peer ID: %d", peerID)
+		metrics.PacketDropped(metrics.ProtocolOpenBridge, metrics.DropUnknownPeer)
 		return
 	}
 
 	peer := models.FindPeerByID(s.DB, peerID)
 
+	stats := s.peerStats(peerID)
+
 	if !s.validateHMAC(ctx, packetBytes, hmacBytes, peer) {
 		logging.Error("Invalid OpenBridge HMAC")
+		stats.RecordHMACFailure()
+		metrics.PacketDropped(metrics.ProtocolOpenBridge, metrics.DropInvalidHMAC)
 		return
 	}
 
+	stats.RecordIngress(packet, len(data), time.Now())
+	metrics.PacketFromSource(metrics.ProtocolOpenBridge, peerID)
+
 	if !rules.PeerShouldIngress(s.DB, &peer, &packet) {
+		metrics.PacketDropped(metrics.ProtocolOpenBridge, metrics.DropRule)
 		return
 	}
 
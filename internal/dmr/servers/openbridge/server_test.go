@@ -21,9 +21,77 @@ package openbridge_test
 
 import (
 	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/dmr/dmrconst"
+	"github.com/USA-RedDragon/DMRHub/internal/dmr/servers/openbridge"
 )
 
 func TestNoop(t *testing.T) {
 	t.Parallel()
 	t.Log("Noop")
 }
+
+func TestPeerStatsCounters(t *testing.T) {
+	t.Parallel()
+
+	stats := openbridge.NewPeerStats(1234)
+	now := time.Now()
+	packet := models.Packet{Src: 1, Dst: 91, GroupCall: true, StreamID: 42, FrameType: dmrconst.FrameVoice}
+
+	stats.RecordIngress(packet, 73, now)
+	stats.RecordIngress(packet, 73, now)
+	stats.RecordEgress(73)
+	stats.RecordHMACFailure()
+
+	status, changed := stats.Snapshot(now)
+	if !changed {
+		t.Error("Expected snapshot to report a change")
+	}
+	if status.ID != 1234 {
+		t.Errorf("Expected peer ID 1234, got %d", status.ID)
+	}
+	if status.PacketsIn != 2 || status.BytesIn != 146 {
+		t.Errorf("Unexpected ingress counters: %d packets, %d bytes", status.PacketsIn, status.BytesIn)
+	}
+	if status.PacketsOut != 1 || status.BytesOut != 73 {
+		t.Errorf("Unexpected egress counters: %d packets, %d bytes", status.PacketsOut, status.BytesOut)
+	}
+	if status.HMACFailures != 1 {
+		t.Errorf("Expected 1 HMAC failure, got %d", status.HMACFailures)
+	}
+	if !status.LastKeepalive.Equal(now) {
+		t.Errorf("Expected last keepalive %v, got %v", now, status.LastKeepalive)
+	}
+	if len(status.ActiveStreams) != 1 || status.ActiveStreams[0].StreamID != 42 {
+		t.Errorf("Expected stream 42 to be active, got %v", status.ActiveStreams)
+	}
+
+	_, changed = stats.Snapshot(now)
+	if changed {
+		t.Error("Expected no change between snapshots")
+	}
+}
+
+func TestPeerStatsStreamEnds(t *testing.T) {
+	t.Parallel()
+
+	stats := openbridge.NewPeerStats(1)
+	now := time.Now()
+	packet := models.Packet{StreamID: 1, FrameType: dmrconst.FrameVoice}
+	stats.RecordIngress(packet, 73, now)
+
+	terminator := models.Packet{StreamID: 1, FrameType: dmrconst.FrameDataSync, DTypeOrVSeq: uint(dmrconst.DTypeVoiceTerm)}
+	stats.RecordIngress(terminator, 73, now)
+	status, _ := stats.Snapshot(now)
+	if len(status.ActiveStreams) != 0 {
+		t.Errorf("Expected no active streams after terminator, got %v", status.ActiveStreams)
+	}
+
+	stats.RecordIngress(models.Packet{StreamID: 2, FrameType: dmrconst.FrameVoice}, 73, now)
+	status, _ = stats.Snapshot(now.Add(5 * time.Second))
+	if len(status.ActiveStreams) != 0 {
+		t.Errorf("Expected stale stream to expire, got %v", status.ActiveStreams)
+	}
+}
@@ -26,7 +26,7 @@ import (
 	"github.com/USA-RedDragon/DMRHub/internal/config"
 	"github.com/USA-RedDragon/DMRHub/internal/db/models"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
-	"github.com/redis/go-redis/v9"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
 	"go.opentelemetry.io/otel"
 )
 
@@ -66,7 +66,7 @@ func (m *SubscriptionManager) CancelSubscription(p models.Peer) {
 	}
 }
 
-func (m *SubscriptionManager) Subscribe(ctx context.Context, redis *redis.Client, p models.Peer) {
+func (m *SubscriptionManager) Subscribe(ctx context.Context, ps pubsub.PubSub, p models.Peer) {
 	_, span := otel.Tracer("DMRHub").Start(ctx, "Server.handlePacket")
 	defer span.End()
 
@@ -87,26 +87,22 @@ func (m *SubscriptionManager) Subscribe(ctx context.Context, redis *redis.Client
 		m.subscriptions[p.ID] = cancel
 		m.subscriptionCancelMutex[p.ID].Unlock()
 		m.subscriptionsMutex.Unlock()
-		go m.subscribe(newCtx, redis, p) //nolint:golint,contextcheck
+		go m.subscribe(newCtx, ps, p) //nolint:golint,contextcheck
 	}
 }
 
-func (m *SubscriptionManager) subscribe(ctx context.Context, redis *redis.Client, p models.Peer) {
+func (m *SubscriptionManager) subscribe(ctx context.Context, ps pubsub.PubSub, p models.Peer) {
 	if config.GetConfig().Debug {
 		logging.Logf("Listening for calls on peer %d", p.ID)
 	}
-	pubsub := redis.Subscribe(ctx, "openbridge:packets")
+	sub := ps.Subscribe(ctx, "openbridge:packets")
 	defer func() {
-		err := pubsub.Unsubscribe(ctx, "openbridge:packets")
-		if err != nil {
-			logging.Errorf("Error unsubscribing from openbridge:packets: %s", err)
-		}
-		err = pubsub.Close()
+		err := sub.Close()
 		if err != nil {
 			logging.Errorf("Error closing pubsub connection: %s", err)
 		}
 	}()
-	pubsubChannel := pubsub.Channel()
+	pubsubChannel := sub.Channel()
 
 	for {
 		select {
@@ -128,7 +124,7 @@ func (m *SubscriptionManager) subscribe(ctx context.Context, redis *redis.Client
 			return
 		case msg := <-pubsubChannel:
 			rawPacket := models.RawDMRPacket{}
-			_, err := rawPacket.UnmarshalMsg([]byte(msg.Payload))
+			_, err := rawPacket.UnmarshalMsg(msg.Payload)
 			if err != nil {
 				logging.Errorf("Failed to unmarshal raw packet: %s", err)
 				continue
@@ -145,7 +141,10 @@ func (m *SubscriptionManager) subscribe(ctx context.Context, redis *redis.Client
 
 			packet.Repeater = p.ID
 			packet.Slot = false
-			redis.Publish(ctx, "openbridge:outgoing", packet.Encode())
+			err = ps.Publish(ctx, "openbridge:outgoing", packet.Encode())
+			if err != nil {
+				logging.Errorf("Failed to publish packet: %s", err)
+			}
 		}
 	}
 }
@@ -28,13 +28,17 @@ import (
 	"time"
 
 	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
-	"github.com/redis/go-redis/v9"
+	"github.com/USA-RedDragon/DMRHub/internal/metrics"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
 	"go.opentelemetry.io/otel"
 )
 
+// RedisClient holds the state shared by the DMR servers, in Redis or in memory.
 type RedisClient struct {
-	Redis *redis.Client
+	KV     kv.KV
+	PubSub pubsub.PubSub
 }
 
 var (
@@ -47,12 +51,40 @@ var (
 
 const repeaterExpireTime = 5 * time.Minute
 
-func MakeRedisClient(redis *redis.Client) *RedisClient {
+func MakeRedisClient(store kv.KV, ps pubsub.PubSub) *RedisClient {
 	return &RedisClient{
-		Redis: redis,
+		KV:     store,
+		PubSub: ps,
 	}
 }
 
+// Publish publishes a message on the data plane, recording how long it took.
+func (s *RedisClient) Publish(ctx context.Context, channel string, message []byte) {
+	start := time.Now()
+	err := s.PubSub.Publish(ctx, channel, message)
+	metrics.ObserveRedisPublish(start)
+	if err != nil {
+		logging.Errorf("Error publishing to %s: %v", channel, err)
+	}
+}
+
+// CountConnectedRepeaters returns the number of repeaters that have completed login.
+func (s *RedisClient) CountConnectedRepeaters(ctx context.Context) int {
+	repeaterIDs, err := s.ListRepeaters(ctx)
+	if err != nil {
+		logging.Errorf("Error listing repeaters: %v", err)
+		return 0
+	}
+	count := 0
+	for _, repeaterID := range repeaterIDs {
+		repeater, err := s.GetRepeater(ctx, repeaterID)
+		if err == nil && repeater.Connection == "YES" {
+			count++
+		}
+	}
+	return count
+}
+
 func (s *RedisClient) UpdateRepeaterPing(ctx context.Context, repeaterID uint) {
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "redisClient.updateRepeaterPing")
 	defer span.End()
@@ -64,7 +96,6 @@ func (s *RedisClient) UpdateRepeaterPing(ctx context.Context, repeaterID uint) {
 	}
 	repeater.LastPing = time.Now()
 	s.StoreRepeater(ctx, repeaterID, repeater)
-	s.Redis.Expire(ctx, fmt.Sprintf("hbrp:repeater:%d", repeaterID), repeaterExpireTime)
 }
 
 func (s *RedisClient) UpdateRepeaterConnection(ctx context.Context, repeaterID uint, connection string) {
@@ -84,7 +115,11 @@ func (s *RedisClient) DeleteRepeater(ctx context.Context, repeaterID uint) bool
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "redisClient.deleteRepeater")
 	defer span.End()
 
-	return s.Redis.Del(ctx, fmt.Sprintf("hbrp:repeater:%d", repeaterID)).Val() == 1
+	deleted, err := s.KV.Delete(ctx, fmt.Sprintf("hbrp:repeater:%d", repeaterID))
+	if err != nil {
+		logging.Errorf("Error deleting repeater: %v", err)
+	}
+	return deleted
 }
 
 func (s *RedisClient) StoreRepeater(ctx context.Context, repeaterID uint, repeater models.Repeater) {
@@ -97,20 +132,23 @@ func (s *RedisClient) StoreRepeater(ctx context.Context, repeaterID uint, repeat
 		return
 	}
 	// Expire repeaters after 5 minutes, this function called often enough to keep them alive
-	s.Redis.Set(ctx, fmt.Sprintf("hbrp:repeater:%d", repeaterID), repeaterBytes, repeaterExpireTime)
+	err = s.KV.Set(ctx, fmt.Sprintf("hbrp:repeater:%d", repeaterID), repeaterBytes, repeaterExpireTime)
+	if err != nil {
+		logging.Errorf("Error storing repeater: %v", err)
+	}
 }
 
 func (s *RedisClient) GetRepeater(ctx context.Context, repeaterID uint) (models.Repeater, error) {
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "redisClient.getRepeater")
 	defer span.End()
 
-	repeaterBits, err := s.Redis.Get(ctx, fmt.Sprintf("hbrp:repeater:%d", repeaterID)).Result()
+	repeaterBits, err := s.KV.Get(ctx, fmt.Sprintf("hbrp:repeater:%d", repeaterID))
 	if err != nil {
 		logging.Errorf("Error getting repeater from redis: %v", err)
 		return models.Repeater{}, ErrNoSuchRepeater
 	}
 	var repeater models.Repeater
-	_, err = repeater.UnmarshalMsg([]byte(repeaterBits))
+	_, err = repeater.UnmarshalMsg(repeaterBits)
 	if err != nil {
 		logging.Errorf("Error unmarshalling repeater: %v", err)
 		return models.Repeater{}, ErrUnmarshalRepeater
@@ -122,31 +160,28 @@ func (s *RedisClient) RepeaterExists(ctx context.Context, repeaterID uint) bool
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "redisClient.repeaterExists")
 	defer span.End()
 
-	return s.Redis.Exists(ctx, fmt.Sprintf("hbrp:repeater:%d", repeaterID)).Val() == 1
+	exists, err := s.KV.Has(ctx, fmt.Sprintf("hbrp:repeater:%d", repeaterID))
+	if err != nil {
+		logging.Errorf("Error checking if repeater exists: %v", err)
+	}
+	return exists
 }
 
 func (s *RedisClient) ListRepeaters(ctx context.Context) ([]uint, error) {
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "redisClient.listRepeaters")
 	defer span.End()
 
-	var cursor uint64
-	var repeaters []uint
-	for {
-		keys, _, err := s.Redis.Scan(ctx, cursor, "hbrp:repeater:*", 0).Result()
+	keys, err := s.KV.Keys(ctx, "hbrp:repeater:")
+	if err != nil {
+		return nil, ErrNoSuchRepeater
+	}
+	repeaters := make([]uint, 0, len(keys))
+	for _, key := range keys {
+		repeaterNum, err := strconv.Atoi(strings.TrimPrefix(key, "hbrp:repeater:"))
 		if err != nil {
-			return nil, ErrNoSuchRepeater
-		}
-		for _, key := range keys {
-			repeaterNum, err := strconv.Atoi(strings.Replace(key, "hbrp:repeater:", "", 1))
-			if err != nil {
-				return nil, ErrCastRepeater
-			}
-			repeaters = append(repeaters, uint(repeaterNum))
-		}
-
-		if cursor == 0 {
-			break
+			return nil, ErrCastRepeater
 		}
+		repeaters = append(repeaters, uint(repeaterNum))
 	}
 	return repeaters, nil
 }
@@ -155,13 +190,13 @@ func (s *RedisClient) GetPeer(ctx context.Context, peerID uint) (models.Peer, er
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "Server.handlePacket")
 	defer span.End()
 
-	peerBits, err := s.Redis.Get(ctx, fmt.Sprintf("openbridge:peer:%d", peerID)).Result()
+	peerBits, err := s.KV.Get(ctx, fmt.Sprintf("openbridge:peer:%d", peerID))
 	if err != nil {
 		logging.Errorf("Error getting peer from redis: %v", err)
 		return models.Peer{}, ErrNoSuchPeer
 	}
 	var peer models.Peer
-	_, err = peer.UnmarshalMsg([]byte(peerBits))
+	_, err = peer.UnmarshalMsg(peerBits)
 	if err != nil {
 		logging.Errorf("Error unmarshalling peer: %v", err)
 		return models.Peer{}, ErrUnmarshalPeer
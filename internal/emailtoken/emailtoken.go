@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package emailtoken issues and checks the signed, expiring tokens that are
+// mailed to users to verify their address or reset their password.
+//
+// Tokens are stateless. Each one is bound to a value that changes once the
+// token has done its job (the email address being verified, or the current
+// password hash), so a used or superseded token stops verifying.
+package emailtoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Purpose keeps a token issued for one flow from being accepted by another.
+type Purpose byte
+
+const (
+	PurposeVerifyEmail   Purpose = 1
+	PurposeResetPassword Purpose = 2
+)
+
+const (
+	// VerifyEmailTTL is how long an email verification link is valid for
+	VerifyEmailTTL = 24 * time.Hour
+	// ResetPasswordTTL is how long a password reset link is valid for
+	ResetPasswordTTL = time.Hour
+
+	bindingBytes = 16
+	// purpose + user ID + expiry + binding
+	payloadBytes = 1 + 4 + 8 + bindingBytes
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+//nolint:golint,gochecknoglobals
+var encoding = base64.RawURLEncoding
+
+// Token is a verified token.
+type Token struct {
+	Purpose   Purpose
+	UserID    uint
+	ExpiresAt time.Time
+	binding   []byte
+}
+
+// Issue returns a token for userID that expires after ttl and is only valid
+// while the bound value stays the same.
+func Issue(key []byte, purpose Purpose, userID uint, binding string, ttl time.Duration, now time.Time) string {
+	payload := make([]byte, 0, payloadBytes)
+	payload = append(payload, byte(purpose))
+	payload = binary.BigEndian.AppendUint32(payload, uint32(userID))              //nolint:golint,gosec
+	payload = binary.BigEndian.AppendUint64(payload, uint64(now.Add(ttl).Unix())) //nolint:golint,gosec
+	payload = append(payload, bindingHash(binding)...)
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(sign(key, payload))
+}
+
+// Parse checks the signature, purpose and expiry of a token.
+// Callers must still check the binding with Bound.
+func Parse(key []byte, purpose Purpose, token string, now time.Time) (Token, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return Token{}, ErrInvalidToken
+	}
+	payload, err := encoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) != payloadBytes {
+		return Token{}, ErrInvalidToken
+	}
+	signature, err := encoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, sign(key, payload)) {
+		return Token{}, ErrInvalidToken
+	}
+
+	parsed := Token{
+		Purpose:   Purpose(payload[0]),
+		UserID:    uint(binary.BigEndian.Uint32(payload[1:5])),
+		ExpiresAt: time.Unix(int64(binary.BigEndian.Uint64(payload[5:13])), 0), //nolint:golint,gosec
+		binding:   payload[13:],
+	}
+	if parsed.Purpose != purpose {
+		return Token{}, ErrInvalidToken
+	}
+	if !now.Before(parsed.ExpiresAt) {
+		return Token{}, ErrExpiredToken
+	}
+	return parsed, nil
+}
+
+// Bound reports whether the token was issued for value.
+func (t Token) Bound(value string) bool {
+	return hmac.Equal(t.binding, bindingHash(value))
+}
+
+func sign(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("dmrhub-email-token"))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// bindingHash keeps the bound value itself out of the token.
+func bindingHash(value string) []byte {
+	sum := sha256.Sum256([]byte(value))
+	return sum[:bindingBytes]
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package emailtoken_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/emailtoken"
+)
+
+//nolint:golint,gochecknoglobals
+var key = []byte("test-secret")
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	token := emailtoken.Issue(key, emailtoken.PurposeVerifyEmail, 3191868, "user@example.com", time.Hour, now)
+
+	parsed, err := emailtoken.Parse(key, emailtoken.PurposeVerifyEmail, token, now)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if parsed.UserID != 3191868 {
+		t.Errorf("UserID = %d, want 3191868", parsed.UserID)
+	}
+	if !parsed.Bound("user@example.com") {
+		t.Error("token should be bound to the address it was issued for")
+	}
+	if parsed.Bound("other@example.com") {
+		t.Error("token should not be bound to a different address")
+	}
+}
+
+func TestRejects(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	token := emailtoken.Issue(key, emailtoken.PurposeResetPassword, 1, "hash", time.Hour, now)
+	// Point the token at another user
+	forged := []byte(token)
+	forged[5] ^= 1
+
+	tests := []struct {
+		name    string
+		key     []byte
+		purpose emailtoken.Purpose
+		token   string
+		now     time.Time
+		want    error
+	}{
+		{"wrong purpose", key, emailtoken.PurposeVerifyEmail, token, now, emailtoken.ErrInvalidToken},
+		{"wrong key", []byte("other"), emailtoken.PurposeResetPassword, token, now, emailtoken.ErrInvalidToken},
+		{"expired", key, emailtoken.PurposeResetPassword, token, now.Add(time.Hour), emailtoken.ErrExpiredToken},
+		{"tampered", key, emailtoken.PurposeResetPassword, string(forged), now, emailtoken.ErrInvalidToken},
+		{"truncated", key, emailtoken.PurposeResetPassword, token[:len(token)/2], now, emailtoken.ErrInvalidToken},
+		{"garbage", key, emailtoken.PurposeResetPassword, "not-a-token", now, emailtoken.ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := emailtoken.Parse(tt.key, tt.purpose, tt.token, tt.now)
+			if err != tt.want { //nolint:golint,errorlint
+				t.Errorf("Parse() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
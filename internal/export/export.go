@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package export writes call history in formats logging software can import.
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatADIF = "adif"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// Encoder writes calls one at a time, so exports of any size can be streamed.
+type Encoder interface {
+	// ContentType is the MIME type of the output
+	ContentType() string
+	// Extension is the file extension of the output, without a dot
+	Extension() string
+	WriteHeader() error
+	WriteCall(call models.Call) error
+	// Flush writes out anything buffered
+	Flush() error
+}
+
+// NewEncoder returns an encoder for format. Exports for a single user pass
+// their ID as self, so their contacts can be told apart from themselves;
+// exports of the whole network pass 0.
+func NewEncoder(format string, w io.Writer, self uint) (Encoder, error) {
+	switch format {
+	case FormatCSV:
+		return &CSV{w: csv.NewWriter(w)}, nil
+	case FormatADIF:
+		return &ADIF{w: w, self: self}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+func slot(call models.Call) int {
+	if call.TimeSlot {
+		return 2
+	}
+	return 1
+}
+
+func destination(call models.Call) (kind string, name string) {
+	switch {
+	case call.IsToTalkgroup:
+		return "talkgroup", call.ToTalkgroup.Name
+	case call.IsToUser:
+		return "user", call.ToUser.Callsign
+	case call.IsToRepeater:
+		return "repeater", call.ToRepeater.Callsign
+	default:
+		return "", ""
+	}
+}
+
+// CSV writes one row per call, with a header row naming the columns.
+type CSV struct {
+	w *csv.Writer
+}
+
+func (e *CSV) ContentType() string { return "text/csv" }
+
+func (e *CSV) Extension() string { return "csv" }
+
+func (e *CSV) WriteHeader() error {
+	return e.w.Write([]string{
+		"id", "start_time", "duration_seconds", "source_id", "source_callsign",
+		"destination_type", "destination_id", "destination_name",
+		"repeater_id", "repeater_callsign", "frequency_hz", "time_slot",
+		"loss", "jitter", "ber", "rssi",
+	})
+}
+
+func (e *CSV) WriteCall(call models.Call) error {
+	kind, name := destination(call)
+	return e.w.Write([]string{
+		strconv.FormatUint(uint64(call.ID), 10),
+		call.StartTime.UTC().Format(time.RFC3339),
+		strconv.FormatFloat(call.Duration.Seconds(), 'f', 3, 64),
+		strconv.FormatUint(uint64(call.UserID), 10),
+		csvText(call.User.Callsign),
+		kind,
+		strconv.FormatUint(uint64(call.DestinationID), 10),
+		csvText(name),
+		strconv.FormatUint(uint64(call.RepeaterID), 10),
+		csvText(call.Repeater.Callsign),
+		strconv.FormatUint(uint64(call.Repeater.TXFrequency), 10),
+		strconv.Itoa(slot(call)),
+		strconv.FormatFloat(float64(call.Loss), 'f', -1, 32),
+		strconv.FormatFloat(float64(call.Jitter), 'f', -1, 32),
+		strconv.FormatFloat(float64(call.BER), 'f', -1, 32),
+		strconv.FormatFloat(float64(call.RSSI), 'f', -1, 32),
+	})
+}
+
+// csvText keeps user supplied text such as talkgroup names from being run as a
+// formula when the export is opened in a spreadsheet.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (e *CSV) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ADIF writes one QSO record per call in the Amateur Data Interchange Format.
+// The contacted station is the other party of a private call and the calling
+// station otherwise. A user's own group and repeater calls have no other party,
+// so they are left out of their export rather than logged as a contact with
+// themselves. The frequency is the one the repeater transmits on, which is left
+// out when the repeater didn't report one.
+type ADIF struct {
+	w    io.Writer
+	self uint
+}
+
+const adifVersion = "3.1.4"
+
+func (e *ADIF) ContentType() string { return "text/plain" }
+
+func (e *ADIF) Extension() string { return "adi" }
+
+func (e *ADIF) WriteHeader() error {
+	var b strings.Builder
+	b.WriteString("DMRHub call log export\n")
+	writeADIFField(&b, "ADIF_VER", adifVersion)
+	writeADIFField(&b, "PROGRAMID", "DMRHub")
+	b.WriteString("<EOH>\n")
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *ADIF) WriteCall(call models.Call) error {
+	contact, contactID := call.User.Callsign, call.UserID
+	if e.self != 0 && call.UserID == e.self {
+		if !call.IsToUser {
+			return nil
+		}
+		contact, contactID = call.ToUser.Callsign, call.DestinationID
+	}
+	if e.self != 0 && contactID == e.self {
+		return nil
+	}
+	end := call.StartTime.Add(call.Duration).UTC()
+
+	var comment string
+	switch {
+	case call.IsToTalkgroup:
+		comment = fmt.Sprintf("TG %d", call.DestinationID)
+		if call.ToTalkgroup.Name != "" {
+			comment += " " + call.ToTalkgroup.Name
+		}
+	case call.IsToUser:
+		comment = fmt.Sprintf("Private call to %s (%d)", call.ToUser.Callsign, call.DestinationID)
+	case call.IsToRepeater:
+		comment = fmt.Sprintf("Call to repeater %d", call.DestinationID)
+	}
+	comment += fmt.Sprintf(" TS%d", slot(call))
+	if call.Repeater.Callsign != "" {
+		comment += fmt.Sprintf(" via %s (%d)", call.Repeater.Callsign, call.RepeaterID)
+	}
+
+	var b strings.Builder
+	writeADIFField(&b, "CALL", contact)
+	writeADIFField(&b, "QSO_DATE", call.StartTime.UTC().Format("20060102"))
+	writeADIFField(&b, "TIME_ON", call.StartTime.UTC().Format("150405"))
+	writeADIFField(&b, "QSO_DATE_OFF", end.Format("20060102"))
+	writeADIFField(&b, "TIME_OFF", end.Format("150405"))
+	writeADIFField(&b, "MODE", "DIGITALVOICE")
+	writeADIFField(&b, "SUBMODE", "DMR")
+	if call.Repeater.TXFrequency != 0 {
+		writeADIFField(&b, "FREQ", strconv.FormatFloat(float64(call.Repeater.TXFrequency)/1e6, 'f', 6, 64))
+	}
+	writeADIFField(&b, "COMMENT", comment)
+	b.WriteString("<EOR>\n")
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *ADIF) Flush() error {
+	return nil
+}
+
+// writeADIFField writes a field as <NAME:length>value. ADIF strings are
+// printable ASCII, so anything else is replaced.
+func writeADIFField(b *strings.Builder, name, value string) {
+	if value == "" {
+		return
+	}
+	value = strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '?'
+		}
+		return r
+	}, value)
+	fmt.Fprintf(b, "<%s:%d>%s ", name, len(value), value)
+}
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package export_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/export"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCalls() []models.Call {
+	tg := uint(3100)
+	toUser := uint(3140598)
+	var repeater models.Repeater
+	repeater.Callsign = "KI5VMF"
+	repeater.TXFrequency = 444525000
+	start := time.Date(2024, 5, 1, 23, 59, 50, 0, time.UTC)
+	return []models.Call{
+		{
+			ID: 1, StartTime: start, Duration: 15 * time.Second, TimeSlot: true,
+			User: models.User{Callsign: "KI5VMF"}, UserID: 3191868,
+			Repeater: repeater, RepeaterID: 311860,
+			IsToTalkgroup: true, GroupCall: true, ToTalkgroupID: &tg, ToTalkgroup: models.Talkgroup{Name: "USA ✓"}, DestinationID: 3100,
+			Loss: 0.25, BER: 0.01,
+		},
+		{
+			ID: 2, StartTime: start, Duration: 2 * time.Second,
+			User: models.User{Callsign: "KI5VMF"}, UserID: 3191868,
+			RepeaterID: 311861,
+			IsToUser:   true, ToUserID: &toUser, ToUser: models.User{Callsign: "KP4DJT"}, DestinationID: 3140598,
+		},
+	}
+}
+
+func TestADIF(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	encoder, err := export.NewEncoder(export.FormatADIF, &buf, 3191868)
+	require.NoError(t, err)
+	require.NoError(t, encoder.WriteHeader())
+	for _, call := range testCalls() {
+		require.NoError(t, encoder.WriteCall(call))
+	}
+	require.NoError(t, encoder.Flush())
+
+	// The user's own group call has no other party, so only the private call is logged
+	assert.Equal(t, "DMRHub call log export\n"+
+		"<ADIF_VER:5>3.1.4 <PROGRAMID:6>DMRHub <EOH>\n"+
+		"<CALL:6>KP4DJT <QSO_DATE:8>20240501 <TIME_ON:6>235950 <QSO_DATE_OFF:8>20240501 <TIME_OFF:6>235952 "+
+		"<MODE:12>DIGITALVOICE <SUBMODE:3>DMR <COMMENT:36>Private call to KP4DJT (3140598) TS1 <EOR>\n",
+		buf.String())
+}
+
+func TestADIFNeverLogsSelf(t *testing.T) {
+	t.Parallel()
+	toSelf := uint(3191868)
+	calls := testCalls()
+	calls[1].ToUserID = &toSelf
+	calls[1].ToUser = models.User{Callsign: "KI5VMF"}
+	calls[1].DestinationID = toSelf
+	repeaterCall := calls[0]
+	repeaterCall.IsToTalkgroup = false
+	repeaterCall.IsToRepeater = true
+	calls = append(calls, repeaterCall)
+
+	var buf bytes.Buffer
+	encoder, err := export.NewEncoder(export.FormatADIF, &buf, 3191868)
+	require.NoError(t, err)
+	for _, call := range calls {
+		require.NoError(t, encoder.WriteCall(call))
+	}
+	assert.Empty(t, buf.String())
+}
+
+func TestADIFNetworkExport(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	encoder, err := export.NewEncoder(export.FormatADIF, &buf, 0)
+	require.NoError(t, err)
+	for _, call := range testCalls() {
+		require.NoError(t, encoder.WriteCall(call))
+	}
+	// Without a user to export for, the contact is the calling station
+	assert.Equal(t, "<CALL:6>KI5VMF <QSO_DATE:8>20240501 <TIME_ON:6>235950 <QSO_DATE_OFF:8>20240502 <TIME_OFF:6>000005 "+
+		"<MODE:12>DIGITALVOICE <SUBMODE:3>DMR <FREQ:10>444.525000 <COMMENT:37>TG 3100 USA ? TS2 via KI5VMF (311860) <EOR>\n"+
+		"<CALL:6>KI5VMF <QSO_DATE:8>20240501 <TIME_ON:6>235950 <QSO_DATE_OFF:8>20240501 <TIME_OFF:6>235952 "+
+		"<MODE:12>DIGITALVOICE <SUBMODE:3>DMR <COMMENT:36>Private call to KP4DJT (3140598) TS1 <EOR>\n",
+		buf.String())
+}
+
+func TestCSV(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	encoder, err := export.NewEncoder(export.FormatCSV, &buf, 0)
+	require.NoError(t, err)
+	require.NoError(t, encoder.WriteHeader())
+	for _, call := range testCalls() {
+		require.NoError(t, encoder.WriteCall(call))
+	}
+	require.NoError(t, encoder.Flush())
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, []string{
+		"1", "2024-05-01T23:59:50Z", "15.000", "3191868", "KI5VMF",
+		"talkgroup", "3100", "USA ✓", "311860", "KI5VMF", "444525000", "2",
+		"0.25", "0", "0.01", "0",
+	}, records[1])
+	assert.Equal(t, "user", records[2][5])
+	assert.Equal(t, "KP4DJT", records[2][7])
+}
+
+func TestCSVEscapesFormulas(t *testing.T) {
+	t.Parallel()
+	calls := testCalls()
+	calls[0].ToTalkgroup.Name = "=HYPERLINK(\"http://example.com\")"
+	calls[0].User.Callsign = "@KI5VMF"
+	calls[0].RSSI = -80
+
+	var buf bytes.Buffer
+	encoder, err := export.NewEncoder(export.FormatCSV, &buf, 0)
+	require.NoError(t, err)
+	require.NoError(t, encoder.WriteCall(calls[0]))
+	require.NoError(t, encoder.Flush())
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "'@KI5VMF", records[0][4])
+	assert.Equal(t, "'=HYPERLINK(\"http://example.com\")", records[0][7])
+	// Numbers stay numbers
+	assert.Equal(t, "-80", records[0][15])
+}
+
+func TestUnknownFormat(t *testing.T) {
+	t.Parallel()
+	_, err := export.NewEncoder("xlsx", &bytes.Buffer{}, 0)
+	assert.ErrorIs(t, err, export.ErrUnknownFormat)
+}
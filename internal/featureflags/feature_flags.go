@@ -35,14 +35,12 @@ var (
 	featureFlagManager *FeatureFlags
 )
 
-type FeatureFlags struct {
-	config *config.Config
-}
+type FeatureFlags struct{}
 
-func Init(config *config.Config) *FeatureFlags {
-	ff := &FeatureFlags{
-		config: config,
-	}
+// Init enables feature flag lookups. Flags are read from the current
+// configuration on every lookup so that reloads take effect immediately.
+func Init() *FeatureFlags {
+	ff := &FeatureFlags{}
 	featureFlagManager = ff
 	return ff
 }
@@ -52,7 +50,7 @@ func IsEnabled(flag FeatureFlag) bool {
 		logging.Error("FeatureFlagManager not initialized")
 		return false
 	}
-	for _, v := range featureFlagManager.config.FeatureFlags {
+	for _, v := range config.GetConfig().FeatureFlags {
 		if v == string(flag) {
 			return true
 		}
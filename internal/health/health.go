@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package health
+
+import (
+	"sort"
+	"sync"
+)
+
+// Components of the DMR plane that report their own liveness.
+const (
+	HBRPSocket                 = "hbrp_socket"
+	HBRPIncomingListener       = "hbrp_incoming_listener"
+	HBRPOutgoingListener       = "hbrp_outgoing_listener"
+	HBRPOutgoingNoAddrListener = "hbrp_outgoing_noaddr_listener"
+	OpenBridgeSocket           = "openbridge_socket"
+	OpenBridgeIncomingListener = "openbridge_incoming_listener"
+	OpenBridgeOutgoingListener = "openbridge_outgoing_listener"
+)
+
+//nolint:golint,gochecknoglobals
+var (
+	mu         sync.RWMutex
+	components = map[string]bool{}
+)
+
+// Set records whether a component is currently alive.
+// Once a component has been set, it is reported until Reset is called.
+func Set(name string, alive bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	components[name] = alive
+}
+
+// Track marks a long-running goroutine as alive and returns a function
+// that marks it dead, meant to be deferred by the goroutine.
+func Track(name string) func() {
+	Set(name, true)
+	return func() {
+		Set(name, false)
+	}
+}
+
+// Components returns the names of all reported components, sorted.
+func Components() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Alive returns whether the named component is alive.
+func Alive(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return components[name]
+}
+
+// Reset forgets all reported components.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	components = map[string]bool{}
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package health_test
+
+import (
+	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/health"
+)
+
+func TestTrack(t *testing.T) {
+	t.Parallel()
+
+	const name = "test_listener"
+	done := health.Track(name)
+	if !health.Alive(name) {
+		t.Errorf("Expected %s to be alive", name)
+	}
+
+	found := false
+	for _, component := range health.Components() {
+		if component == name {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected %s to be reported", name)
+	}
+
+	done()
+	if health.Alive(name) {
+		t.Errorf("Expected %s to be dead", name)
+	}
+}
@@ -24,3 +24,14 @@ type AuthLogin struct {
 	Callsign string `json:"callsign"`
 	Password string `json:"password" binding:"required"`
 }
+
+// AuthTOTP carries either a TOTP code or a recovery code.
+type AuthTOTP struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TOTPSetupResponse struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+}
@@ -19,9 +19,31 @@
 
 package apimodels
 
+import "time"
+
 type PeerPost struct {
 	ID      uint `json:"id" binding:"required"`
 	OwnerID uint `json:"owner" binding:"required"`
 	Ingress bool `json:"ingress"`
 	Egress  bool `json:"egress"`
 }
+
+type WSPeerStatusStream struct {
+	StreamID  uint      `json:"stream_id"`
+	Src       uint      `json:"src"`
+	Dst       uint      `json:"dst"`
+	GroupCall bool      `json:"group_call"`
+	StartTime time.Time `json:"start_time"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type WSPeerStatusResponse struct {
+	ID            uint                 `json:"id"`
+	LastKeepalive time.Time            `json:"last_keepalive"`
+	PacketsIn     uint64               `json:"packets_in"`
+	PacketsOut    uint64               `json:"packets_out"`
+	BytesIn       uint64               `json:"bytes_in"`
+	BytesOut      uint64               `json:"bytes_out"`
+	HMACFailures  uint64               `json:"hmac_failures"`
+	ActiveStreams []WSPeerStatusStream `json:"active_streams"`
+}
@@ -19,15 +19,51 @@
 
 package apimodels
 
-import "github.com/USA-RedDragon/DMRHub/internal/db/models"
+import (
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+)
 
 type RepeaterPost struct {
 	RadioID uint `json:"id" binding:"required"`
 }
 
+type RepeaterAlertPost struct {
+	OfflineAfterMinutes uint   `json:"offline_after_minutes"`
+	Email               bool   `json:"email"`
+	WebhookURL          string `json:"webhook_url"`
+	QuietHoursStart     string `json:"quiet_hours_start"`
+	QuietHoursEnd       string `json:"quiet_hours_end"`
+	Timezone            string `json:"timezone"`
+}
+
 type RepeaterTalkgroupsPost struct {
 	TS1StaticTalkgroups []models.Talkgroup `json:"ts1_static_talkgroups"`
 	TS2StaticTalkgroups []models.Talkgroup `json:"ts2_static_talkgroups"`
 	TS1DynamicTalkgroup models.Talkgroup   `json:"ts1_dynamic_talkgroup"`
 	TS2DynamicTalkgroup models.Talkgroup   `json:"ts2_dynamic_talkgroup"`
 }
+
+type WSRepeaterStateTransmission struct {
+	CallID        uint                    `json:"call_id"`
+	User          WSCallResponseUser      `json:"user"`
+	StartTime     time.Time               `json:"start_time"`
+	GroupCall     bool                    `json:"group_call"`
+	IsToTalkgroup bool                    `json:"is_to_talkgroup"`
+	ToTalkgroup   WSCallResponseTalkgroup `json:"to_talkgroup"`
+	DestinationID uint                    `json:"destination_id"`
+}
+
+type WSRepeaterStateResponse struct {
+	ID                  uint                         `json:"id"`
+	Event               string                       `json:"event"`
+	Connection          string                       `json:"connection"`
+	Connected           time.Time                    `json:"connected_time"`
+	LastPing            time.Time                    `json:"last_ping_time"`
+	PingsReceived       uint                         `json:"pings_received"`
+	TS1DynamicTalkgroup *WSCallResponseTalkgroup     `json:"ts1_dynamic_talkgroup"`
+	TS2DynamicTalkgroup *WSCallResponseTalkgroup     `json:"ts2_dynamic_talkgroup"`
+	TS1Transmission     *WSRepeaterStateTransmission `json:"ts1_transmission"`
+	TS2Transmission     *WSRepeaterStateTransmission `json:"ts2_transmission"`
+}
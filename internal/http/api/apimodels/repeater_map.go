@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package apimodels
+
+import (
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+)
+
+type RepeaterMapPrivacyPost struct {
+	MapPrivacy models.MapPrivacy `json:"map_privacy"`
+}
+
+// RepeaterMap is a GeoJSON feature collection of the repeaters on the network.
+type RepeaterMap struct {
+	Type     string               `json:"type"`
+	Features []RepeaterMapFeature `json:"features"`
+}
+
+type RepeaterMapFeature struct {
+	Type       string                `json:"type"`
+	Geometry   RepeaterMapGeometry   `json:"geometry"`
+	Properties RepeaterMapProperties `json:"properties"`
+}
+
+// RepeaterMapGeometry is a GeoJSON point. Coordinates are longitude, then latitude.
+type RepeaterMapGeometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type RepeaterMapProperties struct {
+	ID       uint      `json:"id"`
+	Callsign string    `json:"callsign"`
+	Hotspot  bool      `json:"hotspot"`
+	Online   bool      `json:"online"`
+	LastPing time.Time `json:"last_ping_time"`
+	// Approximate is set when the owner asked for the position to be fuzzed
+	Approximate bool  `json:"approximate"`
+	RXFrequency uint  `json:"rx_frequency"`
+	TXFrequency uint  `json:"tx_frequency"`
+	ColorCode   uint8 `json:"color_code"`
+	// Height, Location and Description are left out of approximate positions,
+	// as they often pinpoint the site
+	Height      *uint16         `json:"height,omitempty"`
+	Location    string          `json:"location,omitempty"`
+	Description string          `json:"description,omitempty"`
+	URL         string          `json:"url"`
+	TS1         RepeaterMapSlot `json:"ts1"`
+	TS2         RepeaterMapSlot `json:"ts2"`
+}
+
+type RepeaterMapSlot struct {
+	StaticTalkgroups []RepeaterMapTalkgroup `json:"static_talkgroups"`
+	DynamicTalkgroup *RepeaterMapTalkgroup  `json:"dynamic_talkgroup"`
+}
+
+type RepeaterMapTalkgroup struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+func newRepeaterMapSlot(static []models.Talkgroup, dynamicID *uint, dynamic models.Talkgroup) RepeaterMapSlot {
+	slot := RepeaterMapSlot{StaticTalkgroups: make([]RepeaterMapTalkgroup, 0, len(static))}
+	for _, talkgroup := range static {
+		slot.StaticTalkgroups = append(slot.StaticTalkgroups, RepeaterMapTalkgroup{ID: talkgroup.ID, Name: talkgroup.Name})
+	}
+	if dynamicID != nil {
+		slot.DynamicTalkgroup = &RepeaterMapTalkgroup{ID: dynamic.ID, Name: dynamic.Name}
+	}
+	return slot
+}
+
+// NewRepeaterMap builds the map of the given repeaters, leaving out those that
+// are hidden or haven't reported a position. Repeaters seen within
+// onlineWindow of now are marked online.
+func NewRepeaterMap(repeaters []models.Repeater, now time.Time, onlineWindow time.Duration) RepeaterMap {
+	features := make([]RepeaterMapFeature, 0, len(repeaters))
+	for _, repeater := range repeaters {
+		latitude, longitude, ok := repeater.MapPosition()
+		if !ok {
+			continue
+		}
+		properties := RepeaterMapProperties{
+			ID:          repeater.ID,
+			Callsign:    repeater.Callsign,
+			Hotspot:     repeater.Hotspot,
+			Online:      now.Sub(repeater.LastPing) < onlineWindow,
+			LastPing:    repeater.LastPing,
+			Approximate: repeater.EffectiveMapPrivacy() == models.MapPrivacyApproximate,
+			RXFrequency: repeater.RXFrequency,
+			TXFrequency: repeater.TXFrequency,
+			ColorCode:   repeater.ColorCode,
+			URL:         repeater.URL,
+			TS1:         newRepeaterMapSlot(repeater.TS1StaticTalkgroups, repeater.TS1DynamicTalkgroupID, repeater.TS1DynamicTalkgroup),
+			TS2:         newRepeaterMapSlot(repeater.TS2StaticTalkgroups, repeater.TS2DynamicTalkgroupID, repeater.TS2DynamicTalkgroup),
+		}
+		if !properties.Approximate {
+			height := repeater.Height
+			properties.Height = &height
+			properties.Location = repeater.Location
+			properties.Description = repeater.Description
+		}
+		features = append(features, RepeaterMapFeature{
+			Type:       "Feature",
+			Geometry:   RepeaterMapGeometry{Type: "Point", Coordinates: [2]float64{longitude, latitude}},
+			Properties: properties,
+		})
+	}
+	return RepeaterMap{Type: "FeatureCollection", Features: features}
+}
@@ -19,7 +19,13 @@
 
 package apimodels
 
-import "regexp"
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+)
 
 const minUsernameLength = 3
 const maxUsernameLength = 20
@@ -29,6 +35,7 @@ type UserRegistration struct {
 	Callsign string `json:"callsign" binding:"required"`
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	Email    string `json:"email"`
 }
 
 func (r *UserRegistration) IsValidUsername() (bool, string) {
@@ -48,4 +55,45 @@ type UserPatch struct {
 	Callsign string `json:"callsign"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email"`
+	// CurrentPassword is required when users change their own password or email
+	CurrentPassword string `json:"current_password"`
+}
+
+// NormalizeEmail lowercases an email address and reports whether it is a bare, valid address.
+func NormalizeEmail(email string) (string, bool) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", false
+	}
+	return email, true
+}
+
+// UserWithEmail is a user as shown to themselves or an admin.
+type UserWithEmail struct {
+	models.User
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+func NewUserWithEmail(user models.User) UserWithEmail {
+	return UserWithEmail{
+		User:          user,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	}
+}
+
+type EmailVerification struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type PasswordReset struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
 }
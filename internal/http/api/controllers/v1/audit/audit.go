@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package audit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GETAuditLog returns the audit log, newest first. It can be filtered with
+// the actor_id, action, target_type, target_id, since and until query
+// parameters, where since and until are RFC 3339 times.
+func GETAuditLog(c *gin.Context) {
+	db, ok := c.MustGet("PaginatedDB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	cDb, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+
+	entries, err := models.ListAuditLogs(db, filter)
+	if err != nil {
+		logging.Errorf("Error listing audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing audit log"})
+		return
+	}
+	total, err := models.CountAuditLogs(cDb, filter)
+	if err != nil {
+		logging.Errorf("Error counting audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing audit log"})
+		return
+	}
+	response := make([]apimodels.AuditLog, 0, len(entries))
+	for _, entry := range entries {
+		response = append(response, apimodels.NewAuditLog(entry))
+	}
+	c.JSON(http.StatusOK, gin.H{"total": total, "entries": response})
+}
+
+func parseFilter(c *gin.Context) (models.AuditLogFilter, bool) {
+	filter := models.AuditLogFilter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+	}
+	for name, dest := range map[string]*uint{"actor_id": &filter.ActorID, "target_id": &filter.TargetID} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+			return filter, false
+		}
+		*dest = uint(id)
+	}
+	for name, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + ", expected an RFC 3339 time"})
+			return filter, false
+		}
+		*dest = parsed
+	}
+	return filter, true
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package audit_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/audit"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/users"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const adminID = 999999
+
+type auditResponse struct {
+	Total   int `json:"total"`
+	Entries []struct {
+		ActorID    uint           `json:"actor_id"`
+		Action     string         `json:"action"`
+		TargetType string         `json:"target_type"`
+		TargetID   uint           `json:"target_id"`
+		IP         string         `json:"ip"`
+		Before     map[string]any `json:"before"`
+		After      map[string]any `json:"after"`
+	} `json:"entries"`
+}
+
+func makeAuditRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	db := testutils.OpenDB(t)
+	for _, user := range []models.User{
+		{ID: adminID, Callsign: "SYSTEM", Username: "Admin", Approved: true, Admin: true},
+		{ID: 3191868, Callsign: "KI5VMF", Username: "jacob", Approved: true},
+		{ID: 3140598, Callsign: "KP4DJT", Username: "dan", Approved: true},
+	} {
+		require.NoError(t, db.Create(&user).Error)
+	}
+
+	// Every request comes from the super admin
+	router := testutils.NewRouter(db, testutils.LoginAs(adminID))
+	router.POST("/users/promote/:id", users.POSTUserPromote)
+	router.POST("/users/suspend/:id", users.POSTUserSuspend)
+	router.PATCH("/users/:id", users.PATCHUser)
+	router.GET("/audit", audit.GETAuditLog)
+	return router
+}
+
+func TestAuditLogRecordsActions(t *testing.T) {
+	t.Parallel()
+	router := makeAuditRouter(t)
+
+	require.Equal(t, http.StatusOK, testutils.Request(t, router, http.MethodPost, "/users/promote/3191868", nil).Code)
+	require.Equal(t, http.StatusOK, testutils.Request(t, router, http.MethodPost, "/users/suspend/3140598", nil).Code)
+
+	w := testutils.Request(t, router, http.MethodGet, "/audit", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp auditResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, 2, resp.Total)
+	require.Len(t, resp.Entries, 2)
+
+	// Newest first
+	suspend := resp.Entries[0]
+	assert.Equal(t, "user.suspend", suspend.Action)
+	assert.Equal(t, uint(3140598), suspend.TargetID)
+	assert.Equal(t, map[string]any{"suspended": false}, suspend.Before)
+	assert.Equal(t, map[string]any{"suspended": true}, suspend.After)
+
+	promote := resp.Entries[1]
+	assert.Equal(t, "user.promote", promote.Action)
+	assert.Equal(t, uint(adminID), promote.ActorID)
+	assert.Equal(t, "user", promote.TargetType)
+	assert.Equal(t, uint(3191868), promote.TargetID)
+	assert.Equal(t, "192.0.2.10", promote.IP)
+	assert.Equal(t, map[string]any{"admin": false}, promote.Before)
+	assert.Equal(t, map[string]any{"admin": true}, promote.After)
+}
+
+func TestAuditLogRecordsEmailChange(t *testing.T) {
+	t.Parallel()
+	router := makeAuditRouter(t)
+
+	require.Equal(t, http.StatusOK, testutils.Request(t, router, http.MethodPatch, "/users/3191868", map[string]string{"email": "jacob@example.com"}).Code)
+
+	w := testutils.Request(t, router, http.MethodGet, "/audit", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp auditResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Entries, 1)
+	assert.Equal(t, "user.update", resp.Entries[0].Action)
+	assert.Equal(t, true, resp.Entries[0].After["email_changed"])
+	assert.Equal(t, false, resp.Entries[0].After["password_changed"])
+}
+
+func TestAuditLogFilters(t *testing.T) {
+	t.Parallel()
+	router := makeAuditRouter(t)
+	require.Equal(t, http.StatusOK, testutils.Request(t, router, http.MethodPost, "/users/promote/3191868", nil).Code)
+	require.Equal(t, http.StatusOK, testutils.Request(t, router, http.MethodPost, "/users/suspend/3140598", nil).Code)
+
+	for query, total := range map[string]int{
+		"action=user.promote":           1,
+		"target_type=user&target_id=1":  0,
+		"target_id=3140598":             1,
+		"actor_id=999999":               2,
+		"since=2000-01-01T00:00:00Z":    2,
+		"until=2000-01-01T00:00:00Z":    0,
+		"action=user.demote&limit=none": 0,
+	} {
+		w := testutils.Request(t, router, http.MethodGet, "/audit?"+query, nil)
+		require.Equal(t, http.StatusOK, w.Code, query)
+		var resp auditResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, total, resp.Total, query)
+		assert.Len(t, resp.Entries, total, query)
+	}
+
+	// Pagination only limits the entries, not the total
+	w := testutils.Request(t, router, http.MethodGet, "/audit?limit=1&page=2", nil)
+	var resp auditResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Total)
+	require.Len(t, resp.Entries, 1)
+	assert.Equal(t, "user.promote", resp.Entries[0].Action)
+
+	for _, query := range []string{"actor_id=abc", "since=yesterday"} {
+		assert.Equal(t, http.StatusBadRequest, testutils.Request(t, router, http.MethodGet, "/audit?"+query, nil).Code, query)
+	}
+}
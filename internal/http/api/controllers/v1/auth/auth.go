@@ -72,14 +72,17 @@ func POSTLogin(c *gin.Context) {
 				return
 			}
 			if user.Approved {
-				session.Set("user_id", user.ID)
-				err = session.Save()
+				pending, err := beginSession(session, user)
 				if err != nil {
 					logging.Errorf("POSTLogin: %v", err)
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving session"})
 					return
 				}
-				c.JSON(http.StatusOK, gin.H{"message": "Logged in"})
+				if pending {
+					c.JSON(http.StatusOK, gin.H{"message": "Two-factor code required", "totp_required": true})
+					return
+				}
+				c.JSON(http.StatusOK, loggedInResponse(user))
 				return
 			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User is not approved"})
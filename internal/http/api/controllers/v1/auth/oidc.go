@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/smtp"
+	"github.com/USA-RedDragon/DMRHub/internal/sso"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateKey    = "oidc_state"
+	oidcNonceKey    = "oidc_nonce"
+	oidcVerifierKey = "oidc_verifier"
+)
+
+// GETOIDCLogin starts the OIDC authorization code flow by redirecting to the provider.
+func GETOIDCLogin(c *gin.Context) {
+	if !sso.Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not enabled"})
+		return
+	}
+	provider, err := sso.GetProvider(c.Request.Context())
+	if err != nil {
+		logging.Errorf("GETOIDCLogin: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	state, err := sso.RandomString()
+	if err != nil {
+		logging.Errorf("GETOIDCLogin: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	nonce, err := sso.RandomString()
+	if err != nil {
+		logging.Errorf("GETOIDCLogin: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	verifier := sso.NewVerifier()
+
+	session := sessions.Default(c)
+	session.Set(oidcStateKey, state)
+	session.Set(oidcNonceKey, nonce)
+	session.Set(oidcVerifierKey, verifier)
+	err = session.Save()
+	if err != nil {
+		logging.Errorf("GETOIDCLogin: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving session"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(state, nonce, verifier))
+}
+
+// GETOIDCCallback finishes the OIDC flow and logs in the matching user.
+func GETOIDCCallback(c *gin.Context) {
+	if !sso.Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not enabled"})
+		return
+	}
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("GETOIDCCallback: Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	session := sessions.Default(c)
+	state, _ := session.Get(oidcStateKey).(string)
+	nonce, _ := session.Get(oidcNonceKey).(string)
+	verifier, _ := session.Get(oidcVerifierKey).(string)
+	// The flow values are single use, whatever the outcome
+	session.Delete(oidcStateKey)
+	session.Delete(oidcNonceKey)
+	session.Delete(oidcVerifierKey)
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		logging.Errorf("GETOIDCCallback: Provider returned an error: %s %s", providerErr, c.Query("error_description"))
+		saveSession(session)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+		return
+	}
+	if state == "" || c.Query("state") != state {
+		saveSession(session)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login state, please try again"})
+		return
+	}
+
+	provider, err := sso.GetProvider(c.Request.Context())
+	if err != nil {
+		logging.Errorf("GETOIDCCallback: %v", err)
+		saveSession(session)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+	claims, err := provider.Exchange(c.Request.Context(), c.Query("code"), verifier, nonce)
+	if err != nil {
+		logging.Errorf("GETOIDCCallback: %v", err)
+		saveSession(session)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+		return
+	}
+
+	cfg := config.GetConfig()
+	identity, err := sso.IdentityFromClaims(claims, cfg.OIDCUserClaim, cfg.OIDCDMRIDClaim, cfg.OIDCUserClaimTrusted)
+	if err != nil {
+		logging.Errorf("GETOIDCCallback: %v", err)
+		saveSession(session)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+		return
+	}
+
+	user, created, err := sso.FindOrCreateUser(db, identity, cfg.OIDCCreateUsers)
+	switch {
+	case errors.Is(err, sso.ErrUnknownUser):
+		saveSession(session)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No account is linked to this identity"})
+		return
+	case errors.Is(err, sso.ErrNoDMRID), errors.Is(err, sso.ErrUserConflict), errors.Is(err, sso.ErrUnverifiedClaim):
+		logging.Errorf("GETOIDCCallback: %v", err)
+		saveSession(session)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unable to create an account for this identity"})
+		return
+	case err != nil:
+		logging.Errorf("GETOIDCCallback: %v", err)
+		saveSession(session)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	if created {
+		saveSession(session)
+		c.JSON(http.StatusOK, gin.H{"message": "User created, please wait for admin approval"})
+		go webhooks.Emit(db, webhooks.EventUserRegistered, webhooks.UserFromModel(user))
+		if cfg.EnableEmail {
+			err := smtp.Send(
+				cfg.AdminEmail,
+				"New user registration",
+				fmt.Sprintf("A new user has registered through single sign-on.<br><br>Username: %s<br>Callsign: %s<br>DMR ID: %d<br><br><a href=\"%s/admin/users/approval\">Click here</a> to see the approval dashboard", user.Username, user.Callsign, user.ID, cfg.CanonicalHost),
+			)
+			if err != nil {
+				logging.Errorf("GETOIDCCallback: Error sending email: %v", err)
+			}
+		}
+		return
+	}
+	if user.Suspended {
+		saveSession(session)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User is suspended"})
+		return
+	}
+	if !user.Approved {
+		saveSession(session)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User is not approved"})
+		return
+	}
+
+	pending, err := beginSession(session, user)
+	if err != nil {
+		logging.Errorf("GETOIDCCallback: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving session"})
+		return
+	}
+	if pending {
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor code required", "totp_required": true})
+		return
+	}
+	c.Redirect(http.StatusFound, "/")
+}
+
+func saveSession(session sessions.Session) {
+	err := session.Save()
+	if err != nil {
+		logging.Errorf("Error saving session: %v", err)
+	}
+}
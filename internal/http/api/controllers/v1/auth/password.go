@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/emailtoken"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/utils"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/notify"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// POSTPasswordForgot mails a password reset link to a verified address.
+// The response is the same whether or not the address belongs to a user.
+func POSTPasswordForgot(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("POSTPasswordForgot: Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if !config.GetConfig().EnableEmail {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is not enabled"})
+		return
+	}
+	var json apimodels.PasswordResetRequest
+	err := c.ShouldBindJSON(&json)
+	if err != nil {
+		logging.Errorf("POSTPasswordForgot: JSON data is invalid: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+	email, valid := apimodels.NormalizeEmail(json.Email)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email address is not valid"})
+		return
+	}
+
+	user, err := models.FindUserByVerifiedEmail(db, email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logging.Errorf("POSTPasswordForgot: Error finding user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if err == nil && !user.Suspended {
+		// Send in the background so the response time doesn't reveal whether the address exists
+		go func() {
+			err := notify.SendPasswordReset(user)
+			if err != nil {
+				logging.Errorf("POSTPasswordForgot: Error sending email: %v", err)
+			}
+		}()
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "If that address belongs to a verified account, a reset link has been sent"})
+}
+
+// POSTPasswordReset sets a new password using a mailed reset token.
+func POSTPasswordReset(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("POSTPasswordReset: Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	var json apimodels.PasswordReset
+	err := c.ShouldBindJSON(&json)
+	if err != nil {
+		logging.Errorf("POSTPasswordReset: JSON data is invalid: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+
+	token, err := notify.ParseToken(emailtoken.PurposeResetPassword, json.Token)
+	if errors.Is(err, emailtoken.ErrExpiredToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link has expired"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is not valid"})
+		return
+	}
+
+	user, err := models.FindUserByID(db, token.UserID)
+	// The token is bound to the password hash, which is salted freshly on every change,
+	// so it stops working once used
+	if err != nil || !token.Bound(user.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is not valid"})
+		return
+	}
+
+	// A reset password has to pass the same checks as one chosen when registering
+	err = utils.CheckNewPassword(json.Password, config.GetConfig().HIBPAPIKey)
+	if err != nil {
+		utils.RespondNewPasswordError(c, "POSTPasswordReset", err)
+		return
+	}
+
+	hashedPassword := utils.HashPassword(json.Password, config.GetConfig().PasswordSalt)
+
+	// Only update if the password is still the one the token was issued for
+	result := db.Model(&models.User{}).Where("id = ? AND password = ?", user.ID, user.Password).Update("password", hashedPassword)
+	if result.Error != nil {
+		logging.Errorf("POSTPasswordReset: Error saving user: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is not valid"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset, you can now log in"})
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package auth_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/auth"
+	"github.com/USA-RedDragon/DMRHub/internal/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+	router, db := makeTOTPRouter(t)
+	router.POST("/password/reset", auth.POSTPasswordReset)
+	client := &totpClient{t: t, router: router}
+
+	user, err := models.FindUserByID(db, 3191868)
+	require.NoError(t, err)
+	token := notify.ResetPasswordToken(user)
+
+	code, resp := client.post("/password/reset", map[string]string{"token": token + "x", "password": "hunter22"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "Reset link is not valid", resp["error"])
+
+	code, resp = client.post("/password/reset", map[string]string{"token": token, "password": "hunter22"})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Password reset, you can now log in", resp["message"])
+
+	// The link can't be used a second time
+	code, resp = client.post("/password/reset", map[string]string{"token": token, "password": "hunter23"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "Reset link is not valid", resp["error"])
+
+	code, _ = client.post("/login", map[string]string{"username": "jacob", "password": "password"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, resp = client.post("/login", map[string]string{"username": "jacob", "password": "hunter22"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Logged in", resp["message"])
+}
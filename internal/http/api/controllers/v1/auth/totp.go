@@ -0,0 +1,445 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/totp"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	totpPendingUserKey  = "totp_pending_user_id"
+	totpPendingSinceKey = "totp_pending_since"
+	totpAttemptsKey     = "totp_attempts"
+	totpFailuresPrefix  = "auth:totp_failures:"
+
+	// totpLoginTimeout is how long the second step of a login may take
+	totpLoginTimeout = 5 * time.Minute
+	// totpMaxAttempts is how many wrong codes are allowed before the login starts over
+	totpMaxAttempts = 5
+	// totpUserMaxFailures is how many wrong codes a user's logins may see in
+	// totpFailureWindow, so starting the login over doesn't give more guesses
+	totpUserMaxFailures = 10
+	totpFailureWindow   = 15 * time.Minute
+	recoveryCodeCount   = 10
+)
+
+var errNoSecondFactor = errors.New("no code given")
+
+// beginSession logs the user in once their password or identity provider
+// login has been checked. Users with two-factor authentication enabled
+// instead get a pending login that POSTLoginTOTP completes.
+func beginSession(session sessions.Session, user models.User) (bool, error) {
+	session.Delete("user_id")
+	clearPendingLogin(session)
+	if user.TOTPEnabled {
+		session.Set(totpPendingUserKey, user.ID)
+		session.Set(totpPendingSinceKey, time.Now().Unix())
+		session.Set(totpAttemptsKey, 0)
+	} else {
+		session.Set("user_id", user.ID)
+	}
+	err := session.Save()
+	if err != nil {
+		return false, fmt.Errorf("failed to save session: %w", err)
+	}
+	return user.TOTPEnabled, nil
+}
+
+func clearPendingLogin(session sessions.Session) {
+	session.Delete(totpPendingUserKey)
+	session.Delete(totpPendingSinceKey)
+	session.Delete(totpAttemptsKey)
+}
+
+func loggedInResponse(user models.User) gin.H {
+	response := gin.H{"message": "Logged in"}
+	if user.Admin && !user.TOTPEnabled && config.GetConfig().RequireAdminTOTP {
+		// Admin endpoints stay locked until two-factor authentication is set up
+		response["totp_enrollment_required"] = true
+	}
+	return response
+}
+
+// verifySecondFactor checks a TOTP code or consumes a recovery code.
+func verifySecondFactor(db *gorm.DB, user models.User, input apimodels.AuthTOTP) (bool, error) {
+	switch {
+	case input.Code != "":
+		step, ok := totp.Validate(user.TOTPSecret, input.Code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			return false, nil
+		}
+		// Only move the step forward, so two requests racing with the same code can't both win
+		result := db.Model(&models.User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+		if result.Error != nil {
+			return false, fmt.Errorf("failed to record TOTP step: %w", result.Error)
+		}
+		return result.RowsAffected > 0, nil
+	case input.RecoveryCode != "":
+		used, err := models.UseRecoveryCode(db, user.ID, totp.HashRecoveryCode(input.RecoveryCode, config.GetConfig().PasswordSalt))
+		if err != nil {
+			return false, fmt.Errorf("failed to use recovery code: %w", err)
+		}
+		return used, nil
+	default:
+		return false, errNoSecondFactor
+	}
+}
+
+// totpLockedOut reports whether a user has entered too many wrong codes recently.
+func totpLockedOut(ctx context.Context, store kv.KV, key string) (bool, error) {
+	value, err := store.Get(ctx, key)
+	if errors.Is(err, kv.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get TOTP failures: %w", err)
+	}
+	failures, err := strconv.Atoi(string(value))
+	if err != nil {
+		return false, fmt.Errorf("invalid TOTP failure count: %w", err)
+	}
+	return failures >= totpUserMaxFailures, nil
+}
+
+// newRecoveryCodes replaces the user's recovery codes and returns the new ones.
+func newRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, totp.HashRecoveryCode(code, config.GetConfig().PasswordSalt))
+	}
+	err = models.ReplaceRecoveryCodes(db, userID, hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// POSTLoginTOTP completes a login that is waiting on a TOTP or recovery code.
+func POSTLoginTOTP(c *gin.Context) {
+	session := sessions.Default(c)
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("POSTLoginTOTP: Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	store, ok := c.MustGet("KV").(kv.KV)
+	if !ok {
+		logging.Errorf("POSTLoginTOTP: Unable to get KV from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	userID, ok := session.Get(totpPendingUserKey).(uint)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No login in progress"})
+		return
+	}
+	since, _ := session.Get(totpPendingSinceKey).(int64)
+	if time.Since(time.Unix(since, 0)) > totpLoginTimeout {
+		clearPendingLogin(session)
+		saveSession(session)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please log in again"})
+		return
+	}
+
+	var json apimodels.AuthTOTP
+	err := c.ShouldBindJSON(&json)
+	if err != nil {
+		logging.Errorf("POSTLoginTOTP: JSON data is invalid: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+
+	user, err := models.FindUserByID(db, userID)
+	if err != nil {
+		logging.Errorf("POSTLoginTOTP: Error finding user: %v", err)
+		clearPendingLogin(session)
+		saveSession(session)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	failuresKey := totpFailuresPrefix + strconv.FormatUint(uint64(user.ID), 10)
+	lockedOut, err := totpLockedOut(ctx, store, failuresKey)
+	if err != nil {
+		logging.Errorf("POSTLoginTOTP: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if lockedOut {
+		clearPendingLogin(session)
+		saveSession(session)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes, please try again later"})
+		return
+	}
+
+	verified, err := verifySecondFactor(db, user, json)
+	if errors.Is(err, errNoSecondFactor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A code or recovery code is required"})
+		return
+	} else if err != nil {
+		logging.Errorf("POSTLoginTOTP: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if !verified || !user.TOTPEnabled {
+		_, err := store.Incr(ctx, failuresKey, totpFailureWindow)
+		if err != nil {
+			logging.Errorf("POSTLoginTOTP: Error counting TOTP failures: %v", err)
+		}
+		attempts, _ := session.Get(totpAttemptsKey).(int)
+		attempts++
+		if attempts >= totpMaxAttempts {
+			clearPendingLogin(session)
+			saveSession(session)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many attempts, please log in again"})
+			return
+		}
+		session.Set(totpAttemptsKey, attempts)
+		saveSession(session)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	clearPendingLogin(session)
+	_, err = store.Delete(ctx, failuresKey)
+	if err != nil {
+		logging.Errorf("POSTLoginTOTP: Error clearing TOTP failures: %v", err)
+	}
+	if user.Suspended {
+		saveSession(session)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User is suspended"})
+		return
+	}
+	if !user.Approved {
+		saveSession(session)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User is not approved"})
+		return
+	}
+	session.Set("user_id", user.ID)
+	err = session.Save()
+	if err != nil {
+		logging.Errorf("POSTLoginTOTP: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving session"})
+		return
+	}
+	c.JSON(http.StatusOK, loggedInResponse(user))
+}
+
+// sessionUser loads the logged in user for the two-factor management endpoints.
+func sessionUser(c *gin.Context) (*gorm.DB, models.User, bool) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Error("DB cast failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return nil, models.User{}, false
+	}
+	userID, ok := sessions.Default(c).Get("user_id").(uint)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+		return nil, models.User{}, false
+	}
+	user, err := models.FindUserByID(db, userID)
+	if err != nil {
+		logging.Errorf("Error finding user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding user"})
+		return nil, models.User{}, false
+	}
+	return db, user, true
+}
+
+// POSTTOTPSetup starts enrolment by generating a secret for the user to add to their authenticator.
+func POSTTOTPSetup(c *gin.Context) {
+	db, user, ok := sessionUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		logging.Errorf("POSTTOTPSetup: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	err = db.Model(&user).Updates(map[string]any{"totp_secret": secret, "totp_last_step": 0}).Error
+	if err != nil {
+		logging.Errorf("POSTTOTPSetup: Error saving user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, apimodels.TOTPSetupResponse{
+		Secret: secret,
+		URL:    totp.URL(config.GetConfig().NetworkName, user.Username, secret),
+	})
+}
+
+// POSTTOTPEnable finishes enrolment once the user proves their authenticator works.
+func POSTTOTPEnable(c *gin.Context) {
+	db, user, ok := sessionUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor setup has not been started"})
+		return
+	}
+
+	var json apimodels.AuthTOTP
+	err := c.ShouldBindJSON(&json)
+	if err != nil || json.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+	step, valid := totp.Validate(user.TOTPSecret, json.Code, time.Now(), user.TOTPLastStep)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, err := newRecoveryCodes(db, user.ID)
+	if err != nil {
+		logging.Errorf("POSTTOTPEnable: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	err = db.Model(&user).Updates(map[string]any{"totp_enabled": true, "totp_last_step": step}).Error
+	if err != nil {
+		logging.Errorf("POSTTOTPEnable: Error saving user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// POSTTOTPDisable turns two-factor authentication off after checking a code.
+func POSTTOTPDisable(c *gin.Context) {
+	db, user, ok := sessionUser(c)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	var json apimodels.AuthTOTP
+	err := c.ShouldBindJSON(&json)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+	verified, err := verifySecondFactor(db, user, json)
+	if errors.Is(err, errNoSecondFactor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A code or recovery code is required"})
+		return
+	} else if err != nil {
+		logging.Errorf("POSTTOTPDisable: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if !verified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	err = disableTOTP(db, user.ID)
+	if err != nil {
+		logging.Errorf("POSTTOTPDisable: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// POSTTOTPRecoveryCodes replaces the user's recovery codes after checking a TOTP code.
+func POSTTOTPRecoveryCodes(c *gin.Context) {
+	db, user, ok := sessionUser(c)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	var json apimodels.AuthTOTP
+	err := c.ShouldBindJSON(&json)
+	if err != nil || json.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+	verified, err := verifySecondFactor(db, user, apimodels.AuthTOTP{Code: json.Code})
+	if err != nil {
+		logging.Errorf("POSTTOTPRecoveryCodes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if !verified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, err := newRecoveryCodes(db, user.ID)
+	if err != nil {
+		logging.Errorf("POSTTOTPRecoveryCodes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func disableTOTP(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error { //nolint:golint,wrapcheck
+		err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]any{"totp_enabled": false, "totp_secret": "", "totp_last_step": 0}).Error
+		if err != nil {
+			return fmt.Errorf("failed to disable TOTP: %w", err)
+		}
+		return models.ReplaceRecoveryCodes(tx, userID, nil) //nolint:golint,wrapcheck
+	})
+}
@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package auth_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/auth"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/middleware"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/utils"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/USA-RedDragon/DMRHub/internal/totp"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type totpClient struct {
+	t       *testing.T
+	router  *gin.Engine
+	cookies []*http.Cookie
+}
+
+func (c *totpClient) post(path string, body any) (int, map[string]any) {
+	c.t.Helper()
+	w := testutils.Request(c.t, c.router, http.MethodPost, path, body, testutils.WithCookies(c.cookies))
+	if cookies := w.Result().Cookies(); len(cookies) > 0 {
+		c.cookies = cookies
+	}
+	var resp map[string]any
+	require.NoError(c.t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return w.Code, resp
+}
+
+func makeTOTPRouter(t *testing.T) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+	db := testutils.OpenDB(t)
+	user := models.User{
+		ID:       3191868,
+		Callsign: "KI5VMF",
+		Username: "jacob",
+		Password: utils.HashPassword("password", config.GetConfig().PasswordSalt),
+		Approved: true,
+	}
+	require.NoError(t, db.Create(&user).Error)
+
+	router := testutils.NewRouter(db, middleware.KVProvider(kv.NewMemory()))
+	router.POST("/login", auth.POSTLogin)
+	router.POST("/login/totp", auth.POSTLoginTOTP)
+	router.POST("/totp/setup", middleware.RequireLogin(), auth.POSTTOTPSetup)
+	router.POST("/totp/enable", middleware.RequireLogin(), auth.POSTTOTPEnable)
+	return router, db
+}
+
+func TestTOTPLogin(t *testing.T) {
+	t.Parallel()
+	router, _ := makeTOTPRouter(t)
+	client := &totpClient{t: t, router: router}
+	login := map[string]string{"username": "jacob", "password": "password"}
+
+	code, resp := client.post("/login", login)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Logged in", resp["message"])
+
+	code, resp = client.post("/totp/setup", nil)
+	require.Equal(t, http.StatusOK, code)
+	secret, _ := resp["secret"].(string)
+	require.NotEmpty(t, secret)
+
+	now := time.Now()
+	current, err := totp.CodeAt(secret, totp.Step(now))
+	require.NoError(t, err)
+	code, resp = client.post("/totp/enable", map[string]string{"code": current})
+	require.Equal(t, http.StatusOK, code)
+	recoveryCodes, _ := resp["recovery_codes"].([]any)
+	require.Len(t, recoveryCodes, 10)
+
+	// Logging in now needs a second step
+	other := &totpClient{t: t, router: router}
+	code, resp = other.post("/login", login)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, resp["totp_required"])
+	code, _ = other.post("/totp/setup", nil)
+	assert.Equal(t, http.StatusUnauthorized, code, "pending login must not grant a session")
+
+	// The code used to enroll can't be replayed
+	code, resp = other.post("/login/totp", map[string]string{"code": current})
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "Invalid code", resp["error"])
+
+	next, err := totp.CodeAt(secret, totp.Step(now)+1)
+	require.NoError(t, err)
+	code, resp = other.post("/login/totp", map[string]string{"code": next})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Logged in", resp["message"])
+
+	// Recovery codes work once
+	recovery, _ := recoveryCodes[0].(string)
+	third := &totpClient{t: t, router: router}
+	third.post("/login", login)
+	code, _ = third.post("/login/totp", map[string]string{"recovery_code": recovery})
+	assert.Equal(t, http.StatusOK, code)
+
+	fourth := &totpClient{t: t, router: router}
+	fourth.post("/login", login)
+	code, _ = fourth.post("/login/totp", map[string]string{"recovery_code": recovery})
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestTOTPLoginAttemptLimit(t *testing.T) {
+	t.Parallel()
+	router, db := makeTOTPRouter(t)
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&models.User{}).Where("id = ?", 3191868).Updates(map[string]any{"totp_secret": secret, "totp_enabled": true}).Error)
+
+	client := &totpClient{t: t, router: router}
+	code, resp := client.post("/login", map[string]string{"username": "jacob", "password": "password"})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, true, resp["totp_required"])
+
+	for range 4 {
+		code, _ = client.post("/login/totp", map[string]string{"code": "000000"})
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+	_, resp = client.post("/login/totp", map[string]string{"code": "000000"})
+	assert.Equal(t, "Too many attempts, please log in again", resp["error"])
+
+	current, err := totp.CodeAt(secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	_, resp = client.post("/login/totp", map[string]string{"code": current})
+	assert.Equal(t, "No login in progress", resp["error"])
+}
+
+func TestTOTPLoginUserFailureLimit(t *testing.T) {
+	t.Parallel()
+	router, db := makeTOTPRouter(t)
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&models.User{}).Where("id = ?", 3191868).Updates(map[string]any{"totp_secret": secret, "totp_enabled": true}).Error)
+
+	// Starting the login over doesn't reset the number of guesses
+	client := &totpClient{t: t, router: router}
+	for range 5 {
+		code, _ := client.post("/login", map[string]string{"username": "jacob", "password": "password"})
+		require.Equal(t, http.StatusOK, code)
+		for range 2 {
+			code, _ = client.post("/login/totp", map[string]string{"code": "000000"})
+			require.Equal(t, http.StatusUnauthorized, code)
+		}
+	}
+
+	current, err := totp.CodeAt(secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	client.post("/login", map[string]string{"username": "jacob", "password": "password"})
+	code, resp := client.post("/login/totp", map[string]string{"code": current})
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "Too many invalid codes, please try again later", resp["error"])
+}
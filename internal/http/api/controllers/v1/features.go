@@ -23,9 +23,10 @@ import (
 	"net/http"
 
 	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/sso"
 	"github.com/gin-gonic/gin"
 )
 
 func GETFeatures(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"features": config.GetConfig().FeatureFlags})
+	c.JSON(http.StatusOK, gin.H{"features": config.GetConfig().FeatureFlags, "oidc": sso.Enabled(), "email": config.GetConfig().EnableEmail})
 }
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package v1
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/migration"
+	"github.com/USA-RedDragon/DMRHub/internal/health"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/repeaterdb"
+	"github.com/USA-RedDragon/DMRHub/internal/userdb"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	healthCheckTimeout = 2 * time.Second
+	statusOK           = "ok"
+	statusFail         = "fail"
+)
+
+// GETHealthz reports whether the DMR plane of this process is alive.
+func GETHealthz(c *gin.Context) {
+	response := apimodels.HealthResponse{
+		Status:     statusOK,
+		Components: make(map[string]apimodels.HealthComponent),
+	}
+	checkDMRPlane(&response)
+	checkIDDatabases(&response)
+	writeHealth(c, response)
+}
+
+// GETReadyz reports whether this process and its dependencies are ready to serve.
+func GETReadyz(c *gin.Context) {
+	response := apimodels.HealthResponse{
+		Status:     statusOK,
+		Components: make(map[string]apimodels.HealthComponent),
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		setComponent(&response, "database", "unable to get database")
+	} else {
+		setComponent(&response, "database", checkDatabase(ctx, db))
+	}
+
+	// The memory store lives in this process, so there is nothing to check
+	if config.GetConfig().Store == config.StoreRedis {
+		store, ok := c.MustGet("KV").(kv.KV)
+		if !ok {
+			logging.Errorf("Unable to get KV from context")
+			setComponent(&response, "redis", "unable to get redis")
+		} else {
+			errMsg := ""
+			if err := store.Ping(ctx); err != nil {
+				errMsg = err.Error()
+			}
+			setComponent(&response, "redis", errMsg)
+		}
+	}
+
+	checkDMRPlane(&response)
+	checkIDDatabases(&response)
+	writeHealth(c, response)
+}
+
+func checkDatabase(ctx context.Context, db *gorm.DB) string {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err.Error()
+	}
+	err = sqlDB.PingContext(ctx)
+	if err != nil {
+		return err.Error()
+	}
+	migrated, err := migration.IsMigrated(db.WithContext(ctx))
+	if err != nil {
+		return err.Error()
+	}
+	if !migrated {
+		return "migrations pending"
+	}
+	return ""
+}
+
+func checkDMRPlane(response *apimodels.HealthResponse) {
+	for _, name := range health.Components() {
+		errMsg := ""
+		if !health.Alive(name) {
+			errMsg = "not running"
+		}
+		setComponent(response, name, errMsg)
+	}
+}
+
+// checkIDDatabases reports when the DMR ID databases were last refreshed.
+// A stale database does not fail the check since the built-in copy is still usable.
+func checkIDDatabases(response *apimodels.HealthResponse) {
+	for name, getDate := range map[string]func() (time.Time, error){
+		"userdb":     userdb.GetDate,
+		"repeaterdb": repeaterdb.GetDate,
+	} {
+		date, err := getDate()
+		if err != nil {
+			response.Components[name] = apimodels.HealthComponent{Status: statusFail, Error: err.Error()}
+			continue
+		}
+		response.Components[name] = apimodels.HealthComponent{Status: statusOK, LastRefresh: &date}
+	}
+}
+
+func setComponent(response *apimodels.HealthResponse, name string, errMsg string) {
+	if errMsg == "" {
+		response.Components[name] = apimodels.HealthComponent{Status: statusOK}
+		return
+	}
+	response.Status = statusFail
+	response.Components[name] = apimodels.HealthComponent{Status: statusFail, Error: errMsg}
+}
+
+func writeHealth(c *gin.Context, response apimodels.HealthResponse) {
+	if response.Status != statusOK {
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package lastheard
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/export"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GETLastheardExport streams the calls of the whole network as a file. The
+// format query parameter picks csv (the default) or adif, and the filters of
+// the lastheard listings apply.
+func GETLastheardExport(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+	streamExport(c, "dmrhub-calls", 0, func(fn func([]models.Call) error) error {
+		return models.EachCall(db.WithContext(c.Request.Context()), filter, fn)
+	})
+}
+
+// GETLastheardUserExport streams the calls made by or privately to a user as a file.
+func GETLastheardUserExport(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	userID64, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid User ID"})
+		return
+	}
+	userID := uint(userID64)
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+	streamExport(c, fmt.Sprintf("dmrhub-calls-%d", userID), userID, func(fn func([]models.Call) error) error {
+		return models.EachUserCall(db.WithContext(c.Request.Context()), userID, filter, fn)
+	})
+}
+
+// streamExport writes each batch of calls out as soon as it is loaded, so the
+// export is never held in memory. Errors after the first write can only be
+// logged, as the response has already begun.
+func streamExport(c *gin.Context, name string, self uint, each func(func([]models.Call) error) error) {
+	encoder, err := export.NewEncoder(c.DefaultQuery("format", export.FormatCSV), c.Writer, self)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, expected csv or adif"})
+		return
+	}
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format("20060102"), encoder.Extension())
+	c.Header("Content-Type", encoder.ContentType())
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	err = encoder.WriteHeader()
+	if err == nil {
+		err = each(func(calls []models.Call) error {
+			for _, call := range calls {
+				if err := encoder.WriteCall(call); err != nil {
+					return err
+				}
+			}
+			if err := encoder.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		})
+	}
+	if err == nil {
+		err = encoder.Flush()
+	}
+	if err != nil {
+		logging.Errorf("Error exporting calls: %v", err)
+		c.Abort()
+	}
+}
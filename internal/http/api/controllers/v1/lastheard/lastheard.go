@@ -22,6 +22,7 @@ package lastheard
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/USA-RedDragon/DMRHub/internal/db/models"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
@@ -43,14 +44,18 @@ func GETLastheard(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
 	session := sessions.Default(c)
 	userID := session.Get("user_id")
 	var calls []models.Call
-	var count int
+	var count func() int
 	if userID == nil {
 		// This is okay, we just query the latest public calls
-		calls = models.FindCalls(db)
-		count = models.CountCalls(cDb)
+		calls = models.FindCalls(db, filter)
+		count = func() int { return models.CountCalls(cDb, filter) }
 	} else {
 		// Get the last calls for the user
 		uid, ok := userID.(uint)
@@ -59,13 +64,13 @@ func GETLastheard(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 			return
 		}
-		calls = models.FindUserCalls(db, uid)
-		count = models.CountUserCalls(cDb, uid)
+		calls = models.FindUserCalls(db, uid, filter)
+		count = func() int { return models.CountUserCalls(cDb, uid, filter) }
 	}
 	if len(calls) == 0 {
 		c.JSON(http.StatusOK, make([]string, 0))
 	} else {
-		c.JSON(http.StatusOK, gin.H{"calls": calls, "total": count})
+		respond(c, filter, calls, count)
 	}
 }
 
@@ -89,9 +94,12 @@ func GETLastheardUser(c *gin.Context) {
 		return
 	}
 	userID := uint(userID64)
-	calls := models.FindUserCalls(db, userID)
-	count := models.CountUserCalls(cDb, userID)
-	c.JSON(http.StatusOK, gin.H{"calls": calls, "total": count})
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+	calls := models.FindUserCalls(db, userID, filter)
+	respond(c, filter, calls, func() int { return models.CountUserCalls(cDb, userID, filter) })
 }
 
 func GETLastheardRepeater(c *gin.Context) {
@@ -114,9 +122,12 @@ func GETLastheardRepeater(c *gin.Context) {
 		return
 	}
 	repeaterID := uint(repeaterID64)
-	calls := models.FindRepeaterCalls(db, repeaterID)
-	count := models.CountRepeaterCalls(cDb, repeaterID)
-	c.JSON(http.StatusOK, gin.H{"calls": calls, "total": count})
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+	calls := models.FindRepeaterCalls(db, repeaterID, filter)
+	respond(c, filter, calls, func() int { return models.CountRepeaterCalls(cDb, repeaterID, filter) })
 }
 
 func GETLastheardTalkgroup(c *gin.Context) {
@@ -126,6 +137,12 @@ func GETLastheardTalkgroup(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
+	cDb, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
 	id := c.Param("id")
 	talkgroupID64, err := strconv.ParseUint(id, 10, 32)
 	if err != nil {
@@ -133,7 +150,138 @@ func GETLastheardTalkgroup(c *gin.Context) {
 		return
 	}
 	talkgroupID := uint(talkgroupID64)
-	calls := models.FindTalkgroupCalls(db, talkgroupID)
-	count := models.CountTalkgroupCalls(db, talkgroupID)
-	c.JSON(http.StatusOK, gin.H{"calls": calls, "total": count})
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+	calls := models.FindTalkgroupCalls(db, talkgroupID, filter)
+	respond(c, filter, calls, func() int { return models.CountTalkgroupCalls(cDb, talkgroupID, filter) })
+}
+
+// respond writes a page of calls. When the page is full, next_cursor can be
+// passed back as the cursor query parameter to fetch the page after it.
+// Counting every matching call is as slow as the offset pages the cursor
+// avoids, so the total is only included on pages fetched without a cursor.
+func respond(c *gin.Context, filter models.CallFilter, calls []models.Call, count func() int) {
+	response := gin.H{"calls": calls}
+	if filter.After == nil {
+		response["total"] = count()
+	}
+	if len(calls) > 0 && len(calls) >= c.GetInt("PaginationLimit") {
+		response["next_cursor"] = filter.Cursor(calls[len(calls)-1]).Encode()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// parseFilter reads the call filters from the query string:
+//   - since, until: RFC 3339 times bounding the start of the call
+//   - user_id, callsign: the source of the call
+//   - talkgroup_id, repeater_id: the destination talkgroup and source repeater
+//   - type: group or private
+//   - min_duration: a duration such as 5s
+//   - min_loss, max_loss, min_ber, max_ber: fractions between 0 and 1
+//   - sort: start_time, duration, loss or ber, with order asc or desc
+//   - cursor: the next_cursor of the previous page, instead of page
+func parseFilter(c *gin.Context) (models.CallFilter, bool) {
+	filter := models.CallFilter{
+		Callsign: c.Query("callsign"),
+		Sort:     models.CallSort(c.DefaultQuery("sort", string(models.CallSortStartTime))),
+	}
+	switch filter.Sort {
+	case models.CallSortStartTime, models.CallSortDuration, models.CallSortLoss, models.CallSortBER:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort, expected one of start_time, duration, loss or ber"})
+		return filter, false
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		filter.Ascending = true
+	case "desc":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order, expected asc or desc"})
+		return filter, false
+	}
+	switch c.Query("type") {
+	case "":
+	case "group":
+		groupCall := true
+		filter.GroupCall = &groupCall
+	case "private":
+		groupCall := false
+		filter.GroupCall = &groupCall
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type, expected group or private"})
+		return filter, false
+	}
+	for _, param := range []struct {
+		name string
+		dest *uint
+	}{{"user_id", &filter.UserID}, {"talkgroup_id", &filter.TalkgroupID}, {"repeater_id", &filter.RepeaterID}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name})
+			return filter, false
+		}
+		*param.dest = uint(id)
+	}
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name + ", expected an RFC 3339 time"})
+			return filter, false
+		}
+		*param.dest = parsed
+	}
+	if value := c.Query("min_duration"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_duration, expected a duration such as 5s"})
+			return filter, false
+		}
+		filter.MinDuration = duration
+	}
+	for _, param := range []struct {
+		name string
+		dest **float32
+	}{{"min_loss", &filter.MinLoss}, {"max_loss", &filter.MaxLoss}, {"min_ber", &filter.MinBER}, {"max_ber", &filter.MaxBER}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 32)
+		if err != nil || parsed < 0 || parsed > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name + ", expected a fraction between 0 and 1"})
+			return filter, false
+		}
+		threshold := float32(parsed)
+		*param.dest = &threshold
+	}
+	if value := c.Query("cursor"); value != "" {
+		if _, exists := c.GetQuery("page"); exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cursor and page cannot be used together"})
+			return filter, false
+		}
+		cursor, err := models.DecodeCallCursor(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return filter, false
+		}
+		filter.After = &cursor
+		if err := filter.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cursor does not match the sort order"})
+			return filter, false
+		}
+	}
+	return filter, true
 }
@@ -20,10 +20,197 @@
 package lastheard_test
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/lastheard"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNoop(t *testing.T) {
 	t.Parallel()
 	t.Log("Noop")
 }
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func makeLastheardRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	db := testutils.OpenDB(t)
+	require.NoError(t, db.Create(&[]models.User{
+		{ID: 3191868, Callsign: "KI5VMF", Username: "jacob"},
+		{ID: 3140598, Callsign: "KP4DJT", Username: "dan"},
+	}).Error)
+	require.NoError(t, db.Create(&[]models.Talkgroup{{ID: 3100, Name: "USA"}, {ID: 91, Name: "Worldwide"}}).Error)
+	var repeater models.Repeater
+	repeater.Callsign = "KI5VMF"
+	repeater.ID = 311860
+	require.NoError(t, db.Create(&repeater).Error)
+
+	tg := func(id uint) *uint { return &id }
+	calls := []models.Call{
+		{ID: 1, UserID: 3191868, RepeaterID: 311860, StartTime: start, Duration: 5 * time.Second, IsToTalkgroup: true, ToTalkgroupID: tg(3100), DestinationID: 3100, GroupCall: true},
+		{ID: 2, UserID: 3140598, RepeaterID: 311860, StartTime: start.Add(time.Minute), Duration: 30 * time.Second, IsToTalkgroup: true, ToTalkgroupID: tg(3100), GroupCall: true, Loss: 0.2, BER: 0.05},
+		{ID: 3, UserID: 3191868, RepeaterID: 311860, StartTime: start.Add(2 * time.Minute), Duration: 30 * time.Second, IsToTalkgroup: true, ToTalkgroupID: tg(3100), GroupCall: true, Loss: 0.01},
+		{ID: 4, UserID: 3191868, RepeaterID: 311860, StartTime: start.Add(3 * time.Minute), Duration: 10 * time.Second, IsToTalkgroup: true, ToTalkgroupID: tg(91), GroupCall: true},
+		{ID: 5, UserID: 3140598, RepeaterID: 311860, StartTime: start.Add(4 * time.Minute), Duration: 20 * time.Second, IsToUser: true, ToUserID: tg(3191868)},
+		{ID: 6, UserID: 3191868, RepeaterID: 311860, StartTime: start.Add(5 * time.Minute), Duration: 30 * time.Second, IsToTalkgroup: true, ToTalkgroupID: tg(3100), GroupCall: true},
+	}
+	require.NoError(t, db.Create(&calls).Error)
+
+	router := testutils.NewRouter(db)
+	router.GET("/lastheard/export", lastheard.GETLastheardExport)
+	router.GET("/lastheard/user/:id", lastheard.GETLastheardUser)
+	router.GET("/lastheard/user/:id/export", lastheard.GETLastheardUserExport)
+	router.GET("/lastheard/repeater/:id", lastheard.GETLastheardRepeater)
+	router.GET("/lastheard/talkgroup/:id", lastheard.GETLastheardTalkgroup)
+	return router
+}
+
+type callsResponse struct {
+	Calls      []models.Call `json:"calls"`
+	Total      *int          `json:"total"`
+	NextCursor string        `json:"next_cursor"`
+}
+
+func get(t *testing.T, router *gin.Engine, path string, query url.Values) (int, callsResponse) {
+	t.Helper()
+	var resp callsResponse
+	code := testutils.RequestJSON(t, router, http.MethodGet, path+"?"+query.Encode(), nil, &resp)
+	return code, resp
+}
+
+func ids(calls []models.Call) []uint {
+	ids := make([]uint, 0, len(calls))
+	for _, call := range calls {
+		ids = append(ids, call.ID)
+	}
+	return ids
+}
+
+func TestLastheardFilters(t *testing.T) {
+	t.Parallel()
+	router := makeLastheardRouter(t)
+
+	tests := []struct {
+		path  string
+		query url.Values
+		want  []uint
+	}{
+		{"/lastheard/repeater/311860", url.Values{}, []uint{6, 5, 4, 3, 2, 1}},
+		{"/lastheard/repeater/311860", url.Values{"callsign": {"kp4djt"}}, []uint{5, 2}},
+		{"/lastheard/repeater/311860", url.Values{"user_id": {"3191868"}, "talkgroup_id": {"3100"}}, []uint{6, 3, 1}},
+		{"/lastheard/repeater/311860", url.Values{"type": {"private"}}, []uint{5}},
+		{"/lastheard/repeater/311860", url.Values{"since": {start.Add(time.Minute).Format(time.RFC3339)}, "until": {start.Add(4 * time.Minute).Format(time.RFC3339)}}, []uint{4, 3, 2}},
+		{"/lastheard/repeater/311860", url.Values{"min_duration": {"25s"}}, []uint{6, 3, 2}},
+		{"/lastheard/repeater/311860", url.Values{"min_loss": {"0.1"}}, []uint{2}},
+		{"/lastheard/repeater/311860", url.Values{"max_loss": {"0.1"}, "max_ber": {"0"}}, []uint{6, 5, 4, 3, 1}},
+		{"/lastheard/user/3191868", url.Values{"type": {"private"}}, []uint{5}},
+		{"/lastheard/talkgroup/3100", url.Values{"sort": {"duration"}, "order": {"asc"}}, []uint{1, 2, 3, 6}},
+		{"/lastheard/talkgroup/3100", url.Values{"sort": {"loss"}}, []uint{2, 3, 6, 1}},
+	}
+	for _, test := range tests {
+		code, resp := get(t, router, test.path, test.query)
+		require.Equal(t, http.StatusOK, code, "%s?%s", test.path, test.query.Encode())
+		assert.Equal(t, test.want, ids(resp.Calls), "%s?%s", test.path, test.query.Encode())
+		if assert.NotNil(t, resp.Total, "%s?%s", test.path, test.query.Encode()) {
+			assert.Equal(t, len(test.want), *resp.Total, "%s?%s", test.path, test.query.Encode())
+		}
+	}
+}
+
+func TestLastheardKeysetPagination(t *testing.T) {
+	t.Parallel()
+	router := makeLastheardRouter(t)
+
+	for _, query := range []url.Values{
+		{"limit": {"2"}},
+		{"limit": {"2"}, "sort": {"duration"}},
+		{"limit": {"2"}, "sort": {"duration"}, "order": {"asc"}},
+	} {
+		_, all := get(t, router, "/lastheard/repeater/311860", url.Values{"limit": {"none"}, "sort": query["sort"], "order": query["order"]})
+		require.Len(t, all.Calls, 6)
+
+		var seen []uint
+		pages := 0
+		for {
+			code, resp := get(t, router, "/lastheard/repeater/311860", query)
+			require.Equal(t, http.StatusOK, code)
+			if query.Has("cursor") {
+				// Only the first page is counted
+				assert.Nil(t, resp.Total)
+			} else if assert.NotNil(t, resp.Total) {
+				assert.Equal(t, 6, *resp.Total)
+			}
+			seen = append(seen, ids(resp.Calls)...)
+			pages++
+			if resp.NextCursor == "" {
+				break
+			}
+			query.Set("cursor", resp.NextCursor)
+		}
+		// The last full page can't know that nothing follows it
+		assert.Equal(t, 4, pages, query.Encode())
+		assert.Equal(t, ids(all.Calls), seen, query.Encode())
+	}
+}
+
+func TestLastheardInvalidFilters(t *testing.T) {
+	t.Parallel()
+	router := makeLastheardRouter(t)
+
+	_, resp := get(t, router, "/lastheard/repeater/311860", url.Values{"limit": {"2"}, "sort": {"duration"}})
+	require.NotEmpty(t, resp.NextCursor)
+
+	for _, query := range []url.Values{
+		{"sort": {"callsign"}},
+		{"order": {"up"}},
+		{"type": {"broadcast"}},
+		{"user_id": {"me"}},
+		{"since": {"yesterday"}},
+		{"min_duration": {"long"}},
+		{"max_loss": {"2"}},
+		{"cursor": {"not-a-cursor"}},
+		{"cursor": {resp.NextCursor}},
+		{"cursor": {resp.NextCursor}, "sort": {"duration"}, "page": {"2"}},
+	} {
+		code, _ := get(t, router, "/lastheard/repeater/311860", query)
+		assert.Equal(t, http.StatusBadRequest, code, query.Encode())
+	}
+}
+
+func TestLastheardExport(t *testing.T) {
+	t.Parallel()
+	router := makeLastheardRouter(t)
+
+	export := func(path string) *httptest.ResponseRecorder {
+		return testutils.Request(t, router, http.MethodGet, path, nil)
+	}
+
+	w := export("/lastheard/export")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".csv")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 7)
+	assert.True(t, strings.HasPrefix(lines[1], "1,2024-05-01T12:00:00Z,5.000,3191868,KI5VMF,talkgroup,3100,USA,"), lines[1])
+
+	w = export("/lastheard/user/3140598/export?format=adif&since=" + url.QueryEscape(start.Add(2*time.Minute).Format(time.RFC3339)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "dmrhub-calls-3140598-")
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "<EOR>"))
+	// The other party of the private call
+	assert.Contains(t, w.Body.String(), "<CALL:6>KI5VMF ")
+
+	assert.Equal(t, http.StatusBadRequest, export("/lastheard/export?format=xlsx").Code)
+	assert.Equal(t, http.StatusBadRequest, export("/lastheard/user/me/export").Code)
+	assert.Equal(t, http.StatusBadRequest, export("/lastheard/export?since=yesterday").Code)
+}
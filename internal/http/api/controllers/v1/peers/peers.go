@@ -23,16 +23,19 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/USA-RedDragon/DMRHub/internal/audit"
 	"github.com/USA-RedDragon/DMRHub/internal/config"
 	"github.com/USA-RedDragon/DMRHub/internal/db/models"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/servers/openbridge"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/utils"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
 	"github.com/USA-RedDragon/DMRHub/internal/smtp"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
@@ -135,11 +138,24 @@ func DELETEPeer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid peer ID"})
 		return
 	}
+	if !models.PeerIDExists(db, uint(idUint64)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Peer does not exist"})
+		return
+	}
+	peer := models.FindPeerByID(db, uint(idUint64))
 	models.DeletePeer(db, uint(idUint64))
 	if db.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": db.Error.Error()})
 		return
 	}
+	// Stop broadcasting the status of the deleted peer
+	store, ok := c.MustGet("KV").(kv.KV)
+	if !ok {
+		logging.Errorf("Unable to get KV from context")
+	} else if _, err := store.HDel(c.Request.Context(), openbridge.StatusKey, strconv.FormatUint(idUint64, 10)); err != nil {
+		logging.Errorf("Error removing status of peer %d: %v", peer.ID, err)
+	}
+	audit.Record(c, db, audit.ActionPeerDelete, audit.TargetPeer, peer.ID, webhooks.PeerFromModel(peer), nil)
 	c.JSON(http.StatusOK, gin.H{"message": "Peer deleted"})
 }
 
@@ -161,9 +177,9 @@ func POSTPeer(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
-	redis, ok := c.MustGet("Redis").(*redis.Client)
+	ps, ok := c.MustGet("PubSub").(pubsub.PubSub)
 	if !ok {
-		logging.Error("Redis cast failed")
+		logging.Error("PubSub cast failed")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
@@ -221,8 +237,10 @@ func POSTPeer(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": db.Error.Error()})
 			return
 		}
+		audit.Record(c, db, audit.ActionPeerCreate, audit.TargetPeer, peer.ID, nil, webhooks.PeerFromModel(peer))
 		c.JSON(http.StatusOK, gin.H{"message": "Peer created", "password": peer.Password})
-		go openbridge.GetSubscriptionManager().Subscribe(c.Request.Context(), redis, peer)
+		go openbridge.GetSubscriptionManager().Subscribe(c.Request.Context(), ps, peer)
+		go webhooks.Emit(db, webhooks.EventPeerCreated, webhooks.PeerFromModel(peer))
 
 		if config.GetConfig().EnableEmail {
 			err = smtp.Send(
@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package repeaters
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/alerts"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxOfflineAfterMinutes = 24 * 60
+
+// GETRepeaterAlerts lists the logged in user's alert subscriptions for a repeater.
+func GETRepeaterAlerts(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	repeaterID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repeater ID"})
+		return
+	}
+	userID, ok := sessions.Default(c).Get("user_id").(uint)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+		return
+	}
+
+	subscriptions, err := models.ListUserRepeaterAlerts(db, userID, uint(repeaterID))
+	if err != nil {
+		logging.Errorf("Error listing repeater alerts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing alerts"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"alerts": subscriptions})
+}
+
+// POSTRepeaterAlert subscribes the logged in user to offline and online alerts for a repeater.
+func POSTRepeaterAlert(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	repeaterID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repeater ID"})
+		return
+	}
+	userID, ok := sessions.Default(c).Get("user_id").(uint)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+		return
+	}
+	var json apimodels.RepeaterAlertPost
+	err = c.ShouldBindJSON(&json)
+	if err != nil {
+		logging.Errorf("POSTRepeaterAlert: JSON data is invalid: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+
+	alert := models.RepeaterAlert{
+		RepeaterID:          uint(repeaterID),
+		UserID:              userID,
+		OfflineAfterMinutes: json.OfflineAfterMinutes,
+		Email:               json.Email,
+		WebhookURL:          json.WebhookURL,
+		QuietHoursStart:     json.QuietHoursStart,
+		QuietHoursEnd:       json.QuietHoursEnd,
+		Timezone:            json.Timezone,
+	}
+	if alert.OfflineAfterMinutes == 0 {
+		alert.OfflineAfterMinutes = uint(alerts.DefaultOfflineAfter.Minutes())
+	}
+	if alert.OfflineAfterMinutes > maxOfflineAfterMinutes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Offline after must be at most 1440 minutes"})
+		return
+	}
+	if alert.Timezone == "" {
+		alert.Timezone = "UTC"
+	}
+	if !alert.Email && alert.WebhookURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Choose email, a webhook or both"})
+		return
+	}
+	if alert.WebhookURL != "" {
+		err = webhooks.ValidatePublicURL(alert.WebhookURL)
+		switch {
+		case errors.Is(err, webhooks.ErrPrivateDestination):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook URL must not point at a private address"})
+			return
+		case err != nil:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook URL must be an http or https URL"})
+			return
+		}
+		alert.WebhookSecret, err = webhooks.GenerateSecret()
+		if err != nil {
+			logging.Errorf("Error generating webhook secret: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating alert"})
+			return
+		}
+	}
+	err = alerts.ValidateSchedule(alert)
+	switch {
+	case errors.Is(err, alerts.ErrInvalidQuietHours):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quiet hours must have a start and end in HH:MM"})
+		return
+	case errors.Is(err, alerts.ErrInvalidTimezone):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Timezone is not valid"})
+		return
+	}
+
+	user, err := models.FindUserByID(db, userID)
+	if err != nil {
+		logging.Errorf("Error finding user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding user"})
+		return
+	}
+	if alert.Email && !user.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verify your email address before enabling email alerts"})
+		return
+	}
+
+	repeater, err := models.FindRepeaterByID(db, uint(repeaterID))
+	if err != nil {
+		logging.Errorf("Error getting repeater: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Repeater does not exist"})
+		return
+	}
+	// Start from the repeater's current state so subscribing doesn't send an alert
+	alert.Offline = alerts.IsOffline(&alert, repeater.LastPing, time.Now())
+	alert.NotifiedOffline = alert.Offline
+
+	err = db.Create(&alert).Error
+	if err != nil {
+		logging.Errorf("Error creating repeater alert: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating alert"})
+		return
+	}
+	response := gin.H{"message": "Alert created", "alert": alert}
+	if alert.WebhookSecret != "" {
+		// The secret is only ever shown here
+		response["webhook_secret"] = alert.WebhookSecret
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// DELETERepeaterAlert removes one of the logged in user's alert subscriptions.
+func DELETERepeaterAlert(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	repeaterID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repeater ID"})
+		return
+	}
+	alertID, err := strconv.ParseUint(c.Param("alertID"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert ID"})
+		return
+	}
+	userID, ok := sessions.Default(c).Get("user_id").(uint)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+		return
+	}
+
+	deleted, err := models.DeleteUserRepeaterAlert(db, userID, uint(repeaterID), uint(alertID))
+	if err != nil {
+		logging.Errorf("Error deleting repeater alert: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting alert"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert does not exist"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alert deleted"})
+}
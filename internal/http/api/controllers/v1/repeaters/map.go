@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package repeaters
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/audit"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// mapOnlineWindow is how recently a repeater must have pinged to be shown
+// online, matching how long the network keeps a repeater's login around.
+const mapOnlineWindow = 5 * time.Minute
+
+const (
+	mapCacheKey = "repeaters:map"
+	// mapCacheTTL is how long the public map is cached, short enough for
+	// repeaters coming online to show up soon after
+	mapCacheTTL = 30 * time.Second
+)
+
+// GETRepeaterMap returns the repeaters on the network as GeoJSON, respecting
+// each owner's map privacy setting. The map is public and loads every
+// repeater, so the response is cached for a short time.
+func GETRepeaterMap(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	store, ok := c.MustGet("KV").(kv.KV)
+	if !ok {
+		logging.Errorf("Unable to get KV from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	cached, err := store.Get(ctx, mapCacheKey)
+	if err == nil {
+		c.Data(http.StatusOK, "application/geo+json", cached)
+		return
+	} else if !errors.Is(err, kv.ErrNotFound) {
+		logging.Errorf("Error reading cached repeater map: %v", err)
+	}
+
+	repeaters, err := models.ListRepeaters(db)
+	if err != nil {
+		logging.Errorf("Error listing repeaters: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing repeaters"})
+		return
+	}
+	body, err := json.Marshal(apimodels.NewRepeaterMap(repeaters, time.Now(), mapOnlineWindow))
+	if err != nil {
+		logging.Errorf("Error marshalling repeater map: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing repeaters"})
+		return
+	}
+	err = store.Set(ctx, mapCacheKey, body, mapCacheTTL)
+	if err != nil {
+		logging.Errorf("Error caching repeater map: %v", err)
+	}
+	c.Data(http.StatusOK, "application/geo+json", body)
+}
+
+// POSTRepeaterMapPrivacy sets whether a repeater is shown on the public map
+// at its position, at an approximate position or not at all.
+func POSTRepeaterMapPrivacy(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	repeaterID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Repeater ID"})
+		return
+	}
+	var json apimodels.RepeaterMapPrivacyPost
+	err = c.ShouldBindJSON(&json)
+	if err != nil {
+		logging.Errorf("POSTRepeaterMapPrivacy: JSON data is invalid: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+	if !json.MapPrivacy.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Map privacy must be empty for the default, exact, approximate or hidden"})
+		return
+	}
+	repeater, err := models.FindRepeaterByID(db, uint(repeaterID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Repeater does not exist"})
+		return
+	}
+	err = db.Model(&models.Repeater{}).Where("id = ?", repeater.ID).Update("map_privacy", json.MapPrivacy).Error
+	if err != nil {
+		logging.Errorf("POSTRepeaterMapPrivacy: Error updating repeater: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating repeater"})
+		return
+	}
+	// Don't keep showing a repeater its owner just hid
+	store, ok := c.MustGet("KV").(kv.KV)
+	if !ok {
+		logging.Errorf("Unable to get KV from context")
+	} else if _, err := store.Delete(c.Request.Context(), mapCacheKey); err != nil {
+		logging.Errorf("POSTRepeaterMapPrivacy: Error clearing cached repeater map: %v", err)
+	}
+	audit.Record(c, db, audit.ActionRepeaterMapPrivacy, audit.TargetRepeater, repeater.ID,
+		gin.H{"map_privacy": repeater.MapPrivacy}, gin.H{"map_privacy": json.MapPrivacy})
+	c.JSON(http.StatusOK, gin.H{"message": "Map privacy updated"})
+}
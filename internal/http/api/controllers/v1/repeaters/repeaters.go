@@ -26,16 +26,20 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/USA-RedDragon/DMRHub/internal/audit"
 	"github.com/USA-RedDragon/DMRHub/internal/db/models"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/dmrconst"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/servers/hbrp"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/utils"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/mqtt"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
 	"github.com/USA-RedDragon/DMRHub/internal/repeaterdb"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
@@ -169,12 +173,36 @@ func DELETERepeater(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repeater ID"})
 		return
 	}
+	exists, err := models.RepeaterIDExists(db, uint(idUint64))
+	if err != nil {
+		logging.Errorf("Error checking if repeater exists: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking if repeater exists"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Repeater does not exist"})
+		return
+	}
+	repeater, err := models.FindRepeaterByID(db, uint(idUint64))
+	if err != nil {
+		logging.Errorf("Error finding repeater: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding repeater"})
+		return
+	}
 	err = models.DeleteRepeater(db, uint(idUint64))
 	if err != nil {
 		logging.Errorf("Error deleting repeater: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting repeater"})
 		return
 	}
+	// Stop showing the deleted repeater to websocket clients
+	store, ok := c.MustGet("KV").(kv.KV)
+	if !ok {
+		logging.Errorf("Unable to get KV from context")
+	} else if _, err := store.HDel(c.Request.Context(), hbrp.RepeaterStateKey, strconv.FormatUint(idUint64, 10)); err != nil {
+		logging.Errorf("Error removing state of repeater %d: %v", repeater.ID, err)
+	}
+	audit.Record(c, db, audit.ActionRepeaterDelete, audit.TargetRepeater, repeater.ID, webhooks.RepeaterFromModel(repeater), nil)
 	c.JSON(http.StatusOK, gin.H{"message": "Repeater deleted"})
 }
 
@@ -185,9 +213,9 @@ func POSTRepeaterTalkgroups(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
-	redis, ok := c.MustGet("Redis").(*redis.Client)
+	ps, ok := c.MustGet("PubSub").(pubsub.PubSub)
 	if !ok {
-		logging.Errorf("Unable to get DB from context")
+		logging.Errorf("Unable to get PubSub from context")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
@@ -225,6 +253,7 @@ func POSTRepeaterTalkgroups(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting repeater"})
 		return
 	}
+	before := mqtt.TalkgroupsFromModel(repeater)
 
 	err = db.Model(&repeater).Association("TS1StaticTalkgroups").Replace(json.TS1StaticTalkgroups)
 	if err != nil {
@@ -286,7 +315,8 @@ func POSTRepeaterTalkgroups(c *gin.Context) {
 		return
 	}
 	hbrp.GetSubscriptionManager(db).CancelAllRepeaterSubscriptions(repeater.ID)
-	go hbrp.GetSubscriptionManager(db).ListenForCalls(redis, repeater.ID)
+	go hbrp.GetSubscriptionManager(db).ListenForCalls(ps, repeater.ID)
+	linksChanged(c, db, audit.ActionRepeaterTalkgroups, before)
 	c.JSON(http.StatusOK, gin.H{"message": "Repeater talkgroups updated"})
 }
 
@@ -308,9 +338,9 @@ func POSTRepeater(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
-	redis, ok := c.MustGet("Redis").(*redis.Client)
+	ps, ok := c.MustGet("PubSub").(pubsub.PubSub)
 	if !ok {
-		logging.Error("Redis cast failed")
+		logging.Error("PubSub cast failed")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
@@ -419,7 +449,7 @@ func POSTRepeater(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating repeater"})
 			return
 		}
-		go hbrp.GetSubscriptionManager(db).ListenForCalls(redis, repeater.ID)
+		go hbrp.GetSubscriptionManager(db).ListenForCalls(ps, repeater.ID)
 		c.JSON(http.StatusOK, gin.H{"message": "Repeater created", "password": repeater.Password})
 	}
 }
@@ -431,9 +461,9 @@ func POSTRepeaterLink(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
-	redis, ok := c.MustGet("Redis").(*redis.Client)
+	ps, ok := c.MustGet("PubSub").(pubsub.PubSub)
 	if !ok {
-		logging.Error("Redis cast failed")
+		logging.Error("PubSub cast failed")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
@@ -453,6 +483,7 @@ func POSTRepeaterLink(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding repeater"})
 		return
 	}
+	before := mqtt.TalkgroupsFromModel(repeater)
 	// LinkType should be either "dynamic" or "static"
 	if linkType != LinkTypeDynamic && linkType != LinkTypeStatic {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link type"})
@@ -520,13 +551,14 @@ func POSTRepeaterLink(c *gin.Context) {
 			}
 		}
 	}
-	go hbrp.GetSubscriptionManager(db).ListenForCallsOn(redis, repeater.ID, talkgroup.ID)
+	go hbrp.GetSubscriptionManager(db).ListenForCallsOn(ps, repeater.ID, talkgroup.ID)
 	err = db.Save(&repeater).Error
 	if err != nil {
 		logging.Errorf("Error saving repeater: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving repeater"})
 		return
 	}
+	linksChanged(c, db, audit.ActionRepeaterLink, before)
 }
 
 //nolint:golint,gocyclo
@@ -604,6 +636,7 @@ func POSTRepeaterUnlink(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding repeater"})
 		return
 	}
+	before := mqtt.TalkgroupsFromModel(repeater)
 
 	switch linkType {
 	case LinkTypeDynamic:
@@ -706,5 +739,19 @@ func POSTRepeaterUnlink(c *gin.Context) {
 			}
 		}
 	}
+	linksChanged(c, db, audit.ActionRepeaterUnlink, before)
 	c.JSON(http.StatusOK, gin.H{"message": "Timeslot unlinked"})
 }
+
+// linksChanged records a change to a repeater's talkgroup links in the audit
+// log and publishes the new links.
+func linksChanged(c *gin.Context, db *gorm.DB, action string, before mqtt.Talkgroups) {
+	repeater, err := models.FindRepeaterByID(db, before.RepeaterID)
+	if err != nil {
+		logging.Errorf("Error finding repeater %d: %v", before.RepeaterID, err)
+		return
+	}
+	after := mqtt.TalkgroupsFromModel(repeater)
+	audit.Record(c, db, action, audit.TargetRepeater, repeater.ID, before, after)
+	mqtt.PublishTalkgroups(repeater)
+}
@@ -20,10 +20,153 @@
 package repeaters_test
 
 import (
+	"encoding/json"
+	"net/http"
 	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/repeaters"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/middleware"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 )
 
 func TestNoop(t *testing.T) {
 	t.Parallel()
 	t.Log("Noop")
 }
+
+func makeMapRouter(t *testing.T) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+	db := testutils.OpenDB(t)
+	require.NoError(t, db.Create(&models.User{ID: 3191868, Callsign: "KI5VMF", Username: "jacob", Approved: true}).Error)
+	require.NoError(t, db.Create(&[]models.Talkgroup{{ID: 3100, Name: "USA"}, {ID: 91, Name: "Worldwide"}}).Error)
+
+	newRepeater := func(id uint, hotspot bool, privacy models.MapPrivacy, latitude, longitude float64) models.Repeater {
+		repeater := models.Repeater{OwnerID: 3191868, Hotspot: hotspot, MapPrivacy: privacy}
+		repeater.ID = id
+		repeater.Callsign = "KI5VMF"
+		repeater.Latitude = latitude
+		repeater.Longitude = longitude
+		repeater.Height = 30
+		repeater.Location = "Dallas, TX"
+		repeater.Description = "On the water tower at Main and 5th"
+		repeater.TXFrequency = 444525000
+		repeater.ColorCode = 1
+		return repeater
+	}
+	dynamic := uint(91)
+	repeater := newRepeater(311860, false, models.MapPrivacyDefault, 32.7767, -96.797)
+	repeater.LastPing = time.Now()
+	repeater.TS2StaticTalkgroups = []models.Talkgroup{{ID: 3100}}
+	repeater.TS1DynamicTalkgroupID = &dynamic
+	for _, repeater := range []models.Repeater{
+		repeater,
+		newRepeater(311861, false, models.MapPrivacyApproximate, 32.7767, -96.797),
+		newRepeater(311862, false, models.MapPrivacyHidden, 32.7767, -96.797),
+		// No position reported
+		newRepeater(311863, false, models.MapPrivacyExact, 0, 0),
+		newRepeater(319186801, true, models.MapPrivacyDefault, 32.7767, -96.797),
+		newRepeater(319186802, true, models.MapPrivacyExact, 32.7767, -96.797),
+	} {
+		require.NoError(t, db.Create(&repeater).Error)
+	}
+
+	router := testutils.NewRouter(db, testutils.LoginAs(3191868), middleware.KVProvider(kv.NewMemory()))
+	router.GET("/repeaters/map", repeaters.GETRepeaterMap)
+	router.POST("/repeaters/:id/map-privacy", repeaters.POSTRepeaterMapPrivacy)
+	return router, db
+}
+
+func getMap(t *testing.T, router *gin.Engine) map[uint]apimodels.RepeaterMapFeature {
+	t.Helper()
+	w := testutils.Request(t, router, http.MethodGet, "/repeaters/map", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/geo+json", w.Header().Get("Content-Type"))
+	var resp apimodels.RepeaterMap
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "FeatureCollection", resp.Type)
+	features := map[uint]apimodels.RepeaterMapFeature{}
+	for _, feature := range resp.Features {
+		features[feature.Properties.ID] = feature
+	}
+	return features
+}
+
+func TestRepeaterMap(t *testing.T) {
+	t.Parallel()
+	router, _ := makeMapRouter(t)
+	features := getMap(t, router)
+
+	require.Len(t, features, 3)
+	assert.NotContains(t, features, uint(311862))
+	assert.NotContains(t, features, uint(311863))
+	assert.NotContains(t, features, uint(319186801))
+
+	exact := features[311860]
+	assert.Equal(t, "Point", exact.Geometry.Type)
+	assert.Equal(t, [2]float64{-96.797, 32.7767}, exact.Geometry.Coordinates)
+	assert.True(t, exact.Properties.Online)
+	assert.False(t, exact.Properties.Approximate)
+	require.NotNil(t, exact.Properties.Height)
+	assert.Equal(t, uint16(30), *exact.Properties.Height)
+	assert.Equal(t, "Dallas, TX", exact.Properties.Location)
+	assert.Equal(t, "On the water tower at Main and 5th", exact.Properties.Description)
+	assert.Equal(t, []apimodels.RepeaterMapTalkgroup{{ID: 3100, Name: "USA"}}, exact.Properties.TS2.StaticTalkgroups)
+	assert.Empty(t, exact.Properties.TS1.StaticTalkgroups)
+	assert.Equal(t, &apimodels.RepeaterMapTalkgroup{ID: 91, Name: "Worldwide"}, exact.Properties.TS1.DynamicTalkgroup)
+	assert.Nil(t, exact.Properties.TS2.DynamicTalkgroup)
+
+	approximate := features[311861]
+	assert.Equal(t, [2]float64{-96.8, 32.8}, approximate.Geometry.Coordinates)
+	assert.True(t, approximate.Properties.Approximate)
+	assert.False(t, approximate.Properties.Online)
+	assert.Nil(t, approximate.Properties.Height)
+	assert.Empty(t, approximate.Properties.Location)
+	assert.Empty(t, approximate.Properties.Description)
+
+	assert.True(t, features[319186802].Properties.Hotspot)
+}
+
+func TestRepeaterMapCache(t *testing.T) {
+	t.Parallel()
+	router, db := makeMapRouter(t)
+
+	assert.Contains(t, getMap(t, router), uint(311860))
+	// Changes made behind the API's back only show up once the cache expires
+	require.NoError(t, db.Model(&models.Repeater{}).Where("id = ?", 311860).Update("map_privacy", models.MapPrivacyHidden).Error)
+	assert.Contains(t, getMap(t, router), uint(311860))
+}
+
+func TestRepeaterMapPrivacy(t *testing.T) {
+	t.Parallel()
+	router, db := makeMapRouter(t)
+
+	post := func(id string, body map[string]string) int {
+		return testutils.Request(t, router, http.MethodPost, "/repeaters/"+id+"/map-privacy", body).Code
+	}
+
+	require.Equal(t, http.StatusOK, post("319186801", map[string]string{"map_privacy": "approximate"}))
+	require.Equal(t, http.StatusOK, post("311860", map[string]string{"map_privacy": "hidden"}))
+	features := getMap(t, router)
+	assert.Contains(t, features, uint(319186801))
+	assert.NotContains(t, features, uint(311860))
+
+	// Back to the default
+	require.Equal(t, http.StatusOK, post("311860", map[string]string{}))
+	assert.Contains(t, getMap(t, router), uint(311860))
+
+	assert.Equal(t, http.StatusBadRequest, post("311860", map[string]string{"map_privacy": "blurry"}))
+	assert.Equal(t, http.StatusBadRequest, post("1", map[string]string{"map_privacy": "exact"}))
+	assert.Equal(t, http.StatusBadRequest, post("abc", map[string]string{"map_privacy": "exact"}))
+
+	var entries int64
+	require.NoError(t, db.Model(&models.AuditLog{}).Where("action = ?", "repeater.map_privacy").Count(&entries).Error)
+	assert.Equal(t, int64(3), entries)
+}
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultWindow = "24h"
+	defaultLimit  = 10
+	maxLimit      = 50
+)
+
+type window struct {
+	length time.Duration
+	// ttl is how long results are cached
+	ttl time.Duration
+}
+
+//nolint:golint,gochecknoglobals
+var windows = map[string]window{
+	"1h":  {length: time.Hour, ttl: 30 * time.Second},
+	"24h": {length: 24 * time.Hour, ttl: time.Minute},
+	"7d":  {length: 7 * 24 * time.Hour, ttl: 5 * time.Minute},
+	"30d": {length: 30 * 24 * time.Hour, ttl: 5 * time.Minute},
+}
+
+type query func(db *gorm.DB, since time.Time, until time.Time, limit int) (any, error)
+
+// GETStatsTalkers returns the users with the most airtime.
+func GETStatsTalkers(c *gin.Context) {
+	serve(c, "talkers", func(db *gorm.DB, since time.Time, _ time.Time, limit int) (any, error) {
+		return models.TopTalkers(db, since, limit)
+	})
+}
+
+// GETStatsTalkgroups returns the talkgroups with the most airtime.
+func GETStatsTalkgroups(c *gin.Context) {
+	serve(c, "talkgroups", func(db *gorm.DB, since time.Time, _ time.Time, limit int) (any, error) {
+		return models.BusiestTalkgroups(db, since, limit)
+	})
+}
+
+// GETStatsRepeaters returns the repeaters that carried the most airtime.
+func GETStatsRepeaters(c *gin.Context) {
+	serve(c, "repeaters", func(db *gorm.DB, since time.Time, _ time.Time, limit int) (any, error) {
+		return models.BusiestRepeaters(db, since, limit)
+	})
+}
+
+// GETStatsCallsPerHour returns the number of calls started in each hour.
+func GETStatsCallsPerHour(c *gin.Context) {
+	serve(c, "hours", func(db *gorm.DB, since time.Time, until time.Time, _ int) (any, error) {
+		return models.CallsPerHour(db, since, until)
+	})
+}
+
+// GETStatsQuality returns the average loss, jitter and BER per repeater.
+func GETStatsQuality(c *gin.Context) {
+	serve(c, "quality", func(db *gorm.DB, since time.Time, _ time.Time, _ int) (any, error) {
+		return models.RepeaterCallQuality(db, since)
+	})
+}
+
+// serve runs a statistics query over the requested window, caching the
+// response for a short time as the queries scan many calls.
+func serve(c *gin.Context, name string, run query) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	store, ok := c.MustGet("KV").(kv.KV)
+	if !ok {
+		logging.Errorf("Unable to get KV from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	windowName := c.DefaultQuery("window", defaultWindow)
+	win, ok := windows[windowName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Window must be one of 1h, 24h, 7d or 30d"})
+		return
+	}
+	limit := defaultLimit
+	if value, exists := c.GetQuery("limit"); exists {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Limit must be between 1 and " + strconv.Itoa(maxLimit)})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	key := "stats:" + name + ":" + windowName + ":" + strconv.Itoa(limit)
+	cached, err := store.Get(ctx, key)
+	if err == nil {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", cached)
+		return
+	} else if !errors.Is(err, kv.ErrNotFound) {
+		logging.Errorf("Error reading cached %s statistics: %v", name, err)
+	}
+
+	until := time.Now()
+	since := until.Add(-win.length)
+	result, err := run(db.WithContext(ctx), since, until, limit)
+	if err != nil {
+		logging.Errorf("Error calculating %s statistics: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error calculating statistics"})
+		return
+	}
+	body, err := json.Marshal(gin.H{"window": windowName, "since": since, "until": until, name: result})
+	if err != nil {
+		logging.Errorf("Error marshalling %s statistics: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error calculating statistics"})
+		return
+	}
+	err = store.Set(ctx, key, body, win.ttl)
+	if err != nil {
+		logging.Errorf("Error caching %s statistics: %v", name, err)
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
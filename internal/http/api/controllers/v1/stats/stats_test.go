@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package stats_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/stats"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/middleware"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func makeStatsRouter(t *testing.T) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+	db := testutils.OpenDB(t)
+	require.NoError(t, db.Create(&[]models.User{
+		{ID: 3191868, Callsign: "KI5VMF", Username: "jacob"},
+		{ID: 3140598, Callsign: "KP4DJT", Username: "dan"},
+	}).Error)
+	require.NoError(t, db.Create(&[]models.Talkgroup{{ID: 3100, Name: "USA"}, {ID: 91, Name: "Worldwide"}}).Error)
+	var repeater models.Repeater
+	repeater.Callsign = "KI5VMF"
+	repeater.ID = 311860
+	require.NoError(t, db.Create(&repeater).Error)
+
+	now := time.Now()
+	tg := func(id uint) *uint { return &id }
+	calls := []models.Call{
+		{UserID: 3191868, RepeaterID: 311860, StartTime: now.Add(-10 * time.Minute), Duration: 30 * time.Second, IsToTalkgroup: true, ToTalkgroupID: tg(3100), GroupCall: true, Loss: 0.1, BER: 0.02},
+		{UserID: 3191868, RepeaterID: 311860, StartTime: now.Add(-20 * time.Minute), Duration: 30 * time.Second, IsToTalkgroup: true, ToTalkgroupID: tg(3100), GroupCall: true, Loss: 0.3, BER: 0.04},
+		{UserID: 3140598, RepeaterID: 311860, StartTime: now.Add(-2 * time.Hour), Duration: 90 * time.Second, IsToTalkgroup: true, ToTalkgroupID: tg(91), GroupCall: true},
+		// Outside of every window
+		{UserID: 3140598, RepeaterID: 311860, StartTime: now.Add(-40 * 24 * time.Hour), Duration: time.Hour, IsToTalkgroup: true, ToTalkgroupID: tg(91), GroupCall: true},
+	}
+	require.NoError(t, db.Create(&calls).Error)
+
+	// Nothing listens here, so every response is calculated
+	redisClient := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { _ = redisClient.Close() })
+
+	router := testutils.NewRouter(db, middleware.KVProvider(kv.NewRedis(redisClient)))
+	router.GET("/stats/talkers", stats.GETStatsTalkers)
+	router.GET("/stats/talkgroups", stats.GETStatsTalkgroups)
+	router.GET("/stats/repeaters", stats.GETStatsRepeaters)
+	router.GET("/stats/calls-per-hour", stats.GETStatsCallsPerHour)
+	router.GET("/stats/quality", stats.GETStatsQuality)
+	return router, db
+}
+
+type activityResponse struct {
+	Window     string                `json:"window"`
+	Talkers    []models.CallActivity `json:"talkers"`
+	Talkgroups []models.CallActivity `json:"talkgroups"`
+}
+
+func TestStatsActivity(t *testing.T) {
+	t.Parallel()
+	router, _ := makeStatsRouter(t)
+
+	var resp activityResponse
+	require.Equal(t, http.StatusOK, testutils.RequestJSON(t, router, http.MethodGet, "/stats/talkers", nil, &resp))
+	assert.Equal(t, "24h", resp.Window)
+	require.Len(t, resp.Talkers, 2)
+	assert.Equal(t, models.CallActivity{ID: 3140598, Name: "KP4DJT", Calls: 1, Duration: 90 * time.Second}, resp.Talkers[0])
+	assert.Equal(t, models.CallActivity{ID: 3191868, Name: "KI5VMF", Calls: 2, Duration: time.Minute}, resp.Talkers[1])
+
+	resp = activityResponse{}
+	require.Equal(t, http.StatusOK, testutils.RequestJSON(t, router, http.MethodGet, "/stats/talkers?window=1h&limit=1", nil, &resp))
+	require.Len(t, resp.Talkers, 1)
+	assert.Equal(t, uint(3191868), resp.Talkers[0].ID)
+
+	resp = activityResponse{}
+	require.Equal(t, http.StatusOK, testutils.RequestJSON(t, router, http.MethodGet, "/stats/talkgroups?window=30d", nil, &resp))
+	require.Len(t, resp.Talkgroups, 2)
+	assert.Equal(t, "Worldwide", resp.Talkgroups[0].Name)
+	assert.Equal(t, "USA", resp.Talkgroups[1].Name)
+
+	var repeaters struct {
+		Repeaters []models.CallActivity `json:"repeaters"`
+	}
+	require.Equal(t, http.StatusOK, testutils.RequestJSON(t, router, http.MethodGet, "/stats/repeaters?window=7d", nil, &repeaters))
+	require.Len(t, repeaters.Repeaters, 1)
+	assert.Equal(t, 3, repeaters.Repeaters[0].Calls)
+	assert.Equal(t, "KI5VMF", repeaters.Repeaters[0].Name)
+
+	assert.Equal(t, http.StatusBadRequest, testutils.RequestJSON(t, router, http.MethodGet, "/stats/talkers?window=1y", nil, nil))
+	assert.Equal(t, http.StatusBadRequest, testutils.RequestJSON(t, router, http.MethodGet, "/stats/talkers?limit=0", nil, nil))
+	assert.Equal(t, http.StatusBadRequest, testutils.RequestJSON(t, router, http.MethodGet, "/stats/talkers?limit=abc", nil, nil))
+}
+
+func TestStatsCallsPerHour(t *testing.T) {
+	t.Parallel()
+	router, _ := makeStatsRouter(t)
+
+	var resp struct {
+		Hours []models.HourlyCalls `json:"hours"`
+	}
+	require.Equal(t, http.StatusOK, testutils.RequestJSON(t, router, http.MethodGet, "/stats/calls-per-hour", nil, &resp))
+	require.GreaterOrEqual(t, len(resp.Hours), 24)
+	total := 0
+	for i, hour := range resp.Hours {
+		total += hour.Calls
+		if i > 0 {
+			assert.Equal(t, time.Hour, hour.Hour.Sub(resp.Hours[i-1].Hour))
+		}
+	}
+	assert.Equal(t, 3, total)
+
+	// Each call is counted in the hour it started
+	twoHoursAgo := time.Now().Add(-2 * time.Hour).UTC().Truncate(time.Hour)
+	for _, hour := range resp.Hours {
+		if hour.Hour.Equal(twoHoursAgo) {
+			assert.Equal(t, 1, hour.Calls)
+		}
+	}
+}
+
+func TestStatsQuality(t *testing.T) {
+	t.Parallel()
+	router, _ := makeStatsRouter(t)
+
+	var resp struct {
+		Quality []models.RepeaterQuality `json:"quality"`
+	}
+	require.Equal(t, http.StatusOK, testutils.RequestJSON(t, router, http.MethodGet, "/stats/quality?window=1h", nil, &resp))
+	require.Len(t, resp.Quality, 1)
+	assert.Equal(t, "KI5VMF", resp.Quality[0].Callsign)
+	assert.Equal(t, 2, resp.Quality[0].Calls)
+	assert.InDelta(t, 0.2, resp.Quality[0].Loss, 0.0001)
+	assert.InDelta(t, 0.03, resp.Quality[0].BER, 0.0001)
+}
+
+func TestStatsIncludeSummaries(t *testing.T) {
+	t.Parallel()
+	router, db := makeStatsRouter(t)
+
+	// Calls that retention has deleted only remain in the summaries
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	summarized := today.AddDate(0, 0, -3)
+	tooOld := today.AddDate(0, 0, -40)
+	require.NoError(t, db.Create(&[]models.CallSummary{
+		{Day: summarized, SubjectType: models.SummaryUser, SubjectID: 3140598, GroupCall: true, Calls: 4, Duration: 10 * time.Minute},
+		{Day: summarized, SubjectType: models.SummaryUser, SubjectID: 3140598, GroupCall: false, Calls: 1, Duration: time.Minute},
+		{Day: summarized, SubjectType: models.SummaryTalkgroup, SubjectID: 3100, GroupCall: true, Calls: 4, Duration: 10 * time.Minute},
+		{Day: summarized, SubjectType: models.SummaryRepeater, SubjectID: 311860, GroupCall: true, Calls: 5, Duration: 11 * time.Minute, LossSum: 1.0},
+		{Day: tooOld, SubjectType: models.SummaryUser, SubjectID: 3191868, GroupCall: true, Calls: 100, Duration: 10 * time.Hour},
+	}).Error)
+
+	var resp activityResponse
+	require.Equal(t, http.StatusOK, testutils.RequestJSON(t, router, http.MethodGet, "/stats/talkers?window=7d", nil, &resp))
+	require.Len(t, resp.Talkers, 2)
+	assert.Equal(t, models.CallActivity{ID: 3140598, Name: "KP4DJT", Calls: 6, Duration: 12*time.Minute + 30*time.Second}, resp.Talkers[0])
+	assert.Equal(t, models.CallActivity{ID: 3191868, Name: "KI5VMF", Calls: 2, Duration: time.Minute}, resp.Talkers[1])
+
+	resp = activityResponse{}
+	require.Equal(t, http.StatusOK, testutils.RequestJSON(t, router, http.MethodGet, "/stats/talkgroups?window=7d", nil, &resp))
+	require.Len(t, resp.Talkgroups, 2)
+	assert.Equal(t, models.CallActivity{ID: 3100, Name: "USA", Calls: 6, Duration: 11 * time.Minute}, resp.Talkgroups[0])
+
+	// The summarized day isn't within the last day
+	resp = activityResponse{}
+	require.Equal(t, http.StatusOK, testutils.RequestJSON(t, router, http.MethodGet, "/stats/talkers?window=24h", nil, &resp))
+	require.Len(t, resp.Talkers, 2)
+	assert.Equal(t, 1, resp.Talkers[0].Calls)
+
+	var quality struct {
+		Quality []models.RepeaterQuality `json:"quality"`
+	}
+	require.Equal(t, http.StatusOK, testutils.RequestJSON(t, router, http.MethodGet, "/stats/quality?window=7d", nil, &quality))
+	require.Len(t, quality.Quality, 1)
+	assert.Equal(t, 8, quality.Quality[0].Calls)
+	assert.InDelta(t, 1.4/8, quality.Quality[0].Loss, 0.0001)
+}
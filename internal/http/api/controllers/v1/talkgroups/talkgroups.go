@@ -24,6 +24,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/USA-RedDragon/DMRHub/internal/audit"
 	"github.com/USA-RedDragon/DMRHub/internal/db/models"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
@@ -145,12 +146,29 @@ func DELETETalkgroup(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid talkgroup ID"})
 		return
 	}
+	exists, err := models.TalkgroupIDExists(db, uint(idUint64))
+	if err != nil {
+		logging.Errorf("Error checking if talkgroup exists: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking if talkgroup exists"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Talkgroup does not exist"})
+		return
+	}
+	talkgroup, err := models.FindTalkgroupByID(db, uint(idUint64))
+	if err != nil {
+		logging.Errorf("Error finding talkgroup: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding talkgroup"})
+		return
+	}
 	err = models.DeleteTalkgroup(db, uint(idUint64))
 	if err != nil {
 		logging.Errorf("Error deleting talkgroup: %s", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting talkgroup"})
 		return
 	}
+	audit.Record(c, db, audit.ActionTalkgroupDelete, audit.TargetTalkgroup, talkgroup.ID, talkgroupState(talkgroup), nil)
 	c.JSON(http.StatusOK, gin.H{"message": "Talkgroup deleted"})
 }
 
@@ -181,6 +199,7 @@ func POSTTalkgroupNCOs(c *gin.Context) {
 		logging.Errorf("POSTTalkgroupNCOs: JSON data is invalid: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
 	} else {
+		before := userIDs(talkgroup.NCOs)
 		if len(json.UserIDs) == 0 {
 			// remove all NCOs
 			err := db.Model(&talkgroup).Association("NCOs").Clear()
@@ -195,6 +214,7 @@ func POSTTalkgroupNCOs(c *gin.Context) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving talkgroup"})
 				return
 			}
+			audit.Record(c, db, audit.ActionTalkgroupNCOs, audit.TargetTalkgroup, talkgroup.ID, gin.H{"ncos": before}, gin.H{"ncos": []uint{}})
 			c.JSON(http.StatusOK, gin.H{"message": "Talkgroup admins cleared"})
 			return
 		}
@@ -225,6 +245,7 @@ func POSTTalkgroupNCOs(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving talkgroup"})
 			return
 		}
+		audit.Record(c, db, audit.ActionTalkgroupNCOs, audit.TargetTalkgroup, talkgroup.ID, gin.H{"ncos": before}, gin.H{"ncos": json.UserIDs})
 		c.JSON(http.StatusOK, gin.H{"message": "User appointed as net control operator"})
 	}
 }
@@ -256,6 +277,7 @@ func POSTTalkgroupAdmins(c *gin.Context) {
 		logging.Errorf("POSTTalkgroupAdmins: JSON data is invalid: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
 	} else {
+		before := userIDs(talkgroup.Admins)
 		if len(json.UserIDs) == 0 {
 			// remove all Admins
 			err := db.Model(&talkgroup).Association("Admins").Clear()
@@ -270,6 +292,7 @@ func POSTTalkgroupAdmins(c *gin.Context) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving talkgroup"})
 				return
 			}
+			audit.Record(c, db, audit.ActionTalkgroupAdmins, audit.TargetTalkgroup, talkgroup.ID, gin.H{"admins": before}, gin.H{"admins": []uint{}})
 			c.JSON(http.StatusOK, gin.H{"message": "Talkgroup admins cleared"})
 			return
 		}
@@ -300,6 +323,7 @@ func POSTTalkgroupAdmins(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving talkgroup"})
 			return
 		}
+		audit.Record(c, db, audit.ActionTalkgroupAdmins, audit.TargetTalkgroup, talkgroup.ID, gin.H{"admins": before}, gin.H{"admins": json.UserIDs})
 		c.JSON(http.StatusOK, gin.H{"message": "User appointed as admin"})
 	}
 }
@@ -329,6 +353,7 @@ func PATCHTalkgroup(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding talkgroup"})
 			return
 		}
+		before := gin.H{"name": talkgroup.Name, "description": talkgroup.Description}
 
 		if json.Name != "" {
 			// Validate length less than 20 characters
@@ -366,6 +391,7 @@ func PATCHTalkgroup(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving talkgroup"})
 			return
 		}
+		audit.Record(c, db, audit.ActionTalkgroupUpdate, audit.TargetTalkgroup, talkgroup.ID, before, gin.H{"name": talkgroup.Name, "description": talkgroup.Description})
 	}
 }
 
@@ -422,6 +448,25 @@ func POSTTalkgroup(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating talkgroup"})
 			return
 		}
+		audit.Record(c, db, audit.ActionTalkgroupCreate, audit.TargetTalkgroup, talkgroup.ID, nil, talkgroupState(talkgroup))
 		c.JSON(http.StatusOK, gin.H{"message": "Talkgroup created"})
 	}
 }
+
+// talkgroupState is the audit log view of a talkgroup.
+func talkgroupState(talkgroup models.Talkgroup) gin.H {
+	return gin.H{
+		"name":        talkgroup.Name,
+		"description": talkgroup.Description,
+		"admins":      userIDs(talkgroup.Admins),
+		"ncos":        userIDs(talkgroup.NCOs),
+	}
+}
+
+func userIDs(users []models.User) []uint {
+	ids := make([]uint, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
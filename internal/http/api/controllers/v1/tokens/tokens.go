@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package tokens
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxExpiresInDays = 365
+
+func tokenResponse(token models.APIToken) apimodels.TokenResponse {
+	return apimodels.TokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Hint:       token.Hint,
+		Scopes:     token.ScopeList(),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+func GETTokens(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Error("DB cast failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	session := sessions.Default(c)
+	userID, ok := session.Get("user_id").(uint)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+		return
+	}
+
+	tokens, err := models.ListUserAPITokens(db, userID)
+	if err != nil {
+		logging.Errorf("GETTokens: Error getting tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting tokens"})
+		return
+	}
+	response := make([]apimodels.TokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		response = append(response, tokenResponse(token))
+	}
+	c.JSON(http.StatusOK, gin.H{"total": len(response), "tokens": response})
+}
+
+func POSTToken(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Error("DB cast failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	session := sessions.Default(c)
+	userID, ok := session.Get("user_id").(uint)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+		return
+	}
+
+	var json apimodels.TokenPost
+	err := c.ShouldBindJSON(&json)
+	if err != nil {
+		logging.Errorf("POSTToken: JSON data is invalid: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+
+	json.Name = strings.TrimSpace(json.Name)
+	if json.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token name is required"})
+		return
+	}
+	if len(json.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+		return
+	}
+	for _, scope := range json.Scopes {
+		if !slices.Contains(models.ValidTokenScopes(), scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope " + scope})
+			return
+		}
+	}
+	if json.ExpiresInDays == 0 || json.ExpiresInDays > maxExpiresInDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tokens must expire within 1 to 365 days"})
+		return
+	}
+
+	raw, hash, err := models.GenerateAPIToken()
+	if err != nil {
+		logging.Errorf("POSTToken: Error generating token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating token"})
+		return
+	}
+
+	slices.Sort(json.Scopes)
+	token := models.APIToken{
+		UserID:    userID,
+		Name:      json.Name,
+		Hint:      models.TokenHint(raw),
+		Hash:      hash,
+		Scopes:    strings.Join(slices.Compact(json.Scopes), ","),
+		ExpiresAt: time.Now().Add(time.Duration(json.ExpiresInDays) * 24 * time.Hour),
+	}
+	err = db.Create(&token).Error
+	if err != nil {
+		logging.Errorf("POSTToken: Error saving token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, apimodels.TokenCreateResponse{
+		TokenResponse: tokenResponse(token),
+		Token:         raw,
+	})
+}
+
+func DELETEToken(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Error("DB cast failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	session := sessions.Default(c)
+	userID, ok := session.Get("user_id").(uint)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	// Scoped to the user, so nobody can revoke someone else's token by ID
+	deleted, err := models.DeleteUserAPIToken(db, userID, uint(id))
+	if err != nil {
+		logging.Errorf("DELETEToken: Error deleting token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking token"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token does not exist"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
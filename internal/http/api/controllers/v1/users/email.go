@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package users
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/emailtoken"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/notify"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// POSTUserEmailVerify marks a user's email address as verified using the token mailed to it.
+func POSTUserEmailVerify(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Error("DB cast failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	var json apimodels.EmailVerification
+	err := c.ShouldBindJSON(&json)
+	if err != nil {
+		logging.Errorf("POSTUserEmailVerify: JSON data is invalid: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+
+	token, err := notify.ParseToken(emailtoken.PurposeVerifyEmail, json.Token)
+	if errors.Is(err, emailtoken.ErrExpiredToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link has expired"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is not valid"})
+		return
+	}
+
+	user, err := models.FindUserByID(db, token.UserID)
+	if err != nil || user.Email == "" || !token.Bound(user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is not valid"})
+		return
+	}
+
+	// A verified address can only belong to one user
+	inUse, err := models.EmailInUse(db, user.Email, user.ID)
+	if err != nil {
+		logging.Errorf("POSTUserEmailVerify: Error checking email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if inUse {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email address is already registered"})
+		return
+	}
+
+	err = models.ClaimVerifiedEmail(db, &user)
+	if err != nil {
+		logging.Errorf("POSTUserEmailVerify: Error saving user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email address verified"})
+}
+
+// POSTUserEmailResend mails the logged in user a new verification link.
+func POSTUserEmailResend(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Error("DB cast failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if !config.GetConfig().EnableEmail {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is not enabled"})
+		return
+	}
+	session := sessions.Default(c)
+	userID, ok := session.Get("user_id").(uint)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+		return
+	}
+
+	user, err := models.FindUserByID(db, userID)
+	if err != nil {
+		logging.Errorf("POSTUserEmailResend: Error finding user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding user"})
+		return
+	}
+	if user.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No email address is set"})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email address is already verified"})
+		return
+	}
+
+	err = notify.SendVerification(user)
+	if err != nil {
+		logging.Errorf("POSTUserEmailResend: Error sending email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error sending email"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
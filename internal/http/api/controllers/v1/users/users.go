@@ -20,23 +20,24 @@
 package users
 
 import (
-	"crypto/sha1" //#nosec G505 -- False positive, we are not using this for crypto, just HIBP
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/USA-RedDragon/DMRHub/internal/audit"
 	"github.com/USA-RedDragon/DMRHub/internal/config"
 	"github.com/USA-RedDragon/DMRHub/internal/db/models"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/dmrconst"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/utils"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/notify"
 	"github.com/USA-RedDragon/DMRHub/internal/smtp"
 	"github.com/USA-RedDragon/DMRHub/internal/userdb"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
-	gopwned "github.com/mavjs/goPwned"
 	"gorm.io/gorm"
 )
 
@@ -97,15 +98,15 @@ func POSTUser(c *gin.Context) {
 			return
 		}
 
-		// Check that password isn't a zero string
-		if json.Password == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Password cannot be blank"})
+		err := utils.CheckNewPassword(json.Password, config.GetConfig().HIBPAPIKey)
+		if err != nil {
+			utils.RespondNewPasswordError(c, "POSTUser", err)
 			return
 		}
 
 		// Check if the username is already taken
 		var user models.User
-		err := db.Find(&user, "username = ?", json.Username).Error
+		err = db.Find(&user, "username = ?", json.Username).Error
 		if err != nil {
 			logging.Errorf("POSTUser: Error getting user: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user"})
@@ -115,6 +116,26 @@ func POSTUser(c *gin.Context) {
 			return
 		}
 
+		email := ""
+		if json.Email != "" {
+			var valid bool
+			email, valid = apimodels.NormalizeEmail(json.Email)
+			if !valid {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Email address is not valid"})
+				return
+			}
+			inUse, err := models.EmailInUse(db, email, json.DMRId)
+			if err != nil {
+				logging.Errorf("POSTUser: Error checking email: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user"})
+				return
+			}
+			if inUse {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Email address is already registered"})
+				return
+			}
+		}
+
 		// Check if the DMR ID is already taken
 		exists, err := models.UserIDExists(db, json.DMRId)
 		if err != nil {
@@ -127,48 +148,6 @@ func POSTUser(c *gin.Context) {
 			return
 		}
 
-		if config.GetConfig().HIBPAPIKey != "" {
-			goPwned := gopwned.NewClient(nil, config.GetConfig().HIBPAPIKey)
-			h := sha1.New() //#nosec G401 -- False positive, we are not using this for crypto, just HIBP
-			h.Write([]byte(json.Password))
-			sha1HashedPW := fmt.Sprintf("%X", h.Sum(nil))
-			frange := sha1HashedPW[0:5]
-			lrange := sha1HashedPW[5:40]
-			karray, err := goPwned.GetPwnedPasswords(frange, false)
-			if err != nil {
-				// If the error message starts with "Too many requests", then tell the user to retry in one minute
-				if strings.HasPrefix(err.Error(), "Too many requests") {
-					c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests. Please try again in one minute"})
-					return
-				}
-				logging.Errorf("POSTUser: Error getting pwned passwords: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting pwned passwords"})
-				return
-			}
-			strKArray := string(karray)
-			respArray := strings.Split(strKArray, "\r\n")
-
-			var result int64
-			for _, resp := range respArray {
-				strArray := strings.Split(resp, ":")
-				test := strArray[0]
-
-				count, err := strconv.ParseInt(strArray[1], 0, 32)
-				if err != nil {
-					logging.Errorf("POSTUser: Error parsing pwned password count: %v", err)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error parsing pwned password count"})
-					return
-				}
-				if test == lrange {
-					result = count
-				}
-			}
-			if result > 0 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Password has been reported in a data breach. Please use another one"})
-				return
-			}
-		}
-
 		// argon2 the password
 		hashedPassword := utils.HashPassword(json.Password, config.GetConfig().PasswordSalt)
 
@@ -177,6 +156,7 @@ func POSTUser(c *gin.Context) {
 			Username: json.Username,
 			Password: hashedPassword,
 			Callsign: strings.ToUpper(json.Callsign),
+			Email:    email,
 			ID:       json.DMRId,
 			Approved: false,
 			Admin:    false,
@@ -188,6 +168,7 @@ func POSTUser(c *gin.Context) {
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"message": "User created, please wait for admin approval"})
+		go webhooks.Emit(db, webhooks.EventUserRegistered, webhooks.UserFromModel(user))
 		if config.GetConfig().EnableEmail {
 			err := smtp.Send(
 				config.GetConfig().AdminEmail,
@@ -197,6 +178,12 @@ func POSTUser(c *gin.Context) {
 			if err != nil {
 				logging.Errorf("POSTUser: Error sending email: %v", err)
 			}
+			if user.Email != "" {
+				err := notify.SendVerification(user)
+				if err != nil {
+					logging.Errorf("POSTUser: Error sending verification email: %v", err)
+				}
+			}
 		}
 	}
 }
@@ -234,6 +221,7 @@ func POSTUserDemote(c *gin.Context) {
 		return
 	}
 
+	before := gin.H{"admin": user.Admin}
 	user.Admin = false
 	err = db.Save(&user).Error
 	if err != nil {
@@ -241,6 +229,7 @@ func POSTUserDemote(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving user"})
 		return
 	}
+	audit.Record(c, db, audit.ActionUserDemote, audit.TargetUser, user.ID, before, gin.H{"admin": user.Admin})
 	c.JSON(http.StatusOK, gin.H{"message": "User demoted"})
 
 	if config.GetConfig().EnableEmail {
@@ -286,6 +275,7 @@ func POSTUserPromote(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot promote an unapproved user"})
 		return
 	}
+	before := gin.H{"admin": user.Admin}
 	user.Admin = true
 	err = db.Save(&user).Error
 	if err != nil {
@@ -293,6 +283,7 @@ func POSTUserPromote(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving user"})
 		return
 	}
+	audit.Record(c, db, audit.ActionUserPromote, audit.TargetUser, user.ID, before, gin.H{"admin": user.Admin})
 	c.JSON(http.StatusOK, gin.H{"message": "User promoted"})
 
 	if config.GetConfig().EnableEmail {
@@ -341,6 +332,7 @@ func POSTUserUnsuspend(c *gin.Context) {
 		return
 	}
 
+	before := gin.H{"suspended": user.Suspended}
 	user.Suspended = false
 	err = db.Save(&user).Error
 	if err != nil {
@@ -348,7 +340,9 @@ func POSTUserUnsuspend(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving user"})
 		return
 	}
+	audit.Record(c, db, audit.ActionUserUnsuspend, audit.TargetUser, user.ID, before, gin.H{"suspended": user.Suspended})
 	c.JSON(http.StatusOK, gin.H{"message": "User unsuspended"})
+	notify.AccountUnsuspended(user)
 }
 
 func POSTUserApprove(c *gin.Context) {
@@ -385,6 +379,7 @@ func POSTUserApprove(c *gin.Context) {
 		return
 	}
 
+	before := gin.H{"approved": user.Approved}
 	user.Approved = true
 	err = db.Save(&user).Error
 	if err != nil {
@@ -392,7 +387,9 @@ func POSTUserApprove(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving user"})
 		return
 	}
+	audit.Record(c, db, audit.ActionUserApprove, audit.TargetUser, user.ID, before, gin.H{"approved": user.Approved})
 	c.JSON(http.StatusOK, gin.H{"message": "User approved"})
+	notify.AccountApproved(user)
 }
 
 func GETUser(c *gin.Context) {
@@ -413,8 +410,9 @@ func GETUser(c *gin.Context) {
 	if err != nil {
 		logging.Errorf("Error finding user: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User does not exist"})
+		return
 	}
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, apimodels.NewUserWithEmail(user))
 }
 
 func GETUserAdmins(c *gin.Context) {
@@ -534,6 +532,27 @@ func PATCHUser(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "User does not exist"})
 			return
 		}
+		before := gin.H{"callsign": user.Callsign, "username": user.Username}
+
+		if json.Password != "" || json.Email != "" {
+			if _, viaToken := c.Get("APITokenID"); viaToken {
+				c.JSON(http.StatusForbidden, gin.H{"error": "API tokens cannot change the password or email address"})
+				return
+			}
+			// Admins can't know another user's password, but users changing
+			// their own credentials have to prove they know the current one
+			if fromUserID, ok := sessions.Default(c).Get("user_id").(uint); !ok || fromUserID == user.ID {
+				if json.CurrentPassword == "" {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is required"})
+					return
+				}
+				verified, err := utils.VerifyPassword(json.CurrentPassword, user.Password, config.GetConfig().PasswordSalt)
+				if err != nil || !verified {
+					c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+					return
+				}
+			}
+		}
 
 		if json.Callsign != "" {
 			// Check DMR ID is in the database
@@ -561,16 +580,64 @@ func PATCHUser(c *gin.Context) {
 		}
 
 		if json.Password != "" {
+			err := utils.CheckNewPassword(json.Password, config.GetConfig().HIBPAPIKey)
+			if err != nil {
+				utils.RespondNewPasswordError(c, "PATCHUser", err)
+				return
+			}
 			user.Password = utils.HashPassword(json.Password, config.GetConfig().PasswordSalt)
 		}
 
-		err = db.Save(&user).Error
+		emailChanged := false
+		if json.Email != "" {
+			email, valid := apimodels.NormalizeEmail(json.Email)
+			if !valid {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Email address is not valid"})
+				return
+			}
+			inUse, err := models.EmailInUse(db, email, user.ID)
+			if err != nil {
+				logging.Errorf("Error checking email: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding user"})
+				return
+			}
+			if inUse {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Email address is already registered"})
+				return
+			}
+			if email != user.Email {
+				// A new address has to be verified again
+				user.Email = email
+				user.EmailVerified = false
+				emailChanged = true
+			}
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(&user).Error; err != nil {
+				return err
+			}
+			if json.Password != "" {
+				// Tokens minted under the old password shouldn't outlive it
+				return models.DeleteUserAPITokens(tx, user.ID)
+			}
+			return nil
+		})
 		if err != nil {
 			logging.Errorf("Error updating user: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating user"})
 			return
 		}
+		after := gin.H{"callsign": user.Callsign, "username": user.Username, "password_changed": json.Password != "", "email_changed": emailChanged}
+		audit.Record(c, db, audit.ActionUserUpdate, audit.TargetUser, user.ID, before, after)
 		c.JSON(http.StatusOK, gin.H{"message": "User updated"})
+
+		if emailChanged && config.GetConfig().EnableEmail {
+			err := notify.SendVerification(user)
+			if err != nil {
+				logging.Errorf("PATCHUser: Error sending verification email: %v", err)
+			}
+		}
 	}
 }
 
@@ -597,6 +664,12 @@ func DELETEUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User does not exist"})
 		return
 	}
+	user, err := models.FindUserByID(db, uint(idUint64))
+	if err != nil {
+		logging.Errorf("Error finding user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding user"})
+		return
+	}
 
 	err = models.DeleteUser(db, uint(idUint64))
 	if err != nil {
@@ -604,6 +677,7 @@ func DELETEUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting user"})
 		return
 	}
+	audit.Record(c, db, audit.ActionUserDelete, audit.TargetUser, user.ID, user, nil)
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
 }
 
@@ -651,6 +725,7 @@ func POSTUserSuspend(c *gin.Context) {
 		return
 	}
 
+	before := gin.H{"suspended": user.Suspended}
 	user.Suspended = true
 	err = db.Save(&user).Error
 	if err != nil {
@@ -658,7 +733,9 @@ func POSTUserSuspend(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving user"})
 		return
 	}
+	audit.Record(c, db, audit.ActionUserSuspend, audit.TargetUser, user.ID, before, gin.H{"suspended": user.Suspended})
 	c.JSON(http.StatusOK, gin.H{"message": "User suspended"})
+	notify.AccountSuspended(user)
 }
 
 func GETUserSelf(c *gin.Context) {
@@ -690,5 +767,5 @@ func GETUserSelf(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding user"})
 		return
 	}
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, apimodels.NewUserWithEmail(user))
 }
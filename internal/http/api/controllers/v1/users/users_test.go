@@ -28,8 +28,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/dmrconst"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	v1UsersControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/users"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/middleware"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/utils"
 	"github.com/USA-RedDragon/DMRHub/internal/testutils"
 	"github.com/stretchr/testify/assert"
 )
@@ -620,3 +625,45 @@ func TestDemoteUser(t *testing.T) {
 	assert.Equal(t, user.Username, userResp.Username)
 	assert.Equal(t, false, userResp.Admin)
 }
+
+func TestPatchUserCredentials(t *testing.T) {
+	t.Parallel()
+	db := testutils.OpenDB(t)
+	user := models.User{ID: 3191868, Callsign: "KI5VMF", Username: "user", Approved: true,
+		Password: utils.HashPassword("password", config.GetConfig().PasswordSalt)}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	raw, hash, err := models.GenerateAPIToken()
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	token := models.APIToken{UserID: user.ID, Name: "test", Hint: models.TokenHint(raw), Hash: hash, Scopes: models.ScopeUsersWrite, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := db.Create(&token).Error; err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	tokenRouter := testutils.NewRouter(db)
+	tokenRouter.Group("/api/v1", middleware.TokenAuth()).PATCH("/users/:id", v1UsersControllers.PATCHUser)
+	w := testutils.Request(t, tokenRouter, http.MethodPatch, "/api/v1/users/3191868",
+		apimodels.UserPatch{Password: "changed", CurrentPassword: "password"}, testutils.WithBearerToken(raw))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = testutils.Request(t, tokenRouter, http.MethodPatch, "/api/v1/users/3191868",
+		apimodels.UserPatch{Email: "user@example.com", CurrentPassword: "password"}, testutils.WithBearerToken(raw))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	router := testutils.NewRouter(db, testutils.LoginAs(user.ID))
+	router.PATCH("/api/v1/users/:id", v1UsersControllers.PATCHUser)
+	w = testutils.Request(t, router, http.MethodPatch, "/api/v1/users/3191868", apimodels.UserPatch{Email: "user@example.com"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = testutils.Request(t, router, http.MethodPatch, "/api/v1/users/3191868",
+		apimodels.UserPatch{Password: "changed", CurrentPassword: "wrong"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = testutils.Request(t, router, http.MethodPatch, "/api/v1/users/3191868",
+		apimodels.UserPatch{Password: "changed", CurrentPassword: "password"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	tokens, err := models.ListUserAPITokens(db, user.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, tokens, "Expected tokens to be revoked with the password change")
+}
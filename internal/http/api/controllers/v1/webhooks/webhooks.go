@@ -0,0 +1,256 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/USA-RedDragon/DMRHub/internal/audit"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GETWebhooks(c *gin.Context) {
+	db, ok := c.MustGet("PaginatedDB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	cDb, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	list, err := models.ListWebhooks(db)
+	if err != nil {
+		logging.Errorf("Error listing webhooks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing webhooks"})
+		return
+	}
+	total, err := models.CountWebhooks(cDb)
+	if err != nil {
+		logging.Errorf("Error counting webhooks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing webhooks"})
+		return
+	}
+	response := make([]apimodels.Webhook, 0, len(list))
+	for _, webhook := range list {
+		response = append(response, apimodels.NewWebhook(webhook))
+	}
+	c.JSON(http.StatusOK, gin.H{"total": total, "webhooks": response, "events": webhooks.Events()})
+}
+
+func POSTWebhook(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	userID, ok := sessions.Default(c).Get("user_id").(uint)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+		return
+	}
+	var json apimodels.WebhookPost
+	err := c.ShouldBindJSON(&json)
+	if err != nil {
+		logging.Errorf("POSTWebhook: JSON data is invalid: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+	if !validate(c, json.URL, json.Events) {
+		return
+	}
+
+	secret, err := webhooks.GenerateSecret()
+	if err != nil {
+		logging.Errorf("POSTWebhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	webhook := models.Webhook{
+		Name:        json.Name,
+		URL:         json.URL,
+		Secret:      secret,
+		Events:      joinEvents(json.Events),
+		Enabled:     true,
+		CreatedByID: userID,
+	}
+	err = db.Create(&webhook).Error
+	if err != nil {
+		logging.Errorf("POSTWebhook: Error creating webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating webhook"})
+		return
+	}
+	audit.Record(c, db, audit.ActionWebhookCreate, audit.TargetWebhook, webhook.ID, nil, apimodels.NewWebhook(webhook))
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook created", "webhook": apimodels.NewWebhook(webhook), "secret": secret})
+}
+
+func PATCHWebhook(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	webhook, ok := findWebhook(c, db)
+	if !ok {
+		return
+	}
+	var json apimodels.WebhookPatch
+	err := c.ShouldBindJSON(&json)
+	if err != nil {
+		logging.Errorf("PATCHWebhook: JSON data is invalid: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON data is invalid"})
+		return
+	}
+
+	url := webhook.URL
+	if json.URL != "" {
+		url = json.URL
+	}
+	events := webhook.EventList()
+	if json.Events != nil {
+		events = json.Events
+	}
+	if !validate(c, url, events) {
+		return
+	}
+	before := apimodels.NewWebhook(webhook)
+	webhook.URL = url
+	webhook.Events = joinEvents(events)
+	if json.Name != "" {
+		webhook.Name = json.Name
+	}
+	if json.Enabled != nil {
+		webhook.Enabled = *json.Enabled
+	}
+
+	err = db.Save(&webhook).Error
+	if err != nil {
+		logging.Errorf("PATCHWebhook: Error saving webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving webhook"})
+		return
+	}
+	audit.Record(c, db, audit.ActionWebhookUpdate, audit.TargetWebhook, webhook.ID, before, apimodels.NewWebhook(webhook))
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook updated", "webhook": apimodels.NewWebhook(webhook)})
+}
+
+func DELETEWebhook(c *gin.Context) {
+	db, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	webhook, ok := findWebhook(c, db)
+	if !ok {
+		return
+	}
+	err := models.DeleteWebhook(db, webhook.ID)
+	if err != nil {
+		logging.Errorf("DELETEWebhook: Error deleting webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting webhook"})
+		return
+	}
+	audit.Record(c, db, audit.ActionWebhookDelete, audit.TargetWebhook, webhook.ID, apimodels.NewWebhook(webhook), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// GETWebhookDeliveries returns the delivery log of a webhook, newest first.
+func GETWebhookDeliveries(c *gin.Context) {
+	db, ok := c.MustGet("PaginatedDB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	cDb, ok := c.MustGet("DB").(*gorm.DB)
+	if !ok {
+		logging.Errorf("Unable to get DB from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	webhook, ok := findWebhook(c, cDb)
+	if !ok {
+		return
+	}
+	deliveries, err := models.ListWebhookDeliveries(db, webhook.ID)
+	if err != nil {
+		logging.Errorf("Error listing webhook deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing deliveries"})
+		return
+	}
+	total, err := models.CountWebhookDeliveries(cDb, webhook.ID)
+	if err != nil {
+		logging.Errorf("Error counting webhook deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing deliveries"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"total": total, "deliveries": deliveries})
+}
+
+func findWebhook(c *gin.Context, db *gorm.DB) (models.Webhook, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return models.Webhook{}, false
+	}
+	webhook, err := models.FindWebhookByID(db, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook does not exist"})
+		return models.Webhook{}, false
+	} else if err != nil {
+		logging.Errorf("Error finding webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding webhook"})
+		return models.Webhook{}, false
+	}
+	return webhook, true
+}
+
+func validate(c *gin.Context, url string, events []string) bool {
+	if err := webhooks.ValidateURL(url); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an http or https URL"})
+		return false
+	}
+	if err := webhooks.ValidateEvents(events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Events must be one or more of " + strings.Join(webhooks.Events(), ", ")})
+		return false
+	}
+	return true
+}
+
+func joinEvents(events []string) string {
+	events = slices.Clone(events)
+	slices.Sort(events)
+	return strings.Join(slices.Compact(events), ",")
+}
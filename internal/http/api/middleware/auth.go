@@ -34,6 +34,15 @@ import (
 	"gorm.io/gorm"
 )
 
+// hasAdminAccess reports whether a user may use their admin privileges.
+// With REQUIRE_ADMIN_TOTP set, admins must enable two-factor authentication first.
+func hasAdminAccess(user models.User) bool {
+	if !user.Admin || !user.Approved || user.Suspended {
+		return false
+	}
+	return user.TOTPEnabled || !config.GetConfig().RequireAdminTOTP
+}
+
 func RequireAdminOrTGOwner() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		session := sessions.Default(c)
@@ -85,7 +94,7 @@ func RequireAdminOrTGOwner() gin.HandlerFunc {
 				attribute.Bool("user.admin", user.Admin),
 			)
 		}
-		if user.Admin && user.Approved && !user.Suspended {
+		if hasAdminAccess(user) {
 			valid = true
 		} else {
 			// Check if the user is the owner of any talkgroups
@@ -157,7 +166,7 @@ func RequireAdmin() gin.HandlerFunc {
 				attribute.Bool("user.admin", user.Admin),
 			)
 		}
-		if user.Admin && user.Approved && !user.Suspended {
+		if hasAdminAccess(user) {
 			valid = true
 		}
 
@@ -203,6 +212,19 @@ func RequireSuperAdmin() gin.HandlerFunc {
 		if uid != dmrconst.SuperAdminUser {
 			logging.Error("User is not a super admin")
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+			return
+		}
+
+		db, ok := c.MustGet("DB").(*gorm.DB)
+		if !ok {
+			logging.Error("RequireSuperAdmin: Unable to get DB from context")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+			return
+		}
+		var user models.User
+		db.WithContext(ctx).Find(&user, "id = ?", uid)
+		if !hasAdminAccess(user) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
 		}
 	}
 }
@@ -312,7 +334,7 @@ func RequirePeerOwnerOrAdmin() gin.HandlerFunc {
 				attribute.Bool("user.admin", user.Admin),
 			)
 		}
-		if user.Approved && !user.Suspended && user.Admin {
+		if hasAdminAccess(user) {
 			valid = true
 		} else {
 			var peer models.Peer
@@ -380,7 +402,7 @@ func RequireRepeaterOwnerOrAdmin() gin.HandlerFunc {
 				attribute.Bool("user.admin", user.Admin),
 			)
 		}
-		if user.Approved && !user.Suspended && user.Admin {
+		if hasAdminAccess(user) {
 			valid = true
 		} else {
 			var repeater models.Repeater
@@ -448,7 +470,7 @@ func RequireTalkgroupOwnerOrAdmin() gin.HandlerFunc {
 				attribute.Bool("user.admin", user.Admin),
 			)
 		}
-		if user.Admin && !user.Suspended && user.Approved {
+		if hasAdminAccess(user) {
 			valid = true
 		} else {
 			var talkgroup models.Talkgroup
@@ -520,7 +542,7 @@ func RequireSelfOrAdmin() gin.HandlerFunc {
 				attribute.Bool("user.admin", user.Admin),
 			)
 		}
-		if user.Admin && !user.Suspended && user.Approved {
+		if hasAdminAccess(user) {
 			valid = true
 		} else if id == fmt.Sprintf("%d", user.ID) && !user.Suspended && user.Approved {
 			valid = true
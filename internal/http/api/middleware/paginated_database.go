@@ -82,6 +82,7 @@ func PaginatedDatabaseProvider(db *gorm.DB, config PaginationConfig) gin.Handler
 			page = 1
 		}
 
+		c.Set("PaginationLimit", limit)
 		c.Set("PaginatedDB",
 			db.WithContext(c.Request.Context()).Scopes(pagination.NewPaginate(limit, page).Paginate),
 		)
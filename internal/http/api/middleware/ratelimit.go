@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	ratelimit "github.com/JGLTechnologies/gin-rate-limit"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/gin-gonic/gin"
+)
+
+type rateLimitStore struct {
+	kv    kv.KV
+	rate  time.Duration
+	limit uint
+}
+
+// RateLimitStore counts requests in fixed windows of rate, allowing limit requests per window.
+// Requests are let through if the count cannot be stored.
+func RateLimitStore(store kv.KV, rate time.Duration, limit uint) ratelimit.Store {
+	return &rateLimitStore{
+		kv:    store,
+		rate:  rate,
+		limit: limit,
+	}
+}
+
+func (s *rateLimitStore) Limit(key string, c *gin.Context) ratelimit.Info {
+	window := time.Now().Truncate(s.rate)
+	reset := window.Add(s.rate)
+	hits, err := s.kv.Incr(c.Request.Context(), "ratelimit:"+key+":"+strconv.FormatInt(window.Unix(), 10), s.rate)
+	if err != nil {
+		logging.Errorf("Error counting requests for rate limiting: %v", err)
+		return ratelimit.Info{Limit: s.limit, ResetTime: reset, RemainingHits: s.limit}
+	}
+	if hits > int64(s.limit) {
+		return ratelimit.Info{Limit: s.limit, RateLimited: true, ResetTime: reset}
+	}
+	return ratelimit.Info{Limit: s.limit, ResetTime: reset, RemainingHits: s.limit - uint(hits)}
+}
@@ -20,13 +20,21 @@
 package middleware
 
 import (
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 )
 
-func RedisProvider(redis *redis.Client) gin.HandlerFunc {
+func KVProvider(store kv.KV) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Set("Redis", redis)
+		c.Set("KV", store)
+		c.Next()
+	}
+}
+
+func PubSubProvider(ps pubsub.PubSub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("PubSub", ps)
 		c.Next()
 	}
 }
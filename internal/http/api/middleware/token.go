@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// TokenAuth authenticates requests that carry an API token as a Bearer
+// Authorization header. The token's user is placed in the request's session
+// without saving it, so the Require* middleware and the handlers treat the
+// request like one from a logged in user while no cookie is ever issued.
+// Requests without the header fall through to normal session handling.
+func TokenAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			return
+		}
+		raw, found := strings.CutPrefix(header, "Bearer ")
+		if !found {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+			return
+		}
+
+		scope, allowed := RequiredTokenScope(c.Request.Method, c.FullPath())
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API tokens cannot be used for this endpoint"})
+			return
+		}
+
+		db, ok := c.MustGet("DB").(*gorm.DB)
+		if !ok {
+			logging.Error("TokenAuth: Unable to get DB from context")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+			return
+		}
+		db = db.WithContext(c.Request.Context())
+
+		token, err := models.FindAPITokenByHash(db, models.HashAPIToken(strings.TrimSpace(raw)))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+			return
+		}
+		now := time.Now()
+		if token.Expired(now) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has expired"})
+			return
+		}
+		if scope != "" && !token.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token is missing the " + scope + " scope"})
+			return
+		}
+
+		span := trace.SpanFromContext(c.Request.Context())
+		if span.IsRecording() {
+			span.SetAttributes(
+				attribute.String("http.auth", "TokenAuth"),
+				attribute.Int("token.id", int(token.ID)),
+				attribute.Int("user.id", int(token.UserID)),
+			)
+		}
+
+		err = models.TouchAPIToken(db, &token, now)
+		if err != nil {
+			logging.Errorf("TokenAuth: Failed to record token use: %v", err)
+		}
+
+		// Handlers use this to refuse changes a token must never make, such as
+		// to the password or email address
+		c.Set("APITokenID", token.ID)
+		session := sessions.Default(c)
+		session.Set("user_id", token.UserID)
+	}
+}
+
+// RequiredTokenScope returns the scope a token needs for a route, given as
+// the matched route path. Tokens are refused on every route that isn't part of
+// a scoped resource or explicitly public, so new routes stay closed to tokens
+// until someone decides which scope guards them. Authentication, token and
+// webhook management and the audit log are never available to tokens, so a
+// leaked token can't be used to mint new ones, to siphon off network events
+// or to see who did what from where.
+func RequiredTokenScope(method, route string) (string, bool) {
+	resource, _, _ := strings.Cut(strings.TrimPrefix(route, "/api/v1/"), "/")
+	read := method == http.MethodGet || method == http.MethodHead
+	switch resource {
+	case "lastheard", "repeaters", "talkgroups", "users", "peers":
+		if read {
+			return resource + ":read", true
+		}
+		return resource + ":write", true
+	case "stats":
+		if read {
+			return resource + ":read", true
+		}
+		return "", false
+	case "features", "network", "openapi.json", "ping", "version":
+		// Public information about the server
+		return "", true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package middleware_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/middleware"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func TestRequiredTokenScope(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		method  string
+		route   string
+		scope   string
+		allowed bool
+	}{
+		{http.MethodGet, "/api/v1/lastheard", models.ScopeLastheardRead, true},
+		{http.MethodGet, "/api/v1/repeaters/:id", models.ScopeRepeatersRead, true},
+		{http.MethodPost, "/api/v1/repeaters/:id/talkgroups", models.ScopeRepeatersWrite, true},
+		{http.MethodDelete, "/api/v1/peers/:id", models.ScopePeersWrite, true},
+		{http.MethodGet, "/api/v1/version", "", true},
+		{http.MethodPost, "/api/v1/auth/login", "", false},
+		{http.MethodPost, "/api/v1/tokens", "", false},
+		{http.MethodGet, "/api/v1/webhooks/:id/deliveries", "", false},
+		{http.MethodGet, "/api/v1/audit", "", false},
+		{http.MethodGet, "/api/v1/stats/talkers", models.ScopeStatsRead, true},
+		{http.MethodGet, "/api/v1/repeaters/:id/alerts", models.ScopeRepeatersRead, true},
+		{http.MethodDelete, "/api/v1/repeaters/:id/alerts/:alertID", models.ScopeRepeatersWrite, true},
+		{http.MethodGet, "/api/v1/ping", "", true},
+		{http.MethodGet, "/api/v1/features", "", true},
+		{http.MethodGet, "/api/v1/unknown", "", false},
+		{http.MethodGet, "/healthz", "", false},
+	}
+	for _, test := range tests {
+		scope, allowed := middleware.RequiredTokenScope(test.method, test.route)
+		if scope != test.scope || allowed != test.allowed {
+			t.Errorf("%s %s: got (%q, %t), want (%q, %t)", test.method, test.route, scope, allowed, test.scope, test.allowed)
+		}
+	}
+}
+
+func makeTokenRouter(t *testing.T) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+	db := testutils.OpenDB(t)
+	if err := db.Create(&models.User{ID: 3191868, Callsign: "KI5VMF", Username: "user", Approved: true}).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	router := testutils.NewRouter(db)
+	group := router.Group("/api/v1")
+	group.Use(middleware.TokenAuth())
+	whoami := func(c *gin.Context) {
+		c.String(http.StatusOK, fmt.Sprint(sessions.Default(c).Get("user_id")))
+	}
+	group.GET("/lastheard", whoami)
+	group.POST("/repeaters", whoami)
+	group.GET("/tokens", whoami)
+	return router, db
+}
+
+func createToken(t *testing.T, db *gorm.DB, scopes string, expiresAt time.Time) string {
+	t.Helper()
+	raw, hash, err := models.GenerateAPIToken()
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	token := models.APIToken{UserID: 3191868, Name: "test", Hint: models.TokenHint(raw), Hash: hash, Scopes: scopes, ExpiresAt: expiresAt}
+	if err := db.Create(&token).Error; err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	return raw
+}
+
+func TestTokenAuth(t *testing.T) {
+	t.Parallel()
+	router, db := makeTokenRouter(t)
+	token := createToken(t, db, models.ScopeLastheardRead, time.Now().Add(time.Hour))
+
+	w := testutils.Request(t, router, http.MethodGet, "/api/v1/lastheard", nil, testutils.WithBearerToken(token))
+	if w.Code != http.StatusOK || w.Body.String() != "3191868" {
+		t.Errorf("Expected token user in session, got %d %q", w.Code, w.Body.String())
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("Expected no session cookie to be issued for a token request")
+	}
+
+	var stored models.APIToken
+	if err := db.First(&stored).Error; err != nil {
+		t.Fatalf("Failed to load token: %v", err)
+	}
+	if stored.LastUsedAt == nil {
+		t.Error("Expected last used time to be recorded")
+	}
+
+	w = testutils.Request(t, router, http.MethodPost, "/api/v1/repeaters", nil, testutils.WithBearerToken(token))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected missing scope to be forbidden, got %d", w.Code)
+	}
+
+	w = testutils.Request(t, router, http.MethodGet, "/api/v1/tokens", nil, testutils.WithBearerToken(token))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected token management to be forbidden, got %d", w.Code)
+	}
+
+	w = testutils.Request(t, router, http.MethodGet, "/api/v1/lastheard", nil, testutils.WithBearerToken("dmrhub_invalid"))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected invalid token to be rejected, got %d", w.Code)
+	}
+
+	w = testutils.Request(t, router, http.MethodGet, "/api/v1/lastheard", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "<nil>" {
+		t.Errorf("Expected request without token to fall through, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestTokenAuthExpired(t *testing.T) {
+	t.Parallel()
+	router, db := makeTokenRouter(t)
+	token := createToken(t, db, models.ScopeLastheardRead, time.Now().Add(-time.Minute))
+
+	w := testutils.Request(t, router, http.MethodGet, "/api/v1/lastheard", nil, testutils.WithBearerToken(token))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected expired token to be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,238 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package openapi describes the v1 REST API as an OpenAPI 3 document.
+//
+// The document is generated from the operations table and the Go types the
+// handlers bind and return, and committed as openapi.json, which is what the
+// API serves. Run go generate after changing a route or a model to update it.
+package openapi
+
+//go:generate go test -run TestSpecIsUpToDate -update .
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Prefix is where the v1 API is mounted.
+const Prefix = "/api/v1"
+
+// Access is who may call an operation, as enforced by the route's middleware.
+type Access string
+
+const (
+	AccessPublic                Access = "public"
+	AccessLogin                 Access = "login"
+	AccessSelfOrAdmin           Access = "self-or-admin"
+	AccessOwnerOrAdmin          Access = "owner-or-admin"
+	AccessTalkgroupOwner        Access = "talkgroup-owner-or-admin"
+	AccessAdminOrTalkgroupOwner Access = "admin-or-any-talkgroup-owner"
+	AccessAdmin                 Access = "admin"
+	AccessSuperAdmin            Access = "super-admin"
+)
+
+// Param is a query parameter. Type is a value of the parameter's Go type.
+type Param struct {
+	Name        string
+	Description string
+	Type        any
+}
+
+// Operation documents one route.
+type Operation struct {
+	Method string
+	// Path is the gin route below Prefix
+	Path    string
+	Handler gin.HandlerFunc
+	Summary string
+	Tag     string
+	Access  Access
+	// Paginated routes take the page and limit query parameters
+	Paginated bool
+	Query     []Param
+	// Request and Response are values of the JSON body types, nil for none
+	Request  any
+	Response any
+	// ContentType of the response, JSON when empty
+	ContentType string
+	// Redirect is set for routes that answer with a redirect rather than a body
+	Redirect bool
+}
+
+// HandlerName is the name of the function handling the operation, as gin reports it.
+func (o Operation) HandlerName() string {
+	return runtime.FuncForPC(reflect.ValueOf(o.Handler).Pointer()).Name()
+}
+
+func (o Operation) operationID() string {
+	name := o.HandlerName()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+var pathParam = regexp.MustCompile(`:([A-Za-z]+)`)
+
+// Message is the response of operations that only confirm what they did.
+type Message struct {
+	Message string `json:"message"`
+}
+
+// Error is the response of every failed request.
+type Error struct {
+	Error string `json:"error"`
+}
+
+func (o Operation) build(s *schemas) map[string]any {
+	operation := map[string]any{
+		"operationId": o.operationID(),
+		"summary":     o.Summary,
+		"tags":        []string{o.Tag},
+		"x-access":    o.Access,
+		"responses": map[string]any{
+			"default": map[string]any{"$ref": "#/components/responses/Error"},
+		},
+	}
+
+	var parameters []any
+	for _, match := range pathParam.FindAllStringSubmatch(o.Path, -1) {
+		schema := map[string]any{"type": "string"}
+		if strings.HasSuffix(strings.ToLower(match[1]), "id") {
+			schema = map[string]any{"type": "integer", "minimum": 0}
+		}
+		parameters = append(parameters, map[string]any{"name": match[1], "in": "path", "required": true, "schema": schema})
+	}
+	if o.Paginated {
+		parameters = append(parameters,
+			map[string]any{"name": "page", "in": "query", "description": "Page number, starting at 1", "schema": map[string]any{"type": "integer", "minimum": 1}},
+			map[string]any{"name": "limit", "in": "query", "description": "Results per page, or none for all of them", "schema": map[string]any{"type": "string"}},
+		)
+	}
+	for _, param := range o.Query {
+		parameters = append(parameters, map[string]any{
+			"name":        param.Name,
+			"in":          "query",
+			"description": param.Description,
+			"schema":      s.of(reflect.TypeOf(param.Type)),
+		})
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if o.Request != nil {
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": s.of(reflect.TypeOf(o.Request))},
+			},
+		}
+	}
+
+	responses := operation["responses"].(map[string]any)
+	switch {
+	case o.Redirect:
+		responses["302"] = map[string]any{"description": "Redirect"}
+	case o.Response == nil:
+		responses["200"] = map[string]any{"description": "Success"}
+	default:
+		contentType := o.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		responses["200"] = map[string]any{
+			"description": "Success",
+			"content": map[string]any{
+				contentType: map[string]any{"schema": s.of(reflect.TypeOf(o.Response))},
+			},
+		}
+	}
+
+	if o.Access != AccessPublic {
+		security := []any{map[string]any{"session": []string{}}}
+		if scope, allowed := middleware.RequiredTokenScope(o.Method, Prefix+o.Path); allowed {
+			security = append(security, map[string]any{"token": []string{}})
+			if scope != "" {
+				operation["x-token-scope"] = scope
+			}
+		}
+		operation["security"] = security
+	}
+	return operation
+}
+
+// Generate builds the OpenAPI document for operations.
+func Generate(operations []Operation) ([]byte, error) {
+	s := newSchemas()
+	errorSchema := s.of(reflect.TypeOf(Error{}))
+	paths := map[string]map[string]any{}
+	for _, operation := range operations {
+		path := pathParam.ReplaceAllString(operation.Path, "{$1}")
+		if path == "" {
+			path = "/"
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(operation.Method)] = operation.build(s)
+	}
+	document := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "DMRHub API",
+			"version":     "v1",
+			"description": "The REST API of DMRHub. Requests are authenticated with the session cookie set by logging in, or with an API token where the operation allows one.",
+		},
+		"servers": []any{map[string]any{"url": Prefix}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": s.components,
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": "The request failed",
+					"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+				},
+			},
+			"securitySchemes": map[string]any{
+				"session": map[string]any{"type": "apiKey", "in": "cookie", "name": "sessions"},
+				"token":   map[string]any{"type": "http", "scheme": "bearer", "description": "An API token created at /tokens"},
+			},
+		},
+	}
+	out, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// GETOpenAPI serves the OpenAPI document of the v1 API.
+func GETOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", spec)
+}
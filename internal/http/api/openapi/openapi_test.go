@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package openapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/http/api"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/openapi"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite openapi.json")
+
+// TestSpecIsUpToDate fails when a route or a model changed without the
+// committed document being regenerated.
+func TestSpecIsUpToDate(t *testing.T) {
+	t.Parallel()
+	generated, err := openapi.Generate(openapi.Operations)
+	require.NoError(t, err)
+	if *update {
+		require.NoError(t, os.WriteFile("openapi.json", generated, 0o600))
+		return
+	}
+	committed, err := os.ReadFile("openapi.json")
+	require.NoError(t, err)
+	if !bytes.Equal(committed, generated) {
+		t.Fatal("openapi.json is out of date, run go generate ./internal/http/api/openapi")
+	}
+}
+
+// TestRoutesAreDocumented fails when a v1 route has no operation, or an
+// operation no longer matches a route.
+func TestRoutesAreDocumented(t *testing.T) {
+	t.Parallel()
+	router := gin.New()
+	noop := func(*gin.Context) {}
+	api.ApplyRoutes(router, nil, nil, nil, noop, noop)
+
+	routes := map[string]string{}
+	for _, route := range router.Routes() {
+		if path, ok := strings.CutPrefix(route.Path, openapi.Prefix); ok {
+			routes[route.Method+" "+path] = route.Handler
+		}
+	}
+	documented := map[string]bool{}
+	for _, operation := range openapi.Operations {
+		key := operation.Method + " " + operation.Path
+		assert.False(t, documented[key], "%s is documented twice", key)
+		documented[key] = true
+		handler, ok := routes[key]
+		if assert.True(t, ok, "%s is documented but has no route", key) {
+			assert.Equal(t, handler, operation.HandlerName(), "%s is documented with another handler", key)
+		}
+	}
+	for key := range routes {
+		assert.True(t, documented[key], "%s has no operation in openapi.Operations", key)
+	}
+}
+
+func collectRefs(value any, refs map[string]bool) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, child := range value {
+			if ref, ok := child.(string); ok && key == "$ref" {
+				refs[ref] = true
+			}
+			collectRefs(child, refs)
+		}
+	case []any:
+		for _, child := range value {
+			collectRefs(child, refs)
+		}
+	}
+}
+
+func TestRefsResolve(t *testing.T) {
+	t.Parallel()
+	generated, err := openapi.Generate(openapi.Operations)
+	require.NoError(t, err)
+	var document struct {
+		Components struct {
+			Schemas   map[string]any `json:"schemas"`
+			Responses map[string]any `json:"responses"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(generated, &document))
+	var raw any
+	require.NoError(t, json.Unmarshal(generated, &raw))
+
+	refs := map[string]bool{}
+	collectRefs(raw, refs)
+	require.NotEmpty(t, refs)
+	for ref := range refs {
+		switch {
+		case strings.HasPrefix(ref, "#/components/schemas/"):
+			assert.Contains(t, document.Components.Schemas, strings.TrimPrefix(ref, "#/components/schemas/"))
+		case strings.HasPrefix(ref, "#/components/responses/"):
+			assert.Contains(t, document.Components.Responses, strings.TrimPrefix(ref, "#/components/responses/"))
+		default:
+			t.Errorf("unexpected reference %s", ref)
+		}
+	}
+}
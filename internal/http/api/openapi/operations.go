@@ -0,0 +1,342 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package openapi
+
+import (
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	v1Controllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1"
+	v1AuditControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/audit"
+	v1AuthControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/auth"
+	v1LastheardControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/lastheard"
+	v1PeersControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/peers"
+	v1RepeatersControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/repeaters"
+	v1StatsControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/stats"
+	v1TalkgroupsControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/talkgroups"
+	v1TokensControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/tokens"
+	v1UsersControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/users"
+	v1WebhooksControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/webhooks"
+)
+
+// LoginResponse says whether a second factor is needed to finish logging in.
+type LoginResponse struct {
+	Message                string `json:"message"`
+	TOTPRequired           bool   `json:"totp_required,omitempty"`
+	TOTPEnrollmentRequired bool   `json:"totp_enrollment_required,omitempty"`
+}
+
+// RecoveryCodes are shown once, when they are generated.
+type RecoveryCodes struct {
+	Message       string   `json:"message,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Created is the response of creating a repeater or peer.
+type Created struct {
+	Message string `json:"message"`
+	// Password is only ever shown when Created
+	Password string `json:"password"`
+}
+
+// CallList is a page of calls. NextCursor is set when the page is full, and
+// Total is left out of pages fetched with a cursor.
+type CallList struct {
+	Total      *int          `json:"total,omitempty"`
+	Calls      []models.Call `json:"calls"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+type UserList struct {
+	Total int           `json:"total"`
+	Users []models.User `json:"users"`
+}
+
+type RepeaterList struct {
+	Total     int               `json:"total"`
+	Repeaters []models.Repeater `json:"repeaters"`
+}
+
+type TalkgroupList struct {
+	Total      int                `json:"total"`
+	Talkgroups []models.Talkgroup `json:"talkgroups"`
+}
+
+type PeerList struct {
+	Total int           `json:"total"`
+	Peers []models.Peer `json:"peers"`
+}
+
+type statsWindow struct {
+	Window string    `json:"window"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+}
+
+var statsQuery = []Param{
+	{Name: "window", Description: "One of 1h, 24h, 7d or 30d, 24h by default", Type: ""},
+	{Name: "limit", Description: "Between 1 and 50, 10 by default", Type: 0},
+}
+
+var callFilters = []Param{
+	{Name: "since", Description: "Only calls starting at or after this RFC 3339 time", Type: time.Time{}},
+	{Name: "until", Description: "Only calls starting before this RFC 3339 time", Type: time.Time{}},
+	{Name: "user_id", Description: "Only calls from this user", Type: uint(0)},
+	{Name: "callsign", Description: "Only calls from this callsign", Type: ""},
+	{Name: "talkgroup_id", Description: "Only calls to this talkgroup", Type: uint(0)},
+	{Name: "repeater_id", Description: "Only calls through this repeater", Type: uint(0)},
+	{Name: "type", Description: "group or private", Type: ""},
+	{Name: "min_duration", Description: "Only calls at least this long, such as 5s", Type: ""},
+	{Name: "min_loss", Description: "Minimum packet loss, between 0 and 1", Type: float32(0)},
+	{Name: "max_loss", Description: "Maximum packet loss, between 0 and 1", Type: float32(0)},
+	{Name: "min_ber", Description: "Minimum bit error rate, between 0 and 1", Type: float32(0)},
+	{Name: "max_ber", Description: "Maximum bit error rate, between 0 and 1", Type: float32(0)},
+	{Name: "sort", Description: "start_time, duration, loss or ber", Type: models.CallSort("")},
+	{Name: "order", Description: "asc or desc, desc by default", Type: ""},
+	{Name: "cursor", Description: "The next_cursor of the previous page, used instead of page", Type: ""},
+}
+
+var exportQuery = append([]Param{{Name: "format", Description: "csv or adif, csv by default", Type: ""}}, callFilters...)
+
+// Operations documents every route of the v1 API.
+var Operations = []Operation{
+	{Method: "GET", Path: "/openapi.json", Handler: GETOpenAPI, Tag: "meta", Access: AccessPublic,
+		Summary: "This document", Response: map[string]any{}},
+	{Method: "GET", Path: "/features", Handler: v1Controllers.GETFeatures, Tag: "meta", Access: AccessPublic,
+		Summary: "Features enabled on this server", Response: struct {
+			Features []string `json:"features"`
+			OIDC     bool     `json:"oidc"`
+			Email    bool     `json:"email"`
+		}{}},
+	{Method: "GET", Path: "/network/name", Handler: v1Controllers.GETNetworkName, Tag: "meta", Access: AccessPublic,
+		Summary: "Name of the network", Response: "", ContentType: "text/plain"},
+	{Method: "GET", Path: "/version", Handler: v1Controllers.GETVersion, Tag: "meta", Access: AccessPublic,
+		Summary: "Version and commit of the server", Response: "", ContentType: "text/plain"},
+	{Method: "GET", Path: "/ping", Handler: v1Controllers.GETPing, Tag: "meta", Access: AccessPublic,
+		Summary: "Current Unix time of the server", Response: "", ContentType: "text/plain"},
+
+	{Method: "POST", Path: "/auth/login", Handler: v1AuthControllers.POSTLogin, Tag: "auth", Access: AccessPublic,
+		Summary: "Log in with a username or callsign", Request: apimodels.AuthLogin{}, Response: LoginResponse{}},
+	{Method: "POST", Path: "/auth/login/totp", Handler: v1AuthControllers.POSTLoginTOTP, Tag: "auth", Access: AccessPublic,
+		Summary: "Finish logging in with a two-factor code", Request: apimodels.AuthTOTP{}, Response: LoginResponse{}},
+	{Method: "GET", Path: "/auth/logout", Handler: v1AuthControllers.GETLogout, Tag: "auth", Access: AccessPublic,
+		Summary: "Log out", Response: Message{}},
+	{Method: "POST", Path: "/auth/password/forgot", Handler: v1AuthControllers.POSTPasswordForgot, Tag: "auth", Access: AccessPublic,
+		Summary: "Email a password reset link", Request: apimodels.PasswordResetRequest{}, Response: Message{}},
+	{Method: "POST", Path: "/auth/password/reset", Handler: v1AuthControllers.POSTPasswordReset, Tag: "auth", Access: AccessPublic,
+		Summary: "Reset a password with an emailed token", Request: apimodels.PasswordReset{}, Response: Message{}},
+	{Method: "GET", Path: "/auth/oidc/login", Handler: v1AuthControllers.GETOIDCLogin, Tag: "auth", Access: AccessPublic,
+		Summary: "Start logging in with the single sign-on provider", Redirect: true},
+	{Method: "GET", Path: "/auth/oidc/callback", Handler: v1AuthControllers.GETOIDCCallback, Tag: "auth", Access: AccessPublic,
+		Summary: "Finish logging in with the single sign-on provider", Redirect: true},
+	{Method: "POST", Path: "/auth/totp/setup", Handler: v1AuthControllers.POSTTOTPSetup, Tag: "auth", Access: AccessLogin,
+		Summary: "Generate a two-factor secret", Response: apimodels.TOTPSetupResponse{}},
+	{Method: "POST", Path: "/auth/totp/enable", Handler: v1AuthControllers.POSTTOTPEnable, Tag: "auth", Access: AccessLogin,
+		Summary: "Enable two-factor authentication", Request: apimodels.AuthTOTP{}, Response: RecoveryCodes{}},
+	{Method: "POST", Path: "/auth/totp/disable", Handler: v1AuthControllers.POSTTOTPDisable, Tag: "auth", Access: AccessLogin,
+		Summary: "Disable two-factor authentication", Request: apimodels.AuthTOTP{}, Response: Message{}},
+	{Method: "POST", Path: "/auth/totp/recovery-codes", Handler: v1AuthControllers.POSTTOTPRecoveryCodes, Tag: "auth", Access: AccessLogin,
+		Summary: "Replace the two-factor recovery codes", Request: apimodels.AuthTOTP{}, Response: RecoveryCodes{}},
+
+	{Method: "GET", Path: "/repeaters", Handler: v1RepeatersControllers.GETRepeaters, Tag: "repeaters", Access: AccessAdmin,
+		Summary: "List all repeaters", Paginated: true, Response: RepeaterList{}},
+	{Method: "GET", Path: "/repeaters/my", Handler: v1RepeatersControllers.GETMyRepeaters, Tag: "repeaters", Access: AccessLogin,
+		Summary: "List your repeaters", Paginated: true, Response: RepeaterList{}},
+	{Method: "GET", Path: "/repeaters/map", Handler: v1RepeatersControllers.GETRepeaterMap, Tag: "repeaters", Access: AccessPublic,
+		Summary: "Repeater positions as GeoJSON", Response: apimodels.RepeaterMap{}, ContentType: "application/geo+json"},
+	{Method: "POST", Path: "/repeaters", Handler: v1RepeatersControllers.POSTRepeater, Tag: "repeaters", Access: AccessLogin,
+		Summary: "Register a repeater or hotspot", Request: apimodels.RepeaterPost{}, Response: Created{}},
+	{Method: "POST", Path: "/repeaters/:id/link/:type/:slot/:target", Handler: v1RepeatersControllers.POSTRepeaterLink, Tag: "repeaters", Access: AccessOwnerOrAdmin,
+		Summary: "Link a talkgroup to a timeslot, with type static or dynamic", Response: Message{}},
+	{Method: "POST", Path: "/repeaters/:id/unlink/:type/:slot/:target", Handler: v1RepeatersControllers.POSTRepeaterUnlink, Tag: "repeaters", Access: AccessOwnerOrAdmin,
+		Summary: "Unlink a talkgroup from a timeslot", Response: Message{}},
+	{Method: "POST", Path: "/repeaters/:id/talkgroups", Handler: v1RepeatersControllers.POSTRepeaterTalkgroups, Tag: "repeaters", Access: AccessOwnerOrAdmin,
+		Summary: "Replace the talkgroups of a repeater", Request: apimodels.RepeaterTalkgroupsPost{}, Response: Message{}},
+	{Method: "POST", Path: "/repeaters/:id/map-privacy", Handler: v1RepeatersControllers.POSTRepeaterMapPrivacy, Tag: "repeaters", Access: AccessOwnerOrAdmin,
+		Summary: "Set how a repeater is shown on the map", Request: apimodels.RepeaterMapPrivacyPost{}, Response: Message{}},
+	{Method: "GET", Path: "/repeaters/:id", Handler: v1RepeatersControllers.GETRepeater, Tag: "repeaters", Access: AccessLogin,
+		Summary: "Get a repeater", Response: models.Repeater{}},
+	{Method: "DELETE", Path: "/repeaters/:id", Handler: v1RepeatersControllers.DELETERepeater, Tag: "repeaters", Access: AccessOwnerOrAdmin,
+		Summary: "Delete a repeater", Response: Message{}},
+	{Method: "GET", Path: "/repeaters/:id/alerts", Handler: v1RepeatersControllers.GETRepeaterAlerts, Tag: "repeaters", Access: AccessOwnerOrAdmin,
+		Summary: "List your alerts for a repeater", Response: struct {
+			Alerts []models.RepeaterAlert `json:"alerts"`
+		}{}},
+	{Method: "POST", Path: "/repeaters/:id/alerts", Handler: v1RepeatersControllers.POSTRepeaterAlert, Tag: "repeaters", Access: AccessOwnerOrAdmin,
+		Summary: "Subscribe to offline and online alerts for a repeater", Request: apimodels.RepeaterAlertPost{}, Response: struct {
+			Message string               `json:"message"`
+			Alert   models.RepeaterAlert `json:"alert"`
+			// WebhookSecret signs the alert's webhook requests, it is only ever shown when Created
+			WebhookSecret string `json:"webhook_secret,omitempty"`
+		}{}},
+	{Method: "DELETE", Path: "/repeaters/:id/alerts/:alertID", Handler: v1RepeatersControllers.DELETERepeaterAlert, Tag: "repeaters", Access: AccessOwnerOrAdmin,
+		Summary: "Delete an alert", Response: Message{}},
+
+	{Method: "GET", Path: "/talkgroups", Handler: v1TalkgroupsControllers.GETTalkgroups, Tag: "talkgroups", Access: AccessLogin,
+		Summary: "List all talkgroups", Paginated: true, Response: TalkgroupList{}},
+	{Method: "GET", Path: "/talkgroups/my", Handler: v1TalkgroupsControllers.GETMyTalkgroups, Tag: "talkgroups", Access: AccessLogin,
+		Summary: "List the talkgroups you administer", Paginated: true, Response: TalkgroupList{}},
+	{Method: "POST", Path: "/talkgroups", Handler: v1TalkgroupsControllers.POSTTalkgroup, Tag: "talkgroups", Access: AccessAdmin,
+		Summary: "Create a talkgroup", Request: apimodels.TalkgroupPost{}, Response: Message{}},
+	{Method: "POST", Path: "/talkgroups/:id/admins", Handler: v1TalkgroupsControllers.POSTTalkgroupAdmins, Tag: "talkgroups", Access: AccessAdmin,
+		Summary: "Replace the admins of a talkgroup", Request: apimodels.TalkgroupAdminAction{}, Response: Message{}},
+	{Method: "POST", Path: "/talkgroups/:id/ncos", Handler: v1TalkgroupsControllers.POSTTalkgroupNCOs, Tag: "talkgroups", Access: AccessTalkgroupOwner,
+		Summary: "Replace the net control operators of a talkgroup", Request: apimodels.TalkgroupAdminAction{}, Response: Message{}},
+	{Method: "GET", Path: "/talkgroups/:id", Handler: v1TalkgroupsControllers.GETTalkgroup, Tag: "talkgroups", Access: AccessLogin,
+		Summary: "Get a talkgroup", Response: models.Talkgroup{}},
+	{Method: "PATCH", Path: "/talkgroups/:id", Handler: v1TalkgroupsControllers.PATCHTalkgroup, Tag: "talkgroups", Access: AccessTalkgroupOwner,
+		Summary: "Update a talkgroup", Request: apimodels.TalkgroupPatch{}},
+	{Method: "DELETE", Path: "/talkgroups/:id", Handler: v1TalkgroupsControllers.DELETETalkgroup, Tag: "talkgroups", Access: AccessAdmin,
+		Summary: "Delete a talkgroup", Response: Message{}},
+
+	{Method: "GET", Path: "/users", Handler: v1UsersControllers.GETUsers, Tag: "users", Access: AccessAdminOrTalkgroupOwner,
+		Summary: "List all users", Paginated: true, Response: UserList{}},
+	{Method: "POST", Path: "/users", Handler: v1UsersControllers.POSTUser, Tag: "users", Access: AccessPublic,
+		Summary: "Register", Request: apimodels.UserRegistration{}, Response: Message{}},
+	{Method: "GET", Path: "/users/me", Handler: v1UsersControllers.GETUserSelf, Tag: "users", Access: AccessLogin,
+		Summary: "Get yourself", Response: apimodels.UserWithEmail{}},
+	{Method: "POST", Path: "/users/email/verify", Handler: v1UsersControllers.POSTUserEmailVerify, Tag: "users", Access: AccessPublic,
+		Summary: "Verify an email address with an emailed token", Request: apimodels.EmailVerification{}, Response: Message{}},
+	{Method: "POST", Path: "/users/email/resend", Handler: v1UsersControllers.POSTUserEmailResend, Tag: "users", Access: AccessLogin,
+		Summary: "Resend the verification email", Response: Message{}},
+	{Method: "GET", Path: "/users/admins", Handler: v1UsersControllers.GETUserAdmins, Tag: "users", Access: AccessSuperAdmin,
+		Summary: "List admins", Paginated: true, Response: UserList{}},
+	{Method: "GET", Path: "/users/suspended", Handler: v1UsersControllers.GETUserSuspended, Tag: "users", Access: AccessAdmin,
+		Summary: "List suspended users", Paginated: true, Response: UserList{}},
+	{Method: "GET", Path: "/users/unapproved", Handler: v1UsersControllers.GETUserUnapproved, Tag: "users", Access: AccessAdmin,
+		Summary: "List users awaiting approval", Paginated: true, Response: UserList{}},
+	{Method: "POST", Path: "/users/promote/:id", Handler: v1UsersControllers.POSTUserPromote, Tag: "users", Access: AccessSuperAdmin,
+		Summary: "Make a user an admin", Response: Message{}},
+	{Method: "POST", Path: "/users/demote/:id", Handler: v1UsersControllers.POSTUserDemote, Tag: "users", Access: AccessSuperAdmin,
+		Summary: "Take admin away from a user", Response: Message{}},
+	{Method: "POST", Path: "/users/approve/:id", Handler: v1UsersControllers.POSTUserApprove, Tag: "users", Access: AccessAdmin,
+		Summary: "Approve a user", Response: Message{}},
+	{Method: "POST", Path: "/users/unsuspend/:id", Handler: v1UsersControllers.POSTUserUnsuspend, Tag: "users", Access: AccessAdmin,
+		Summary: "Unsuspend a user", Response: Message{}},
+	{Method: "POST", Path: "/users/suspend/:id", Handler: v1UsersControllers.POSTUserSuspend, Tag: "users", Access: AccessAdmin,
+		Summary: "Suspend a user", Response: Message{}},
+	{Method: "GET", Path: "/users/:id", Handler: v1UsersControllers.GETUser, Tag: "users", Access: AccessSelfOrAdmin,
+		Summary: "Get a user", Response: apimodels.UserWithEmail{}},
+	{Method: "PATCH", Path: "/users/:id", Handler: v1UsersControllers.PATCHUser, Tag: "users", Access: AccessSelfOrAdmin,
+		Summary: "Update a user", Request: apimodels.UserPatch{}, Response: Message{}},
+	{Method: "DELETE", Path: "/users/:id", Handler: v1UsersControllers.DELETEUser, Tag: "users", Access: AccessSuperAdmin,
+		Summary: "Delete a user", Response: Message{}},
+
+	{Method: "GET", Path: "/peers", Handler: v1PeersControllers.GETPeers, Tag: "peers", Access: AccessAdmin,
+		Summary: "List all OpenBridge peers", Paginated: true, Response: PeerList{}},
+	{Method: "GET", Path: "/peers/my", Handler: v1PeersControllers.GETMyPeers, Tag: "peers", Access: AccessLogin,
+		Summary: "List your OpenBridge peers", Paginated: true, Response: PeerList{}},
+	{Method: "POST", Path: "/peers", Handler: v1PeersControllers.POSTPeer, Tag: "peers", Access: AccessAdmin,
+		Summary: "Create an OpenBridge peer", Request: apimodels.PeerPost{}, Response: Created{}},
+	{Method: "GET", Path: "/peers/:id", Handler: v1PeersControllers.GETPeer, Tag: "peers", Access: AccessOwnerOrAdmin,
+		Summary: "Get an OpenBridge peer", Response: models.Peer{}},
+	{Method: "DELETE", Path: "/peers/:id", Handler: v1PeersControllers.DELETEPeer, Tag: "peers", Access: AccessOwnerOrAdmin,
+		Summary: "Delete an OpenBridge peer", Response: Message{}},
+
+	{Method: "GET", Path: "/tokens", Handler: v1TokensControllers.GETTokens, Tag: "tokens", Access: AccessLogin,
+		Summary: "List your API tokens", Response: struct {
+			Total  int                       `json:"total"`
+			Tokens []apimodels.TokenResponse `json:"tokens"`
+		}{}},
+	{Method: "POST", Path: "/tokens", Handler: v1TokensControllers.POSTToken, Tag: "tokens", Access: AccessLogin,
+		Summary: "Create an API token", Request: apimodels.TokenPost{}, Response: apimodels.TokenCreateResponse{}},
+	{Method: "DELETE", Path: "/tokens/:id", Handler: v1TokensControllers.DELETEToken, Tag: "tokens", Access: AccessLogin,
+		Summary: "Revoke an API token", Response: Message{}},
+
+	{Method: "GET", Path: "/webhooks", Handler: v1WebhooksControllers.GETWebhooks, Tag: "webhooks", Access: AccessAdmin,
+		Summary: "List webhooks and the events they can subscribe to", Paginated: true, Response: struct {
+			Total    int                 `json:"total"`
+			Webhooks []apimodels.Webhook `json:"webhooks"`
+			Events   []string            `json:"events"`
+		}{}},
+	{Method: "POST", Path: "/webhooks", Handler: v1WebhooksControllers.POSTWebhook, Tag: "webhooks", Access: AccessAdmin,
+		Summary: "Create a webhook", Request: apimodels.WebhookPost{}, Response: struct {
+			Message string            `json:"message"`
+			Webhook apimodels.Webhook `json:"webhook"`
+			// Secret is only ever shown when Created
+			Secret string `json:"secret"`
+		}{}},
+	{Method: "PATCH", Path: "/webhooks/:id", Handler: v1WebhooksControllers.PATCHWebhook, Tag: "webhooks", Access: AccessAdmin,
+		Summary: "Update a webhook", Request: apimodels.WebhookPatch{}, Response: struct {
+			Message string            `json:"message"`
+			Webhook apimodels.Webhook `json:"webhook"`
+		}{}},
+	{Method: "DELETE", Path: "/webhooks/:id", Handler: v1WebhooksControllers.DELETEWebhook, Tag: "webhooks", Access: AccessAdmin,
+		Summary: "Delete a webhook", Response: Message{}},
+	{Method: "GET", Path: "/webhooks/:id/deliveries", Handler: v1WebhooksControllers.GETWebhookDeliveries, Tag: "webhooks", Access: AccessAdmin,
+		Summary: "List the deliveries of a webhook", Paginated: true, Response: struct {
+			Total      int                      `json:"total"`
+			Deliveries []models.WebhookDelivery `json:"deliveries"`
+		}{}},
+
+	{Method: "GET", Path: "/stats/talkers", Handler: v1StatsControllers.GETStatsTalkers, Tag: "stats", Access: AccessLogin,
+		Summary: "Users with the most airtime", Query: statsQuery, Response: struct {
+			statsWindow
+			Talkers []models.CallActivity `json:"talkers"`
+		}{}},
+	{Method: "GET", Path: "/stats/talkgroups", Handler: v1StatsControllers.GETStatsTalkgroups, Tag: "stats", Access: AccessLogin,
+		Summary: "Talkgroups with the most airtime", Query: statsQuery, Response: struct {
+			statsWindow
+			Talkgroups []models.CallActivity `json:"talkgroups"`
+		}{}},
+	{Method: "GET", Path: "/stats/repeaters", Handler: v1StatsControllers.GETStatsRepeaters, Tag: "stats", Access: AccessLogin,
+		Summary: "Repeaters that carried the most airtime", Query: statsQuery, Response: struct {
+			statsWindow
+			Repeaters []models.CallActivity `json:"repeaters"`
+		}{}},
+	{Method: "GET", Path: "/stats/calls-per-hour", Handler: v1StatsControllers.GETStatsCallsPerHour, Tag: "stats", Access: AccessLogin,
+		Summary: "Calls started in each hour", Query: statsQuery[:1], Response: struct {
+			statsWindow
+			Hours []models.HourlyCalls `json:"hours"`
+		}{}},
+	{Method: "GET", Path: "/stats/quality", Handler: v1StatsControllers.GETStatsQuality, Tag: "stats", Access: AccessLogin,
+		Summary: "Average loss, jitter and bit error rate per repeater", Query: statsQuery[:1], Response: struct {
+			statsWindow
+			Quality []models.RepeaterQuality `json:"quality"`
+		}{}},
+
+	{Method: "GET", Path: "/audit", Handler: v1AuditControllers.GETAuditLog, Tag: "audit", Access: AccessSuperAdmin,
+		Summary: "The audit log of administrative actions, newest first", Paginated: true, Query: []Param{
+			{Name: "actor_id", Description: "Only actions taken by this user", Type: uint(0)},
+			{Name: "action", Description: "Only this action, such as user.promote", Type: ""},
+			{Name: "target_type", Description: "Only actions on this kind of target, such as user", Type: ""},
+			{Name: "target_id", Description: "Only actions on this target", Type: uint(0)},
+			{Name: "since", Description: "Only actions at or after this RFC 3339 time", Type: time.Time{}},
+			{Name: "until", Description: "Only actions before this RFC 3339 time", Type: time.Time{}},
+		}, Response: struct {
+			Total   int                  `json:"total"`
+			Entries []apimodels.AuditLog `json:"entries"`
+		}{}},
+
+	{Method: "GET", Path: "/lastheard", Handler: v1LastheardControllers.GETLastheard, Tag: "lastheard", Access: AccessPublic,
+		Summary:   "Recent talkgroup calls, and your private calls when logged in. An empty list is returned as []",
+		Paginated: true, Query: callFilters, Response: CallList{}},
+	{Method: "GET", Path: "/lastheard/export", Handler: v1LastheardControllers.GETLastheardExport, Tag: "lastheard", Access: AccessAdmin,
+		Summary: "Export every call as CSV or ADIF", Query: exportQuery, Response: "", ContentType: "text/csv"},
+	{Method: "GET", Path: "/lastheard/user/:id", Handler: v1LastheardControllers.GETLastheardUser, Tag: "lastheard", Access: AccessSelfOrAdmin,
+		Summary: "Calls made by or privately to a user", Paginated: true, Query: callFilters, Response: CallList{}},
+	{Method: "GET", Path: "/lastheard/user/:id/export", Handler: v1LastheardControllers.GETLastheardUserExport, Tag: "lastheard", Access: AccessSelfOrAdmin,
+		Summary: "Export the calls of a user as CSV or ADIF", Query: exportQuery, Response: "", ContentType: "text/csv"},
+	{Method: "GET", Path: "/lastheard/repeater/:id", Handler: v1LastheardControllers.GETLastheardRepeater, Tag: "lastheard", Access: AccessOwnerOrAdmin,
+		Summary: "Calls through or to a repeater", Paginated: true, Query: callFilters, Response: CallList{}},
+	{Method: "GET", Path: "/lastheard/talkgroup/:id", Handler: v1LastheardControllers.GETLastheardTalkgroup, Tag: "lastheard", Access: AccessLogin,
+		Summary: "Calls to a talkgroup", Paginated: true, Query: callFilters, Response: CallList{}},
+}
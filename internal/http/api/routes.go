@@ -24,22 +24,28 @@ import (
 
 	"github.com/USA-RedDragon/DMRHub/internal/config"
 	v1Controllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1"
+	v1AuditControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/audit"
 	v1AuthControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/auth"
 	v1LastheardControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/lastheard"
 	v1PeersControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/peers"
 	v1RepeatersControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/repeaters"
+	v1StatsControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/stats"
 	v1TalkgroupsControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/talkgroups"
+	v1TokensControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/tokens"
 	v1UsersControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/users"
+	v1WebhooksControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/controllers/v1/webhooks"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/middleware"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/openapi"
 	websocketControllers "github.com/USA-RedDragon/DMRHub/internal/http/api/websocket"
 	"github.com/USA-RedDragon/DMRHub/internal/http/websocket"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
 // ApplyRoutes to the HTTP Mux.
-func ApplyRoutes(router *gin.Engine, db *gorm.DB, redis *redis.Client, ratelimit gin.HandlerFunc, userSuspension gin.HandlerFunc) {
+func ApplyRoutes(router *gin.Engine, db *gorm.DB, store kv.KV, ps pubsub.PubSub, ratelimit gin.HandlerFunc, userSuspension gin.HandlerFunc) {
 	router.GET("/robots.txt", func(c *gin.Context) {
 		if config.GetConfig().AllowScraping {
 			if config.GetConfig().CustomRobotsTxt != "" {
@@ -51,34 +57,52 @@ func ApplyRoutes(router *gin.Engine, db *gorm.DB, redis *redis.Client, ratelimit
 		}
 		c.String(http.StatusOK, "User-agent: *\nDisallow: /")
 	})
+	router.GET("/healthz", v1Controllers.GETHealthz)
+	router.GET("/readyz", v1Controllers.GETReadyz)
 	apiV1 := router.Group("/api/v1")
 	apiV1.Use(ratelimit)
+	apiV1.Use(middleware.TokenAuth())
 	v1(apiV1, userSuspension)
 
 	ws := router.Group("/ws")
 	ws.Use(ratelimit)
-	ws.GET("/repeaters", middleware.RequireLogin(), userSuspension, websocket.CreateHandler(websocketControllers.CreateRepeatersWebsocket(db, redis)))
-	ws.GET("/calls", websocket.CreateHandler(websocketControllers.CreateCallsWebsocket(db, redis)))
-	ws.GET("/peers", websocket.CreateHandler(websocketControllers.CreatePeersWebsocket(db, redis)))
+	ws.GET("/repeaters", middleware.RequireLogin(), userSuspension, websocket.CreateHandler(websocketControllers.CreateRepeatersWebsocket(db, store, ps)))
+	ws.GET("/calls", websocket.CreateHandler(websocketControllers.CreateCallsWebsocket(db, ps)))
+	ws.GET("/peers", middleware.RequireLogin(), userSuspension, websocket.CreateHandler(websocketControllers.CreatePeersWebsocket(db, store, ps)))
 }
 
 func v1(group *gin.RouterGroup, userSuspension gin.HandlerFunc) {
 	group.GET("/features", v1Controllers.GETFeatures)
 	v1Auth := group.Group("/auth")
 	v1Auth.POST("/login", v1AuthControllers.POSTLogin)
+	v1Auth.POST("/login/totp", v1AuthControllers.POSTLoginTOTP)
 	v1Auth.GET("/logout", v1AuthControllers.GETLogout)
+	v1Auth.POST("/password/forgot", v1AuthControllers.POSTPasswordForgot)
+	v1Auth.POST("/password/reset", v1AuthControllers.POSTPasswordReset)
+	v1Auth.GET("/oidc/login", v1AuthControllers.GETOIDCLogin)
+	v1Auth.GET("/oidc/callback", v1AuthControllers.GETOIDCCallback)
+	v1Auth.POST("/totp/setup", middleware.RequireLogin(), userSuspension, v1AuthControllers.POSTTOTPSetup)
+	v1Auth.POST("/totp/enable", middleware.RequireLogin(), userSuspension, v1AuthControllers.POSTTOTPEnable)
+	v1Auth.POST("/totp/disable", middleware.RequireLogin(), userSuspension, v1AuthControllers.POSTTOTPDisable)
+	v1Auth.POST("/totp/recovery-codes", middleware.RequireLogin(), userSuspension, v1AuthControllers.POSTTOTPRecoveryCodes)
 
 	v1Repeaters := group.Group("/repeaters")
 	// Paginated
 	v1Repeaters.GET("", middleware.RequireAdmin(), userSuspension, v1RepeatersControllers.GETRepeaters)
 	// Paginated
 	v1Repeaters.GET("/my", middleware.RequireLogin(), userSuspension, v1RepeatersControllers.GETMyRepeaters)
+	// Public GeoJSON of repeater positions
+	v1Repeaters.GET("/map", v1RepeatersControllers.GETRepeaterMap)
 	v1Repeaters.POST("", middleware.RequireLogin(), userSuspension, v1RepeatersControllers.POSTRepeater)
 	v1Repeaters.POST("/:id/link/:type/:slot/:target", middleware.RequireRepeaterOwnerOrAdmin(), userSuspension, v1RepeatersControllers.POSTRepeaterLink)
 	v1Repeaters.POST("/:id/unlink/:type/:slot/:target", middleware.RequireRepeaterOwnerOrAdmin(), userSuspension, v1RepeatersControllers.POSTRepeaterUnlink)
 	v1Repeaters.POST("/:id/talkgroups", middleware.RequireRepeaterOwnerOrAdmin(), userSuspension, v1RepeatersControllers.POSTRepeaterTalkgroups)
+	v1Repeaters.POST("/:id/map-privacy", middleware.RequireRepeaterOwnerOrAdmin(), userSuspension, v1RepeatersControllers.POSTRepeaterMapPrivacy)
 	v1Repeaters.GET("/:id", middleware.RequireLogin(), userSuspension, v1RepeatersControllers.GETRepeater)
 	v1Repeaters.DELETE("/:id", middleware.RequireRepeaterOwnerOrAdmin(), userSuspension, v1RepeatersControllers.DELETERepeater)
+	v1Repeaters.GET("/:id/alerts", middleware.RequireRepeaterOwnerOrAdmin(), userSuspension, v1RepeatersControllers.GETRepeaterAlerts)
+	v1Repeaters.POST("/:id/alerts", middleware.RequireRepeaterOwnerOrAdmin(), userSuspension, v1RepeatersControllers.POSTRepeaterAlert)
+	v1Repeaters.DELETE("/:id/alerts/:alertID", middleware.RequireRepeaterOwnerOrAdmin(), userSuspension, v1RepeatersControllers.DELETERepeaterAlert)
 
 	v1Talkgroups := group.Group("/talkgroups")
 	// Paginated
@@ -97,6 +121,8 @@ func v1(group *gin.RouterGroup, userSuspension gin.HandlerFunc) {
 	v1Users.GET("", middleware.RequireAdminOrTGOwner(), userSuspension, v1UsersControllers.GETUsers)
 	v1Users.POST("", v1UsersControllers.POSTUser)
 	v1Users.GET("/me", middleware.RequireLogin(), userSuspension, v1UsersControllers.GETUserSelf)
+	v1Users.POST("/email/verify", v1UsersControllers.POSTUserEmailVerify)
+	v1Users.POST("/email/resend", middleware.RequireLogin(), userSuspension, v1UsersControllers.POSTUserEmailResend)
 	// Paginated
 	v1Users.GET("/admins", middleware.RequireSuperAdmin(), userSuspension, v1UsersControllers.GETUserAdmins)
 	// Paginated
@@ -120,17 +146,47 @@ func v1(group *gin.RouterGroup, userSuspension gin.HandlerFunc) {
 	v1Peers.GET("/:id", middleware.RequirePeerOwnerOrAdmin(), v1PeersControllers.GETPeer)
 	v1Peers.DELETE("/:id", middleware.RequirePeerOwnerOrAdmin(), v1PeersControllers.DELETEPeer)
 
+	v1Tokens := group.Group("/tokens")
+	v1Tokens.GET("", middleware.RequireLogin(), userSuspension, v1TokensControllers.GETTokens)
+	v1Tokens.POST("", middleware.RequireLogin(), userSuspension, v1TokensControllers.POSTToken)
+	v1Tokens.DELETE("/:id", middleware.RequireLogin(), userSuspension, v1TokensControllers.DELETEToken)
+
+	v1Webhooks := group.Group("/webhooks")
+	// Paginated
+	v1Webhooks.GET("", middleware.RequireAdmin(), userSuspension, v1WebhooksControllers.GETWebhooks)
+	v1Webhooks.POST("", middleware.RequireAdmin(), userSuspension, v1WebhooksControllers.POSTWebhook)
+	v1Webhooks.PATCH("/:id", middleware.RequireAdmin(), userSuspension, v1WebhooksControllers.PATCHWebhook)
+	v1Webhooks.DELETE("/:id", middleware.RequireAdmin(), userSuspension, v1WebhooksControllers.DELETEWebhook)
+	// Paginated
+	v1Webhooks.GET("/:id/deliveries", middleware.RequireAdmin(), userSuspension, v1WebhooksControllers.GETWebhookDeliveries)
+
+	v1Stats := group.Group("/stats")
+	v1Stats.GET("/talkers", middleware.RequireLogin(), userSuspension, v1StatsControllers.GETStatsTalkers)
+	v1Stats.GET("/talkgroups", middleware.RequireLogin(), userSuspension, v1StatsControllers.GETStatsTalkgroups)
+	v1Stats.GET("/repeaters", middleware.RequireLogin(), userSuspension, v1StatsControllers.GETStatsRepeaters)
+	v1Stats.GET("/calls-per-hour", middleware.RequireLogin(), userSuspension, v1StatsControllers.GETStatsCallsPerHour)
+	v1Stats.GET("/quality", middleware.RequireLogin(), userSuspension, v1StatsControllers.GETStatsQuality)
+
+	// Paginated
+	group.GET("/audit", middleware.RequireSuperAdmin(), userSuspension, v1AuditControllers.GETAuditLog)
+
 	v1Lastheard := group.Group("/lastheard")
 	// Returns the lastheard data for the server, adds personal data if logged in
 	// Paginated
 	v1Lastheard.GET("", v1LastheardControllers.GETLastheard)
+	// Streams every call on the network as CSV or ADIF
+	v1Lastheard.GET("/export", middleware.RequireAdmin(), userSuspension, v1LastheardControllers.GETLastheardExport)
 	// Paginated
 	v1Lastheard.GET("/user/:id", middleware.RequireSelfOrAdmin(), userSuspension, v1LastheardControllers.GETLastheardUser)
+	// Streams a user's calls as CSV or ADIF
+	v1Lastheard.GET("/user/:id/export", middleware.RequireSelfOrAdmin(), userSuspension, v1LastheardControllers.GETLastheardUserExport)
 	// Paginated
 	v1Lastheard.GET("/repeater/:id", middleware.RequireRepeaterOwnerOrAdmin(), userSuspension, v1LastheardControllers.GETLastheardRepeater)
 	// Paginated
 	v1Lastheard.GET("/talkgroup/:id", middleware.RequireLogin(), userSuspension, v1LastheardControllers.GETLastheardTalkgroup)
 
+	// Described by internal/http/api/openapi, update it with go generate when changing a route
+	group.GET("/openapi.json", openapi.GETOpenAPI)
 	group.GET("/network/name", v1Controllers.GETNetworkName)
 	group.GET("/version", v1Controllers.GETVersion)
 	group.GET("/ping", v1Controllers.GETPing)
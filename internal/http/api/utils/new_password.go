@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package utils
+
+import (
+	"crypto/sha1" //#nosec G505 -- False positive, we are not using this for crypto, just HIBP
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/gin-gonic/gin"
+	gopwned "github.com/mavjs/goPwned"
+)
+
+var (
+	ErrBlankPassword  = errors.New("password cannot be blank")
+	ErrPwnedPassword  = errors.New("password has been reported in a data breach")
+	ErrPwnedRateLimit = errors.New("too many requests to Have I Been Pwned")
+)
+
+// CheckNewPassword applies the rules every newly chosen password has to pass,
+// whether it is set when registering or when resetting a forgotten password.
+// Breached passwords are only looked for when a HIBP API key is configured.
+func CheckNewPassword(password string, hibpAPIKey string) error {
+	if password == "" {
+		return ErrBlankPassword
+	}
+	if hibpAPIKey == "" {
+		return nil
+	}
+
+	goPwned := gopwned.NewClient(nil, hibpAPIKey)
+	h := sha1.New() //#nosec G401 -- False positive, we are not using this for crypto, just HIBP
+	h.Write([]byte(password))
+	sha1HashedPW := fmt.Sprintf("%X", h.Sum(nil))
+	frange := sha1HashedPW[0:5]
+	lrange := sha1HashedPW[5:40]
+	karray, err := goPwned.GetPwnedPasswords(frange, false)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "Too many requests") {
+			return ErrPwnedRateLimit
+		}
+		return fmt.Errorf("error getting pwned passwords: %w", err)
+	}
+
+	for _, resp := range strings.Split(string(karray), "\r\n") {
+		strArray := strings.Split(resp, ":")
+		if len(strArray) != 2 { //nolint:golint,mnd
+			continue
+		}
+		count, err := strconv.ParseInt(strArray[1], 0, 32)
+		if err != nil {
+			return fmt.Errorf("error parsing pwned password count: %w", err)
+		}
+		if strArray[0] == lrange && count > 0 {
+			return ErrPwnedPassword
+		}
+	}
+	return nil
+}
+
+// RespondNewPasswordError writes the response for a password CheckNewPassword refused.
+func RespondNewPasswordError(c *gin.Context, caller string, err error) {
+	switch {
+	case errors.Is(err, ErrBlankPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password cannot be blank"})
+	case errors.Is(err, ErrPwnedPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password has been reported in a data breach. Please use another one"})
+	case errors.Is(err, ErrPwnedRateLimit):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests. Please try again in one minute"})
+	default:
+		logging.Errorf("%s: Error checking pwned passwords: %v", caller, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting pwned passwords"})
+	}
+}
@@ -20,10 +20,24 @@
 package utils_test
 
 import (
+	"errors"
 	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/utils"
 )
 
 func TestNoop(t *testing.T) {
 	t.Parallel()
 	t.Log("Noop")
 }
+
+func TestCheckNewPassword(t *testing.T) {
+	t.Parallel()
+	if err := utils.CheckNewPassword("", ""); !errors.Is(err, utils.ErrBlankPassword) {
+		t.Errorf("Expected ErrBlankPassword, got %v", err)
+	}
+	// Without an API key breached passwords aren't looked for
+	if err := utils.CheckNewPassword("hunter22", ""); err != nil {
+		t.Errorf("Expected password to be accepted, got %v", err)
+	}
+}
@@ -21,32 +21,133 @@ package websocket
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"time"
 
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/dmr/servers/openbridge"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
 	"github.com/USA-RedDragon/DMRHub/internal/http/websocket"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
 	"github.com/gin-contrib/sessions"
-	"github.com/redis/go-redis/v9"
+	gorillaWebsocket "github.com/gorilla/websocket"
 	"gorm.io/gorm"
 )
 
 type PeersWebsocket struct {
 	websocket.Websocket
-	redis *redis.Client
-	db    *gorm.DB
+	kv     kv.KV
+	pubsub pubsub.PubSub
+	db     *gorm.DB
 }
 
-func CreatePeersWebsocket(db *gorm.DB, redis *redis.Client) *PeersWebsocket {
+// peerVisibilityTTL is how long a connection trusts its cached view of who owns a peer.
+const peerVisibilityTTL = 30 * time.Second
+
+// peerVisibility is whether the user of a connection may see a peer, as of checkedAt.
+type peerVisibility struct {
+	visible   bool
+	checkedAt time.Time
+}
+
+func CreatePeersWebsocket(db *gorm.DB, store kv.KV, ps pubsub.PubSub) *PeersWebsocket {
 	return &PeersWebsocket{
-		redis: redis,
-		db:    db,
+		kv:     store,
+		pubsub: ps,
+		db:     db,
 	}
 }
 
 func (c *PeersWebsocket) OnMessage(_ context.Context, _ *http.Request, _ websocket.Writer, _ sessions.Session, _ []byte, _ int) {
 }
 
-func (c *PeersWebsocket) OnConnect(_ context.Context, _ *http.Request, _ websocket.Writer, _ sessions.Session) {
+func (c *PeersWebsocket) OnConnect(ctx context.Context, _ *http.Request, w websocket.Writer, session sessions.Session) {
+	userIDIface := session.Get("user_id")
+	if userIDIface == nil {
+		return
+	}
+	userID, ok := userIDIface.(uint)
+	if !ok {
+		logging.Errorf("Failed to convert user ID to uint")
+		return
+	}
+	user, err := models.FindUserByID(c.db, userID)
+	if err != nil {
+		logging.Errorf("Failed to find user %d: %v", userID, err)
+		return
+	}
+
+	// Subscribe before sending the snapshot so that no update is missed in between
+	subscription := c.pubsub.Subscribe(ctx, openbridge.StatusChannel)
+
+	statuses, err := c.kv.HGetAll(ctx, openbridge.StatusKey)
+	if err != nil {
+		logging.Errorf("Failed to get peer statuses: %v", err)
+	}
+	visible := make(map[uint]peerVisibility)
+	for _, status := range statuses {
+		c.sendStatus(w, user, visible, status)
+	}
+
+	go func() {
+		defer func() {
+			err := subscription.Close()
+			if err != nil {
+				logging.Errorf("Failed to close pubsub: %v", err)
+			}
+		}()
+		channel := subscription.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-channel:
+				if !ok {
+					return
+				}
+				c.sendStatus(w, user, visible, msg.Payload)
+			}
+		}
+	}()
 }
 
 func (c *PeersWebsocket) OnDisconnect(_ context.Context, _ *http.Request, _ sessions.Session) {
 }
+
+// canSee reports whether the user may see the peer, caching the answer for the connection.
+func (c *PeersWebsocket) canSee(user models.User, visible map[uint]peerVisibility, peerID uint) bool {
+	cached, ok := visible[peerID]
+	if ok && time.Since(cached.checkedAt) < peerVisibilityTTL {
+		return cached.visible
+	}
+
+	canSee := models.PeerIDExists(c.db, peerID)
+	if canSee && !user.Admin {
+		peer := models.FindPeerByID(c.db, peerID)
+		canSee = peer.OwnerID == user.ID
+	}
+	visible[peerID] = peerVisibility{visible: canSee, checkedAt: time.Now()}
+	return canSee
+}
+
+// sendStatus writes the peer status to the websocket if the user is allowed to see it.
+func (c *PeersWebsocket) sendStatus(w websocket.Writer, user models.User, visible map[uint]peerVisibility, status []byte) {
+	var peerStatus apimodels.WSPeerStatusResponse
+	err := json.Unmarshal(status, &peerStatus)
+	if err != nil {
+		logging.Errorf("Failed to unmarshal peer status: %v", err)
+		return
+	}
+
+	if !c.canSee(user, visible, peerStatus.ID) {
+		return
+	}
+
+	w.WriteMessage(websocket.Message{
+		Type: gorillaWebsocket.TextMessage,
+		Data: status,
+	})
+}
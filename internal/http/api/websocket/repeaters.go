@@ -21,32 +21,200 @@ package websocket
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"time"
 
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/dmr/servers/hbrp"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
 	"github.com/USA-RedDragon/DMRHub/internal/http/websocket"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
 	"github.com/gin-contrib/sessions"
-	"github.com/redis/go-redis/v9"
+	gorillaWebsocket "github.com/gorilla/websocket"
 	"gorm.io/gorm"
 )
 
 type RepeatersWebsocket struct {
 	websocket.Websocket
-	redis *redis.Client
-	db    *gorm.DB
+	kv     kv.KV
+	pubsub pubsub.PubSub
+	db     *gorm.DB
 }
 
-func CreateRepeatersWebsocket(db *gorm.DB, redis *redis.Client) *RepeatersWebsocket {
+func CreateRepeatersWebsocket(db *gorm.DB, store kv.KV, ps pubsub.PubSub) *RepeatersWebsocket {
 	return &RepeatersWebsocket{
-		redis: redis,
-		db:    db,
+		kv:     store,
+		pubsub: ps,
+		db:     db,
 	}
 }
 
+// repeaterVisibilityTTL is how long a connection trusts its cached view of who owns
+// a repeater, so deleted and transferred repeaters drop out of view.
+const repeaterVisibilityTTL = 30 * time.Second
+
+// repeaterVisibility is whether the user of a connection may see a repeater, as of checkedAt.
+type repeaterVisibility struct {
+	visible   bool
+	checkedAt time.Time
+}
+
+// repeaterStates tracks the state last sent to a single websocket connection.
+type repeaterStates struct {
+	user    models.User
+	visible map[uint]repeaterVisibility
+	states  map[uint]*apimodels.WSRepeaterStateResponse
+}
+
 func (c *RepeatersWebsocket) OnMessage(_ context.Context, _ *http.Request, _ websocket.Writer, _ sessions.Session, _ []byte, _ int) {
 }
 
-func (c *RepeatersWebsocket) OnConnect(_ context.Context, _ *http.Request, _ websocket.Writer, _ sessions.Session) {
+func (c *RepeatersWebsocket) OnConnect(ctx context.Context, _ *http.Request, w websocket.Writer, session sessions.Session) {
+	userIDIface := session.Get("user_id")
+	if userIDIface == nil {
+		return
+	}
+	userID, ok := userIDIface.(uint)
+	if !ok {
+		logging.Errorf("Failed to convert user ID to uint")
+		return
+	}
+	user, err := models.FindUserByID(c.db, userID)
+	if err != nil {
+		logging.Errorf("Failed to find user %d: %v", userID, err)
+		return
+	}
+
+	states := &repeaterStates{
+		user:    user,
+		visible: make(map[uint]repeaterVisibility),
+		states:  make(map[uint]*apimodels.WSRepeaterStateResponse),
+	}
+
+	// Subscribe before sending the snapshot so that no update is missed in between
+	subscription := c.pubsub.Subscribe(ctx, hbrp.RepeaterStateChannel, "calls")
+
+	snapshot, err := c.kv.HGetAll(ctx, hbrp.RepeaterStateKey)
+	if err != nil {
+		logging.Errorf("Failed to get repeater states: %v", err)
+	}
+	for _, state := range snapshot {
+		c.handleState(w, states, state)
+	}
+
+	go func() {
+		defer func() {
+			err := subscription.Close()
+			if err != nil {
+				logging.Errorf("Failed to close pubsub: %v", err)
+			}
+		}()
+		channel := subscription.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-channel:
+				if !ok {
+					return
+				}
+				if msg.Channel == hbrp.RepeaterStateChannel {
+					c.handleState(w, states, msg.Payload)
+				} else {
+					c.handleCall(w, states, msg.Payload)
+				}
+			}
+		}
+	}()
 }
 
 func (c *RepeatersWebsocket) OnDisconnect(_ context.Context, _ *http.Request, _ sessions.Session) {
 }
+
+func (c *RepeatersWebsocket) canSee(states *repeaterStates, repeaterID uint) bool {
+	cached, ok := states.visible[repeaterID]
+	if ok && time.Since(cached.checkedAt) < repeaterVisibilityTTL {
+		return cached.visible
+	}
+
+	exists, err := models.RepeaterIDExists(c.db, repeaterID)
+	if err != nil {
+		logging.Errorf("Failed to check if repeater %d exists: %v", repeaterID, err)
+		return false
+	}
+	if exists && !states.user.Admin {
+		var repeater models.Repeater
+		err = c.db.Select("id", "owner_id").First(&repeater, repeaterID).Error
+		if err != nil {
+			logging.Errorf("Failed to find repeater %d: %v", repeaterID, err)
+			return false
+		}
+		exists = repeater.OwnerID == states.user.ID
+	}
+	states.visible[repeaterID] = repeaterVisibility{visible: exists, checkedAt: time.Now()}
+	if !exists {
+		delete(states.states, repeaterID)
+	}
+	return exists
+}
+
+// handleState forwards a repeater state published by the HBRP server.
+func (c *RepeatersWebsocket) handleState(w websocket.Writer, states *repeaterStates, data []byte) {
+	var state apimodels.WSRepeaterStateResponse
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		logging.Errorf("Failed to unmarshal repeater state: %v", err)
+		return
+	}
+	if !c.canSee(states, state.ID) {
+		return
+	}
+	states.states[state.ID] = &state
+	c.send(w, &state)
+}
+
+// handleCall updates the active transmission on a repeater slot as calls start and stop.
+func (c *RepeatersWebsocket) handleCall(w websocket.Writer, states *repeaterStates, data []byte) {
+	var call models.Call
+	err := json.Unmarshal(data, &call)
+	if err != nil {
+		logging.Errorf("Failed to unmarshal call: %v", err)
+		return
+	}
+	state, ok := states.states[call.Repeater.ID]
+	if !ok || !c.canSee(states, call.Repeater.ID) {
+		return
+	}
+
+	transmission := &state.TS1Transmission
+	if call.TimeSlot {
+		transmission = &state.TS2Transmission
+	}
+
+	switch {
+	case call.Active && (*transmission == nil || (*transmission).CallID != call.ID):
+		*transmission = hbrp.TransmissionFromCall(call)
+	case !call.Active && *transmission != nil && (*transmission).CallID == call.ID:
+		*transmission = nil
+	default:
+		return
+	}
+
+	state.Event = hbrp.RepeaterEventTransmission
+	c.send(w, state)
+}
+
+func (c *RepeatersWebsocket) send(w websocket.Writer, state *apimodels.WSRepeaterStateResponse) {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		logging.Errorf("Failed to marshal repeater state: %v", err)
+		return
+	}
+	w.WriteMessage(websocket.Message{
+		Type: gorillaWebsocket.TextMessage,
+		Data: stateJSON,
+	})
+}
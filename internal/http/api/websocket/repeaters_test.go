@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package websocket_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/dmr/servers/hbrp"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/apimodels"
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/websocket"
+	ws "github.com/USA-RedDragon/DMRHub/internal/http/websocket"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/gin-contrib/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// userSession is the session of a logged in user. Only Get is used by the websockets.
+type userSession struct {
+	sessions.Session
+	userID uint
+}
+
+func (s userSession) Get(key any) any {
+	if key == "user_id" {
+		return s.userID
+	}
+	return nil
+}
+
+type channelWriter chan ws.Message
+
+func (w channelWriter) WriteMessage(message ws.Message) {
+	w <- message
+}
+
+func (w channelWriter) Error(string) {}
+
+func TestRepeatersWebsocketSendsEveryUpdate(t *testing.T) {
+	t.Parallel()
+	db := testutils.OpenDB(t)
+	require.NoError(t, db.Create(&models.User{ID: 3191868, Callsign: "KI5VMF", Username: "jacob", Approved: true}).Error)
+	repeater := models.Repeater{OwnerID: 3191868}
+	repeater.ID = 319186801
+	repeater.Callsign = "KI5VMF"
+	require.NoError(t, db.Create(&repeater).Error)
+
+	ps := pubsub.NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer := make(channelWriter, 10)
+	websocket.CreateRepeatersWebsocket(db, kv.NewMemory(), ps).OnConnect(ctx, nil, writer, userSession{userID: 3191868})
+
+	for _, event := range []string{hbrp.RepeaterEventConnected, hbrp.RepeaterEventPing, hbrp.RepeaterEventPing} {
+		state, err := json.Marshal(apimodels.WSRepeaterStateResponse{ID: 319186801, Event: event, Connection: "YES"})
+		require.NoError(t, err)
+		require.NoError(t, ps.Publish(ctx, hbrp.RepeaterStateChannel, state))
+
+		select {
+		case message := <-writer:
+			var received apimodels.WSRepeaterStateResponse
+			require.NoError(t, json.Unmarshal(message.Data, &received))
+			assert.Equal(t, event, received.Event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the %s update to be sent", event)
+		}
+	}
+
+	// Repeaters of other owners are never sent
+	state, err := json.Marshal(apimodels.WSRepeaterStateResponse{ID: 311860, Event: hbrp.RepeaterEventConnected})
+	require.NoError(t, err)
+	require.NoError(t, ps.Publish(ctx, hbrp.RepeaterStateChannel, state))
+	select {
+	case message := <-writer:
+		t.Fatalf("Expected no update for another repeater, got %s", message.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
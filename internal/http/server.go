@@ -27,6 +27,7 @@ import (
 	"io"
 	"net/http"
 	"path"
+	"slices"
 	"strings"
 	"time"
 
@@ -35,12 +36,13 @@ import (
 	"github.com/USA-RedDragon/DMRHub/internal/http/api"
 	"github.com/USA-RedDragon/DMRHub/internal/http/api/middleware"
 	redisSessions "github.com/USA-RedDragon/DMRHub/internal/http/sessions"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"golang.org/x/sync/errgroup"
 	"gorm.io/gorm"
@@ -60,14 +62,14 @@ const debugWriteTimeout = 60 * time.Second
 const rateLimitRate = time.Second
 const rateLimitLimit = 10
 
-func MakeServer(db *gorm.DB, redisClient *redis.Client, version, commit string) Server {
+func MakeServer(db *gorm.DB, store kv.KV, ps pubsub.PubSub, version, commit string) Server {
 	if config.GetConfig().Debug {
 		gin.SetMode(gin.DebugMode)
 	} else {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	r := CreateRouter(db, redisClient, version, commit)
+	r := CreateRouter(db, store, ps, version, commit)
 
 	writeTimeout := defTimeout
 	if config.GetConfig().Debug {
@@ -95,7 +97,7 @@ func MakeServer(db *gorm.DB, redisClient *redis.Client, version, commit string)
 //go:embed frontend/dist/*
 var FS embed.FS
 
-func addMiddleware(r *gin.Engine, db *gorm.DB, redisClient *redis.Client, version, commit string) {
+func addMiddleware(r *gin.Engine, db *gorm.DB, store kv.KV, ps pubsub.PubSub, version, commit string) {
 	// Debug
 	if config.GetConfig().Debug {
 		pprof.Register(r)
@@ -110,23 +112,27 @@ func addMiddleware(r *gin.Engine, db *gorm.DB, redisClient *redis.Client, versio
 	// DBs
 	r.Use(middleware.DatabaseProvider(db))
 	r.Use(middleware.PaginatedDatabaseProvider(db, middleware.PaginationConfig{}))
-	r.Use(middleware.RedisProvider(redisClient))
+	r.Use(middleware.KVProvider(store))
+	r.Use(middleware.PubSubProvider(ps))
 
 	// CORS
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowCredentials = true
-	corsConfig.AllowOrigins = config.GetConfig().CORSHosts
+	// Checked on every request so that reloaded CORS hosts take effect immediately
+	corsConfig.AllowOriginFunc = func(origin string) bool {
+		return slices.Contains(config.GetConfig().CORSHosts, origin)
+	}
 	r.Use(cors.New(corsConfig))
 
 	// Sessions
-	sessionStore, _ := redisSessions.NewStore(redisClient, config.GetConfig().Secret, config.GetConfig().Secret)
+	sessionStore, _ := redisSessions.NewStore(store, config.GetConfig().Secret, config.GetConfig().Secret)
 	r.Use(sessions.Sessions("sessions", sessionStore))
 
 	// Versioning
 	r.Use(middleware.VersionProvider(version, commit))
 }
 
-func CreateRouter(db *gorm.DB, redisClient *redis.Client, version, commit string) *gin.Engine {
+func CreateRouter(db *gorm.DB, store kv.KV, ps pubsub.PubSub, version, commit string) *gin.Engine {
 	if config.GetConfig().Debug {
 		gin.SetMode(gin.DebugMode)
 	} else {
@@ -142,13 +148,9 @@ func CreateRouter(db *gorm.DB, redisClient *redis.Client, version, commit string
 		logging.Errorf("Failed setting trusted proxies: %v", err)
 	}
 
-	addMiddleware(r, db, redisClient, version, commit)
+	addMiddleware(r, db, store, ps, version, commit)
 
-	ratelimitStore := ratelimit.RedisStore(&ratelimit.RedisOptions{
-		RedisClient: redisClient,
-		Rate:        rateLimitRate,
-		Limit:       rateLimitLimit,
-	})
+	ratelimitStore := middleware.RateLimitStore(store, rateLimitRate, rateLimitLimit)
 	ratelimitMW := ratelimit.RateLimiter(ratelimitStore, &ratelimit.Options{
 		ErrorHandler: func(c *gin.Context, info ratelimit.Info) {
 			c.String(http.StatusTooManyRequests, "Too many requests. Try again in "+time.Until(info.ResetTime).String())
@@ -160,7 +162,7 @@ func CreateRouter(db *gorm.DB, redisClient *redis.Client, version, commit string
 
 	userLockoutMiddleware := middleware.SuspendedUserLockout()
 
-	api.ApplyRoutes(r, db, redisClient, ratelimitMW, userLockoutMiddleware)
+	api.ApplyRoutes(r, db, store, ps, ratelimitMW, userLockoutMiddleware)
 
 	addFrontendRoutes(r)
 
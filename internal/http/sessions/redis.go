@@ -6,14 +6,14 @@
 package sessions
 
 // This is a modified version of <https://github.com/gin-contrib/sessions>
-// to use a redis client instead of creating its own
+// to use the shared key-value store instead of creating its own redis client
 // See their license: <https://github.com/gin-contrib/sessions/blob/828f9855fb30f12d40251e5211df3be5c7973e8c/LICENSE>
 
 import (
 	"errors"
 
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
 	"github.com/gin-contrib/sessions"
-	"github.com/redis/go-redis/v9"
 )
 
 type Store interface {
@@ -24,7 +24,7 @@ var (
 	ErrCast = errors.New("unable to cast Store to *store")
 )
 
-// NewStore creates a new redis store.
+// NewStore creates a new session store.
 //
 // Keys are defined in pairs to allow key rotation, but the common case is to set a single
 // authentication key and optionally an encryption key.
@@ -35,7 +35,7 @@ var (
 //
 // It is recommended to use an authentication key with 32 or 64 bytes. The encryption key,
 // if set, must be either 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256 modes.
-func NewStore(db *redis.Client, keyPairs ...[]byte) (Store, error) {
+func NewStore(db kv.KV, keyPairs ...[]byte) (Store, error) {
 	s, err := NewRediStore(db, keyPairs...)
 	if err != nil {
 		return nil, err
@@ -58,7 +58,7 @@ func GetRedisStore(s Store) (*RediStore, error) {
 	return realStore.RediStore, nil
 }
 
-// SetKeyPrefix sets the key prefix in the redis database.
+// SetKeyPrefix sets the key prefix in the store.
 func SetKeyPrefix(s Store, prefix string) error {
 	rediStore, err := GetRedisStore(s)
 	if err != nil {
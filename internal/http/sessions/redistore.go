@@ -4,7 +4,7 @@
 // license that can be found in the LICENSE file.
 
 // This is a modified version of <https://github.com/boj/redistore>
-// to use a redis client instead of creating its own
+// to use the shared key-value store instead of creating its own redis client
 // See their license: <https://github.com/boj/redistore/blob/cd5dcc76aeff9ba06b0a924829fe24fd69cdd517/LICENSE>
 
 package sessions
@@ -21,13 +21,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
-	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/otel"
 )
 
-// Amount of time for cookies/redis keys to expire.
+// Amount of time for cookies and stored sessions to expire.
 var sessionExpire = 86400 * 30 //nolint:golint,gochecknoglobals
 
 // SessionSerializer provides an interface hook for alternative serializers.
@@ -46,12 +46,11 @@ var (
 	ErrUnmarshal        = errors.New("error unmarshaling session")
 	ErrSerialization    = errors.New("error serializing session")
 	ErrDeserialization  = errors.New("error deserializing session")
-	ErrClose            = errors.New("error closing session")
 	ErrGetSession       = errors.New("error getting session")
 	ErrDeletingSession  = errors.New("error deleting session")
 	ErrSavingSession    = errors.New("error saving session")
 	ErrCookieEncode     = errors.New("error encoding cookie")
-	ErrRedis            = errors.New("error with redis")
+	ErrStore            = errors.New("error with session store")
 	ErrSetExpiration    = errors.New("error setting expiration")
 )
 
@@ -112,12 +111,12 @@ func (s GobSerializer) Deserialize(d []byte, ss *sessions.Session) error {
 	return nil
 }
 
-// RediStore stores sessions in a redis backend.
+// RediStore stores sessions in Redis, or in memory on a single node.
 type RediStore struct {
-	DB            *redis.Client
+	DB            kv.KV
 	Codecs        []securecookie.Codec
 	Options       *sessions.Options // default configuration
-	DefaultMaxAge int               // default Redis TTL for a MaxAge == 0 session
+	DefaultMaxAge int               // default TTL for a MaxAge == 0 session
 	maxLength     int
 	keyPrefix     string
 	serializer    SessionSerializer
@@ -173,7 +172,7 @@ const defaultMaxAge = 20 * 60 // 20 minutes seems like a reasonable default
 const maxLength = 4096
 
 // NewRediStore instantiates a RediStore.
-func NewRediStore(db *redis.Client, keyPairs ...[]byte) (*RediStore, error) {
+func NewRediStore(db kv.KV, keyPairs ...[]byte) (*RediStore, error) {
 	rs := &RediStore{
 		DB:     db,
 		Codecs: securecookie.CodecsFromPairs(keyPairs...),
@@ -190,15 +189,6 @@ func NewRediStore(db *redis.Client, keyPairs ...[]byte) (*RediStore, error) {
 	return rs, err
 }
 
-// Close closes the underlying *redis.Pool.
-func (s *RediStore) Close() error {
-	err := s.DB.Close()
-	if err != nil {
-		return ErrClose
-	}
-	return nil
-}
-
 // Get returns a session for the given name after adding it to the registry.
 //
 // See gorilla/sessions FilesystemStore.Get().
@@ -259,12 +249,12 @@ func (s *RediStore) Save(r *http.Request, w http.ResponseWriter, session *sessio
 	return nil
 }
 
-// Delete removes the session from redis, and sets the cookie to expire.
+// Delete removes the session from the store, and sets the cookie to expire.
 //
 // WARNING: This method should be considered deprecated since it is not exposed via the gorilla/sessions interface.
 // Set session.Options.MaxAge = -1 and call Save instead. - July 18th, 2013.
 func (s *RediStore) Delete(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
-	if _, err := s.DB.Del(r.Context(), s.keyPrefix+session.ID).Result(); err != nil {
+	if _, err := s.DB.Delete(r.Context(), s.keyPrefix+session.ID); err != nil {
 		return ErrDeletingSession
 	}
 	// Set cookie to expire.
@@ -282,14 +272,14 @@ func (s *RediStore) Delete(r *http.Request, w http.ResponseWriter, session *sess
 func (s *RediStore) ping(ctx context.Context) (bool, error) {
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "RediStore.ping")
 	defer span.End()
-	data, err := s.DB.Ping(ctx).Result()
-	if err != nil || data != "PONG" {
-		return false, ErrRedis
+	err := s.DB.Ping(ctx)
+	if err != nil {
+		return false, ErrStore
 	}
 	return true, nil
 }
 
-// save stores the session in redis.
+// save stores the session.
 func (s *RediStore) save(ctx context.Context, session *sessions.Session) error {
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "RediStore.save")
 	defer span.End()
@@ -304,37 +294,37 @@ func (s *RediStore) save(ctx context.Context, session *sessions.Session) error {
 	if age == 0 {
 		age = time.Duration(s.DefaultMaxAge) * time.Second
 	}
-	_, err = s.DB.SetEx(ctx, s.keyPrefix+session.ID, b, age).Result()
+	err = s.DB.Set(ctx, s.keyPrefix+session.ID, b, age)
 	if err != nil {
 		return ErrSetExpiration
 	}
 	return nil
 }
 
-// load reads the session from redis.
+// load reads the session from the store.
 // returns true if there is a sessoin data in DB.
 func (s *RediStore) load(ctx context.Context, session *sessions.Session) (bool, error) {
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "RediStore.load")
 	defer span.End()
-	data, err := s.DB.Get(ctx, s.keyPrefix+session.ID).Result()
+	data, err := s.DB.Get(ctx, s.keyPrefix+session.ID)
 	if err != nil {
 		return false, ErrGetSession
 	}
 	if len(data) == 0 {
 		return false, nil // no data was associated with this key
 	}
-	err = s.serializer.Deserialize([]byte(data), session)
+	err = s.serializer.Deserialize(data, session)
 	if err != nil {
 		return false, ErrDeserialization
 	}
 	return true, nil
 }
 
-// delete removes keys from redis if MaxAge<0.
+// delete removes keys from the store if MaxAge<0.
 func (s *RediStore) delete(ctx context.Context, session *sessions.Session) error {
 	ctx, span := otel.Tracer("DMRHub").Start(ctx, "RediStore.delete")
 	defer span.End()
-	if _, err := s.DB.Del(ctx, s.keyPrefix+session.ID).Result(); err != nil {
+	if _, err := s.DB.Delete(ctx, s.keyPrefix+session.ID); err != nil {
 		return ErrDeletingSession
 	}
 	return nil
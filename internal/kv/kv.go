@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package kv is the key-value storage shared by the DMR servers and the HTTP API.
+// It is backed by Redis, or kept in memory when DMRHub runs as a single node.
+package kv
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("key not found")
+	ErrWrongType = errors.New("key holds a different kind of value")
+)
+
+// KV stores values, hashes and lists under keys that may expire.
+// A ttl of 0 stores a key without an expiry.
+type KV interface {
+	// Get returns the value of key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetNX sets key only if it does not exist, reporting whether it was set.
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	Has(ctx context.Context, key string) (bool, error)
+	// Delete removes key, reporting whether it existed.
+	Delete(ctx context.Context, key string) (bool, error)
+	// CompareAndDelete removes key only while it holds value, reporting whether it did.
+	CompareAndDelete(ctx context.Context, key string, value []byte) (bool, error)
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+	// Incr increments the counter at key, setting ttl when the counter is created.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Keys lists the keys starting with prefix.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+
+	HSet(ctx context.Context, key string, field string, value []byte) error
+	// HGet returns the value of field in the hash at key, or ErrNotFound.
+	HGet(ctx context.Context, key string, field string) ([]byte, error)
+	// HDel removes field from the hash at key, reporting whether it existed.
+	HDel(ctx context.Context, key string, field string) (bool, error)
+	HGetAll(ctx context.Context, key string) (map[string][]byte, error)
+
+	RPush(ctx context.Context, key string, value []byte) error
+	// LRange returns the whole list stored at key.
+	LRange(ctx context.Context, key string) ([][]byte, error)
+
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,314 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package kv
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired keys are dropped from memory.
+// Expired keys are never returned, this only bounds memory use.
+const sweepInterval = time.Minute
+
+type entry struct {
+	value   []byte
+	hash    map[string][]byte
+	list    [][]byte
+	expires time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+type memoryKV struct {
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+// NewMemory keeps keys in the memory of this process.
+// It can only be used when a single DMRHub instance is running.
+func NewMemory() KV {
+	return &memoryKV{
+		entries:   make(map[string]*entry),
+		lastSweep: time.Now(),
+	}
+}
+
+// lookup returns the live entry for key. The caller must hold the lock.
+func (m *memoryKV) lookup(key string) *entry {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if e.expired(time.Now()) {
+		delete(m.entries, key)
+		return nil
+	}
+	return e
+}
+
+// sweep drops expired keys at most once per sweepInterval. The caller must hold the lock.
+func (m *memoryKV) sweep() {
+	now := time.Now()
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, e := range m.entries {
+		if e.expired(now) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+func (m *memoryKV) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func (m *memoryKV) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if e == nil {
+		return nil, ErrNotFound
+	}
+	if e.value == nil {
+		return nil, ErrWrongType
+	}
+	return slices.Clone(e.value), nil
+}
+
+func (m *memoryKV) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+	if value == nil {
+		value = []byte{}
+	}
+	m.entries[key] = &entry{value: slices.Clone(value), expires: m.expiry(ttl)}
+	return nil
+}
+
+func (m *memoryKV) SetNX(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+	if m.lookup(key) != nil {
+		return false, nil
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	m.entries[key] = &entry{value: slices.Clone(value), expires: m.expiry(ttl)}
+	return true, nil
+}
+
+func (m *memoryKV) Has(_ context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lookup(key) != nil, nil
+}
+
+func (m *memoryKV) Delete(_ context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lookup(key) == nil {
+		return false, nil
+	}
+	delete(m.entries, key)
+	return true, nil
+}
+
+func (m *memoryKV) CompareAndDelete(_ context.Context, key string, value []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if e == nil || e.value == nil || !bytes.Equal(e.value, value) {
+		return false, nil
+	}
+	delete(m.entries, key)
+	return true, nil
+}
+
+func (m *memoryKV) Expire(_ context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if e == nil {
+		return nil
+	}
+	if ttl <= 0 {
+		delete(m.entries, key)
+		return nil
+	}
+	e.expires = m.expiry(ttl)
+	return nil
+}
+
+func (m *memoryKV) Incr(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+	e := m.lookup(key)
+	if e == nil {
+		e = &entry{value: []byte("0"), expires: m.expiry(ttl)}
+		m.entries[key] = e
+	}
+	if e.value == nil {
+		return 0, ErrWrongType
+	}
+	count, err := strconv.ParseInt(string(e.value), 10, 64)
+	if err != nil {
+		return 0, ErrWrongType
+	}
+	count++
+	e.value = strconv.AppendInt(nil, count, 10)
+	return count, nil
+}
+
+func (m *memoryKV) Keys(_ context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var keys []string
+	for key, e := range m.entries {
+		if strings.HasPrefix(key, prefix) && !e.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memoryKV) HSet(_ context.Context, key string, field string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if e == nil {
+		e = &entry{hash: make(map[string][]byte)}
+		m.entries[key] = e
+	}
+	if e.hash == nil {
+		return ErrWrongType
+	}
+	e.hash[field] = slices.Clone(value)
+	return nil
+}
+
+func (m *memoryKV) HGet(_ context.Context, key string, field string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if e == nil {
+		return nil, ErrNotFound
+	}
+	if e.hash == nil {
+		return nil, ErrWrongType
+	}
+	value, ok := e.hash[field]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(value), nil
+}
+
+func (m *memoryKV) HDel(_ context.Context, key string, field string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if e == nil {
+		return false, nil
+	}
+	if e.hash == nil {
+		return false, ErrWrongType
+	}
+	if _, ok := e.hash[field]; !ok {
+		return false, nil
+	}
+	delete(e.hash, field)
+	// Like Redis, a hash without fields stops existing
+	if len(e.hash) == 0 {
+		delete(m.entries, key)
+	}
+	return true, nil
+}
+
+func (m *memoryKV) HGetAll(_ context.Context, key string) (map[string][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	values := make(map[string][]byte)
+	e := m.lookup(key)
+	if e == nil {
+		return values, nil
+	}
+	if e.hash == nil {
+		return nil, ErrWrongType
+	}
+	for field, value := range e.hash {
+		values[field] = slices.Clone(value)
+	}
+	return values, nil
+}
+
+func (m *memoryKV) RPush(_ context.Context, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if e == nil {
+		e = &entry{list: [][]byte{}}
+		m.entries[key] = e
+	}
+	if e.list == nil {
+		return ErrWrongType
+	}
+	e.list = append(e.list, slices.Clone(value))
+	return nil
+}
+
+func (m *memoryKV) LRange(_ context.Context, key string) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if e == nil {
+		return [][]byte{}, nil
+	}
+	if e.list == nil {
+		return nil, ErrWrongType
+	}
+	values := make([][]byte, len(e.list))
+	for i, value := range e.list {
+		values[i] = slices.Clone(value)
+	}
+	return values, nil
+}
+
+func (m *memoryKV) Ping(_ context.Context) error {
+	return nil
+}
@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package kv_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
+)
+
+func TestMemoryValues(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := kv.NewMemory()
+
+	if _, err := store.Get(ctx, "missing"); !errors.Is(err, kv.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := store.Set(ctx, "hbrp:repeater:311860", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+	value, err := store.Get(ctx, "hbrp:repeater:311860")
+	if err != nil || string(value) != "value" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+	if has, _ := store.Has(ctx, "hbrp:repeater:311860"); !has {
+		t.Error("expected the key to exist")
+	}
+	if err := store.Set(ctx, "hbrp:repeater:311861", []byte("other"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "parrot:stream:1", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := store.Keys(ctx, "hbrp:repeater:")
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"hbrp:repeater:311860", "hbrp:repeater:311861"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if deleted, _ := store.Delete(ctx, "hbrp:repeater:311860"); !deleted {
+		t.Error("expected the key to be deleted")
+	}
+	if deleted, _ := store.Delete(ctx, "hbrp:repeater:311860"); deleted {
+		t.Error("expected a second delete to report nothing deleted")
+	}
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := kv.NewMemory()
+
+	if err := store.Set(ctx, "short", []byte("value"), 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "refreshed", []byte("value"), 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Expire(ctx, "refreshed", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if has, _ := store.Has(ctx, "short"); has {
+		t.Error("expected the key to have expired")
+	}
+	if has, _ := store.Has(ctx, "refreshed"); !has {
+		t.Error("expected the refreshed key to still exist")
+	}
+	if keys, _ := store.Keys(ctx, ""); !slices.Equal(keys, []string{"refreshed"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func TestMemoryIncr(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := kv.NewMemory()
+
+	for want := int64(1); want <= 3; want++ {
+		count, err := store.Incr(ctx, "ratelimit", 20*time.Millisecond)
+		if err != nil || count != want {
+			t.Fatalf("Incr = %d, %v, want %d", count, err, want)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if count, _ := store.Incr(ctx, "ratelimit", time.Second); count != 1 {
+		t.Errorf("expected the counter to restart after expiring, got %d", count)
+	}
+
+	_ = store.Set(ctx, "text", []byte("abc"), 0)
+	if _, err := store.Incr(ctx, "text", 0); !errors.Is(err, kv.ErrWrongType) {
+		t.Errorf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestMemoryHashesAndLists(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := kv.NewMemory()
+
+	_ = store.HSet(ctx, "hbrp:repeaters:state", "311860", []byte("a"))
+	_ = store.HSet(ctx, "hbrp:repeaters:state", "311861", []byte("b"))
+	_ = store.HSet(ctx, "hbrp:repeaters:state", "311860", []byte("c"))
+	hash, err := store.HGetAll(ctx, "hbrp:repeaters:state")
+	if err != nil || len(hash) != 2 || string(hash["311860"]) != "c" || string(hash["311861"]) != "b" {
+		t.Errorf("HGetAll = %v, %v", hash, err)
+	}
+	if empty, err := store.HGetAll(ctx, "missing"); err != nil || len(empty) != 0 {
+		t.Errorf("HGetAll of a missing key = %v, %v", empty, err)
+	}
+	if value, err := store.HGet(ctx, "hbrp:repeaters:state", "311861"); err != nil || string(value) != "b" {
+		t.Errorf("HGet = %q, %v", value, err)
+	}
+	if _, err := store.HGet(ctx, "hbrp:repeaters:state", "311862"); !errors.Is(err, kv.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing field, got %v", err)
+	}
+	_ = store.HSet(ctx, "calltracker:calls", "1", []byte("a"))
+	_ = store.HSet(ctx, "calltracker:calls", "2", []byte("b"))
+	if deleted, err := store.HDel(ctx, "calltracker:calls", "1"); err != nil || !deleted {
+		t.Errorf("HDel = %v, %v", deleted, err)
+	}
+	if deleted, _ := store.HDel(ctx, "calltracker:calls", "1"); deleted {
+		t.Error("expected a second HDel of the same field to report nothing deleted")
+	}
+	_, _ = store.HDel(ctx, "calltracker:calls", "2")
+	if ok, _ := store.Has(ctx, "calltracker:calls"); ok {
+		t.Error("expected a hash without fields to be removed")
+	}
+
+	_ = store.RPush(ctx, "parrot:stream:1:packets", []byte("1"))
+	_ = store.RPush(ctx, "parrot:stream:1:packets", []byte("2"))
+	list, err := store.LRange(ctx, "parrot:stream:1:packets")
+	if err != nil || len(list) != 2 || string(list[0]) != "1" || string(list[1]) != "2" {
+		t.Errorf("LRange = %q, %v", list, err)
+	}
+
+	if _, err := store.Get(ctx, "parrot:stream:1:packets"); !errors.Is(err, kv.ErrWrongType) {
+		t.Errorf("expected ErrWrongType reading a list as a value, got %v", err)
+	}
+	if err := store.RPush(ctx, "hbrp:repeaters:state", []byte("x")); !errors.Is(err, kv.ErrWrongType) {
+		t.Errorf("expected ErrWrongType pushing to a hash, got %v", err)
+	}
+}
+
+func TestMemorySetNXAndCompareAndDelete(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := kv.NewMemory()
+
+	if set, err := store.SetNX(ctx, "calltracker:lock:1", []byte("a"), time.Minute); err != nil || !set {
+		t.Fatalf("SetNX = %v, %v", set, err)
+	}
+	if set, _ := store.SetNX(ctx, "calltracker:lock:1", []byte("b"), time.Minute); set {
+		t.Error("expected SetNX of an existing key to do nothing")
+	}
+	if deleted, _ := store.CompareAndDelete(ctx, "calltracker:lock:1", []byte("b")); deleted {
+		t.Error("expected CompareAndDelete with another value to do nothing")
+	}
+	if deleted, err := store.CompareAndDelete(ctx, "calltracker:lock:1", []byte("a")); err != nil || !deleted {
+		t.Errorf("CompareAndDelete = %v, %v", deleted, err)
+	}
+	if set, _ := store.SetNX(ctx, "calltracker:lock:1", []byte("b"), time.Millisecond); !set {
+		t.Error("expected SetNX to succeed once the key was deleted")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if set, _ := store.SetNX(ctx, "calltracker:lock:1", []byte("c"), time.Minute); !set {
+		t.Error("expected SetNX to succeed once the key expired")
+	}
+}
@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// compareAndDelete deletes KEYS[1] if it holds ARGV[1], in one step.
+var compareAndDelete = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type redisKV struct {
+	client *redis.Client
+}
+
+// NewRedis stores keys in Redis.
+func NewRedis(client *redis.Client) KV {
+	return &redisKV{client: client}
+}
+
+func (r *redisKV) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := r.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("redis get %s: %w", key, err)
+	}
+	return value, nil
+}
+
+func (r *redisKV) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	err := r.client.Set(ctx, key, value, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("redis set %s: %w", key, err)
+	}
+	return nil
+}
+
+func (r *redisKV) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	set, err := r.client.SetNX(ctx, key, value, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis setnx %s: %w", key, err)
+	}
+	return set, nil
+}
+
+func (r *redisKV) Has(ctx context.Context, key string) (bool, error) {
+	count, err := r.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis exists %s: %w", key, err)
+	}
+	return count == 1, nil
+}
+
+func (r *redisKV) Delete(ctx context.Context, key string) (bool, error) {
+	count, err := r.client.Del(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis del %s: %w", key, err)
+	}
+	return count == 1, nil
+}
+
+func (r *redisKV) CompareAndDelete(ctx context.Context, key string, value []byte) (bool, error) {
+	count, err := compareAndDelete.Run(ctx, r.client, []string{key}, value).Int64()
+	if err != nil {
+		return false, fmt.Errorf("redis compare and delete %s: %w", key, err)
+	}
+	return count == 1, nil
+}
+
+func (r *redisKV) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	err := r.client.Expire(ctx, key, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("redis expire %s: %w", key, err)
+	}
+	return nil
+}
+
+func (r *redisKV) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		if ttl > 0 {
+			pipe.ExpireNX(ctx, key, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("redis incr %s: %w", key, err)
+	}
+	return incr.Val(), nil
+}
+
+func (r *redisKV) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	iter := r.client.Scan(ctx, 0, prefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("redis scan %s: %w", prefix, err)
+	}
+	return keys, nil
+}
+
+func (r *redisKV) HSet(ctx context.Context, key string, field string, value []byte) error {
+	err := r.client.HSet(ctx, key, field, value).Err()
+	if err != nil {
+		return fmt.Errorf("redis hset %s: %w", key, err)
+	}
+	return nil
+}
+
+func (r *redisKV) HGet(ctx context.Context, key string, field string) ([]byte, error) {
+	value, err := r.client.HGet(ctx, key, field).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("redis hget %s: %w", key, err)
+	}
+	return value, nil
+}
+
+func (r *redisKV) HDel(ctx context.Context, key string, field string) (bool, error) {
+	count, err := r.client.HDel(ctx, key, field).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis hdel %s: %w", key, err)
+	}
+	return count == 1, nil
+}
+
+func (r *redisKV) HGetAll(ctx context.Context, key string) (map[string][]byte, error) {
+	fields, err := r.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis hgetall %s: %w", key, err)
+	}
+	values := make(map[string][]byte, len(fields))
+	for field, value := range fields {
+		values[field] = []byte(value)
+	}
+	return values, nil
+}
+
+func (r *redisKV) RPush(ctx context.Context, key string, value []byte) error {
+	err := r.client.RPush(ctx, key, value).Err()
+	if err != nil {
+		return fmt.Errorf("redis rpush %s: %w", key, err)
+	}
+	return nil
+}
+
+func (r *redisKV) LRange(ctx context.Context, key string) ([][]byte, error) {
+	items, err := r.client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis lrange %s: %w", key, err)
+	}
+	values := make([][]byte, len(items))
+	for i, item := range items {
+		values[i] = []byte(item)
+	}
+	return values, nil
+}
+
+func (r *redisKV) Ping(ctx context.Context) error {
+	err := r.client.Ping(ctx).Err()
+	if err != nil {
+		return fmt.Errorf("redis ping: %w", err)
+	}
+	return nil
+}
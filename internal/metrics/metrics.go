@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Protocols used as the protocol label.
+const (
+	ProtocolHBRP       = "hbrp"
+	ProtocolOpenBridge = "openbridge"
+)
+
+// Drop reasons used as the reason label.
+const (
+	DropInvalidLength   = "invalid_length"
+	DropUnknownCommand  = "unknown_command"
+	DropInvalidPacket   = "invalid_packet"
+	DropInvalidRepeater = "invalid_repeater"
+	DropUnknownPeer     = "unknown_peer"
+	DropInvalidHMAC     = "invalid_hmac"
+	DropTimeslot        = "timeslot"
+	DropRule            = "rule"
+)
+
+// allSources is the source label value used when per-repeater labels are disabled.
+const allSources = "all"
+
+//nolint:golint,gochecknoglobals
+var (
+	packetsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dmrhub",
+		Name:      "packets_received_total",
+		Help:      "Packets received, by protocol and command.",
+	}, []string{"protocol", "command"})
+	packetsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dmrhub",
+		Name:      "packets_sent_total",
+		Help:      "Packets sent, by protocol and command.",
+	}, []string{"protocol", "command"})
+	packetsBySource = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dmrhub",
+		Name:      "packets_by_source_total",
+		Help:      "DMR data packets accepted, by protocol and repeater or peer ID.",
+	}, []string{"protocol", "source"})
+	packetsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dmrhub",
+		Name:      "packets_dropped_total",
+		Help:      "Packets dropped, by protocol and reason.",
+	}, []string{"protocol", "reason"})
+	activeCalls = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "dmrhub",
+		Name:      "active_calls",
+		Help:      "Calls currently in progress on the network. Every instance reports the same count.",
+	})
+	callDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "dmrhub",
+		Name:      "call_duration_seconds",
+		Help:      "Duration of completed calls.",
+		Buckets:   []float64{1, 2, 5, 10, 15, 30, 60, 120, 300},
+	})
+	callLoss = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "dmrhub",
+		Name:      "call_loss_ratio",
+		Help:      "Packet loss of completed calls.",
+		Buckets:   []float64{0, 0.001, 0.01, 0.02, 0.05, 0.1, 0.25, 0.5, 1},
+	})
+	callJitter = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "dmrhub",
+		Name:      "call_jitter_milliseconds",
+		Help:      "Average jitter of completed calls.",
+		Buckets:   []float64{-60, -20, -10, -5, 0, 5, 10, 20, 60, 120},
+	})
+	callBER = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "dmrhub",
+		Name:      "call_ber_ratio",
+		Help:      "Bit error rate of completed calls.",
+		Buckets:   []float64{0, 0.001, 0.01, 0.02, 0.05, 0.1, 0.25, 0.5, 1},
+	})
+	redisPublishLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "dmrhub",
+		Name:      "redis_publish_duration_seconds",
+		Help:      "Latency of Redis publishes on the data plane.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 14), //nolint:golint,mnd
+	})
+)
+
+func sourceLabel(id uint) string {
+	if !config.GetConfig().MetricsRepeaterIDLabel {
+		return allSources
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// PacketReceived records a packet received from a repeater or peer.
+func PacketReceived(protocol string, command string) {
+	packetsReceived.WithLabelValues(protocol, command).Inc()
+}
+
+// PacketFromSource records a DMR data packet accepted from the given repeater or peer.
+func PacketFromSource(protocol string, source uint) {
+	packetsBySource.WithLabelValues(protocol, sourceLabel(source)).Inc()
+}
+
+// PacketSent records a packet sent to a repeater or peer.
+func PacketSent(protocol string, command string) {
+	packetsSent.WithLabelValues(protocol, command).Inc()
+}
+
+// PacketDropped records a packet that was dropped.
+func PacketDropped(protocol string, reason string) {
+	packetsDropped.WithLabelValues(protocol, reason).Inc()
+}
+
+// CallEnded records the end of a call along with its quality statistics.
+func CallEnded(duration time.Duration, loss, jitter, ber float32) {
+	callDuration.Observe(duration.Seconds())
+	callLoss.Observe(float64(loss))
+	callJitter.Observe(float64(jitter))
+	callBER.Observe(float64(ber))
+}
+
+// SetActiveCalls records the number of calls in progress. Calls can start on
+// one instance and be ended by another, so the count is taken from the
+// shared store rather than kept by each instance.
+func SetActiveCalls(count int) {
+	activeCalls.Set(float64(count))
+}
+
+// ObserveRedisPublish records how long a Redis publish took.
+func ObserveRedisPublish(start time.Time) {
+	redisPublishLatency.Observe(time.Since(start).Seconds())
+}
+
+// RegisterConnectedRepeaters registers a gauge reporting the number of connected repeaters
+// using the given function, which is called on every scrape.
+func RegisterConnectedRepeaters(count func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "dmrhub",
+		Name:      "connected_repeaters",
+		Help:      "Repeaters currently connected.",
+	}, count)
+}
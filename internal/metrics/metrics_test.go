@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPacketFromSourceBoundsCardinality(t *testing.T) {
+	t.Parallel()
+
+	metrics.PacketFromSource(metrics.ProtocolHBRP, 311860)
+	metrics.PacketFromSource(metrics.ProtocolHBRP, 311861)
+
+	expected := `
+# HELP dmrhub_packets_by_source_total DMR data packets accepted, by protocol and repeater or peer ID.
+# TYPE dmrhub_packets_by_source_total counter
+dmrhub_packets_by_source_total{protocol="hbrp",source="all"} 2
+`
+	err := testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "dmrhub_packets_by_source_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
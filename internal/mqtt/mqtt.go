@@ -0,0 +1,398 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package mqtt publishes call, repeater and talkgroup events to an MQTT
+// broker so other systems can follow the network without polling the API.
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// CallEvent is the stage of a call a message describes.
+type CallEvent string
+
+const (
+	CallStarted CallEvent = "start"
+	CallUpdated CallEvent = "update"
+	CallEnded   CallEvent = "end"
+)
+
+const (
+	// UpdateInterval is the minimum time between update messages for one call
+	UpdateInterval = time.Second
+	// QueueSize is how many messages can wait for the broker before new ones are dropped
+	QueueSize = 1024
+
+	// staleCallAfter forgets the throttle of calls that stopped getting updates,
+	// such as calls that were ended on another instance
+	staleCallAfter = time.Minute
+
+	connectTimeout    = 5 * time.Second
+	publishTimeout    = 5 * time.Second
+	disconnectQuiesce = 250
+)
+
+var ErrPublishTimeout = errors.New("timed out publishing to the MQTT broker")
+
+//nolint:golint,gochecknoglobals
+var current atomic.Pointer[Publisher]
+
+// Sender delivers messages to a broker.
+type Sender interface {
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+	Close()
+}
+
+// Options configure the connection to the broker and the published messages.
+type Options struct {
+	Broker      string
+	ClientID    string
+	Username    string
+	Password    string
+	TopicPrefix string
+	QoS         byte
+	Retain      bool
+}
+
+// OptionsFromConfig returns the MQTT options in the configuration.
+func OptionsFromConfig(cfg *config.Config) Options {
+	return Options{
+		Broker:      cfg.MQTTBroker,
+		ClientID:    cfg.MQTTClientID,
+		Username:    cfg.MQTTUsername,
+		Password:    cfg.MQTTPassword,
+		TopicPrefix: cfg.MQTTTopicPrefix,
+		QoS:         byte(cfg.MQTTQoS), //nolint:golint,gosec // validated to be 0-2
+		Retain:      cfg.MQTTRetain,
+	}
+}
+
+// Publisher turns network events into MQTT messages.
+// Messages are sent in the order they are published by a single worker, so
+// publishing never waits for the broker.
+type Publisher struct {
+	sender Sender
+	prefix string
+	qos    byte
+	retain bool
+	// lastUpdate holds when the last message was sent for each active call
+	lastUpdate *xsync.MapOf[uint, time.Time]
+
+	queue     chan message
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	dropping  atomic.Bool
+}
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// NewPublisher creates a Publisher that sends messages with the given sender.
+// Close must be called to stop its worker.
+func NewPublisher(sender Sender, prefix string, qos byte, retain bool) *Publisher {
+	p := &Publisher{
+		sender:     sender,
+		prefix:     prefix,
+		qos:        qos,
+		retain:     retain,
+		lastUpdate: xsync.NewMapOf[uint, time.Time](),
+		queue:      make(chan message, QueueSize),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Dial connects to the broker. If the broker can't be reached the connection
+// keeps being retried in the background and messages are queued until then.
+func Dial(opts Options) (*Publisher, error) {
+	clientOpts := paho.NewClientOptions().
+		AddBroker(opts.Broker).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectTimeout(connectTimeout).
+		SetOnConnectHandler(func(paho.Client) {
+			logging.Logf("Connected to MQTT broker %s", opts.Broker)
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logging.Errorf("Lost connection to MQTT broker %s: %v", opts.Broker, err)
+		})
+	client := paho.NewClient(clientOpts)
+	token := client.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		logging.Errorf("MQTT broker %s is not reachable yet, retrying in the background", opts.Broker)
+	} else if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("error connecting to MQTT broker: %w", err)
+	}
+	return NewPublisher(pahoSender{client: client}, opts.TopicPrefix, opts.QoS, opts.Retain), nil
+}
+
+type pahoSender struct {
+	client paho.Client
+}
+
+func (s pahoSender) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	token := s.client.Publish(topic, qos, retained, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return ErrPublishTimeout
+	}
+	return token.Error() //nolint:golint,wrapcheck
+}
+
+func (s pahoSender) Close() {
+	s.client.Disconnect(disconnectQuiesce)
+}
+
+// Start connects to the configured broker. Publishing is disabled when no broker is configured.
+func Start(cfg *config.Config) error {
+	if cfg.MQTTBroker == "" {
+		return nil
+	}
+	publisher, err := Dial(OptionsFromConfig(cfg))
+	if err != nil {
+		return err
+	}
+	current.Store(publisher)
+	return nil
+}
+
+// Stop disconnects from the broker.
+func Stop() {
+	if publisher := current.Swap(nil); publisher != nil {
+		publisher.Close()
+	}
+}
+
+// PublishCall publishes a call event if MQTT is enabled.
+func PublishCall(event CallEvent, call webhooks.Call) {
+	if publisher := current.Load(); publisher != nil {
+		publisher.PublishCall(event, call, time.Now())
+	}
+}
+
+// PublishRepeaterState publishes the state of a repeater if MQTT is enabled.
+func PublishRepeaterState(repeaterID uint, state []byte) {
+	if publisher := current.Load(); publisher != nil {
+		publisher.PublishRepeaterState(repeaterID, state)
+	}
+}
+
+// PublishTalkgroups publishes the talkgroups linked to a repeater if MQTT is enabled.
+func PublishTalkgroups(repeater models.Repeater) {
+	if publisher := current.Load(); publisher != nil {
+		publisher.PublishTalkgroups(repeater)
+	}
+}
+
+// Call is the payload of call messages.
+type Call struct {
+	Event CallEvent     `json:"event"`
+	Call  webhooks.Call `json:"call"`
+}
+
+// Talkgroups is the payload of talkgroup link messages.
+type Talkgroups struct {
+	RepeaterID          uint   `json:"repeater_id"`
+	TS1StaticTalkgroups []uint `json:"ts1_static_talkgroups"`
+	TS2StaticTalkgroups []uint `json:"ts2_static_talkgroups"`
+	TS1DynamicTalkgroup *uint  `json:"ts1_dynamic_talkgroup"`
+	TS2DynamicTalkgroup *uint  `json:"ts2_dynamic_talkgroup"`
+}
+
+func TalkgroupsFromModel(repeater models.Repeater) Talkgroups {
+	talkgroups := Talkgroups{
+		RepeaterID:          repeater.ID,
+		TS1StaticTalkgroups: make([]uint, 0, len(repeater.TS1StaticTalkgroups)),
+		TS2StaticTalkgroups: make([]uint, 0, len(repeater.TS2StaticTalkgroups)),
+		TS1DynamicTalkgroup: repeater.TS1DynamicTalkgroupID,
+		TS2DynamicTalkgroup: repeater.TS2DynamicTalkgroupID,
+	}
+	for _, talkgroup := range repeater.TS1StaticTalkgroups {
+		talkgroups.TS1StaticTalkgroups = append(talkgroups.TS1StaticTalkgroups, talkgroup.ID)
+	}
+	for _, talkgroup := range repeater.TS2StaticTalkgroups {
+		talkgroups.TS2StaticTalkgroups = append(talkgroups.TS2StaticTalkgroups, talkgroup.ID)
+	}
+	return talkgroups
+}
+
+// CallTopic is the topic a call is published to, based on its destination:
+// <prefix>/calls/tg/<id>, <prefix>/calls/user/<id> or <prefix>/calls/repeater/<id>.
+func (p *Publisher) CallTopic(call webhooks.Call) string {
+	switch {
+	case call.IsToTalkgroup:
+		return p.topic("calls", "tg", call.DestinationID)
+	case call.IsToUser:
+		return p.topic("calls", "user", call.DestinationID)
+	default:
+		return p.topic("calls", "repeater", call.DestinationID)
+	}
+}
+
+// RepeaterStateTopic is the topic a repeater's state is published to: <prefix>/repeaters/<id>/state.
+func (p *Publisher) RepeaterStateTopic(repeaterID uint) string {
+	return p.topic("repeaters", repeaterID, "state")
+}
+
+// TalkgroupsTopic is the topic a repeater's talkgroup links are published to: <prefix>/repeaters/<id>/talkgroups.
+func (p *Publisher) TalkgroupsTopic(repeaterID uint) string {
+	return p.topic("repeaters", repeaterID, "talkgroups")
+}
+
+func (p *Publisher) topic(levels ...any) string {
+	parts := make([]string, 0, len(levels)+1)
+	parts = append(parts, p.prefix)
+	for _, level := range levels {
+		switch level := level.(type) {
+		case uint:
+			parts = append(parts, strconv.FormatUint(uint64(level), 10))
+		default:
+			parts = append(parts, fmt.Sprint(level))
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// PublishCall publishes a call event. Updates arrive with every voice packet,
+// so they are only sent once per UpdateInterval for each call.
+func (p *Publisher) PublishCall(event CallEvent, call webhooks.Call, now time.Time) {
+	switch event {
+	case CallStarted:
+		p.lastUpdate.Store(call.ID, now)
+	case CallUpdated:
+		throttled := false
+		p.lastUpdate.Compute(call.ID, func(last time.Time, loaded bool) (time.Time, bool) {
+			if loaded && now.Sub(last) < UpdateInterval {
+				throttled = true
+				return last, false
+			}
+			return now, false
+		})
+		if throttled {
+			return
+		}
+	case CallEnded:
+		p.lastUpdate.Delete(call.ID)
+	}
+	p.publishJSON(p.CallTopic(call), Call{Event: event, Call: call})
+}
+
+// PublishRepeaterState publishes the JSON state of a repeater.
+func (p *Publisher) PublishRepeaterState(repeaterID uint, state []byte) {
+	p.publish(p.RepeaterStateTopic(repeaterID), state)
+}
+
+// PublishTalkgroups publishes the talkgroups linked to a repeater.
+func (p *Publisher) PublishTalkgroups(repeater models.Repeater) {
+	p.publishJSON(p.TalkgroupsTopic(repeater.ID), TalkgroupsFromModel(repeater))
+}
+
+// Close sends the queued messages and disconnects from the broker.
+func (p *Publisher) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		<-p.stopped
+		p.sender.Close()
+	})
+}
+
+func (p *Publisher) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(staleCallAfter)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-p.queue:
+			p.send(msg)
+		case now := <-ticker.C:
+			p.lastUpdate.Range(func(callID uint, last time.Time) bool {
+				if now.Sub(last) > staleCallAfter {
+					p.lastUpdate.Delete(callID)
+				}
+				return true
+			})
+		case <-p.done:
+			for {
+				select {
+				case msg := <-p.queue:
+					p.send(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *Publisher) publishJSON(topic string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		logging.Errorf("Error marshalling MQTT message for %s: %v", topic, err)
+		return
+	}
+	p.publish(topic, payload)
+}
+
+// publish queues a message for the worker, dropping it if the queue is full
+// because the broker can't keep up.
+func (p *Publisher) publish(topic string, payload []byte) {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	select {
+	case p.queue <- message{topic: topic, payload: payload}:
+		p.dropping.Store(false)
+	default:
+		// Only log the first drop while the broker is behind
+		if !p.dropping.Swap(true) {
+			logging.Errorf("MQTT queue is full, dropping messages until the broker catches up")
+		}
+	}
+}
+
+func (p *Publisher) send(msg message) {
+	err := p.sender.Publish(msg.topic, p.qos, p.retain, msg.payload)
+	if err != nil {
+		logging.Errorf("Error publishing MQTT message to %s: %v", msg.topic, err)
+	}
+}
@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package mqtt_test
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/mqtt"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+type message struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  []byte
+}
+
+type fakeSender struct {
+	mu       sync.Mutex
+	messages []message
+}
+
+func (s *fakeSender) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, message{topic: topic, qos: qos, retained: retained, payload: payload})
+	return nil
+}
+
+func (s *fakeSender) Close() {}
+
+func TestTopics(t *testing.T) {
+	t.Parallel()
+	publisher := mqtt.NewPublisher(&fakeSender{}, "dmrhub", 0, false)
+	defer publisher.Close()
+
+	tests := map[string]string{
+		publisher.CallTopic(webhooks.Call{IsToTalkgroup: true, DestinationID: 3100}):  "dmrhub/calls/tg/3100",
+		publisher.CallTopic(webhooks.Call{IsToUser: true, DestinationID: 3191868}):    "dmrhub/calls/user/3191868",
+		publisher.CallTopic(webhooks.Call{IsToRepeater: true, DestinationID: 311860}): "dmrhub/calls/repeater/311860",
+		publisher.RepeaterStateTopic(311860):                                          "dmrhub/repeaters/311860/state",
+		publisher.TalkgroupsTopic(311860):                                             "dmrhub/repeaters/311860/talkgroups",
+	}
+	for got, want := range tests {
+		if got != want {
+			t.Errorf("Expected topic %s, got %s", want, got)
+		}
+	}
+}
+
+func TestPublishCallThrottlesUpdates(t *testing.T) {
+	t.Parallel()
+	sender := &fakeSender{}
+	publisher := mqtt.NewPublisher(sender, "dmrhub", 1, true)
+	call := webhooks.Call{ID: 1, IsToTalkgroup: true, DestinationID: 3100}
+	now := time.Now()
+
+	publisher.PublishCall(mqtt.CallStarted, call, now)
+	publisher.PublishCall(mqtt.CallUpdated, call, now.Add(60*time.Millisecond))
+	publisher.PublishCall(mqtt.CallUpdated, call, now.Add(mqtt.UpdateInterval))
+	publisher.PublishCall(mqtt.CallUpdated, call, now.Add(mqtt.UpdateInterval+60*time.Millisecond))
+	publisher.PublishCall(mqtt.CallEnded, call, now.Add(mqtt.UpdateInterval+120*time.Millisecond))
+	// Close sends the queued messages
+	publisher.Close()
+
+	want := []mqtt.CallEvent{mqtt.CallStarted, mqtt.CallUpdated, mqtt.CallEnded}
+	if len(sender.messages) != len(want) {
+		t.Fatalf("Expected %d messages, got %d", len(want), len(sender.messages))
+	}
+	for i, msg := range sender.messages {
+		if msg.topic != "dmrhub/calls/tg/3100" || msg.qos != 1 || !msg.retained {
+			t.Errorf("Unexpected message settings: %+v", msg)
+		}
+		var payload mqtt.Call
+		if err := json.Unmarshal(msg.payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Event != want[i] || payload.Call.ID != call.ID {
+			t.Errorf("Expected %s event for call %d, got %+v", want[i], call.ID, payload)
+		}
+	}
+}
+
+func TestPublishTalkgroups(t *testing.T) {
+	t.Parallel()
+	sender := &fakeSender{}
+	publisher := mqtt.NewPublisher(sender, "network", 0, false)
+	dynamic := uint(91)
+	repeater := models.Repeater{
+		TS1StaticTalkgroups:   []models.Talkgroup{{ID: 1}, {ID: 3100}},
+		TS2DynamicTalkgroupID: &dynamic,
+	}
+	repeater.ID = 311860
+	publisher.PublishTalkgroups(repeater)
+	publisher.Close()
+
+	if len(sender.messages) != 1 || sender.messages[0].topic != "network/repeaters/311860/talkgroups" {
+		t.Fatalf("Unexpected messages: %+v", sender.messages)
+	}
+	var payload mqtt.Talkgroups
+	if err := json.Unmarshal(sender.messages[0].payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.TS1StaticTalkgroups) != 2 || payload.TS1StaticTalkgroups[1] != 3100 || len(payload.TS2StaticTalkgroups) != 0 {
+		t.Errorf("Unexpected static talkgroups: %+v", payload)
+	}
+	if payload.TS1DynamicTalkgroup != nil || payload.TS2DynamicTalkgroup == nil || *payload.TS2DynamicTalkgroup != dynamic {
+		t.Errorf("Unexpected dynamic talkgroups: %+v", payload)
+	}
+}
+
+// blockingSender holds every message until it is released, like a broker that is down.
+type blockingSender struct {
+	fakeSender
+	release chan struct{}
+}
+
+func (s *blockingSender) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	<-s.release
+	return s.fakeSender.Publish(topic, qos, retained, payload)
+}
+
+func TestPublishDoesNotWaitForBroker(t *testing.T) {
+	t.Parallel()
+	sender := &blockingSender{release: make(chan struct{})}
+	publisher := mqtt.NewPublisher(sender, "dmrhub", 0, false)
+	call := webhooks.Call{IsToTalkgroup: true, DestinationID: 3100}
+	start := time.Now()
+
+	for i := range mqtt.QueueSize + 100 {
+		call.ID = uint(i)
+		publisher.PublishCall(mqtt.CallStarted, call, start)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected publishing not to wait for the broker, took %v", elapsed)
+	}
+
+	close(sender.release)
+	publisher.Close()
+	// One message may already be with the worker when the queue fills up
+	if len(sender.messages) < mqtt.QueueSize || len(sender.messages) > mqtt.QueueSize+1 {
+		t.Fatalf("Expected the messages beyond the queue to be dropped, got %d", len(sender.messages))
+	}
+	for i, msg := range sender.messages {
+		var payload mqtt.Call
+		if err := json.Unmarshal(msg.payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Call.ID < uint(i) || (i > 0 && payload.Call.ID <= call.ID) {
+			t.Fatalf("Expected messages in order, got call %d at %d", payload.Call.ID, i)
+		}
+		call.ID = payload.Call.ID
+	}
+}
+
+// TestBroker publishes through a real broker, such as a local mosquitto
+// started with `mosquitto -p 1883`, when MQTT_TEST_BROKER is set.
+func TestBroker(t *testing.T) {
+	t.Parallel()
+	broker := os.Getenv("MQTT_TEST_BROKER")
+	if broker == "" {
+		t.Skip("MQTT_TEST_BROKER is not set")
+	}
+
+	received := make(chan paho.Message, 1)
+	subscriber := paho.NewClient(paho.NewClientOptions().AddBroker(broker).SetClientID("dmrhub-test-subscriber"))
+	if token := subscriber.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer subscriber.Disconnect(0)
+	if token := subscriber.Subscribe("dmrhub-test/#", 1, func(_ paho.Client, msg paho.Message) {
+		received <- msg
+	}); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	publisher, err := mqtt.Dial(mqtt.Options{Broker: broker, ClientID: "dmrhub-test", TopicPrefix: "dmrhub-test", QoS: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	publisher.PublishCall(mqtt.CallStarted, webhooks.Call{ID: 1, IsToTalkgroup: true, DestinationID: 3100}, time.Now())
+
+	select {
+	case msg := <-received:
+		if msg.Topic() != "dmrhub-test/calls/tg/3100" {
+			t.Errorf("Unexpected topic %s", msg.Topic())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the message")
+	}
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package netconfig
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// Export reads the network configuration from the database.
+// Password hashes and repeater/peer passwords are only included with includeSecrets.
+func Export(db *gorm.DB, includeSecrets bool) (*Document, error) {
+	doc := &Document{
+		Version:    Version,
+		ExportedAt: time.Now().UTC(),
+		Users:      []User{},
+		Talkgroups: []Talkgroup{},
+		Repeaters:  []Repeater{},
+		Peers:      []Peer{},
+	}
+
+	users, err := models.ListUsers(db.Order("id asc"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	for _, user := range users {
+		exported := User{
+			ID:        user.ID,
+			Callsign:  user.Callsign,
+			Username:  user.Username,
+			Admin:     user.Admin,
+			Approved:  user.Approved,
+			Suspended: user.Suspended,
+		}
+		if includeSecrets {
+			exported.PasswordHash = user.Password
+		}
+		doc.Users = append(doc.Users, exported)
+	}
+
+	talkgroups, err := models.ListTalkgroups(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list talkgroups: %w", err)
+	}
+	for _, talkgroup := range talkgroups {
+		doc.Talkgroups = append(doc.Talkgroups, Talkgroup{
+			ID:          talkgroup.ID,
+			Name:        talkgroup.Name,
+			Description: talkgroup.Description,
+			Admins:      userIDs(talkgroup.Admins),
+			NCOs:        userIDs(talkgroup.NCOs),
+		})
+	}
+
+	repeaters, err := models.ListRepeaters(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repeaters: %w", err)
+	}
+	for _, repeater := range repeaters {
+		exported := Repeater{
+			ID:                  repeater.ID,
+			OwnerID:             repeater.OwnerID,
+			Callsign:            repeater.Callsign,
+			Hotspot:             repeater.Hotspot,
+			MapPrivacy:          string(repeater.MapPrivacy),
+			RXFrequency:         repeater.RXFrequency,
+			TXFrequency:         repeater.TXFrequency,
+			TXPower:             repeater.TXPower,
+			ColorCode:           repeater.ColorCode,
+			Latitude:            repeater.Latitude,
+			Longitude:           repeater.Longitude,
+			Height:              repeater.Height,
+			Location:            repeater.Location,
+			Description:         repeater.Description,
+			URL:                 repeater.URL,
+			TS1StaticTalkgroups: talkgroupIDs(repeater.TS1StaticTalkgroups),
+			TS2StaticTalkgroups: talkgroupIDs(repeater.TS2StaticTalkgroups),
+			TS1DynamicTalkgroup: repeater.TS1DynamicTalkgroupID,
+			TS2DynamicTalkgroup: repeater.TS2DynamicTalkgroupID,
+		}
+		if includeSecrets {
+			exported.Password = repeater.Password
+		}
+		doc.Repeaters = append(doc.Repeaters, exported)
+	}
+
+	for _, peer := range models.ListPeers(db) {
+		exported := Peer{
+			ID:      peer.ID,
+			OwnerID: peer.OwnerID,
+			Ingress: peer.Ingress,
+			Egress:  peer.Egress,
+			Rules:   []PeerRule{},
+		}
+		for _, rule := range models.ListRulesForPeer(db, peer.ID) {
+			direction := DirectionEgress
+			if rule.Direction {
+				direction = DirectionIngress
+			}
+			exported.Rules = append(exported.Rules, PeerRule{
+				Direction:    direction,
+				SubjectIDMin: rule.SubjectIDMin,
+				SubjectIDMax: rule.SubjectIDMax,
+			})
+		}
+		if includeSecrets {
+			exported.Password = peer.Password
+		}
+		doc.Peers = append(doc.Peers, exported)
+	}
+
+	return doc, nil
+}
+
+func userIDs(users []models.User) []uint {
+	ids := make([]uint, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+func talkgroupIDs(talkgroups []models.Talkgroup) []uint {
+	ids := make([]uint, 0, len(talkgroups))
+	for _, talkgroup := range talkgroups {
+		ids = append(ids, talkgroup.ID)
+	}
+	return ids
+}
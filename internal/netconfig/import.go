@@ -0,0 +1,454 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package netconfig
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/dmr/dmrconst"
+	"gorm.io/gorm"
+)
+
+type Mode string
+
+const (
+	// ModeMerge creates and updates everything in the document and leaves
+	// anything not mentioned in it alone.
+	ModeMerge Mode = "merge"
+	// ModeReplace additionally deletes anything not in the document.
+	ModeReplace Mode = "replace"
+)
+
+var (
+	ErrUnknownMode = errors.New("unknown import mode")
+	errDryRun      = errors.New("dry run")
+)
+
+// Counts is the number of rows an import touched for one kind of object.
+type Counts struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Deleted int `json:"deleted"`
+}
+
+// Report summarizes what an import did, or would do on a dry run.
+type Report struct {
+	DryRun     bool   `json:"dry_run"`
+	Users      Counts `json:"users"`
+	Talkgroups Counts `json:"talkgroups"`
+	Repeaters  Counts `json:"repeaters"`
+	Peers      Counts `json:"peers"`
+}
+
+// Import applies the document to the database in a single transaction.
+// With dryRun the transaction is rolled back after the import, so the
+// report reflects exactly what a real import would change.
+func Import(db *gorm.DB, doc *Document, mode Mode, dryRun bool) (Report, error) {
+	report := Report{DryRun: dryRun}
+	if mode != ModeMerge && mode != ModeReplace {
+		return report, fmt.Errorf("%w: %s", ErrUnknownMode, mode)
+	}
+	if err := validate(doc); err != nil {
+		return report, err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		importer := &importer{tx: tx, doc: doc, report: &report}
+		if mode == ModeReplace {
+			if err := importer.deleteMissing(); err != nil {
+				return err
+			}
+		}
+		if err := importer.importUsers(); err != nil {
+			return err
+		}
+		if err := importer.importTalkgroups(); err != nil {
+			return err
+		}
+		if err := importer.importRepeaters(); err != nil {
+			return err
+		}
+		if err := importer.importPeers(); err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return report, err //nolint:golint,wrapcheck
+	}
+	return report, nil
+}
+
+// validate checks the structure of the document before anything is written.
+// References to users and talkgroups are checked during the import, since
+// in merge mode they may already exist in the database.
+func validate(doc *Document) error {
+	var errs []error
+
+	users := map[uint]bool{}
+	for _, user := range doc.Users {
+		if user.ID == 0 {
+			errs = append(errs, fmt.Errorf("%w: user %q has no id", ErrInvalidDocument, user.Callsign))
+		} else if users[user.ID] {
+			errs = append(errs, fmt.Errorf("%w: duplicate user %d", ErrInvalidDocument, user.ID))
+		}
+		users[user.ID] = true
+	}
+	talkgroups := map[uint]bool{}
+	for _, talkgroup := range doc.Talkgroups {
+		if talkgroup.ID == 0 {
+			errs = append(errs, fmt.Errorf("%w: talkgroup %q has no id", ErrInvalidDocument, talkgroup.Name))
+		} else if talkgroups[talkgroup.ID] {
+			errs = append(errs, fmt.Errorf("%w: duplicate talkgroup %d", ErrInvalidDocument, talkgroup.ID))
+		}
+		talkgroups[talkgroup.ID] = true
+	}
+	repeaters := map[uint]bool{}
+	for _, repeater := range doc.Repeaters {
+		if repeater.ID == 0 {
+			errs = append(errs, fmt.Errorf("%w: repeater %q has no id", ErrInvalidDocument, repeater.Callsign))
+		} else if repeaters[repeater.ID] {
+			errs = append(errs, fmt.Errorf("%w: duplicate repeater %d", ErrInvalidDocument, repeater.ID))
+		}
+		if !models.MapPrivacy(repeater.MapPrivacy).Valid() {
+			errs = append(errs, fmt.Errorf("%w: repeater %d has unknown map privacy %q", ErrInvalidDocument, repeater.ID, repeater.MapPrivacy))
+		}
+		repeaters[repeater.ID] = true
+	}
+	peers := map[uint]bool{}
+	for _, peer := range doc.Peers {
+		if peer.ID == 0 {
+			errs = append(errs, fmt.Errorf("%w: peer has no id", ErrInvalidDocument))
+		} else if peers[peer.ID] {
+			errs = append(errs, fmt.Errorf("%w: duplicate peer %d", ErrInvalidDocument, peer.ID))
+		}
+		peers[peer.ID] = true
+		for _, rule := range peer.Rules {
+			if rule.Direction != DirectionIngress && rule.Direction != DirectionEgress {
+				errs = append(errs, fmt.Errorf("%w: peer %d has a rule with unknown direction %q", ErrInvalidDocument, peer.ID, rule.Direction))
+			}
+			if rule.SubjectIDMin > rule.SubjectIDMax {
+				errs = append(errs, fmt.Errorf("%w: peer %d has a rule with subject_id_min above subject_id_max", ErrInvalidDocument, peer.ID))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type importer struct {
+	tx     *gorm.DB
+	doc    *Document
+	report *Report
+}
+
+// exists reports whether a row exists, including soft-deleted rows which
+// would otherwise collide with the primary key on create.
+func (i *importer) exists(model any, id uint) (bool, error) {
+	var count int64
+	err := i.tx.Unscoped().Model(model).Where("id = ?", id).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to look up %T %d: %w", model, id, err)
+	}
+	return count > 0, nil
+}
+
+// upsert creates the row or, if it exists, updates the given columns
+// and restores it if it was soft-deleted.
+func (i *importer) upsert(model any, id uint, columns []string, counts *Counts) error {
+	exists, err := i.exists(model, id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := i.tx.Create(model).Error; err != nil {
+			return fmt.Errorf("failed to create %T %d: %w", model, id, err)
+		}
+		counts.Created++
+		return nil
+	}
+	err = i.tx.Unscoped().Model(model).Select(append(columns, "deleted_at")).Updates(model).Error
+	if err != nil {
+		return fmt.Errorf("failed to update %T %d: %w", model, id, err)
+	}
+	counts.Updated++
+	return nil
+}
+
+func (i *importer) requireUsers(what string, ids ...uint) error {
+	for _, id := range ids {
+		exists, err := models.UserIDExists(i.tx, id)
+		if err != nil {
+			return fmt.Errorf("failed to look up user %d: %w", id, err)
+		}
+		if !exists {
+			return fmt.Errorf("%w: %s references unknown user %d", ErrInvalidDocument, what, id)
+		}
+	}
+	return nil
+}
+
+func (i *importer) requireTalkgroups(what string, ids ...uint) error {
+	for _, id := range ids {
+		exists, err := models.TalkgroupIDExists(i.tx, id)
+		if err != nil {
+			return fmt.Errorf("failed to look up talkgroup %d: %w", id, err)
+		}
+		if !exists {
+			return fmt.Errorf("%w: %s references unknown talkgroup %d", ErrInvalidDocument, what, id)
+		}
+	}
+	return nil
+}
+
+func (i *importer) importUsers() error {
+	for _, user := range i.doc.Users {
+		model := &models.User{
+			ID:        user.ID,
+			Callsign:  user.Callsign,
+			Username:  user.Username,
+			Admin:     user.Admin,
+			Approved:  user.Approved,
+			Suspended: user.Suspended,
+			Password:  user.PasswordHash,
+		}
+		columns := []string{"callsign", "username", "admin", "approved", "suspended"}
+		// Without a hash in the document, keep whatever password the user already has
+		if user.PasswordHash != "" {
+			columns = append(columns, "password")
+		}
+		if err := i.upsert(model, user.ID, columns, &i.report.Users); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *importer) importTalkgroups() error {
+	for _, talkgroup := range i.doc.Talkgroups {
+		model := &models.Talkgroup{
+			ID:          talkgroup.ID,
+			Name:        talkgroup.Name,
+			Description: talkgroup.Description,
+		}
+		if err := i.requireUsers(fmt.Sprintf("talkgroup %d", talkgroup.ID), slices.Concat(talkgroup.Admins, talkgroup.NCOs)...); err != nil {
+			return err
+		}
+		if err := i.upsert(model, talkgroup.ID, []string{"name", "description"}, &i.report.Talkgroups); err != nil {
+			return err
+		}
+		if err := i.tx.Model(model).Association("Admins").Replace(usersByID(talkgroup.Admins)); err != nil {
+			return fmt.Errorf("failed to set admins of talkgroup %d: %w", talkgroup.ID, err)
+		}
+		if err := i.tx.Model(model).Association("NCOs").Replace(usersByID(talkgroup.NCOs)); err != nil {
+			return fmt.Errorf("failed to set NCOs of talkgroup %d: %w", talkgroup.ID, err)
+		}
+	}
+	return nil
+}
+
+func (i *importer) importRepeaters() error {
+	for _, repeater := range i.doc.Repeaters {
+		model := &models.Repeater{
+			OwnerID:               repeater.OwnerID,
+			Hotspot:               repeater.Hotspot,
+			MapPrivacy:            models.MapPrivacy(repeater.MapPrivacy),
+			Password:              repeater.Password,
+			TS1DynamicTalkgroupID: repeater.TS1DynamicTalkgroup,
+			TS2DynamicTalkgroupID: repeater.TS2DynamicTalkgroup,
+			RepeaterConfiguration: models.RepeaterConfiguration{
+				ID:          repeater.ID,
+				Callsign:    repeater.Callsign,
+				RXFrequency: repeater.RXFrequency,
+				TXFrequency: repeater.TXFrequency,
+				TXPower:     repeater.TXPower,
+				ColorCode:   repeater.ColorCode,
+				Latitude:    repeater.Latitude,
+				Longitude:   repeater.Longitude,
+				Height:      repeater.Height,
+				Location:    repeater.Location,
+				Description: repeater.Description,
+				URL:         repeater.URL,
+			},
+		}
+		columns := []string{
+			"owner_id", "hotspot", "map_privacy", "ts1_dynamic_talkgroup_id", "ts2_dynamic_talkgroup_id",
+			"callsign", "rx_frequency", "tx_frequency", "tx_power", "color_code",
+			"latitude", "longitude", "height", "location", "description", "url",
+		}
+		if repeater.Password != "" {
+			columns = append(columns, "password")
+		}
+		what := fmt.Sprintf("repeater %d", repeater.ID)
+		if err := i.requireUsers(what, repeater.OwnerID); err != nil {
+			return err
+		}
+		referenced := slices.Concat(repeater.TS1StaticTalkgroups, repeater.TS2StaticTalkgroups)
+		for _, dynamic := range []*uint{repeater.TS1DynamicTalkgroup, repeater.TS2DynamicTalkgroup} {
+			if dynamic != nil {
+				referenced = append(referenced, *dynamic)
+			}
+		}
+		if err := i.requireTalkgroups(what, referenced...); err != nil {
+			return err
+		}
+		if err := i.upsert(model, repeater.ID, columns, &i.report.Repeaters); err != nil {
+			return err
+		}
+		if err := i.tx.Model(model).Association("TS1StaticTalkgroups").Replace(talkgroupsByID(repeater.TS1StaticTalkgroups)); err != nil {
+			return fmt.Errorf("failed to set TS1 static talkgroups of repeater %d: %w", repeater.ID, err)
+		}
+		if err := i.tx.Model(model).Association("TS2StaticTalkgroups").Replace(talkgroupsByID(repeater.TS2StaticTalkgroups)); err != nil {
+			return fmt.Errorf("failed to set TS2 static talkgroups of repeater %d: %w", repeater.ID, err)
+		}
+	}
+	return nil
+}
+
+func (i *importer) importPeers() error {
+	for _, peer := range i.doc.Peers {
+		model := &models.Peer{
+			ID:       peer.ID,
+			OwnerID:  peer.OwnerID,
+			Ingress:  peer.Ingress,
+			Egress:   peer.Egress,
+			Password: peer.Password,
+		}
+		columns := []string{"owner_id", "ingress", "egress"}
+		if peer.Password != "" {
+			columns = append(columns, "password")
+		}
+		if err := i.requireUsers(fmt.Sprintf("peer %d", peer.ID), peer.OwnerID); err != nil {
+			return err
+		}
+		if err := i.upsert(model, peer.ID, columns, &i.report.Peers); err != nil {
+			return err
+		}
+
+		// Rules have no stable identity outside the database, so the
+		// document's rules replace the peer's rules wholesale
+		err := i.tx.Unscoped().Where("peer_id = ?", peer.ID).Delete(&models.PeerRule{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to clear rules of peer %d: %w", peer.ID, err)
+		}
+		for _, rule := range peer.Rules {
+			err := i.tx.Create(&models.PeerRule{
+				PeerID:       peer.ID,
+				Direction:    rule.Direction == DirectionIngress,
+				SubjectIDMin: rule.SubjectIDMin,
+				SubjectIDMax: rule.SubjectIDMax,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to create rule for peer %d: %w", peer.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// deleteMissing removes everything that isn't in the document. The
+// built-in Parrot and system admin users are always kept.
+func (i *importer) deleteMissing() error {
+	var peerIDs []uint
+	if err := i.tx.Model(&models.Peer{}).Pluck("id", &peerIDs).Error; err != nil {
+		return fmt.Errorf("failed to list peers: %w", err)
+	}
+	for _, id := range peerIDs {
+		if slices.ContainsFunc(i.doc.Peers, func(p Peer) bool { return p.ID == id }) {
+			continue
+		}
+		err := i.tx.Unscoped().Where("peer_id = ?", id).Delete(&models.PeerRule{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to delete rules of peer %d: %w", id, err)
+		}
+		if err := i.tx.Unscoped().Delete(&models.Peer{ID: id}).Error; err != nil {
+			return fmt.Errorf("failed to delete peer %d: %w", id, err)
+		}
+		i.report.Peers.Deleted++
+	}
+
+	var repeaterIDs []uint
+	if err := i.tx.Model(&models.Repeater{}).Pluck("id", &repeaterIDs).Error; err != nil {
+		return fmt.Errorf("failed to list repeaters: %w", err)
+	}
+	for _, id := range repeaterIDs {
+		if slices.ContainsFunc(i.doc.Repeaters, func(r Repeater) bool { return r.ID == id }) {
+			continue
+		}
+		if err := models.DeleteRepeater(i.tx, id); err != nil {
+			return fmt.Errorf("failed to delete repeater %d: %w", id, err)
+		}
+		i.report.Repeaters.Deleted++
+	}
+
+	var talkgroupIDs []uint
+	if err := i.tx.Model(&models.Talkgroup{}).Pluck("id", &talkgroupIDs).Error; err != nil {
+		return fmt.Errorf("failed to list talkgroups: %w", err)
+	}
+	for _, id := range talkgroupIDs {
+		if slices.ContainsFunc(i.doc.Talkgroups, func(t Talkgroup) bool { return t.ID == id }) {
+			continue
+		}
+		if err := models.DeleteTalkgroup(i.tx, id); err != nil {
+			return fmt.Errorf("failed to delete talkgroup %d: %w", id, err)
+		}
+		i.report.Talkgroups.Deleted++
+	}
+
+	var userIDs []uint
+	if err := i.tx.Model(&models.User{}).Pluck("id", &userIDs).Error; err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+	for _, id := range userIDs {
+		if id == dmrconst.ParrotUser || id == dmrconst.SuperAdminUser {
+			continue
+		}
+		if slices.ContainsFunc(i.doc.Users, func(u User) bool { return u.ID == id }) {
+			continue
+		}
+		if err := models.DeleteUser(i.tx, id); err != nil {
+			return fmt.Errorf("failed to delete user %d: %w", id, err)
+		}
+		i.report.Users.Deleted++
+	}
+	return nil
+}
+
+func usersByID(ids []uint) []models.User {
+	users := make([]models.User, 0, len(ids))
+	for _, id := range ids {
+		users = append(users, models.User{ID: id})
+	}
+	return users
+}
+
+func talkgroupsByID(ids []uint) []models.Talkgroup {
+	talkgroups := make([]models.Talkgroup, 0, len(ids))
+	for _, id := range ids {
+		talkgroups = append(talkgroups, models.Talkgroup{ID: id})
+	}
+	return talkgroups
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package netconfig exports a network's configuration to a versioned
+// JSON or YAML document and imports it again on another server.
+package netconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the document format version written by Export.
+const Version = 1
+
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported network configuration version")
+	ErrUnknownFormat      = errors.New("unknown network configuration format")
+	ErrInvalidDocument    = errors.New("invalid network configuration")
+)
+
+// Document is the exported configuration of a network.
+type Document struct {
+	Version    int         `json:"version" yaml:"version"`
+	ExportedAt time.Time   `json:"exported_at" yaml:"exported_at"`
+	Users      []User      `json:"users" yaml:"users"`
+	Talkgroups []Talkgroup `json:"talkgroups" yaml:"talkgroups"`
+	Repeaters  []Repeater  `json:"repeaters" yaml:"repeaters"`
+	Peers      []Peer      `json:"peers" yaml:"peers"`
+}
+
+type User struct {
+	ID        uint   `json:"id" yaml:"id"`
+	Callsign  string `json:"callsign" yaml:"callsign"`
+	Username  string `json:"username" yaml:"username"`
+	Admin     bool   `json:"admin" yaml:"admin"`
+	Approved  bool   `json:"approved" yaml:"approved"`
+	Suspended bool   `json:"suspended" yaml:"suspended"`
+	// PasswordHash is only exported when secrets are requested
+	PasswordHash string `json:"password_hash,omitempty" yaml:"password_hash,omitempty"`
+}
+
+type Talkgroup struct {
+	ID          uint   `json:"id" yaml:"id"`
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+	Admins      []uint `json:"admins" yaml:"admins"`
+	NCOs        []uint `json:"ncos" yaml:"ncos"`
+}
+
+type Repeater struct {
+	ID                  uint    `json:"id" yaml:"id"`
+	OwnerID             uint    `json:"owner_id" yaml:"owner_id"`
+	Callsign            string  `json:"callsign" yaml:"callsign"`
+	Hotspot             bool    `json:"hotspot" yaml:"hotspot"`
+	MapPrivacy          string  `json:"map_privacy,omitempty" yaml:"map_privacy,omitempty"`
+	RXFrequency         uint    `json:"rx_frequency" yaml:"rx_frequency"`
+	TXFrequency         uint    `json:"tx_frequency" yaml:"tx_frequency"`
+	TXPower             uint8   `json:"tx_power" yaml:"tx_power"`
+	ColorCode           uint8   `json:"color_code" yaml:"color_code"`
+	Latitude            float64 `json:"latitude" yaml:"latitude"`
+	Longitude           float64 `json:"longitude" yaml:"longitude"`
+	Height              uint16  `json:"height" yaml:"height"`
+	Location            string  `json:"location" yaml:"location"`
+	Description         string  `json:"description" yaml:"description"`
+	URL                 string  `json:"url" yaml:"url"`
+	TS1StaticTalkgroups []uint  `json:"ts1_static_talkgroups" yaml:"ts1_static_talkgroups"`
+	TS2StaticTalkgroups []uint  `json:"ts2_static_talkgroups" yaml:"ts2_static_talkgroups"`
+	TS1DynamicTalkgroup *uint   `json:"ts1_dynamic_talkgroup,omitempty" yaml:"ts1_dynamic_talkgroup,omitempty"`
+	TS2DynamicTalkgroup *uint   `json:"ts2_dynamic_talkgroup,omitempty" yaml:"ts2_dynamic_talkgroup,omitempty"`
+	// Password is only exported when secrets are requested
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+}
+
+type Peer struct {
+	ID      uint       `json:"id" yaml:"id"`
+	OwnerID uint       `json:"owner_id" yaml:"owner_id"`
+	Ingress bool       `json:"ingress" yaml:"ingress"`
+	Egress  bool       `json:"egress" yaml:"egress"`
+	Rules   []PeerRule `json:"rules" yaml:"rules"`
+	// Password is only exported when secrets are requested
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+}
+
+type PeerRule struct {
+	// Direction is either "ingress" or "egress"
+	Direction    string `json:"direction" yaml:"direction"`
+	SubjectIDMin uint   `json:"subject_id_min" yaml:"subject_id_min"`
+	SubjectIDMax uint   `json:"subject_id_max" yaml:"subject_id_max"`
+}
+
+const (
+	DirectionIngress = "ingress"
+	DirectionEgress  = "egress"
+)
+
+// FormatFromPath picks a format from a file extension, defaulting to JSON.
+func FormatFromPath(path string) Format {
+	lower := strings.ToLower(path)
+	if strings.HasSuffix(lower, ".yaml") || strings.HasSuffix(lower, ".yml") {
+		return FormatYAML
+	}
+	return FormatJSON
+}
+
+// Encode writes the document in the given format.
+func Encode(w io.Writer, doc *Document, format Format) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(doc); err != nil {
+			return fmt.Errorf("failed to encode network configuration: %w", err)
+		}
+	case FormatYAML:
+		encoder := yaml.NewEncoder(w)
+		const indent = 2
+		encoder.SetIndent(indent)
+		if err := encoder.Encode(doc); err != nil {
+			return fmt.Errorf("failed to encode network configuration: %w", err)
+		}
+		if err := encoder.Close(); err != nil {
+			return fmt.Errorf("failed to encode network configuration: %w", err)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+	return nil
+}
+
+// Decode reads a document in the given format and checks its version.
+// Unknown keys are rejected so typos don't silently drop configuration.
+func Decode(r io.Reader, format Format) (*Document, error) {
+	var doc Document
+	switch format {
+	case FormatJSON:
+		decoder := json.NewDecoder(r)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+		}
+	case FormatYAML:
+		decoder := yaml.NewDecoder(r)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+	if doc.Version != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, doc.Version)
+	}
+	return &doc, nil
+}
@@ -0,0 +1,252 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package netconfig_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/netconfig"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"gorm.io/gorm"
+)
+
+func seed(t *testing.T, database *gorm.DB) {
+	t.Helper()
+	owner := models.User{ID: 3191868, Callsign: "KI5VMF", Username: "owner", Password: "hash", Approved: true}
+	nco := models.User{ID: 3140598, Callsign: "N0CALL", Username: "nco", Password: "hash2", Approved: true}
+	local := models.Talkgroup{ID: 1, Name: "Local", Description: "Local traffic", Admins: []models.User{owner}, NCOs: []models.User{nco}}
+	worldwide := models.Talkgroup{ID: 91, Name: "Worldwide"}
+	for _, value := range []any{&owner, &nco, &local, &worldwide} {
+		if err := database.Create(value).Error; err != nil {
+			t.Fatalf("Failed to seed %T: %v", value, err)
+		}
+	}
+	dynamic := uint(91)
+	repeater := models.Repeater{
+		OwnerID:               owner.ID,
+		Password:              "s3cret",
+		TS2StaticTalkgroups:   []models.Talkgroup{local},
+		TS1DynamicTalkgroupID: &dynamic,
+		RepeaterConfiguration: models.RepeaterConfiguration{ID: 319186801, Callsign: "KI5VMF", ColorCode: 1, Location: "Somewhere"},
+	}
+	if err := database.Create(&repeater).Error; err != nil {
+		t.Fatalf("Failed to seed repeater: %v", err)
+	}
+	peer := models.Peer{ID: 1234, OwnerID: owner.ID, Ingress: true, Egress: true, Password: "peerpass"}
+	if err := database.Create(&peer).Error; err != nil {
+		t.Fatalf("Failed to seed peer: %v", err)
+	}
+	rule := models.PeerRule{PeerID: peer.ID, Direction: true, SubjectIDMin: 1, SubjectIDMax: 100}
+	if err := database.Create(&rule).Error; err != nil {
+		t.Fatalf("Failed to seed peer rule: %v", err)
+	}
+}
+
+func roundTrip(t *testing.T, doc *netconfig.Document, format netconfig.Format) *netconfig.Document {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := netconfig.Encode(&buf, doc, format); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decoded, err := netconfig.Decode(&buf, format)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	return decoded
+}
+
+func TestExportOmitsSecrets(t *testing.T) {
+	t.Parallel()
+	database := testutils.OpenDB(t)
+	seed(t, database)
+
+	doc, err := netconfig.Export(database, false)
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := netconfig.Encode(&buf, doc, netconfig.FormatJSON); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	for _, secret := range []string{"password", "s3cret", "peerpass"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("Expected export not to contain %q", secret)
+		}
+	}
+
+	doc, err = netconfig.Export(database, true)
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if doc.Users[1].PasswordHash != "hash" || doc.Repeaters[0].Password != "s3cret" || doc.Peers[0].Password != "peerpass" {
+		t.Error("Expected secrets in export")
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	t.Parallel()
+	source := testutils.OpenDB(t)
+	seed(t, source)
+
+	for _, format := range []netconfig.Format{netconfig.FormatJSON, netconfig.FormatYAML} {
+		doc, err := netconfig.Export(source, true)
+		if err != nil {
+			t.Fatalf("Failed to export: %v", err)
+		}
+		decoded := roundTrip(t, doc, format)
+
+		target := testutils.OpenDB(t)
+		report, err := netconfig.Import(target, decoded, netconfig.ModeMerge, false)
+		if err != nil {
+			t.Fatalf("Failed to import %s: %v", format, err)
+		}
+		if report.Users.Created != 2 || report.Talkgroups.Created != 2 || report.Repeaters.Created != 1 || report.Peers.Created != 1 {
+			t.Errorf("Unexpected report %+v", report)
+		}
+
+		reexported, err := netconfig.Export(target, true)
+		if err != nil {
+			t.Fatalf("Failed to export: %v", err)
+		}
+		var want, got bytes.Buffer
+		reexported.ExportedAt = doc.ExportedAt
+		if err := netconfig.Encode(&want, doc, netconfig.FormatJSON); err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		if err := netconfig.Encode(&got, reexported, netconfig.FormatJSON); err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		if want.String() != got.String() {
+			t.Errorf("Round trip through %s changed the configuration:\nwant %s\ngot %s", format, want.String(), got.String())
+		}
+
+		// Importing the same document again only updates
+		report, err = netconfig.Import(target, decoded, netconfig.ModeMerge, false)
+		if err != nil {
+			t.Fatalf("Failed to re-import: %v", err)
+		}
+		if report.Users.Created != 0 || report.Users.Updated != 2 {
+			t.Errorf("Unexpected re-import report %+v", report)
+		}
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	t.Parallel()
+	source := testutils.OpenDB(t)
+	seed(t, source)
+	doc, err := netconfig.Export(source, false)
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	target := testutils.OpenDB(t)
+	report, err := netconfig.Import(target, doc, netconfig.ModeMerge, true)
+	if err != nil {
+		t.Fatalf("Failed to dry run: %v", err)
+	}
+	if !report.DryRun || report.Repeaters.Created != 1 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	count, err := models.CountRepeaters(target)
+	if err != nil {
+		t.Fatalf("Failed to count repeaters: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected dry run not to create repeaters, found %d", count)
+	}
+}
+
+func TestImportReplace(t *testing.T) {
+	t.Parallel()
+	database := testutils.OpenDB(t)
+	seed(t, database)
+	doc, err := netconfig.Export(database, false)
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	doc.Repeaters = nil
+	doc.Peers = nil
+	doc.Talkgroups = doc.Talkgroups[:1]
+	report, err := netconfig.Import(database, doc, netconfig.ModeReplace, false)
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if report.Repeaters.Deleted != 1 || report.Peers.Deleted != 1 || report.Talkgroups.Deleted != 1 || report.Users.Deleted != 0 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if models.PeerIDExists(database, 1234) {
+		t.Error("Expected peer to be deleted")
+	}
+
+	// Passwords are kept when the document has none
+	user, err := models.FindUserByID(database, 3191868)
+	if err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+	if user.Password != "hash" {
+		t.Errorf("Expected password to be kept, got %q", user.Password)
+	}
+}
+
+func TestImportUnknownReference(t *testing.T) {
+	t.Parallel()
+	database := testutils.OpenDB(t)
+	doc := &netconfig.Document{
+		Version:    netconfig.Version,
+		Talkgroups: []netconfig.Talkgroup{{ID: 1, Name: "Local", Admins: []uint{42}}},
+	}
+	_, err := netconfig.Import(database, doc, netconfig.ModeMerge, false)
+	if !errors.Is(err, netconfig.ErrInvalidDocument) {
+		t.Errorf("Expected ErrInvalidDocument, got %v", err)
+	}
+	exists, err := models.TalkgroupIDExists(database, 1)
+	if err != nil {
+		t.Fatalf("Failed to look up talkgroup: %v", err)
+	}
+	if exists {
+		t.Error("Expected failed import to be rolled back")
+	}
+}
+
+func TestDecodeRejectsBadDocuments(t *testing.T) {
+	t.Parallel()
+	_, err := netconfig.Decode(strings.NewReader(`{"version": 2}`), netconfig.FormatJSON)
+	if !errors.Is(err, netconfig.ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+	_, err = netconfig.Decode(strings.NewReader("version: 1\ntalkgroup: []\n"), netconfig.FormatYAML)
+	if !errors.Is(err, netconfig.ErrInvalidDocument) {
+		t.Errorf("Expected ErrInvalidDocument, got %v", err)
+	}
+	_, err = netconfig.Decode(strings.NewReader(`{"version": 1, "peers": [{"id": 1, "rules": [{"direction": "sideways"}]}]}`), netconfig.FormatJSON)
+	if err != nil {
+		t.Fatalf("Expected structurally valid document to decode: %v", err)
+	}
+	_, err = netconfig.Import(nil, &netconfig.Document{Version: netconfig.Version, Peers: []netconfig.Peer{{ID: 1, Rules: []netconfig.PeerRule{{Direction: "sideways"}}}}}, netconfig.ModeMerge, false)
+	if !errors.Is(err, netconfig.ErrInvalidDocument) {
+		t.Errorf("Expected ErrInvalidDocument for bad direction, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package notify sends emails to individual users.
+package notify
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/emailtoken"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/smtp"
+)
+
+// VerifyEmailToken returns a token proving the user owns their current address.
+func VerifyEmailToken(user models.User) string {
+	return emailtoken.Issue(key(), emailtoken.PurposeVerifyEmail, user.ID, user.Email, emailtoken.VerifyEmailTTL, time.Now())
+}
+
+// ResetPasswordToken returns a token that can set a new password until the
+// password is next changed.
+func ResetPasswordToken(user models.User) string {
+	return emailtoken.Issue(key(), emailtoken.PurposeResetPassword, user.ID, user.Password, emailtoken.ResetPasswordTTL, time.Now())
+}
+
+// ParseToken checks a token mailed to a user.
+func ParseToken(purpose emailtoken.Purpose, token string) (emailtoken.Token, error) {
+	return emailtoken.Parse(key(), purpose, token, time.Now()) //nolint:golint,wrapcheck
+}
+
+// SendVerification mails the user a link to verify their address.
+func SendVerification(user models.User) error {
+	link := fmt.Sprintf("%s/verify-email?token=%s", config.GetConfig().CanonicalHost, url.QueryEscape(VerifyEmailToken(user)))
+	return smtp.Send( //nolint:golint,wrapcheck
+		user.Email,
+		"Verify your email address",
+		fmt.Sprintf("Hi %s,<br><br><a href=\"%s\">Click here</a> to verify your email address for %s. The link expires in 24 hours.<br><br>If you did not request this, you can ignore this email.",
+			html.EscapeString(user.Callsign), link, html.EscapeString(config.GetConfig().NetworkName)),
+	)
+}
+
+// SendPasswordReset mails the user a link to choose a new password.
+func SendPasswordReset(user models.User) error {
+	link := fmt.Sprintf("%s/reset-password?token=%s", config.GetConfig().CanonicalHost, url.QueryEscape(ResetPasswordToken(user)))
+	return smtp.Send( //nolint:golint,wrapcheck
+		user.Email,
+		"Reset your password",
+		fmt.Sprintf("Hi %s,<br><br>Someone asked to reset the password for %s on %s. <a href=\"%s\">Click here</a> to choose a new one. The link expires in an hour and can only be used once.<br><br>If you did not request this, you can ignore this email.",
+			html.EscapeString(user.Callsign), html.EscapeString(user.Username), html.EscapeString(config.GetConfig().NetworkName), link),
+	)
+}
+
+// AccountApproved tells the user an admin has approved their account.
+func AccountApproved(user models.User) {
+	send(user, "Your account has been approved",
+		fmt.Sprintf("Your account on %s has been approved. <a href=\"%s/login\">Click here</a> to log in.",
+			html.EscapeString(config.GetConfig().NetworkName), config.GetConfig().CanonicalHost))
+}
+
+// AccountSuspended tells the user their account has been suspended.
+func AccountSuspended(user models.User) {
+	send(user, "Your account has been suspended",
+		fmt.Sprintf("Your account on %s has been suspended by an admin. Contact the network admins if you think this is a mistake.",
+			html.EscapeString(config.GetConfig().NetworkName)))
+}
+
+// AccountUnsuspended tells the user their account is active again.
+func AccountUnsuspended(user models.User) {
+	send(user, "Your account has been unsuspended",
+		fmt.Sprintf("Your account on %s is active again.", html.EscapeString(config.GetConfig().NetworkName)))
+}
+
+// send delivers a notification if email is enabled and the user has a verified address.
+func send(user models.User, subject, body string) {
+	if !config.GetConfig().EnableEmail || user.Email == "" || !user.EmailVerified {
+		return
+	}
+	err := smtp.Send(user.Email, subject, fmt.Sprintf("Hi %s,<br><br>%s", html.EscapeString(user.Callsign), body))
+	if err != nil {
+		logging.Errorf("Error sending %q email to user %d: %v", subject, user.ID, err)
+	}
+}
+
+func key() []byte {
+	return []byte(config.GetConfig().Secret)
+}
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package pubsub
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+)
+
+// bufferSize is how many messages a subscriber can fall behind by before
+// messages to it are dropped. Publishers never wait for a slow subscriber, as
+// they are the DMR servers relaying voice, so one stuck websocket can't hold up
+// the network.
+const bufferSize = 100
+
+type memoryPubSub struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[*memorySubscription]struct{}
+}
+
+// NewMemory carries messages between goroutines of this process.
+// It can only be used when a single DMRHub instance is running.
+func NewMemory() PubSub {
+	return &memoryPubSub{
+		subscribers: make(map[string]map[*memorySubscription]struct{}),
+	}
+}
+
+func (m *memoryPubSub) Publish(_ context.Context, channel string, payload []byte) error {
+	m.mu.RLock()
+	subs := make([]*memorySubscription, 0, len(m.subscribers[channel]))
+	for sub := range m.subscribers[channel] {
+		subs = append(subs, sub)
+	}
+	m.mu.RUnlock()
+	for _, sub := range subs {
+		sub.deliver(Message{Channel: channel, Payload: slices.Clone(payload)})
+	}
+	return nil
+}
+
+func (m *memoryPubSub) Subscribe(_ context.Context, channels ...string) Subscription {
+	sub := &memorySubscription{
+		pubsub:   m,
+		channels: slices.Clone(channels),
+		messages: make(chan Message, bufferSize),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, channel := range channels {
+		if m.subscribers[channel] == nil {
+			m.subscribers[channel] = make(map[*memorySubscription]struct{})
+		}
+		m.subscribers[channel][sub] = struct{}{}
+	}
+	return sub
+}
+
+func (m *memoryPubSub) unsubscribe(sub *memorySubscription) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, channel := range sub.channels {
+		delete(m.subscribers[channel], sub)
+		if len(m.subscribers[channel]) == 0 {
+			delete(m.subscribers, channel)
+		}
+	}
+}
+
+type memorySubscription struct {
+	pubsub   *memoryPubSub
+	channels []string
+	messages chan Message
+	mu       sync.RWMutex
+	closed   bool
+	// dropping is set while messages are being dropped, so only the first is logged
+	dropping  atomic.Bool
+	closeOnce sync.Once
+}
+
+// deliver adds the message to the subscriber's buffer, dropping it if the buffer is full.
+func (s *memorySubscription) deliver(msg Message) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.messages <- msg:
+		s.dropping.Store(false)
+	default:
+		if !s.dropping.Swap(true) {
+			logging.Errorf("Dropping messages on %s, subscriber is not keeping up", msg.Channel)
+		}
+	}
+}
+
+func (s *memorySubscription) Channel() <-chan Message {
+	return s.messages
+}
+
+func (s *memorySubscription) Close() error {
+	s.closeOnce.Do(func() {
+		s.pubsub.unsubscribe(s)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		close(s.messages)
+	})
+	return nil
+}
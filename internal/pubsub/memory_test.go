@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package pubsub_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
+)
+
+func receive(t *testing.T, sub pubsub.Subscription) pubsub.Message {
+	t.Helper()
+	select {
+	case msg := <-sub.Channel():
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+	return pubsub.Message{}
+}
+
+func TestMemoryFanOut(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ps := pubsub.NewMemory()
+
+	first := ps.Subscribe(ctx, "calls")
+	second := ps.Subscribe(ctx, "calls", "hbrp:repeaters:state")
+	other := ps.Subscribe(ctx, "calls:public")
+	defer first.Close()
+	defer second.Close()
+	defer other.Close()
+
+	_ = ps.Publish(ctx, "calls", []byte("call"))
+	_ = ps.Publish(ctx, "hbrp:repeaters:state", []byte("state"))
+
+	if msg := receive(t, first); msg.Channel != "calls" || string(msg.Payload) != "call" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if msg := receive(t, second); msg.Channel != "calls" || string(msg.Payload) != "call" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if msg := receive(t, second); msg.Channel != "hbrp:repeaters:state" || string(msg.Payload) != "state" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	select {
+	case msg := <-other.Channel():
+		t.Errorf("expected no message on another channel, got %+v", msg)
+	default:
+	}
+}
+
+func TestMemoryClose(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ps := pubsub.NewMemory()
+
+	sub := ps.Subscribe(ctx, "hbrp:incoming")
+	if err := sub.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Close(); err != nil {
+		t.Fatalf("expected closing twice to succeed, got %v", err)
+	}
+	if _, ok := <-sub.Channel(); ok {
+		t.Error("expected the channel to be closed")
+	}
+	// Publishing with no subscribers left must not block or panic
+	if err := ps.Publish(ctx, "hbrp:incoming", []byte("packet")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryCloseUnblocksPublisher(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ps := pubsub.NewMemory()
+
+	sub := ps.Subscribe(ctx, "hbrp:outgoing")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// More than the buffer holds, nobody is reading
+		for range 200 {
+			_ = ps.Publish(ctx, "hbrp:outgoing", []byte("packet"))
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_ = sub.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publisher stayed blocked after the subscription was closed")
+	}
+}
+
+func TestMemorySlowSubscriberDoesNotBlock(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ps := pubsub.NewMemory()
+
+	slow := ps.Subscribe(ctx, "hbrp:outgoing")
+	defer slow.Close()
+	start := time.Now()
+	// More than the buffer holds, nobody is reading
+	for range 200 {
+		_ = ps.Publish(ctx, "hbrp:outgoing", []byte("packet"))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected publishing not to wait for the subscriber, took %v", elapsed)
+	}
+
+	// A subscriber that keeps up still gets every message
+	fast := ps.Subscribe(ctx, "hbrp:outgoing")
+	defer fast.Close()
+	_ = ps.Publish(ctx, "hbrp:outgoing", []byte("next"))
+	if msg := receive(t, fast); string(msg.Payload) != "next" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	received := 0
+	for range len(slow.Channel()) {
+		<-slow.Channel()
+		received++
+	}
+	if received != 100 {
+		t.Errorf("expected the slow subscriber to keep a full buffer, got %d messages", received)
+	}
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package pubsub carries messages between the DMR servers and the HTTP API.
+// It is backed by Redis, or kept in memory when DMRHub runs as a single node.
+package pubsub
+
+import "context"
+
+// Message is a payload received on a channel.
+type Message struct {
+	Channel string
+	Payload []byte
+}
+
+// Subscription receives the messages published on the channels it was created for.
+type Subscription interface {
+	// Channel is closed once the subscription is closed.
+	Channel() <-chan Message
+	// Close ends the subscription. It is safe to call more than once.
+	Close() error
+}
+
+// PubSub publishes messages to every subscriber of a channel.
+type PubSub interface {
+	Publish(ctx context.Context, channel string, payload []byte) error
+	Subscribe(ctx context.Context, channels ...string) Subscription
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type redisPubSub struct {
+	client *redis.Client
+}
+
+// NewRedis carries messages over Redis pubsub.
+func NewRedis(client *redis.Client) PubSub {
+	return &redisPubSub{client: client}
+}
+
+func (r *redisPubSub) Publish(ctx context.Context, channel string, payload []byte) error {
+	err := r.client.Publish(ctx, channel, payload).Err()
+	if err != nil {
+		return fmt.Errorf("redis publish %s: %w", channel, err)
+	}
+	return nil
+}
+
+func (r *redisPubSub) Subscribe(ctx context.Context, channels ...string) Subscription {
+	sub := &redisSubscription{
+		pubsub:   r.client.Subscribe(ctx, channels...),
+		messages: make(chan Message),
+		done:     make(chan struct{}),
+	}
+	go sub.forward()
+	return sub
+}
+
+type redisSubscription struct {
+	pubsub    *redis.PubSub
+	messages  chan Message
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// forward copies messages from Redis until the subscription is closed.
+func (s *redisSubscription) forward() {
+	defer close(s.messages)
+	in := s.pubsub.Channel()
+	for {
+		select {
+		case <-s.done:
+			return
+		case msg, ok := <-in:
+			if !ok {
+				return
+			}
+			select {
+			case s.messages <- Message{Channel: msg.Channel, Payload: []byte(msg.Payload)}:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+func (s *redisSubscription) Channel() <-chan Message {
+	return s.messages
+}
+
+func (s *redisSubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err := s.pubsub.Close()
+		if err != nil {
+			s.err = fmt.Errorf("redis unsubscribe: %w", err)
+		}
+	})
+	return s.err
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package queue
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
+)
+
+type pubsubQueue struct {
+	pubsub  pubsub.PubSub
+	channel string
+}
+
+// NewPubSub publishes each message on channel. Every consumer receives every
+// message, so only one DMRHub instance should consume it.
+func NewPubSub(ps pubsub.PubSub, channel string) Queue {
+	return &pubsubQueue{
+		pubsub:  ps,
+		channel: channel,
+	}
+}
+
+func (q *pubsubQueue) Add(ctx context.Context, _ uint, payload []byte) error {
+	err := q.pubsub.Publish(ctx, q.channel, payload)
+	if err != nil {
+		return fmt.Errorf("queue %s: %w", q.channel, err)
+	}
+	return nil
+}
+
+func (q *pubsubQueue) Consume(ctx context.Context, handler Handler) error {
+	sub := q.pubsub.Subscribe(ctx, q.channel)
+	defer func() {
+		err := sub.Close()
+		if err != nil {
+			logging.Errorf("Error closing pubsub: %v", err)
+		}
+	}()
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			handler(ctx, msg.Payload)
+		}
+	}
+}
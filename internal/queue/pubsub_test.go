@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
+	"github.com/USA-RedDragon/DMRHub/internal/queue"
+)
+
+func TestPubSubQueue(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := queue.NewPubSub(pubsub.NewMemory(), "hbrp:incoming")
+	received := make(chan []byte, 1)
+	done := make(chan error)
+	go func() {
+		done <- q.Consume(ctx, func(_ context.Context, payload []byte) {
+			select {
+			case received <- payload:
+			default:
+			}
+		})
+	}()
+
+	// The consumer subscribes in the background, so keep adding until it is listening
+	var payload []byte
+	for payload == nil {
+		if err := q.Add(ctx, 1234, []byte("packet")); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		select {
+		case payload = <-received:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if string(payload) != "packet" {
+		t.Errorf("Expected packet, got %q", payload)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Consume did not return after the context was cancelled")
+	}
+}
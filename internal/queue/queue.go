@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package queue hands each incoming packet to one of the DMRHub instances sharing a network.
+// It is backed by Redis Streams, with pubsub as a fallback for a single instance.
+package queue
+
+import "context"
+
+// Handler processes one message from the queue.
+type Handler func(ctx context.Context, payload []byte)
+
+// Queue delivers messages to consumers. Messages added with the same key are
+// kept in order on one shard.
+type Queue interface {
+	// Add queues payload on the shard of key.
+	Add(ctx context.Context, key uint, payload []byte) error
+	// Consume hands messages to handler, one at a time, until ctx is done.
+	Consume(ctx context.Context, handler Handler) error
+}
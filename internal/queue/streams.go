@@ -0,0 +1,321 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// group is the consumer group that all DMRHub instances read the streams with
+	group = "dmrhub"
+	// payloadField is the stream entry field holding the message
+	payloadField = "payload"
+	// heartbeatInterval is how often a consumer announces itself and rebalances its shards
+	heartbeatInterval = time.Second
+	// consumerTTL is how long a consumer keeps its shards after its last heartbeat
+	consumerTTL = 5 * time.Second
+	// readCount is the most entries read or claimed at once
+	readCount = 100
+	// maxAge is how old a claimed entry can be and still be handled.
+	// Older packets belong to calls that have already moved on.
+	maxAge = 10 * time.Second
+	// consumerExpiry is how long an idle consumer with nothing pending stays in the group
+	consumerExpiry = time.Hour
+)
+
+// StreamsOptions configures a queue backed by Redis Streams.
+type StreamsOptions struct {
+	// Shards is the number of streams the messages are split over
+	Shards int
+	// Backlog is roughly the most entries each stream keeps
+	Backlog int
+}
+
+type streamsQueue struct {
+	client   *redis.Client
+	name     string
+	consumer string
+	shards   int
+	backlog  int64
+}
+
+// NewStreams splits the queue over the Redis Streams name:0 to name:<shards-1>.
+// The live consumers share out the shards between themselves, and read them
+// through one consumer group, so every message is handled by a single instance.
+// Entries are acknowledged once handled, and entries left pending by a consumer
+// that went away are claimed by the shard's new owner.
+func NewStreams(client *redis.Client, name string, opts StreamsOptions) Queue {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "dmrhub"
+	}
+	suffix := make([]byte, 4) //nolint:golint,mnd
+	_, _ = rand.Read(suffix)
+	return &streamsQueue{
+		client:   client,
+		name:     name,
+		consumer: hostname + "-" + hex.EncodeToString(suffix),
+		shards:   opts.Shards,
+		backlog:  int64(opts.Backlog),
+	}
+}
+
+func (q *streamsQueue) stream(shard int) string {
+	return q.name + ":" + strconv.Itoa(shard)
+}
+
+func (q *streamsQueue) consumersKey() string {
+	return q.name + ":consumers"
+}
+
+func (q *streamsQueue) Add(ctx context.Context, key uint, payload []byte) error {
+	stream := q.stream(int(key % uint(q.shards)))
+	err := q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: q.backlog,
+		Approx: true,
+		Values: []any{payloadField, payload},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("redis xadd %s: %w", stream, err)
+	}
+	return nil
+}
+
+func (q *streamsQueue) Consume(ctx context.Context, handler Handler) error {
+	for shard := range q.shards {
+		err := q.client.XGroupCreateMkStream(ctx, q.stream(shard), group, "$").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("redis xgroup create %s: %w", q.stream(shard), err)
+		}
+	}
+	defer q.leave(ctx)
+
+	var owned []int
+	var lastHeartbeat, lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastHeartbeat) >= heartbeatInterval {
+			shards, err := q.heartbeat(ctx)
+			if err != nil {
+				logging.Errorf("Error announcing consumer %s: %v", q.consumer, err)
+			} else {
+				lastHeartbeat = time.Now()
+				if !slices.Equal(owned, shards) {
+					logging.Logf("Consumer %s now handles %d of %d shards of %s", q.consumer, len(shards), q.shards, q.name)
+					owned = shards
+					// Pick up what the previous owners left pending straight away
+					lastClaim = time.Time{}
+				}
+			}
+		}
+		if time.Since(lastClaim) >= consumerTTL {
+			for _, shard := range owned {
+				q.claim(ctx, shard, handler)
+			}
+			lastClaim = time.Now()
+		}
+		if len(owned) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(heartbeatInterval):
+			}
+			continue
+		}
+		q.read(ctx, owned, handler)
+	}
+	return nil
+}
+
+// heartbeat records that this consumer is alive, forgets consumers that stopped
+// sending heartbeats, and returns the shards this consumer owns.
+func (q *streamsQueue) heartbeat(ctx context.Context) ([]int, error) {
+	now := time.Now()
+	var members *redis.StringSliceCmd
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, q.consumersKey(), redis.Z{Score: float64(now.UnixMilli()), Member: q.consumer})
+		pipe.ZRemRangeByScore(ctx, q.consumersKey(), "-inf", strconv.FormatInt(now.Add(-consumerTTL).UnixMilli(), 10))
+		members = pipe.ZRange(ctx, q.consumersKey(), 0, -1)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("redis heartbeat %s: %w", q.consumersKey(), err)
+	}
+	return ownedShards(q.consumer, members.Val(), q.shards), nil
+}
+
+// leave hands this consumer's shards to the others without waiting for it to time out.
+func (q *streamsQueue) leave(ctx context.Context) {
+	err := q.client.ZRem(context.WithoutCancel(ctx), q.consumersKey(), q.consumer).Err()
+	if err != nil {
+		logging.Errorf("Error removing consumer %s: %v", q.consumer, err)
+	}
+}
+
+// read waits briefly for new entries on the owned shards and handles them.
+func (q *streamsQueue) read(ctx context.Context, shards []int, handler Handler) {
+	streams := make([]string, 0, 2*len(shards)) //nolint:golint,mnd
+	for _, shard := range shards {
+		streams = append(streams, q.stream(shard))
+	}
+	for range shards {
+		streams = append(streams, ">")
+	}
+	results, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: q.consumer,
+		Streams:  streams,
+		Count:    readCount,
+		Block:    heartbeatInterval,
+	}).Result()
+	if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		logging.Errorf("Error reading %s: %v", q.name, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(heartbeatInterval):
+		}
+		return
+	}
+	for _, result := range results {
+		for _, msg := range result.Messages {
+			q.handle(ctx, result.Stream, msg, handler)
+		}
+	}
+}
+
+// claim takes over the entries of shard that another consumer read but never acknowledged.
+func (q *streamsQueue) claim(ctx context.Context, shard int, handler Handler) {
+	stream := q.stream(shard)
+	start := "0-0"
+	for {
+		messages, next, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    group,
+			MinIdle:  consumerTTL,
+			Start:    start,
+			Count:    readCount,
+			Consumer: q.consumer,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				logging.Errorf("Error claiming pending entries of %s: %v", stream, err)
+			}
+			return
+		}
+		for _, msg := range messages {
+			if entryAge(msg.ID) > maxAge {
+				q.ack(ctx, stream, msg.ID)
+				continue
+			}
+			q.handle(ctx, stream, msg, handler)
+		}
+		if next == "0-0" {
+			break
+		}
+		start = next
+	}
+	q.pruneConsumers(ctx, stream)
+}
+
+func (q *streamsQueue) handle(ctx context.Context, stream string, msg redis.XMessage, handler Handler) {
+	payload, ok := msg.Values[payloadField].(string)
+	if ok {
+		handler(ctx, []byte(payload))
+	} else {
+		logging.Errorf("Dropping malformed entry %s on %s", msg.ID, stream)
+	}
+	q.ack(ctx, stream, msg.ID)
+}
+
+func (q *streamsQueue) ack(ctx context.Context, stream string, id string) {
+	err := q.client.XAck(ctx, stream, group, id).Err()
+	if err != nil {
+		logging.Errorf("Error acknowledging entry %s on %s: %v", id, stream, err)
+	}
+}
+
+// pruneConsumers removes consumers of instances that are long gone from the group.
+func (q *streamsQueue) pruneConsumers(ctx context.Context, stream string) {
+	consumers, err := q.client.XInfoConsumers(ctx, stream, group).Result()
+	if err != nil {
+		logging.Errorf("Error listing consumers of %s: %v", stream, err)
+		return
+	}
+	for _, consumer := range consumers {
+		if consumer.Name == q.consumer || consumer.Pending > 0 || consumer.Idle < consumerExpiry {
+			continue
+		}
+		err := q.client.XGroupDelConsumer(ctx, stream, group, consumer.Name).Err()
+		if err != nil {
+			logging.Errorf("Error removing consumer %s from %s: %v", consumer.Name, stream, err)
+		}
+	}
+}
+
+// ownedShards returns the shards that consumer owns out of members. Each shard
+// goes to the member with the highest hash of its name and the shard, which
+// spreads the shards evenly and only moves those of a member joining or leaving.
+func ownedShards(consumer string, members []string, shards int) []int {
+	var owned []int
+	for shard := range shards {
+		var owner string
+		var highest uint64
+		for _, member := range members {
+			h := fnv.New64a()
+			_, _ = h.Write([]byte(member + ":" + strconv.Itoa(shard)))
+			score := h.Sum64()
+			if owner == "" || score > highest || (score == highest && member < owner) {
+				owner = member
+				highest = score
+			}
+		}
+		if owner == consumer {
+			owned = append(owned, shard)
+		}
+	}
+	return owned
+}
+
+// entryAge returns how long ago a stream entry was added, going by the
+// millisecond timestamp at the start of its ID.
+func entryAge(id string) time.Duration {
+	millis, _, _ := strings.Cut(id, "-")
+	added, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Since(time.UnixMilli(added))
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package queue
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOwnedShards(t *testing.T) {
+	t.Parallel()
+	const shards = 64
+	members := []string{"a", "b", "c"}
+
+	seen := map[int]string{}
+	for _, member := range members {
+		for _, shard := range ownedShards(member, members, shards) {
+			if owner, ok := seen[shard]; ok {
+				t.Fatalf("Shard %d is owned by both %s and %s", shard, owner, member)
+			}
+			seen[shard] = member
+		}
+	}
+	if len(seen) != shards {
+		t.Fatalf("Expected all %d shards to be owned, got %d", shards, len(seen))
+	}
+
+	// Only the shards of a member that leaves should move
+	remaining := []string{"a", "c"}
+	for _, member := range remaining {
+		for _, shard := range ownedShards(member, remaining, shards) {
+			if seen[shard] != member && seen[shard] != "b" {
+				t.Errorf("Shard %d moved from %s to %s", shard, seen[shard], member)
+			}
+		}
+	}
+
+	if owned := ownedShards("d", members, shards); len(owned) != 0 {
+		t.Errorf("Expected a consumer that is not a member to own nothing, got %v", owned)
+	}
+}
+
+func TestEntryAge(t *testing.T) {
+	t.Parallel()
+	id := strconv.FormatInt(time.Now().Add(-time.Minute).UnixMilli(), 10) + "-0"
+	age := entryAge(id)
+	if age < time.Minute || age > 2*time.Minute {
+		t.Errorf("Expected an age of about a minute, got %v", age)
+	}
+	if age := entryAge("not-an-id"); age != 0 {
+		t.Errorf("Expected a malformed ID to have no age, got %v", age)
+	}
+}
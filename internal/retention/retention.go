@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package retention removes old calls and their voice data. Calls are rolled
+// up into daily summaries before they are deleted so that long term
+// statistics keep working.
+package retention
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"gorm.io/gorm"
+)
+
+const batchSize = 500
+
+var errConcurrentDelete = errors.New("calls were deleted concurrently")
+
+// summaryColumns are the columns of a call needed to summarize it
+var summaryColumns = []string{ //nolint:golint,gochecknoglobals
+	"id", "start_time", "duration", "user_id", "repeater_id", "group_call",
+	"is_to_talkgroup", "to_talkgroup_id", "loss", "jitter", "ber", "rssi",
+}
+
+// Policy is how many days one kind of call is kept. Zero keeps it forever.
+type Policy struct {
+	GroupCall bool
+	// CallDataDays is how long the voice data of a call is kept
+	CallDataDays int
+	// CallDays is how long the call itself is kept
+	CallDays int
+}
+
+// Policies returns the configured policies for group and private calls.
+func Policies(cfg *config.Config) []Policy {
+	return []Policy{
+		{GroupCall: true, CallDataDays: cfg.GroupCallDataRetention, CallDays: cfg.GroupCallRetention},
+		{GroupCall: false, CallDataDays: cfg.PrivateCallDataRetention, CallDays: cfg.PrivateCallRetention},
+	}
+}
+
+// Run applies the policies. It is safe to run on several instances at once.
+func Run(ctx context.Context, db *gorm.DB, policies []Policy, now time.Time) {
+	db = db.WithContext(ctx)
+	for _, policy := range policies {
+		kind := "private"
+		if policy.GroupCall {
+			kind = "group"
+		}
+		if policy.CallDataDays > 0 {
+			dropped, err := DropCallData(db, policy.GroupCall, now.AddDate(0, 0, -policy.CallDataDays))
+			if err != nil {
+				logging.Errorf("Retention: Error dropping %s call data: %v", kind, err)
+			} else if dropped > 0 {
+				logging.Logf("Retention: Dropped the voice data of %d %s calls", dropped, kind)
+			}
+		}
+		if policy.CallDays > 0 {
+			deleted, err := DeleteCalls(db, policy.GroupCall, now.AddDate(0, 0, -policy.CallDays))
+			if err != nil {
+				logging.Errorf("Retention: Error deleting %s calls: %v", kind, err)
+			} else if deleted > 0 {
+				logging.Logf("Retention: Deleted %d %s calls", deleted, kind)
+			}
+		}
+	}
+}
+
+// DropCallData removes the voice data of calls that started before the given time.
+func DropCallData(db *gorm.DB, groupCall bool, before time.Time) (int64, error) {
+	result := db.Unscoped().Model(&models.Call{}).
+		Where("group_call = ? AND active = ? AND start_time < ? AND call_data IS NOT NULL", groupCall, false, before).
+		Update("call_data", nil)
+	return result.RowsAffected, result.Error //nolint:golint,wrapcheck
+}
+
+// DeleteCalls deletes calls that started before the given time, adding them
+// to the call summaries in the same transaction. A batch that another
+// instance deleted part of is rolled back and selected again, so every call
+// is summarized exactly once.
+func DeleteCalls(db *gorm.DB, groupCall bool, before time.Time) (int, error) {
+	deleted := 0
+	for {
+		var calls []models.Call
+		err := db.Unscoped().Select(summaryColumns).
+			Where("group_call = ? AND active = ? AND start_time < ?", groupCall, false, before).
+			Order("id asc").Limit(batchSize).Find(&calls).Error
+		if err != nil {
+			return deleted, err //nolint:golint,wrapcheck
+		}
+		if len(calls) == 0 {
+			return deleted, nil
+		}
+
+		ids := make([]uint, 0, len(calls))
+		for _, call := range calls {
+			ids = append(ids, call.ID)
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Unscoped().Where("id IN ?", ids).Delete(&models.Call{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected != int64(len(ids)) {
+				return errConcurrentDelete
+			}
+			return models.AddCallSummaries(tx, Summarize(calls))
+		})
+		if errors.Is(err, errConcurrentDelete) {
+			continue
+		}
+		if err != nil {
+			return deleted, err //nolint:golint,wrapcheck
+		}
+		deleted += len(calls)
+		if len(calls) < batchSize {
+			return deleted, nil
+		}
+	}
+}
+
+type summaryKey struct {
+	day         time.Time
+	subjectType string
+	subjectID   uint
+	groupCall   bool
+}
+
+// Summarize rolls calls up into one summary per UTC day for the calling user,
+// the source repeater and the destination talkgroup of each call.
+func Summarize(calls []models.Call) []models.CallSummary {
+	summaries := map[summaryKey]*models.CallSummary{}
+	add := func(call models.Call, subjectType string, subjectID uint) {
+		start := call.StartTime.UTC()
+		key := summaryKey{
+			day:         time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC),
+			subjectType: subjectType,
+			subjectID:   subjectID,
+			groupCall:   call.GroupCall,
+		}
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &models.CallSummary{
+				Day:         key.day,
+				SubjectType: subjectType,
+				SubjectID:   subjectID,
+				GroupCall:   call.GroupCall,
+			}
+			summaries[key] = summary
+		}
+		summary.Calls++
+		summary.Duration += call.Duration
+		summary.LossSum += float64(call.Loss)
+		summary.JitterSum += float64(call.Jitter)
+		summary.BERSum += float64(call.BER)
+		summary.RSSISum += float64(call.RSSI)
+	}
+	for _, call := range calls {
+		add(call, models.SummaryUser, call.UserID)
+		add(call, models.SummaryRepeater, call.RepeaterID)
+		if call.IsToTalkgroup && call.ToTalkgroupID != nil {
+			add(call, models.SummaryTalkgroup, *call.ToTalkgroupID)
+		}
+	}
+
+	result := make([]models.CallSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		result = append(result, *summary)
+	}
+	// A stable order keeps concurrent upserts from deadlocking
+	slices.SortFunc(result, func(a, b models.CallSummary) int {
+		return cmp.Or(
+			a.Day.Compare(b.Day),
+			cmp.Compare(a.SubjectType, b.SubjectType),
+			cmp.Compare(a.SubjectID, b.SubjectID),
+			compareBool(a.GroupCall, b.GroupCall),
+		)
+	})
+	return result
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package retention_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/retention"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func groupCall(start time.Time, talkgroupID uint) models.Call {
+	return models.Call{
+		CallData:      []byte{1, 2, 3},
+		StartTime:     start,
+		Duration:      10 * time.Second,
+		UserID:        3191868,
+		RepeaterID:    311860,
+		GroupCall:     true,
+		IsToTalkgroup: true,
+		ToTalkgroupID: &talkgroupID,
+		DestinationID: talkgroupID,
+		Loss:          0.1,
+	}
+}
+
+func privateCall(start time.Time) models.Call {
+	userID := uint(3140598)
+	return models.Call{
+		CallData:      []byte{1, 2, 3},
+		StartTime:     start,
+		Duration:      5 * time.Second,
+		UserID:        3191868,
+		RepeaterID:    311860,
+		IsToUser:      true,
+		ToUserID:      &userID,
+		DestinationID: userID,
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	db := testutils.OpenDB(t)
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	day := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+
+	calls := []models.Call{
+		groupCall(day(1), 3100),  // kept with its data
+		groupCall(day(10), 3100), // data dropped
+		groupCall(day(40), 3100), // deleted
+		groupCall(day(40), 91),   // deleted
+		privateCall(day(3)),      // data dropped
+		privateCall(day(10)),     // deleted
+	}
+	require.NoError(t, db.Create(&calls).Error)
+
+	policies := []retention.Policy{
+		{GroupCall: true, CallDataDays: 7, CallDays: 30},
+		{GroupCall: false, CallDataDays: 2, CallDays: 7},
+	}
+	retention.Run(context.Background(), db, policies, now)
+
+	var remaining []models.Call
+	require.NoError(t, db.Unscoped().Order("id asc").Find(&remaining).Error)
+	require.Len(t, remaining, 3)
+	assert.Equal(t, calls[0].ID, remaining[0].ID)
+	assert.NotEmpty(t, remaining[0].CallData)
+	assert.Equal(t, calls[1].ID, remaining[1].ID)
+	assert.Empty(t, remaining[1].CallData)
+	assert.Equal(t, calls[4].ID, remaining[2].ID)
+	assert.Empty(t, remaining[2].CallData)
+
+	summaries, err := models.ListCallSummaries(db, models.SummaryUser, 3191868, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, summaries, 2)
+	// The private call from 10 days ago comes first, then both group calls from 40 days ago
+	assert.False(t, summaries[1].GroupCall)
+	assert.Equal(t, uint(1), summaries[1].Calls)
+	assert.Equal(t, day(10).Truncate(24*time.Hour), summaries[1].Day.UTC())
+	assert.True(t, summaries[0].GroupCall)
+	assert.Equal(t, uint(2), summaries[0].Calls)
+	assert.Equal(t, 20*time.Second, summaries[0].Duration)
+	assert.InDelta(t, 0.2, summaries[0].LossSum, 0.0001)
+
+	summaries, err = models.ListCallSummaries(db, models.SummaryTalkgroup, 3100, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, uint(1), summaries[0].Calls)
+
+	// Running again changes nothing
+	retention.Run(context.Background(), db, policies, now)
+	summaries, err = models.ListCallSummaries(db, models.SummaryRepeater, 311860, time.Time{})
+	require.NoError(t, err)
+	total := uint(0)
+	for _, summary := range summaries {
+		total += summary.Calls
+	}
+	assert.Equal(t, uint(3), total)
+}
+
+func TestDeleteCallsAddsToExistingSummaries(t *testing.T) {
+	t.Parallel()
+	db := testutils.OpenDB(t)
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	require.NoError(t, db.Create([]models.Call{groupCall(start, 3100)}).Error)
+	_, err := retention.DeleteCalls(db, true, start.Add(time.Hour))
+	require.NoError(t, err)
+
+	// A later call on the same day is added to the same summary
+	require.NoError(t, db.Create([]models.Call{groupCall(start.Add(2*time.Hour), 3100)}).Error)
+	deleted, err := retention.DeleteCalls(db, true, start.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	summaries, err := models.ListCallSummaries(db, models.SummaryTalkgroup, 3100, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, uint(2), summaries[0].Calls)
+	assert.Equal(t, 20*time.Second, summaries[0].Duration)
+}
+
+func TestDisabledPolicyKeepsCalls(t *testing.T) {
+	t.Parallel()
+	db := testutils.OpenDB(t)
+	now := time.Now()
+	require.NoError(t, db.Create([]models.Call{groupCall(now.AddDate(-5, 0, 0), 3100)}).Error)
+
+	retention.Run(context.Background(), db, []retention.Policy{{GroupCall: true}}, now)
+
+	var call models.Call
+	require.NoError(t, db.First(&call).Error)
+	assert.NotEmpty(t, call.CallData)
+}
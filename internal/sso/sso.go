@@ -0,0 +1,275 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package sso implements single sign-on through an OpenID Connect provider.
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/userdb"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDisabled        = errors.New("OIDC is not configured")
+	ErrNoIDToken       = errors.New("token response did not contain an ID token")
+	ErrNonceMismatch   = errors.New("ID token nonce does not match")
+	ErrMissingClaim    = errors.New("ID token is missing the user claim")
+	ErrUnverifiedClaim = errors.New("ID token user claim is not verified")
+	ErrUnknownUser     = errors.New("no user matches the ID token")
+	ErrNoDMRID         = errors.New("ID token has no valid DMR ID to create a user with")
+	ErrUserConflict    = errors.New("a different user already has this DMR ID or username")
+)
+
+// Provider is a discovered OpenID Connect provider.
+type Provider struct {
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+//nolint:golint,gochecknoglobals
+var (
+	providerMu sync.Mutex
+	provider   *Provider
+)
+
+// Enabled reports whether an OIDC provider is configured.
+func Enabled() bool {
+	return config.GetConfig().OIDCIssuerURL != ""
+}
+
+// GetProvider returns the configured provider, running discovery on first use.
+// Failed discovery is retried on the next call rather than cached.
+func GetProvider(ctx context.Context) (*Provider, error) {
+	if !Enabled() {
+		return nil, ErrDisabled
+	}
+
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if provider != nil {
+		return provider, nil
+	}
+
+	cfg := config.GetConfig()
+	discovered, err := NewProvider(ctx, cfg.OIDCIssuerURL, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL, cfg.OIDCScopes)
+	if err != nil {
+		return nil, err
+	}
+	provider = discovered
+	return provider, nil
+}
+
+// NewProvider runs discovery against the issuer and sets up the client.
+func NewProvider(ctx context.Context, issuer, clientID, clientSecret, redirectURL string, scopes []string) (*Provider, error) {
+	discovered, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	return &Provider{
+		oauth2: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     discovered.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: discovered.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// RandomString returns a URL safe random string for states and nonces.
+func RandomString() (string, error) {
+	const length = 32
+	b := make([]byte, length)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewVerifier returns a new PKCE code verifier.
+func NewVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// AuthCodeURL is where the user is sent to log in with the provider.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange trades an authorization code for tokens and returns the claims
+// of the ID token once its signature, issuer, audience, expiry and nonce
+// have been checked.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (map[string]any, error) {
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrNoIDToken
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+	var claims map[string]any
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ID token claims: %w", err)
+	}
+	return claims, nil
+}
+
+// Identity is who an ID token says the user is. Only claims the provider
+// vouches for are kept, since users can often edit their own profile.
+type Identity struct {
+	// Callsign is empty unless the user claim is verified or trusted
+	Callsign string
+	// Email is empty unless the provider verified the address
+	Email string
+	// DMRID is zero if the token didn't carry one
+	DMRID uint
+}
+
+// IdentityFromClaims maps ID token claims to an identity. The user claim is
+// only used if the provider marks it verified, as with email and
+// email_verified, or if the admin trusts it because users can't change it.
+// A verified email address is kept as well so it can be matched instead.
+func IdentityFromClaims(claims map[string]any, userClaim, dmrIDClaim string, userClaimTrusted bool) (Identity, error) {
+	var identity Identity
+
+	callsign, _ := claims[userClaim].(string)
+	callsign = strings.TrimSpace(callsign)
+	verified, hasVerified := claims[userClaim+"_verified"]
+	if callsign != "" && ((hasVerified && verified == true) || (!hasVerified && userClaimTrusted)) {
+		identity.Callsign = strings.ToUpper(callsign)
+	}
+
+	email, _ := claims["email"].(string)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email != "" && claims["email_verified"] == true {
+		identity.Email = email
+	}
+
+	if identity.Callsign == "" && identity.Email == "" {
+		if callsign == "" && email == "" {
+			return identity, fmt.Errorf("%w: %s", ErrMissingClaim, userClaim)
+		}
+		return identity, fmt.Errorf("%w: %s", ErrUnverifiedClaim, userClaim)
+	}
+
+	switch dmrID := claims[dmrIDClaim].(type) {
+	case float64:
+		if dmrID > 0 && dmrID == float64(uint(dmrID)) {
+			identity.DMRID = uint(dmrID)
+		}
+	case string:
+		id, err := strconv.ParseUint(dmrID, 10, 32)
+		if err == nil {
+			identity.DMRID = uint(id)
+		}
+	}
+	return identity, nil
+}
+
+// FindOrCreateUser returns the user matching the identity, by callsign or
+// else by verified email address. With create set, a user that doesn't exist
+// yet is created unapproved, so an admin still has to approve them before
+// they can log in. Creating a user needs a verified or trusted callsign.
+func FindOrCreateUser(db *gorm.DB, identity Identity, create bool) (models.User, bool, error) {
+	var user models.User
+	if identity.Callsign != "" {
+		err := db.Where("UPPER(callsign) = ?", identity.Callsign).First(&user).Error
+		if err == nil {
+			return user, false, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, false, fmt.Errorf("failed to look up user: %w", err)
+		}
+	}
+	if identity.Email != "" {
+		var err error
+		user, err = models.FindUserByVerifiedEmail(db, identity.Email)
+		if err == nil {
+			return user, false, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, false, fmt.Errorf("failed to look up user: %w", err)
+		}
+	}
+	if !create {
+		return user, false, ErrUnknownUser
+	}
+
+	if identity.Callsign == "" {
+		return user, false, ErrUnverifiedClaim
+	}
+	if !userdb.IsValidUserID(identity.DMRID) || !userdb.ValidUserCallsign(identity.DMRID, identity.Callsign) {
+		return user, false, ErrNoDMRID
+	}
+	var count int64
+	err := db.Model(&models.User{}).Where("id = ? OR username = ?", identity.DMRID, identity.Callsign).Count(&count).Error
+	if err != nil {
+		return user, false, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if count > 0 {
+		return user, false, ErrUserConflict
+	}
+
+	user = models.User{
+		ID:       identity.DMRID,
+		Callsign: identity.Callsign,
+		Username: identity.Callsign,
+		Approved: false,
+	}
+	if identity.Email != "" {
+		inUse, err := models.EmailInUse(db, identity.Email, 0)
+		if err != nil {
+			return user, false, fmt.Errorf("failed to look up email: %w", err)
+		}
+		if !inUse {
+			// The provider verified the address, so there's no need to ask again
+			user.Email = identity.Email
+			user.EmailVerified = true
+		}
+	}
+	err = db.Create(&user).Error
+	if err != nil {
+		return user, false, fmt.Errorf("failed to create user: %w", err)
+	}
+	return user, true, nil
+}
@@ -0,0 +1,341 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package sso_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/sso"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/go-jose/go-jose/v4"
+)
+
+const clientID = "dmrhub"
+
+type authorization struct {
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+// mockProvider is a minimal OpenID Connect provider supporting discovery,
+// the authorization code flow with PKCE and RS256 signed ID tokens.
+type mockProvider struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	mu       sync.Mutex
+	codes    map[string]authorization
+	audience string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	m := &mockProvider{key: key, codes: map[string]authorization{}, audience: clientID}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                m.server.URL,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &m.key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+		m.mu.Lock()
+		auth, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		m.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.sign(t, auth),
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockProvider) sign(t *testing.T, auth authorization) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: m.key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	claims := map[string]any{
+		"iss":   m.server.URL,
+		"sub":   "user-1",
+		"aud":   m.audience,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": auth.nonce,
+	}
+	for k, v := range auth.claims {
+		claims[k] = v
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Failed to marshal claims: %v", err)
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	token, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize token: %v", err)
+	}
+	return token
+}
+
+// authorize plays the part of the user logging in at the provider and
+// returns the code the provider would redirect back with.
+func (m *mockProvider) authorize(t *testing.T, authURL string, claims map[string]any) (string, string) {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Failed to parse auth URL: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("Expected a PKCE S256 challenge in %s", authURL)
+	}
+	if query.Get("client_id") != clientID {
+		t.Fatalf("Unexpected client_id %q", query.Get("client_id"))
+	}
+	code, err := sso.RandomString()
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+	m.mu.Lock()
+	m.codes[code] = authorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: claims}
+	m.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func newProvider(t *testing.T, mock *mockProvider) *sso.Provider {
+	t.Helper()
+	provider, err := sso.NewProvider(context.Background(), mock.server.URL, clientID, "secret", "http://localhost/callback", []string{"openid"})
+	if err != nil {
+		t.Fatalf("Failed discovery: %v", err)
+	}
+	return provider
+}
+
+func TestLoginFlow(t *testing.T) {
+	t.Parallel()
+	mock := newMockProvider(t)
+	provider := newProvider(t, mock)
+
+	verifier := sso.NewVerifier()
+	code, state := mock.authorize(t, provider.AuthCodeURL("state", "nonce", verifier), map[string]any{"callsign": "ki5vmf", "dmr_id": 3191868})
+	if state != "state" {
+		t.Errorf("Expected state to round trip, got %q", state)
+	}
+
+	claims, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+	identity, err := sso.IdentityFromClaims(claims, "callsign", "dmr_id", true)
+	if err != nil {
+		t.Fatalf("Failed to map claims: %v", err)
+	}
+	if identity.Callsign != "KI5VMF" || identity.DMRID != 3191868 {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+}
+
+func TestLoginFlowWrongVerifier(t *testing.T) {
+	t.Parallel()
+	mock := newMockProvider(t)
+	provider := newProvider(t, mock)
+
+	code, _ := mock.authorize(t, provider.AuthCodeURL("state", "nonce", sso.NewVerifier()), map[string]any{"callsign": "KI5VMF"})
+	_, err := provider.Exchange(context.Background(), code, sso.NewVerifier(), "nonce")
+	if err == nil {
+		t.Error("Expected exchange with the wrong PKCE verifier to fail")
+	}
+}
+
+func TestLoginFlowNonceMismatch(t *testing.T) {
+	t.Parallel()
+	mock := newMockProvider(t)
+	provider := newProvider(t, mock)
+
+	verifier := sso.NewVerifier()
+	code, _ := mock.authorize(t, provider.AuthCodeURL("state", "nonce", verifier), map[string]any{"callsign": "KI5VMF"})
+	_, err := provider.Exchange(context.Background(), code, verifier, "other")
+	if !errors.Is(err, sso.ErrNonceMismatch) {
+		t.Errorf("Expected ErrNonceMismatch, got %v", err)
+	}
+}
+
+func TestLoginFlowWrongAudience(t *testing.T) {
+	t.Parallel()
+	mock := newMockProvider(t)
+	mock.audience = "someone-else"
+	provider := newProvider(t, mock)
+
+	verifier := sso.NewVerifier()
+	code, _ := mock.authorize(t, provider.AuthCodeURL("state", "nonce", verifier), map[string]any{"callsign": "KI5VMF"})
+	_, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+	if err == nil {
+		t.Error("Expected an ID token for another client to be rejected")
+	}
+}
+
+func TestIdentityFromClaims(t *testing.T) {
+	t.Parallel()
+	_, err := sso.IdentityFromClaims(map[string]any{}, "callsign", "dmr_id", false)
+	if !errors.Is(err, sso.ErrMissingClaim) {
+		t.Errorf("Expected ErrMissingClaim, got %v", err)
+	}
+	_, err = sso.IdentityFromClaims(map[string]any{"email": "a@example.com", "email_verified": false}, "email", "dmr_id", true)
+	if !errors.Is(err, sso.ErrUnverifiedClaim) {
+		t.Errorf("Expected ErrUnverifiedClaim, got %v", err)
+	}
+	identity, err := sso.IdentityFromClaims(map[string]any{"callsign": "n0call", "dmr_id": "3140598"}, "callsign", "dmr_id", true)
+	if err != nil {
+		t.Fatalf("Failed to map claims: %v", err)
+	}
+	if identity.Callsign != "N0CALL" || identity.DMRID != 3140598 {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+}
+
+func TestIdentityFromClaimsUntrusted(t *testing.T) {
+	t.Parallel()
+	// Users can set their own callsign attribute, so it can't be used unless verified
+	_, err := sso.IdentityFromClaims(map[string]any{"callsign": "ki5vmf"}, "callsign", "dmr_id", false)
+	if !errors.Is(err, sso.ErrUnverifiedClaim) {
+		t.Errorf("Expected ErrUnverifiedClaim, got %v", err)
+	}
+	_, err = sso.IdentityFromClaims(map[string]any{"callsign": "ki5vmf", "callsign_verified": false}, "callsign", "dmr_id", true)
+	if !errors.Is(err, sso.ErrUnverifiedClaim) {
+		t.Errorf("Expected ErrUnverifiedClaim, got %v", err)
+	}
+
+	identity, err := sso.IdentityFromClaims(map[string]any{"callsign": "ki5vmf", "callsign_verified": true}, "callsign", "dmr_id", false)
+	if err != nil || identity.Callsign != "KI5VMF" {
+		t.Errorf("Expected verified callsign, got %+v %v", identity, err)
+	}
+
+	identity, err = sso.IdentityFromClaims(map[string]any{"callsign": "ki5vmf", "email": "Jacob@Example.com", "email_verified": true}, "callsign", "dmr_id", false)
+	if err != nil || identity.Callsign != "" || identity.Email != "jacob@example.com" {
+		t.Errorf("Expected only the verified email, got %+v %v", identity, err)
+	}
+
+	identity, err = sso.IdentityFromClaims(map[string]any{"callsign": "ki5vmf", "email": "jacob@example.com"}, "callsign", "dmr_id", false)
+	if !errors.Is(err, sso.ErrUnverifiedClaim) || identity.Email != "" {
+		t.Errorf("Expected unverified email to be ignored, got %+v %v", identity, err)
+	}
+}
+
+func TestFindOrCreateUser(t *testing.T) {
+	t.Parallel()
+	db := testutils.OpenDB(t)
+	if err := db.Create(&models.User{ID: 3191868, Callsign: "KI5VMF", Username: "jacob", Approved: true}).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	user, created, err := sso.FindOrCreateUser(db, sso.Identity{Callsign: "KI5VMF"}, false)
+	if err != nil || created || user.ID != 3191868 {
+		t.Errorf("Expected to find existing user, got %+v %t %v", user, created, err)
+	}
+
+	_, _, err = sso.FindOrCreateUser(db, sso.Identity{Callsign: "N0CALL"}, false)
+	if !errors.Is(err, sso.ErrUnknownUser) {
+		t.Errorf("Expected ErrUnknownUser, got %v", err)
+	}
+
+	_, _, err = sso.FindOrCreateUser(db, sso.Identity{Callsign: "N0CALL"}, true)
+	if !errors.Is(err, sso.ErrNoDMRID) {
+		t.Errorf("Expected ErrNoDMRID, got %v", err)
+	}
+}
+
+func TestFindOrCreateUserByEmail(t *testing.T) {
+	t.Parallel()
+	db := testutils.OpenDB(t)
+	for _, user := range []models.User{
+		{ID: 3191868, Callsign: "KI5VMF", Username: "jacob", Email: "jacob@example.com", EmailVerified: true, Approved: true},
+		{ID: 3140598, Callsign: "N0CALL", Username: "squatter", Email: "dan@example.com", Approved: true},
+	} {
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	user, created, err := sso.FindOrCreateUser(db, sso.Identity{Email: "jacob@example.com"}, false)
+	if err != nil || created || user.ID != 3191868 {
+		t.Errorf("Expected to find user by verified email, got %+v %t %v", user, created, err)
+	}
+
+	// An address the user never verified doesn't identify them
+	_, _, err = sso.FindOrCreateUser(db, sso.Identity{Email: "dan@example.com"}, false)
+	if !errors.Is(err, sso.ErrUnknownUser) {
+		t.Errorf("Expected ErrUnknownUser, got %v", err)
+	}
+
+	// Users are only created from a callsign the provider vouches for
+	_, _, err = sso.FindOrCreateUser(db, sso.Identity{Email: "new@example.com", DMRID: 3140598}, true)
+	if !errors.Is(err, sso.ErrUnverifiedClaim) {
+		t.Errorf("Expected ErrUnverifiedClaim, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package testutils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/config"
+	"github.com/USA-RedDragon/DMRHub/internal/db"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// testPragmas skip syncing to disk, which the throwaway test databases don't need
+// and which otherwise makes migrating the schema take seconds.
+const testPragmas = "?_pragma=synchronous(OFF)"
+
+//nolint:golint,gochecknoglobals
+var (
+	serverOnce sync.Once
+	server     *gorm.DB
+	serverErr  error
+)
+
+// OpenDB opens an empty database private to the test, migrated to the current schema.
+// It is a SQLite file unless DATABASE_DRIVER names another backend. Then the
+// test gets its own schema on Postgres, or its own database on MySQL, on the
+// server DATABASE_DSN points at, which is dropped when the test ends.
+func OpenDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	var dialector gorm.Dialector
+	var err error
+	switch driver := os.Getenv("DATABASE_DRIVER"); driver {
+	case "", config.DatabaseSQLite:
+		dialector, err = db.Dialector(config.DatabaseSQLite, filepath.Join(t.TempDir(), "dmrhub.db")+testPragmas)
+	default:
+		dialector, err = serverDialector(t, driver)
+	}
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	database, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		sqlDB, err := database.DB()
+		if err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	err = db.Migrate(database)
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	return database
+}
+
+// serverDialector creates a schema or database for the test on the server
+// DATABASE_DSN points at.
+func serverDialector(t *testing.T, driver string) (gorm.Dialector, error) {
+	t.Helper()
+	dsn := config.GetConfig().DatabaseDSN
+	serverOnce.Do(func() {
+		// Not db.Open, which hands out in-memory databases once a test
+		// router has set TEST
+		dialector, err := db.Dialector(driver, dsn)
+		if err == nil {
+			server, err = gorm.Open(dialector, &gorm.Config{})
+		}
+		serverErr = err
+	})
+	if serverErr != nil {
+		return nil, fmt.Errorf("failed to connect to the server: %w", serverErr)
+	}
+	name := "test_" + strings.ToLower(rand.Text())
+	switch driver {
+	case config.DatabasePostgres:
+		err := server.Exec("CREATE SCHEMA " + name).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+		t.Cleanup(func() {
+			_ = server.Exec("DROP SCHEMA " + name + " CASCADE").Error
+		})
+		return db.Dialector(driver, withSearchPath(dsn, name))
+	case config.DatabaseMySQL:
+		cfg, err := mysqlDriver.ParseDSN(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mysql DSN: %w", err)
+		}
+		err = server.Exec("CREATE DATABASE " + name).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to create database: %w", err)
+		}
+		t.Cleanup(func() {
+			_ = server.Exec("DROP DATABASE " + name).Error
+		})
+		cfg.DBName = name
+		return db.Dialector(driver, cfg.FormatDSN())
+	default:
+		return db.Dialector(driver, dsn)
+	}
+}
+
+// withSearchPath points a Postgres DSN, in either URL or keyword form, at a schema.
+func withSearchPath(dsn string, schema string) string {
+	if strings.Contains(dsn, "://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		return dsn + separator + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
@@ -31,7 +31,9 @@ import (
 
 	"github.com/USA-RedDragon/DMRHub/internal/db"
 	"github.com/USA-RedDragon/DMRHub/internal/http"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
 	"github.com/gin-gonic/gin"
 	"github.com/ory/dockertest/v3"
 	"github.com/ory/dockertest/v3/docker"
@@ -161,5 +163,6 @@ func CreateTestDBRouter() (*gin.Engine, *TestDB) {
 	os.Setenv("TEST", "test")
 	var t TestDB
 	t.database = db.MakeDB()
-	return http.CreateRouter(db.MakeDB(), t.createRedis(), "test", "deadbeef"), &t
+	redisClient := t.createRedis()
+	return http.CreateRouter(db.MakeDB(), kv.NewRedis(redisClient), pubsub.NewRedis(redisClient), "test", "deadbeef"), &t
 }
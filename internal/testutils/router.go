@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package testutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/USA-RedDragon/DMRHub/internal/http/api/middleware"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RemoteAddr is the client address of every request sent with Request.
+const RemoteAddr = "192.0.2.10:5000"
+
+// NewRouter returns a router for testing handlers against the database. Like the
+// API router it provides the database, the paginated database and cookie sessions,
+// then runs the given middleware before the handlers.
+func NewRouter(database *gorm.DB, handlers ...gin.HandlerFunc) *gin.Engine {
+	router := gin.New()
+	router.Use(middleware.DatabaseProvider(database))
+	router.Use(middleware.PaginatedDatabaseProvider(database, middleware.PaginationConfig{}))
+	router.Use(sessions.Sessions("sessions", cookie.NewStore([]byte("secret"))))
+	router.Use(handlers...)
+	return router
+}
+
+// LoginAs is middleware that runs every request as logged in by the user.
+func LoginAs(userID uint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessions.Default(c).Set("user_id", userID)
+	}
+}
+
+// RequestOption changes a request before Request sends it.
+type RequestOption func(*http.Request)
+
+// WithBearerToken authenticates the request with an API token.
+func WithBearerToken(token string) RequestOption {
+	return func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithCookies sends the cookies with the request.
+func WithCookies(cookies []*http.Cookie) RequestOption {
+	return func(req *http.Request) {
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+	}
+}
+
+// Request sends a request to the router and returns the recorded response.
+// A body other than nil is sent as JSON.
+func Request(t *testing.T, router *gin.Engine, method string, path string, body any, options ...RequestOption) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), method, path, reader)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.RemoteAddr = RemoteAddr
+	for _, option := range options {
+		option(req)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// RequestJSON sends a request like Request and decodes a successful response into
+// resp. It returns the status code.
+func RequestJSON(t *testing.T, router *gin.Engine, method string, path string, body any, resp any, options ...RequestOption) int {
+	t.Helper()
+	w := Request(t, router, method, path, body, options...)
+	if w.Code == http.StatusOK && resp != nil {
+		err := json.Unmarshal(w.Body.Bytes(), resp)
+		if err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return w.Code
+}
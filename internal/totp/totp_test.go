@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package totp_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/totp"
+)
+
+// rfcSecret is the SHA1 seed from RFC 6238 appendix B
+//
+//nolint:golint,gochecknoglobals
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestRFC6238Vectors(t *testing.T) {
+	t.Parallel()
+	// RFC 6238 lists 8 digit codes, the last 6 digits are the 6 digit code
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, want := range vectors {
+		code, err := totp.CodeAt(rfcSecret, totp.Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("Failed to generate code: %v", err)
+		}
+		if code != want[2:] {
+			t.Errorf("At %d: got %s, want %s", unix, code, want[2:])
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, err := totp.CodeAt(secret, totp.Step(now))
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+
+	step, ok := totp.Validate(secret, code, now, 0)
+	if !ok || step != totp.Step(now) {
+		t.Fatal("Expected current code to validate")
+	}
+	if _, ok := totp.Validate(secret, code, now, step); ok {
+		t.Error("Expected code to be rejected when replayed")
+	}
+	if _, ok := totp.Validate(secret, code, now.Add(totp.Period), 0); !ok {
+		t.Error("Expected previous code to be accepted within the skew")
+	}
+	if _, ok := totp.Validate(secret, code, now.Add(3*totp.Period), 0); ok {
+		t.Error("Expected old code to be rejected")
+	}
+	if _, ok := totp.Validate(secret, "12345", now, 0); ok {
+		t.Error("Expected short code to be rejected")
+	}
+}
+
+func TestURL(t *testing.T) {
+	t.Parallel()
+	url := totp.URL("DMRHub", "KI5VMF", "ABC")
+	if !strings.HasPrefix(url, "otpauth://totp/DMRHub:KI5VMF?") || !strings.Contains(url, "secret=ABC") {
+		t.Errorf("Unexpected URL %s", url)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	t.Parallel()
+	codes, err := totp.GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("Failed to generate codes: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if seen[code] {
+			t.Errorf("Duplicate code %s", code)
+		}
+		seen[code] = true
+	}
+	if totp.HashRecoveryCode(codes[0], "key") != totp.HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")), "key") {
+		t.Error("Expected hashing to ignore case and dashes")
+	}
+	if totp.HashRecoveryCode(codes[0], "key") == totp.HashRecoveryCode(codes[0], "other") {
+		t.Error("Expected hash to depend on the key")
+	}
+}
@@ -0,0 +1,288 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/logging"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it is marked failed
+	MaxAttempts = 8
+	// RetryBatchSize is how many due deliveries are retried at a time
+	RetryBatchSize = 100
+	// LogRetention is how long finished deliveries are kept in the delivery log
+	LogRetention = 30 * 24 * time.Hour
+
+	requestTimeout = 10 * time.Second
+	firstRetry     = 30 * time.Second
+	maxRetry       = time.Hour
+	maxErrorLength = 500
+)
+
+var (
+	ErrStatus   = errors.New("webhook returned an error status")
+	ErrDisabled = errors.New("webhook has been disabled or deleted")
+)
+
+// Target is an endpoint deliveries are posted to.
+type Target struct {
+	URL     string
+	Secret  string
+	Enabled bool
+	// PublicOnly refuses to connect to private, loopback and link-local
+	// addresses. It is set for endpoints that users rather than admins choose.
+	PublicOnly bool
+}
+
+// WebhookTarget returns the target of an admin registered webhook.
+func WebhookTarget(webhook models.Webhook) Target {
+	return Target{
+		URL:     webhook.URL,
+		Secret:  webhook.Secret,
+		Enabled: webhook.ID != 0 && webhook.Enabled,
+	}
+}
+
+// AlertTarget returns the target of a repeater alert's webhook.
+func AlertTarget(alert models.RepeaterAlert) Target {
+	return Target{
+		URL:        alert.WebhookURL,
+		Secret:     alert.WebhookSecret,
+		Enabled:    alert.ID != 0 && alert.WebhookURL != "",
+		PublicOnly: true,
+	}
+}
+
+// Emit records a delivery of event to every webhook subscribed to it and
+// sends them in the background. Failed deliveries are retried by RetryDue.
+func Emit(db *gorm.DB, event string, data any) {
+	webhooks, err := models.ListWebhooksForEvent(db, event)
+	if err != nil {
+		logging.Errorf("Error listing webhooks for %s: %v", event, err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	now := time.Now()
+	body, err := json.Marshal(Envelope{Event: event, Time: now, Data: data})
+	if err != nil {
+		logging.Errorf("Error encoding %s webhook payload: %v", event, err)
+		return
+	}
+	for _, webhook := range webhooks {
+		delivery := models.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Payload:       string(body),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: now,
+		}
+		err := db.Create(&delivery).Error
+		if err != nil {
+			logging.Errorf("Error recording %s delivery to webhook %d: %v", event, webhook.ID, err)
+			continue
+		}
+		go Deliver(context.Background(), db, WebhookTarget(webhook), delivery, now)
+	}
+}
+
+// EmitAlert records a delivery of a repeater alert event to the alert's
+// webhook and makes the first attempt. Failed deliveries are retried by RetryDue.
+func EmitAlert(ctx context.Context, db *gorm.DB, alert models.RepeaterAlert, event string, data any) {
+	now := time.Now()
+	body, err := json.Marshal(Envelope{Event: event, Time: now, Data: data})
+	if err != nil {
+		logging.Errorf("Error encoding %s alert payload: %v", event, err)
+		return
+	}
+	delivery := models.WebhookDelivery{
+		RepeaterAlertID: alert.ID,
+		Event:           event,
+		Payload:         string(body),
+		Status:          models.DeliveryPending,
+		NextAttemptAt:   now,
+	}
+	err = db.Create(&delivery).Error
+	if err != nil {
+		logging.Errorf("Error recording %s delivery to repeater alert %d: %v", event, alert.ID, err)
+		return
+	}
+	Deliver(ctx, db, AlertTarget(alert), delivery, now)
+}
+
+// RetryDue sends every pending delivery whose next attempt is due.
+func RetryDue(ctx context.Context, db *gorm.DB, now time.Time) {
+	deliveries, err := models.ListDueWebhookDeliveries(db, now, RetryBatchSize)
+	if err != nil {
+		logging.Errorf("Error listing webhook deliveries: %v", err)
+		return
+	}
+	webhooks := map[uint]Target{}
+	alerts := map[uint]Target{}
+	for _, delivery := range deliveries {
+		var target Target
+		if delivery.RepeaterAlertID != 0 {
+			target, err = cachedTarget(alerts, delivery.RepeaterAlertID, func() (Target, error) {
+				alert, err := models.FindRepeaterAlertByID(db, delivery.RepeaterAlertID)
+				return AlertTarget(alert), err
+			})
+		} else {
+			target, err = cachedTarget(webhooks, delivery.WebhookID, func() (Target, error) {
+				webhook, err := models.FindWebhookByID(db, delivery.WebhookID)
+				return WebhookTarget(webhook), err
+			})
+		}
+		if err != nil {
+			logging.Errorf("Error finding target of webhook delivery %d: %v", delivery.ID, err)
+			continue
+		}
+		Deliver(ctx, db, target, delivery, now)
+	}
+}
+
+// cachedTarget looks up a target once per retry batch. A deleted target is
+// returned disabled so its deliveries are failed.
+func cachedTarget(cache map[uint]Target, id uint, find func() (Target, error)) (Target, error) {
+	if target, ok := cache[id]; ok {
+		return target, nil
+	}
+	target, err := find()
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return Target{}, err
+	}
+	cache[id] = target
+	return target, nil
+}
+
+// PruneLog deletes finished deliveries older than LogRetention.
+func PruneLog(db *gorm.DB, now time.Time) {
+	deleted, err := models.DeleteWebhookDeliveriesBefore(db, now.Add(-LogRetention))
+	if err != nil {
+		logging.Errorf("Error pruning webhook deliveries: %v", err)
+		return
+	}
+	if deleted > 0 {
+		logging.Logf("Pruned %d webhook deliveries", deleted)
+	}
+}
+
+// Deliver makes one attempt at sending a delivery and records the outcome.
+// Nothing is sent if another instance has already claimed the attempt.
+func Deliver(ctx context.Context, db *gorm.DB, target Target, delivery models.WebhookDelivery, now time.Time) {
+	claimed, err := models.ClaimWebhookDelivery(db, &delivery, now, now.Add(2*requestTimeout))
+	if err != nil {
+		logging.Errorf("Error claiming webhook delivery %d: %v", delivery.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	var statusCode int
+	disabled := !target.Enabled
+	if disabled {
+		err = ErrDisabled
+	} else {
+		statusCode, err = send(ctx, target, delivery)
+	}
+	updates := map[string]any{"status_code": statusCode}
+	switch {
+	case err == nil:
+		delivered := time.Now()
+		updates["status"] = models.DeliveryDelivered
+		updates["error"] = ""
+		updates["delivered_at"] = &delivered
+	case delivery.Attempts >= MaxAttempts || disabled:
+		updates["status"] = models.DeliveryFailed
+		updates["error"] = truncate(err.Error())
+	default:
+		updates["error"] = truncate(err.Error())
+		updates["next_attempt_at"] = time.Now().Add(Backoff(delivery.Attempts))
+	}
+	err = db.Model(&models.WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error
+	if err != nil {
+		logging.Errorf("Error saving webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// Backoff returns how long to wait before retrying after the given number of attempts.
+func Backoff(attempts uint) time.Duration {
+	delay := firstRetry
+	for i := uint(1); i < attempts && delay < maxRetry; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetry)
+}
+
+func send(ctx context.Context, target Target, delivery models.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "DMRHub")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(target.Secret, timestamp, body))
+
+	client := http.DefaultClient
+	if target.PublicOnly {
+		client = publicClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused
+	_, _ = io.CopyN(io.Discard, resp.Body, 4096) //nolint:golint,mnd
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%w: %s", ErrStatus, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func truncate(message string) string {
+	if len(message) > maxErrorLength {
+		return message[:maxErrorLength]
+	}
+	return message
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package webhooks
+
+import (
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+)
+
+// Call is the data of call events.
+type Call struct {
+	ID            uint      `json:"id"`
+	StartTime     time.Time `json:"start_time"`
+	Duration      float64   `json:"duration_seconds"`
+	Active        bool      `json:"active"`
+	UserID        uint      `json:"user_id"`
+	UserCallsign  string    `json:"user_callsign"`
+	RepeaterID    uint      `json:"repeater_id"`
+	TimeSlot      uint      `json:"time_slot"`
+	GroupCall     bool      `json:"group_call"`
+	IsToTalkgroup bool      `json:"is_to_talkgroup"`
+	IsToUser      bool      `json:"is_to_user"`
+	IsToRepeater  bool      `json:"is_to_repeater"`
+	DestinationID uint      `json:"destination_id"`
+	Loss          float32   `json:"loss"`
+	Jitter        float32   `json:"jitter"`
+	BER           float32   `json:"ber"`
+	RSSI          float32   `json:"rssi"`
+}
+
+func CallFromModel(call *models.Call) Call {
+	timeSlot := uint(1)
+	if call.TimeSlot {
+		timeSlot = 2
+	}
+	return Call{
+		ID:            call.ID,
+		StartTime:     call.StartTime,
+		Duration:      call.Duration.Seconds(),
+		Active:        call.Active,
+		UserID:        call.UserID,
+		UserCallsign:  call.User.Callsign,
+		RepeaterID:    call.RepeaterID,
+		TimeSlot:      timeSlot,
+		GroupCall:     call.GroupCall,
+		IsToTalkgroup: call.IsToTalkgroup,
+		IsToUser:      call.IsToUser,
+		IsToRepeater:  call.IsToRepeater,
+		DestinationID: call.DestinationID,
+		Loss:          call.Loss,
+		Jitter:        call.Jitter,
+		BER:           call.BER,
+		RSSI:          call.RSSI,
+	}
+}
+
+// Repeater is the data of repeater events.
+type Repeater struct {
+	ID        uint      `json:"id"`
+	Callsign  string    `json:"callsign"`
+	OwnerID   uint      `json:"owner_id"`
+	Hotspot   bool      `json:"hotspot"`
+	Connected time.Time `json:"connected_time"`
+	LastPing  time.Time `json:"last_ping_time"`
+}
+
+func RepeaterFromModel(repeater models.Repeater) Repeater {
+	return Repeater{
+		ID:        repeater.ID,
+		Callsign:  repeater.Callsign,
+		OwnerID:   repeater.OwnerID,
+		Hotspot:   repeater.Hotspot,
+		Connected: repeater.Connected,
+		LastPing:  repeater.LastPing,
+	}
+}
+
+// User is the data of user events.
+type User struct {
+	ID       uint   `json:"id"`
+	Callsign string `json:"callsign"`
+	Username string `json:"username"`
+}
+
+func UserFromModel(user models.User) User {
+	return User{
+		ID:       user.ID,
+		Callsign: user.Callsign,
+		Username: user.Username,
+	}
+}
+
+// Peer is the data of peer events.
+type Peer struct {
+	ID      uint `json:"id"`
+	OwnerID uint `json:"owner_id"`
+	Ingress bool `json:"ingress"`
+	Egress  bool `json:"egress"`
+}
+
+func PeerFromModel(peer models.Peer) Peer {
+	return Peer{
+		ID:      peer.ID,
+		OwnerID: peer.OwnerID,
+		Ingress: peer.Ingress,
+		Egress:  peer.Egress,
+	}
+}
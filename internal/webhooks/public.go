@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrPrivateDestination = errors.New("refusing to send to a private, loopback or link-local address")
+
+//nolint:golint,gochecknoglobals
+var (
+	// sharedAddressSpace is the carrier-grade NAT range, which netip does not count as private
+	sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+	// publicClient checks each address as it is dialled, so neither a DNS
+	// answer nor a redirect can point a request back inside the network.
+	publicClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy: nil,
+			DialContext: (&net.Dialer{
+				Timeout: requestTimeout,
+				Control: refusePrivate,
+			}).DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: requestTimeout,
+			MaxIdleConns:        10,               //nolint:golint,mnd
+			IdleConnTimeout:     90 * time.Second, //nolint:golint,mnd
+		},
+	}
+)
+
+// IsPublicAddr reports whether ip can be reached from the internet.
+func IsPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() &&
+		!ip.IsPrivate() &&
+		!ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// ValidatePublicURL checks that webhooks can be posted to rawURL and that it
+// doesn't name a private address. Hostnames are checked again when dialling.
+func ValidatePublicURL(rawURL string) error {
+	err := ValidateURL(rawURL)
+	if err != nil {
+		return err
+	}
+	parsed, _ := url.Parse(rawURL)
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateDestination
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !IsPublicAddr(ip) {
+		return ErrPrivateDestination
+	}
+	return nil
+}
+
+func refusePrivate(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("failed to parse address %s: %w", address, err)
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("failed to parse address %s: %w", address, err)
+	}
+	if !IsPublicAddr(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateDestination, ip)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+// Package webhooks sends signed JSON POSTs to admin registered endpoints when
+// things happen on the network, and to the endpoints users give for repeater
+// alerts. User endpoints may only be on public addresses.
+//
+// Every request carries these headers:
+//
+//	X-DMRHub-Event: the event name, e.g. call.started
+//	X-DMRHub-Delivery: the delivery ID, the same on every retry
+//	X-DMRHub-Timestamp: Unix seconds when the request was signed
+//	X-DMRHub-Signature: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// Receivers should recompute the signature over the raw body and reject
+// requests with an old timestamp.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Events that webhooks can subscribe to
+const (
+	EventCallStarted          = "call.started"
+	EventCallEnded            = "call.ended"
+	EventRepeaterConnected    = "repeater.connected"
+	EventRepeaterDisconnected = "repeater.disconnected"
+	EventUserRegistered       = "user.registered"
+	EventPeerCreated          = "peer.created"
+)
+
+// Events returns every event a webhook can subscribe to.
+func Events() []string {
+	return []string{
+		EventCallStarted,
+		EventCallEnded,
+		EventRepeaterConnected,
+		EventRepeaterDisconnected,
+		EventUserRegistered,
+		EventPeerCreated,
+	}
+}
+
+const (
+	HeaderEvent     = "X-DMRHub-Event"
+	HeaderDelivery  = "X-DMRHub-Delivery"
+	HeaderTimestamp = "X-DMRHub-Timestamp"
+	HeaderSignature = "X-DMRHub-Signature"
+
+	secretPrefix = "whsec_"
+	secretBytes  = 32
+)
+
+var (
+	ErrInvalidURL   = errors.New("webhook URL must be an absolute http or https URL")
+	ErrInvalidEvent = errors.New("unknown webhook event")
+	ErrNoEvents     = errors.New("at least one event is required")
+)
+
+// ValidateURL checks that webhooks can be posted to rawURL.
+func ValidateURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+// ValidateEvents checks that a webhook subscribes to known events.
+func ValidateEvents(events []string) error {
+	if len(events) == 0 {
+		return ErrNoEvents
+	}
+	for _, event := range events {
+		if !slices.Contains(Events(), event) {
+			return fmt.Errorf("%w: %s", ErrInvalidEvent, event)
+		}
+	}
+	return nil
+}
+
+// Envelope is the JSON body of every webhook request.
+type Envelope struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data"`
+}
+
+// GenerateSecret returns a new random signing secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header value for a body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature header against the timestamp header and body,
+// rejecting requests signed more than tolerance away from now.
+func Verify(secret string, timestamp string, body []byte, signature string, tolerance time.Duration, now time.Time) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age > tolerance || age < -tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body)))
+}
@@ -0,0 +1,247 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// DMRHub - Run a DMR network server in a single binary
+// Copyright (C) 2023-2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/USA-RedDragon/DMRHub>
+
+package webhooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/DMRHub/internal/db/models"
+	"github.com/USA-RedDragon/DMRHub/internal/testutils"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
+	"gorm.io/gorm"
+)
+
+func waitForDelivery(t *testing.T, db *gorm.DB, check func(models.WebhookDelivery) bool) models.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var delivery models.WebhookDelivery
+		err := db.First(&delivery).Error
+		if err == nil && check(delivery) {
+			return delivery
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for delivery, last seen %+v", delivery)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"call.started"}`)
+	signature := webhooks.Sign("whsec_test", now.Unix(), body)
+
+	if !webhooks.Verify("whsec_test", "1700000000", body, signature, time.Minute, now) {
+		t.Error("expected signature to verify")
+	}
+	if webhooks.Verify("whsec_other", "1700000000", body, signature, time.Minute, now) {
+		t.Error("expected signature with another secret to fail")
+	}
+	if webhooks.Verify("whsec_test", "1700000000", []byte(`{}`), signature, time.Minute, now) {
+		t.Error("expected signature over another body to fail")
+	}
+	if webhooks.Verify("whsec_test", "1700000000", body, signature, time.Minute, now.Add(2*time.Minute)) {
+		t.Error("expected an old signature to fail")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+	want := map[uint]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		8:  time.Hour,
+		20: time.Hour,
+	}
+	for attempts, delay := range want {
+		if got := webhooks.Backoff(attempts); got != delay {
+			t.Errorf("Backoff(%d) = %v, want %v", attempts, got, delay)
+		}
+	}
+}
+
+func TestEmitDeliversSignedPayload(t *testing.T) {
+	t.Parallel()
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	received := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- request{r.Header, body}
+	}))
+	defer server.Close()
+
+	db := testutils.OpenDB(t)
+	webhook := models.Webhook{Name: "bot", URL: server.URL, Secret: "whsec_test", Events: "call.started,user.registered", Enabled: true}
+	unsubscribed := models.Webhook{Name: "log", URL: server.URL, Secret: "whsec_test", Events: "peer.created", Enabled: true}
+	db.Create(&webhook)
+	db.Create(&unsubscribed)
+
+	webhooks.Emit(db, webhooks.EventUserRegistered, webhooks.User{ID: 3191868, Callsign: "KI5VMF", Username: "jacob"})
+
+	var req request
+	select {
+	case req = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook")
+	}
+	if req.header.Get(webhooks.HeaderEvent) != webhooks.EventUserRegistered {
+		t.Errorf("event header = %q", req.header.Get(webhooks.HeaderEvent))
+	}
+	if !webhooks.Verify("whsec_test", req.header.Get(webhooks.HeaderTimestamp), req.body, req.header.Get(webhooks.HeaderSignature), time.Minute, time.Now()) {
+		t.Error("signature did not verify")
+	}
+	var envelope struct {
+		Event string        `json:"event"`
+		Data  webhooks.User `json:"data"`
+	}
+	if err := json.Unmarshal(req.body, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Event != webhooks.EventUserRegistered || envelope.Data.Callsign != "KI5VMF" {
+		t.Errorf("unexpected payload %s", req.body)
+	}
+
+	delivery := waitForDelivery(t, db, func(d models.WebhookDelivery) bool { return d.Status != models.DeliveryPending })
+	if delivery.Status != models.DeliveryDelivered || delivery.Attempts != 1 || delivery.StatusCode != http.StatusOK {
+		t.Errorf("unexpected delivery %+v", delivery)
+	}
+	var count int64
+	db.Model(&models.WebhookDelivery{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected only the subscribed webhook to get a delivery, got %d", count)
+	}
+}
+
+func TestFailedDeliveriesAreRetried(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	db := testutils.OpenDB(t)
+	webhook := models.Webhook{Name: "bot", URL: server.URL, Secret: "whsec_test", Events: "peer.created", Enabled: true}
+	db.Create(&webhook)
+
+	webhooks.Emit(db, webhooks.EventPeerCreated, webhooks.Peer{ID: 1})
+	delivery := waitForDelivery(t, db, func(d models.WebhookDelivery) bool { return d.StatusCode != 0 })
+	if delivery.Status != models.DeliveryPending || delivery.Attempts != 1 || delivery.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected delivery after failure %+v", delivery)
+	}
+	if until := time.Until(delivery.NextAttemptAt); until < 20*time.Second {
+		t.Errorf("expected the retry to be backed off, next attempt in %v", until)
+	}
+
+	// Not due yet
+	webhooks.RetryDue(context.Background(), db, time.Now())
+	if calls.Load() != 1 {
+		t.Fatalf("expected no retry before it is due, got %d calls", calls.Load())
+	}
+
+	webhooks.RetryDue(context.Background(), db, delivery.NextAttemptAt)
+	delivery = waitForDelivery(t, db, func(models.WebhookDelivery) bool { return true })
+	if delivery.Status != models.DeliveryDelivered || delivery.Attempts != 2 || delivery.Error != "" {
+		t.Errorf("unexpected delivery after retry %+v", delivery)
+	}
+}
+
+func TestValidateEvents(t *testing.T) {
+	t.Parallel()
+	if err := webhooks.ValidateEvents([]string{webhooks.EventCallStarted, webhooks.EventCallEnded}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := webhooks.ValidateEvents(nil); err == nil {
+		t.Error("expected an error for no events")
+	}
+	if err := webhooks.ValidateEvents([]string{"call.exploded"}); err == nil {
+		t.Error("expected an error for an unknown event")
+	}
+}
+
+func TestValidatePublicURL(t *testing.T) {
+	t.Parallel()
+	tests := map[string]error{
+		"https://example.com/hook":       nil,
+		"http://203.0.113.7:8080/hook":   nil,
+		"ftp://example.com/hook":         webhooks.ErrInvalidURL,
+		"http://localhost/hook":          webhooks.ErrPrivateDestination,
+		"http://api.localhost./hook":     webhooks.ErrPrivateDestination,
+		"http://127.0.0.1/hook":          webhooks.ErrPrivateDestination,
+		"http://10.0.0.5/hook":           webhooks.ErrPrivateDestination,
+		"http://192.168.1.1/hook":        webhooks.ErrPrivateDestination,
+		"http://169.254.169.254/latest":  webhooks.ErrPrivateDestination,
+		"http://100.64.0.1/hook":         webhooks.ErrPrivateDestination,
+		"http://0.0.0.0/hook":            webhooks.ErrPrivateDestination,
+		"http://[::1]/hook":              webhooks.ErrPrivateDestination,
+		"http://[fd00::1]/hook":          webhooks.ErrPrivateDestination,
+		"http://[fe80::1]/hook":          webhooks.ErrPrivateDestination,
+		"http://[::ffff:127.0.0.1]/hook": webhooks.ErrPrivateDestination,
+	}
+	for rawURL, want := range tests {
+		if err := webhooks.ValidatePublicURL(rawURL); !errors.Is(err, want) {
+			t.Errorf("ValidatePublicURL(%q) = %v, want %v", rawURL, err, want)
+		}
+	}
+}
+
+func TestPublicOnlyTargetRefusesPrivateAddress(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	db := testutils.OpenDB(t)
+	now := time.Now()
+	delivery := models.WebhookDelivery{RepeaterAlertID: 1, Event: "alert.offline", Payload: "{}", Status: models.DeliveryPending, NextAttemptAt: now}
+	db.Create(&delivery)
+
+	// A hostname that resolves to loopback is refused when dialling, not just when parsing
+	target := webhooks.Target{URL: strings.Replace(server.URL, "127.0.0.1", "localhost", 1), Secret: "whsec_test", Enabled: true, PublicOnly: true}
+	webhooks.Deliver(context.Background(), db, target, delivery, now)
+
+	delivery = waitForDelivery(t, db, func(models.WebhookDelivery) bool { return true })
+	if delivery.Status != models.DeliveryPending || !strings.Contains(delivery.Error, webhooks.ErrPrivateDestination.Error()) {
+		t.Errorf("unexpected delivery %+v", delivery)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("expected no request to reach the loopback server, got %d", calls.Load())
+	}
+}
@@ -21,12 +21,16 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/USA-RedDragon/DMRHub/internal/alerts"
+	"github.com/USA-RedDragon/DMRHub/internal/cmd"
 	"github.com/USA-RedDragon/DMRHub/internal/config"
 	"github.com/USA-RedDragon/DMRHub/internal/db"
 	"github.com/USA-RedDragon/DMRHub/internal/db/models"
@@ -36,10 +40,16 @@ import (
 	"github.com/USA-RedDragon/DMRHub/internal/dmr/servers/openbridge"
 	"github.com/USA-RedDragon/DMRHub/internal/featureflags"
 	"github.com/USA-RedDragon/DMRHub/internal/http"
+	"github.com/USA-RedDragon/DMRHub/internal/kv"
 	"github.com/USA-RedDragon/DMRHub/internal/logging"
 	"github.com/USA-RedDragon/DMRHub/internal/metrics"
+	"github.com/USA-RedDragon/DMRHub/internal/mqtt"
+	"github.com/USA-RedDragon/DMRHub/internal/pubsub"
+	"github.com/USA-RedDragon/DMRHub/internal/queue"
 	"github.com/USA-RedDragon/DMRHub/internal/repeaterdb"
+	"github.com/USA-RedDragon/DMRHub/internal/retention"
 	"github.com/USA-RedDragon/DMRHub/internal/userdb"
+	"github.com/USA-RedDragon/DMRHub/internal/webhooks"
 	"github.com/go-co-op/gocron/v2"
 	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
@@ -95,18 +105,66 @@ var (
 	commit  = "none"
 )
 
+// reloadOnSIGHUP reloads the runtime-safe configuration whenever SIGHUP is received.
+func reloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		err := config.Reload()
+		if err != nil {
+			logging.Errorf("Failed to reload configuration, keeping the current one: %v", err)
+			continue
+		}
+		logging.Log("Configuration reloaded")
+	}
+}
+
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "serve" {
+		args = args[1:]
+	}
+	if cmd.IsCommand(args) {
+		os.Exit(runCommand(args))
+	}
 	os.Exit(start())
 }
 
+// runCommand runs an administrative subcommand instead of starting the server.
+func runCommand(args []string) int {
+	defer logging.Close()
+
+	// config check reports its own validation errors
+	if args[0] != "config" {
+		err := config.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+			return 1
+		}
+	}
+
+	err := cmd.Run(cmd.Env{Out: os.Stdout, OpenDB: db.Open}, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
 func start() int {
 	logging.Errorf("DMRHub v%s-%s", version, commit)
 	logging.Logf("DMRHub v%s-%s", version, commit)
 	defer logging.Close()
 
+	err := config.Load()
+	if err != nil {
+		logging.Errorf("Invalid configuration: %v", err)
+		return 1
+	}
+
 	ctx := context.Background()
 
-	featureflags.Init(config.GetConfig())
+	featureflags.Init()
 
 	scheduler, err := gocron.NewScheduler()
 	if err != nil {
@@ -171,48 +229,124 @@ func start() int {
 		logging.Errorf("Failed to schedule user update: %s", err)
 	}
 
+	_, err = scheduler.NewJob(
+		gocron.DurationJob(time.Minute),
+		gocron.NewTask(func() {
+			alerts.Check(ctx, database, time.Now())
+		}),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	)
+	if err != nil {
+		logging.Errorf("Failed to schedule repeater alerts: %s", err)
+	}
+
+	const webhookRetryInterval = 30 * time.Second
+	_, err = scheduler.NewJob(
+		gocron.DurationJob(webhookRetryInterval),
+		gocron.NewTask(func() {
+			webhooks.RetryDue(ctx, database, time.Now())
+		}),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	)
+	if err != nil {
+		logging.Errorf("Failed to schedule webhook retries: %s", err)
+	}
+	_, err = scheduler.NewJob(
+		gocron.DailyJob(1, gocron.NewAtTimes(
+			gocron.NewAtTime(0, 0, 0),
+		)),
+		gocron.NewTask(func() {
+			webhooks.PruneLog(database, time.Now())
+		}),
+	)
+	if err != nil {
+		logging.Errorf("Failed to schedule webhook log pruning: %s", err)
+	}
+
+	_, err = scheduler.NewJob(
+		gocron.DurationJob(time.Hour),
+		gocron.NewTask(func() {
+			retention.Run(ctx, database, retention.Policies(config.GetConfig()), time.Now())
+		}),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	)
+	if err != nil {
+		logging.Errorf("Failed to schedule call retention: %s", err)
+	}
+
 	scheduler.Start()
 
 	const connsPerCPU = 10
 	const maxIdleTime = 10 * time.Minute
 
-	redis := redis.NewClient(&redis.Options{
-		Addr:            config.GetConfig().RedisHost,
-		Password:        config.GetConfig().RedisPassword,
-		PoolFIFO:        true,
-		PoolSize:        runtime.GOMAXPROCS(0) * connsPerCPU,
-		MinIdleConns:    runtime.GOMAXPROCS(0),
-		ConnMaxIdleTime: maxIdleTime,
-	})
-	_, err = redis.Ping(ctx).Result()
-	if err != nil {
-		logging.Errorf("Failed to connect to redis: %s", err)
-		return 1
-	}
-	defer func() {
-		err := redis.Close()
+	var store kv.KV
+	var ps pubsub.PubSub
+	var redisClient *redis.Client
+	switch config.GetConfig().Store {
+	case config.StoreMemory:
+		logging.Log("Using in-memory store, DMRHub cannot be scaled beyond a single instance")
+		store = kv.NewMemory()
+		ps = pubsub.NewMemory()
+	default:
+		redisClient = redis.NewClient(&redis.Options{
+			Addr:            config.GetConfig().RedisHost,
+			Password:        config.GetConfig().RedisPassword,
+			PoolFIFO:        true,
+			PoolSize:        runtime.GOMAXPROCS(0) * connsPerCPU,
+			MinIdleConns:    runtime.GOMAXPROCS(0),
+			ConnMaxIdleTime: maxIdleTime,
+		})
+		_, err = redisClient.Ping(ctx).Result()
 		if err != nil {
-			logging.Errorf("Failed to close redis: %s", err)
-		}
-	}()
-	if config.GetConfig().OTLPEndpoint != "" {
-		if err := redisotel.InstrumentTracing(redis); err != nil {
-			logging.Errorf("Failed to trace redis: %s", err)
+			logging.Errorf("Failed to connect to redis: %s", err)
 			return 1
 		}
+		defer func() {
+			err := redisClient.Close()
+			if err != nil {
+				logging.Errorf("Failed to close redis: %s", err)
+			}
+		}()
+		if config.GetConfig().OTLPEndpoint != "" {
+			if err := redisotel.InstrumentTracing(redisClient); err != nil {
+				logging.Errorf("Failed to trace redis: %s", err)
+				return 1
+			}
 
-		// Enable metrics instrumentation.
-		if err := redisotel.InstrumentMetrics(redis); err != nil {
-			logging.Errorf("Failed to instrument redis: %s", err)
-			return 1
+			// Enable metrics instrumentation.
+			if err := redisotel.InstrumentMetrics(redisClient); err != nil {
+				logging.Errorf("Failed to instrument redis: %s", err)
+				return 1
+			}
 		}
+		store = kv.NewRedis(redisClient)
+		ps = pubsub.NewRedis(redisClient)
 	}
 
-	callTracker := calltracker.NewCallTracker(database, redis)
+	err = mqtt.Start(config.GetConfig())
+	if err != nil {
+		logging.Errorf("Failed to start MQTT publisher: %v", err)
+		return 1
+	}
+	defer mqtt.Stop()
 
-	redisClient := servers.MakeRedisClient(redis)
+	callTracker := calltracker.NewCallTracker(database, store, ps)
+	go callTracker.Start(ctx)
+
+	serverStore := servers.MakeRedisClient(store, ps)
+	metrics.RegisterConnectedRepeaters(func() float64 {
+		return float64(serverStore.CountConnectedRepeaters(ctx))
+	})
 
-	hbrpServer := hbrp.MakeServer(database, redis, redisClient, callTracker, version, commit)
+	ingress := queue.NewPubSub(ps, "hbrp:incoming")
+	if config.GetConfig().Ingress == config.IngressStreams {
+		ingress = queue.NewStreams(redisClient, "hbrp:incoming", queue.StreamsOptions{
+			Shards:  config.GetConfig().IngressShards,
+			Backlog: config.GetConfig().IngressBacklog,
+		})
+	}
+
+	hbrpServer := hbrp.MakeServer(database, store, serverStore, ingress, callTracker, version, commit)
 	err = hbrpServer.Start(ctx)
 	if err != nil {
 		logging.Errorf("Failed to start HBRP server: %v", err)
@@ -228,14 +362,14 @@ func start() int {
 			return err //nolint:golint,wrapcheck
 		}
 		for _, repeater := range repeaters {
-			go hbrp.GetSubscriptionManager(database).ListenForCalls(redis, repeater.ID)
+			go hbrp.GetSubscriptionManager(database).ListenForCalls(ps, repeater.ID)
 		}
 		return nil
 	})
 
 	if config.GetConfig().OpenBridgePort != 0 {
 		// Start the OpenBridge server
-		openbridgeServer := openbridge.MakeServer(database, redisClient, callTracker)
+		openbridgeServer := openbridge.MakeServer(database, serverStore, callTracker)
 		err := openbridgeServer.Start(ctx)
 		if err != nil {
 			logging.Errorf("Failed to start OpenBridge server: %v", err)
@@ -247,12 +381,12 @@ func start() int {
 			// For each peer in the DB, start a gofunc to listen for calls
 			peers := models.ListPeers(database)
 			for _, peer := range peers {
-				go openbridge.GetSubscriptionManager().Subscribe(ctx, redis, peer)
+				go openbridge.GetSubscriptionManager().Subscribe(ctx, ps, peer)
 			}
 		}()
 	}
 
-	http := http.MakeServer(database, redis, version, commit)
+	http := http.MakeServer(database, store, ps, version, commit)
 	err = http.Start()
 	if err != nil {
 		logging.Errorf("Failed to start HTTP server %v", err)
@@ -319,7 +453,9 @@ func start() int {
 		}()
 		select {
 		case <-c:
-			redis.Close()
+			if redisClient != nil {
+				_ = redisClient.Close()
+			}
 			logging.Error("Shutdown safely completed")
 			logging.Close()
 			os.Exit(0)
@@ -333,7 +469,9 @@ func start() int {
 
 	shutdown.AddWithParam(stop)
 
-	shutdown.Listen(syscall.SIGINT, syscall.SIGKILL, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+	go reloadOnSIGHUP()
+
+	shutdown.Listen(syscall.SIGINT, syscall.SIGKILL, syscall.SIGTERM, syscall.SIGQUIT)
 
 	return 0
 }